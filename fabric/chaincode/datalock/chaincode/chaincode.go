@@ -0,0 +1,41 @@
+// Package chaincode : datalock as a library. New creates the
+// chaincode, to run on its own or to mount in another chaincode,
+// its methods served under a prefix :
+//
+//	dl := chaincode.New(chaincode.WithPrefix("datalock."))
+//
+//	func (h *Host) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+//		function, _ := stub.GetFunctionAndParameters()
+//		if dl.Handles(function) {
+//			return dl.Invoke(stub)
+//		}
+//		...
+//	}
+//
+// Datalock keeps its state in the namespace of the chaincode
+// serving it, next to the state of the host
+package chaincode
+
+import "datalock/internal"
+
+type (
+	DataLockChaincode = internal.DataLockChaincode
+	Option            = internal.Option
+	Method            = internal.Method
+	Methods           = internal.Methods
+	Clock             = internal.Clock
+	ClockFunc         = internal.ClockFunc
+	ConfigSource      = internal.ConfigSource
+	ConfigFunc        = internal.ConfigFunc
+)
+
+var (
+	New              = internal.New
+	WithLogger       = internal.WithLogger
+	WithClock        = internal.WithClock
+	WithConfigSource = internal.WithConfigSource
+	WithMetrics      = internal.WithMetrics
+	WithMethods      = internal.WithMethods
+	WithPrefix       = internal.WithPrefix
+	WithBootstrapMSP = internal.WithBootstrapMSP
+)
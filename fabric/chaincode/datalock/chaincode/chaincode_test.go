@@ -0,0 +1,56 @@
+package chaincode
+
+import (
+	"datalock/model"
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+// hostCC : chaincode with a method of its own,
+// mounting datalock under datalock.
+type hostCC struct {
+	dl *DataLockChaincode
+}
+
+func (h hostCC) Init(shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (h hostCC) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	function, _ := stub.GetFunctionAndParameters()
+	if h.dl.Handles(function) {
+		return h.dl.Invoke(stub)
+	}
+	if function == "hello" {
+		return shim.Success([]byte("hello"))
+	}
+	return shim.Error("method not supported")
+}
+
+func TestMount(t *testing.T) {
+	is := assert.New(t)
+	stub := shimtest.NewMockStub("HostCC", hostCC{dl: New(WithPrefix("datalock."))})
+	invoke := func(args ...string) pb.Response {
+		raw := make([][]byte, len(args))
+		for i, arg := range args {
+			raw[i] = []byte(arg)
+		}
+		return stub.MockInvoke("mockID", raw)
+	}
+
+	is.Equal([]byte("hello"), invoke("hello").Payload)
+	is.Equal(int32(shim.ERROR), invoke("startTransitionProcess", "txID-1").Status)
+
+	resp := invoke("datalock.startTransitionProcess", "txID-1")
+	is.Equal(int32(shim.OK), resp.Status, resp.Message)
+	resp = invoke("datalock.getTxDetails", "txID-1")
+	is.Equal(int32(shim.OK), resp.Status, resp.Message)
+	var tx model.Transaction
+	is.NoError(json.Unmarshal(resp.Payload, &tx))
+	is.Equal(model.TxStatePROCESSING, tx.State)
+}
@@ -0,0 +1,283 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"datalock/model"
+	"datalock/pkg/canonical"
+	"datalock/pkg/errors"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Finished and aborted transactions past the retention period are
+// deleted from world state, along with the data of their stages.
+// A compact summary with the hash of the full payload stays on
+// chain, the payload itself goes out in the archive event for
+// off-chain storage. Data of private stages is left to the
+// blockToLive of its collection.
+
+const (
+	txArchiveObj = "txID~archive"
+	// archiveEventName : event carrying payloads of archived transactions
+	archiveEventName = "datalock.archived"
+)
+
+func txArchiveKey(txID string) string {
+	key, _ := shim.CreateCompositeKey(txArchiveObj, []string{txID})
+	return key
+}
+
+// archiveTxs : scans a page of the simple keys, archiving the
+// transactions past retention. Range queries with pagination
+// can't be used by a transaction writing state, so the page
+// is bounded by the number of keys scanned. Mounted under a
+// prefix, only the transaction keys are scanned and the
+// bookmark is a txID
+func archiveTxs(stub shim.ChaincodeStubInterface, input model.ArchiveInput) (*model.ArchiveOutput, error) {
+	const op = errors.Op("Archive.archiveTxs")
+	limit := input.Limit
+	if limit == 0 {
+		limit = model.DefaultArchiveLimit
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	start, end := input.Bookmark, ""
+	isMounted := mounted(stub)
+	if isMounted {
+		start, end = txKey(stub, input.Bookmark), txKeyPrefix+string(utf8.MaxRune)
+	}
+	itr, err := stub.GetStateByRange(start, end)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to create range iterator : %w", err),
+			errors.SeverityError,
+		)
+	}
+	defer itr.Close()
+	txIDs := []string{}
+	out := &model.ArchiveOutput{Archived: []string{}, Kept: []string{}}
+	for scanned := 0; itr.HasNext(); scanned++ {
+		kv, err := itr.Next()
+		if err != nil {
+			return nil, errors.E(
+				op,
+				errors.CodeUnexpected,
+				fmt.Errorf("failed to iterate keys : %w", err),
+				errors.SeverityError,
+			)
+		}
+		key := kv.Key
+		if isMounted {
+			key = strings.TrimPrefix(key, txKeyPrefix)
+		}
+		if scanned == limit {
+			out.Bookmark = key
+			break
+		}
+		// lock states (chaincode::key) sit along transactions
+		if !strings.Contains(key, "::") {
+			txIDs = append(txIDs, key)
+		}
+	}
+
+	archived := []model.ArchivedTx{}
+	for _, txID := range txIDs {
+		entry, kept, err := archiveTx(stub, txID, input.Retention, now)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+		if kept {
+			out.Kept = append(out.Kept, txID)
+		}
+		if entry != nil {
+			out.Archived = append(out.Archived, txID)
+			archived = append(archived, *entry)
+		}
+	}
+	err = setArchiveEvent(stub, archived)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return out, nil
+}
+
+// archiveTx : deletes txID if finished or aborted before
+// now - retention, writing its summary. Returns the archive
+// event entry, nil if not archived, and true if the transaction
+// is kept for holding locks or having children left
+func archiveTx(stub shim.ChaincodeStubInterface, txID string, retention, now int64) (*model.ArchivedTx, bool, error) {
+	const op = errors.Op("Archive.archiveTx")
+	id := errors.TxID(txID)
+
+	tx, err := getTxView(stub, txID)
+	if err != nil {
+		return nil, false, errors.E(op, err)
+	}
+	endedAt := tx.FinishedAt
+	switch tx.State {
+	case model.TxStateFINISHED:
+	case model.TxStateABORTED:
+		endedAt = tx.AbortedAt
+	default:
+		return nil, false, nil
+	}
+	// transactions ended before the time was recorded are past retention
+	if endedAt != 0 && now-endedAt < retention {
+		return nil, false, nil
+	}
+	// no lock index may point at an archived transaction
+	lockIDs, err := getAllLockState(stub, txID)
+	if err != nil {
+		return nil, false, errors.E(op, err)
+	}
+	children, err := getTxChildren(stub, txID)
+	if err != nil {
+		return nil, false, errors.E(op, err)
+	}
+	if len(lockIDs) != 0 || len(children) != 0 {
+		return nil, true, nil
+	}
+
+	payload, err := canonical.Marshal(tx)
+	if err != nil {
+		return nil, false, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to encode transaction : %w", err),
+			errors.SeverityError,
+			id,
+		)
+	}
+	sum := sha256.Sum256(payload)
+	summary := model.TxArchive{
+		TxID:        tx.TxID,
+		State:       tx.State,
+		Workflow:    tx.Workflow,
+		Parent:      tx.Parent,
+		EndedAt:     endedAt,
+		Stages:      slices.Sorted(maps.Keys(tx.StageData)),
+		Revision:    tx.Revision,
+		Digest:      tx.Digest,
+		PayloadHash: hex.EncodeToString(sum[:]),
+		ArchivedAt:  now,
+	}
+
+	keys := []string{txKey(stub, txID)}
+	for _, stage := range summary.Stages {
+		keys = append(keys, stageDataKey(txID, stage))
+	}
+	if tx.Parent != "" {
+		keys = append(keys, txChildKey(tx.Parent, txID))
+	}
+	for _, key := range keys {
+		err = stub.DelState(key)
+		if err != nil {
+			return nil, false, errors.E(
+				op,
+				errors.CodeUnexpected,
+				fmt.Errorf("failed to delete transaction state : %w", err),
+				errors.SeverityError,
+				id,
+			)
+		}
+	}
+	raw, err := canonical.Marshal(summary)
+	if err != nil {
+		return nil, false, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to encode transaction archive : %w", err),
+			errors.SeverityError,
+			id,
+		)
+	}
+	err = stub.PutState(txArchiveKey(txID), raw)
+	if err != nil {
+		return nil, false, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to put transaction archive : %w", err),
+			errors.SeverityError,
+			id,
+		)
+	}
+	return &model.ArchivedTx{Archive: summary, Payload: payload}, false, nil
+}
+
+// getTxArchive : summary of an archived transaction
+func getTxArchive(stub shim.ChaincodeStubInterface, txID string) (*model.TxArchive, error) {
+	const op = errors.Op("Archive.getTxArchive")
+	id := errors.TxID(txID)
+	raw, err := stub.GetState(txArchiveKey(txID))
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to get transaction archive : %w", err),
+			errors.SeverityError,
+			id,
+		)
+	}
+	if len(raw) == 0 {
+		return nil, errors.E(op, errors.CodeNotFound, fmt.Errorf("transaction archive not found"), errors.SeverityDebug, id)
+	}
+	var summary model.TxArchive
+	err = json.Unmarshal(raw, &summary)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("invalid transaction archive : %w", err),
+			errors.SeverityError,
+			id,
+		)
+	}
+	return &summary, nil
+}
+
+// setArchiveEvent : sets event carrying the payload
+// of every transaction archived by the request
+func setArchiveEvent(stub shim.ChaincodeStubInterface, archived []model.ArchivedTx) error {
+	const op = errors.Op("Archive.setArchiveEvent")
+	if len(archived) == 0 {
+		return nil
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if config.DisableEvents {
+		return nil
+	}
+	raw, err := json.Marshal(archived)
+	if err != nil {
+		return errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to encode archive event : %w", err),
+			errors.SeverityError,
+		)
+	}
+	err = stub.SetEvent(archiveEventName, raw)
+	if err != nil {
+		return errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to set archive event : %w", err),
+			errors.SeverityError,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"datalock/model"
+	"datalock/pkg/endorsement"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchiveFinished(t *testing.T) {
+	is := assert.New(t)
+	n := endorsement.NewNetwork("emissions-data", "Org1")
+	n.Deploy(dataLockCCName, New(WithConfigSource(adminConfig)))
+	n.Deploy(keysCCName, keysCC{})
+
+	admin := testCreator(t, "Org1MSP", "admin")
+	step := 0
+	now := int64(1000)
+	submit := func(args ...string) pb.Response {
+		step++
+		p := endorsement.NewProposal(fmt.Sprintf("step-%d", step), dataLockCCName, args...)
+		p.Timestamp = &timestamp.Timestamp{Seconds: now}
+		p.Creator = admin
+		resp, _ := n.Submit(p)
+		return resp
+	}
+	stage := func(txID string, last bool, locks, free []string) {
+		input := model.StageUpdateInput{TxID: txID, Name: fmt.Sprintf("stage-%d", step), IsLast: last, Storage: map[string]string{"k": "v"}}
+		if len(locks) != 0 {
+			input.DataLocks = map[string]model.DataChaincodeInput{keysCCName: {Keys: locks, Params: append([]string{"lock"}, locks...)}}
+		}
+		if len(free) != 0 {
+			input.DataFree = map[string]model.DataChaincodeInput{keysCCName: {Keys: free, Params: append([]string{"free"}, free...)}}
+		}
+		raw, _ := json.Marshal(input)
+		resp := submit("stageUpdate", string(raw))
+		is.Equal(int32(shim.OK), resp.Status, resp.Message)
+	}
+	archive := func(input model.ArchiveInput) model.ArchiveOutput {
+		raw, _ := json.Marshal(input)
+		resp := submit("archiveFinished", string(raw))
+		is.Equal(int32(shim.OK), resp.Status, resp.Message)
+		var out model.ArchiveOutput
+		is.NoError(json.Unmarshal(resp.Payload, &out))
+		return out
+	}
+	// archiveAll : pages through every key
+	archiveAll := func(limit int) (archived, kept []string) {
+		input := model.ArchiveInput{Retention: 600, Limit: limit}
+		for {
+			out := archive(input)
+			archived = append(archived, out.Archived...)
+			kept = append(kept, out.Kept...)
+			if out.Bookmark == "" {
+				return archived, kept
+			}
+			input.Bookmark = out.Bookmark
+		}
+	}
+
+	submit("startTransitionProcess", "txID-1")
+	stage("txID-1", false, []string{"key-1"}, nil)
+	stage("txID-1", true, nil, []string{"key-1"})
+	submit("startTransitionProcess", "txID-2")
+	stage("txID-2", true, []string{"key-2"}, nil)
+	submit("startTransitionProcess", "txID-3")
+	submit("abortTransitionProcess", "txID-3")
+	submit("startTransitionProcess", "txID-4")
+	submit("startTransitionProcess", "parent-1")
+	submit("startChildTransition", "parent-1", "child-1")
+	submit("abortTransitionProcess", "child-1")
+	stage("parent-1", true, nil, nil)
+	now = 1900
+	submit("startTransitionProcess", "txID-5")
+	stage("txID-5", true, nil, nil)
+
+	now = 2000
+	t.Run("archive", func(t *testing.T) {
+		archived, kept := archiveAll(3)
+		is.Equal([]string{"child-1", "txID-1", "txID-3"}, archived)
+		// parent-1 still has the index entry of child-1
+		is.Equal([]string{"parent-1", "txID-2"}, kept)
+
+		events := n.Events()
+		is.Equal(archiveEventName, events[len(events)-1].EventName)
+
+		is.Empty(n.GetState(dataLockCCName, "txID-1"))
+		is.Empty(n.GetState(dataLockCCName, stageDataKey("txID-1", "stage-2")))
+		is.NotEmpty(n.GetState(dataLockCCName, "txID-4"))
+		is.NotEmpty(n.GetState(dataLockCCName, "txID-5"))
+
+		resp := n.Evaluate(endorsement.NewProposal("query", dataLockCCName, "getTxDetails", "txID-1"))
+		is.Equal(int32(404), resp.Status)
+	})
+
+	t.Run("event", func(t *testing.T) {
+		var entries []model.ArchivedTx
+		for _, e := range n.Events() {
+			if e.EventName == archiveEventName {
+				var page []model.ArchivedTx
+				is.NoError(json.Unmarshal(e.Payload, &page))
+				entries = append(entries, page...)
+			}
+		}
+		is.Len(entries, 3)
+		for _, entry := range entries {
+			sum := sha256.Sum256(entry.Payload)
+			is.Equal(hex.EncodeToString(sum[:]), entry.Archive.PayloadHash)
+			var tx model.Transaction
+			is.NoError(json.Unmarshal(entry.Payload, &tx))
+			is.Equal(entry.Archive.TxID, tx.TxID)
+		}
+		is.Equal([]string{"stage-1", "stage-2"}, entries[1].Archive.Stages)
+
+		resp := n.Evaluate(endorsement.NewProposal("query", dataLockCCName, "getTxArchive", "txID-1"))
+		is.Equal(int32(shim.OK), resp.Status, resp.Message)
+		var summary model.TxArchive
+		is.NoError(json.Unmarshal(resp.Payload, &summary))
+		is.Equal(entries[1].Archive, summary)
+		is.Equal(model.TxStateFINISHED, summary.State)
+		is.Equal(int64(1000), summary.EndedAt)
+		is.Equal(int64(2000), summary.ArchivedAt)
+	})
+
+	t.Run("second-pass", func(t *testing.T) {
+		archived, kept := archiveAll(0)
+		is.Equal([]string{"parent-1"}, archived)
+		is.Equal([]string{"txID-2"}, kept)
+
+		// no lock index points at an archived transaction
+		view := readLedger(n)
+		is.NoError(checkLockIndex(view))
+		for txID := range view.index {
+			is.Contains(view.txs, txID)
+		}
+	})
+
+	t.Run("reuse", func(t *testing.T) {
+		resp := submit("startTransitionProcess", "txID-1")
+		is.Equal(int32(409), resp.Status)
+		is.Contains(resp.Message, "transaction was archived")
+		resp = submit("startChildTransition", "txID-4", "txID-3")
+		is.Equal(int32(409), resp.Status)
+		is.Contains(resp.Message, "transaction was archived")
+		is.Empty(n.GetState(dataLockCCName, "txID-1"))
+	})
+
+	t.Run("not-admin", func(t *testing.T) {
+		p := endorsement.NewProposal("not-admin", dataLockCCName, "archiveFinished", `{}`)
+		p.Creator = testCreator(t, "Org2MSP", "user")
+		resp, _ := n.Submit(p)
+		is.Equal(int32(409), resp.Status)
+		is.Contains(resp.Message, "caller of MSP = Org2MSP is not admin")
+	})
+
+	t.Run("invalid-input", func(t *testing.T) {
+		resp := submit("archiveFinished", `{"retention":-1}`)
+		is.Equal(int32(400), resp.Status)
+	})
+}
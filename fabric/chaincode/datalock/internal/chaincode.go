@@ -3,33 +3,241 @@ package internal
 import (
 	"datalock/pkg/errors"
 	"datalock/pkg/logger"
+	"datalock/pkg/metrics"
+	"encoding/json"
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/hyperledger/fabric-chaincode-go/shim"
 	pb "github.com/hyperledger/fabric-protos-go/peer"
 )
 
-// DataLockChaincode : implements fabric
-// chaincode interface
-type DataLockChaincode struct{}
+// DataLockChaincode : implements fabric chaincode interface.
+// It serves the datalock methods with a logger configured from
+// the environment, no metrics, the transaction timestamp and
+// the on-chain configuration, unless New is given others
+type DataLockChaincode struct {
+	log     *logger.Logger
+	clock   Clock
+	config  ConfigSource
+	metrics metrics.Interface
+	methods Methods
+	prefix  string
+	// bootstrapMSP : MSP whose clients may call bootstrapConfig
+	bootstrapMSP string
+}
+
+type Option func(*DataLockChaincode)
+
+// New : creates chaincode serving the datalock methods,
+// with defaults for the dependencies opts don't set
+func New(opts ...Option) *DataLockChaincode {
+	c := &DataLockChaincode{methods: defaultMethods()}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c.withDefaults()
+}
+
+// WithLogger : logs requests with log instead of a
+// logger configured from the environment
+func WithLogger(log *logger.Logger) Option {
+	return func(c *DataLockChaincode) { c.log = log }
+}
+
+// WithClock : reads the time of requests from clock,
+// which must give the same time on every endorsing peer
+func WithClock(clock Clock) Option {
+	return func(c *DataLockChaincode) { c.clock = clock }
+}
+
+// WithConfigSource : reads the configuration from
+// source instead of the ledger
+func WithConfigSource(source ConfigSource) Option {
+	return func(c *DataLockChaincode) { c.config = source }
+}
+
+// WithMetrics : records metrics in m
+func WithMetrics(m metrics.Interface) Option {
+	return func(c *DataLockChaincode) { c.metrics = m }
+}
+
+// WithMethods : adds methods, replacing
+// datalock methods of the same name
+func WithMethods(methods Methods) Option {
+	return func(c *DataLockChaincode) {
+		if c.methods == nil {
+			c.methods = defaultMethods()
+		}
+		for name, method := range methods {
+			c.methods[name] = method
+		}
+	}
+}
+
+// WithPrefix : serves methods under prefix, e.g.
+// datalock.stageUpdate, to mount them in a chaincode
+// having methods of its own
+func WithPrefix(prefix string) Option {
+	return func(c *DataLockChaincode) { c.prefix = prefix }
+}
+
+// WithBootstrapMSP : lets clients of mspID bootstrap the
+// configuration, instead of DATALOCK_BOOTSTRAP_MSP
+func WithBootstrapMSP(mspID string) Option {
+	return func(c *DataLockChaincode) { c.bootstrapMSP = mspID }
+}
+
+// Handles : true if function is a method served by c,
+// for a chaincode mounting c to route its requests
+func (c *DataLockChaincode) Handles(function string) bool {
+	_, ok := c.method(function)
+	return ok
+}
+
+func (c *DataLockChaincode) method(function string) (Method, bool) {
+	name, ok := strings.CutPrefix(function, c.prefix)
+	if !ok {
+		return nil, false
+	}
+	methods := c.methods
+	if methods == nil {
+		methods = defaultMethods()
+	}
+	method, ok := methods[name]
+	return method, ok
+}
 
+// withDefaults : copy of c with defaults for the
+// dependencies it wasn't given, c being built by New
+// or the zero value
+func (c *DataLockChaincode) withDefaults() *DataLockChaincode {
+	out := *c
+	if out.log == nil {
+		out.log = logger.New(logger.ConfigFromEnv())
+	}
+	if out.clock == nil {
+		out.clock = ClockFunc(txClock)
+	}
+	if out.config == nil {
+		out.config = ConfigFunc(ledgerConfig)
+	}
+	if out.metrics == nil {
+		out.metrics = metrics.Nop{}
+	}
+	if out.bootstrapMSP == "" {
+		out.bootstrapMSP = os.Getenv("DATALOCK_BOOTSTRAP_MSP")
+	}
+	return &out
+}
+
+// requestStub : carries the dependencies of the
+// chaincode down to the methods serving a request
+type requestStub struct {
+	shim.ChaincodeStubInterface
+	cc *DataLockChaincode
+}
+
+// chaincodeOf : chaincode serving the request of stub,
+// the zero value with defaults if none
+func chaincodeOf(stub shim.ChaincodeStubInterface) *DataLockChaincode {
+	switch s := stub.(type) {
+	case requestStub:
+		return s.cc
+	case dryRunStub:
+		return s.cc
+	default:
+		return (&DataLockChaincode{}).withDefaults()
+	}
+}
+
+// mounted : true if datalock serves stub under a prefix,
+// sharing the namespace of a host chaincode
+func mounted(stub shim.ChaincodeStubInterface) bool {
+	return chaincodeOf(stub).prefix != ""
+}
+
+// Init : bootstraps the configuration if given as
+// argument, e.g. {"Args":["init","<config json>"]}
 func (c *DataLockChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
-	return shim.Success(nil)
+	const op = errors.Op("DataLockChaincode.Init")
+	_, args := stub.GetFunctionAndParameters()
+	if len(args) == 0 {
+		return shim.Success(nil)
+	}
+	cc := c.withDefaults()
+	stub = requestStub{stub, cc}
+	log := cc.log.ForRequest(logger.Fields{
+		"fabricTxID": stub.GetTxID(),
+		"channel":    stub.GetChannelID(),
+		"method":     "init",
+	})
+	raw, err := initConfig(stub, args)
+	if err != nil {
+		err = errors.E(op, err)
+		log.SystemErr(err)
+		return errorResponse(err)
+	}
+	return shim.Success(raw)
 }
 
 func (c *DataLockChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	const op = errors.Op("DataLockChaincode.Invoke")
 	methodName, args := stub.GetFunctionAndParameters()
-	method, ok := methodMap[methodName]
+	cc := c.withDefaults()
+	stub = requestStub{stub, cc}
+	log := cc.log.ForRequest(logger.Fields{
+		"fabricTxID": stub.GetTxID(),
+		"channel":    stub.GetChannelID(),
+		"method":     methodName,
+		"txID":       requestTxID(args),
+	})
+	log.Debugf("request received")
+
+	method, ok := c.method(methodName)
 	if !ok {
-		err := fmt.Errorf("method not supported")
-		logger.SystemErr(methodName, errors.E(op, err, errors.SeverityDebug, errors.CodeInvalidInput))
-		return shim.Error(err.Error())
+		err := errors.E(op, fmt.Errorf("method not supported"), errors.SeverityDebug, errors.CodeInvalidInput)
+		log.SystemErr(err)
+		resp := errorResponse(err)
+		log.Debugf("response status = %d", resp.Status)
+		return resp
 	}
-	resp, err := method(stub, args)
+	raw, err := method(stub, args)
 	if err != nil {
-		logger.SystemErr(methodName, errors.E(op, err))
-		return shim.Error(err.Error())
+		err = errors.E(op, err)
+		log.SystemErr(err)
+		resp := errorResponse(err)
+		log.Debugf("response status = %d", resp.Status)
+		return resp
+	}
+	log.Debugf("response status = %d", shim.OK)
+	return shim.Success(raw)
+}
+
+// requestTxID : datalock txID of the request, used to correlate
+// log lines. Methods take it either as first argument or
+// as tx_id of the json input
+func requestTxID(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	var input struct {
+		TxID string `json:"tx_id"`
+	}
+	if json.Unmarshal([]byte(args[0]), &input) == nil {
+		return input.TxID
+	}
+	return args[0]
+}
+
+// errorResponse : fabric response with status taken from
+// error code and message as json encoded errors.Payload
+func errorResponse(err error) pb.Response {
+	// payload only holds strings and numbers, encoding can't fail
+	raw, _ := json.Marshal(errors.NewPayload(err))
+	return pb.Response{
+		Status:  errors.Status(err),
+		Message: string(raw),
 	}
-	return shim.Success(resp)
 }
@@ -0,0 +1,224 @@
+package internal
+
+import (
+	"bytes"
+	"datalock/mock"
+	"datalock/model"
+	"datalock/pkg/endorsement"
+	"datalock/pkg/errors"
+	"datalock/pkg/logger"
+	"datalock/pkg/metrics"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorResponse(t *testing.T) {
+	is := assert.New(t)
+	emCCName := "EmissionsCC"
+	emStub := shimtest.NewMockStub(emCCName, mock.MockEmissionsCC{})
+	loadMockEmissions(emStub)
+
+	txStub := shimtest.NewMockStub("dataLockCC", &DataLockChaincode{})
+	txStub.Invokables[emCCName] = emStub
+
+	const mockID = "mockID"
+	payload := func(message string) errors.Payload {
+		var p errors.Payload
+		is.NoError(json.Unmarshal([]byte(message), &p))
+		return p
+	}
+
+	t.Run("MethodNotSupported", func(t *testing.T) {
+		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"methodNotSupported"}))
+		is.Equal(int32(errors.CodeInvalidInput), resp.Status)
+		p := payload(resp.Message)
+		is.Equal("INVALID_INPUT", p.ID)
+		is.Equal("method not supported", p.Message)
+	})
+
+	t.Run("InvalidInput", func(t *testing.T) {
+		raw, _ := json.Marshal(model.StageUpdateInput{})
+		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"stageUpdate", string(raw)}))
+		is.Equal(int32(errors.CodeInvalidInput), resp.Status)
+		p := payload(resp.Message)
+		is.Equal([]errors.Field{"tx_id", "name"}, p.Fields)
+	})
+
+	t.Run("Locked", func(t *testing.T) {
+		txStub.MockInvoke(mockID, stringArgsToByte([]string{"startTransitionProcess", "txID-1"}))
+		txStub.MockInvoke(mockID, stringArgsToByte([]string{"startTransitionProcess", "txID-2"}))
+		txStub.MockTransactionStart(mockID)
+		putLockState(txStub, "txID-1", emCCName, "uuid-1")
+		txStub.MockTransactionEnd(mockID)
+
+		raw, _ := json.Marshal(model.StageUpdateInput{
+			TxID: "txID-2",
+			Name: "GetValidEmissions",
+			DataLocks: map[string]model.DataChaincodeInput{
+				emCCName: {
+					Keys:   []string{"uuid-1"},
+					Params: []string{"getValidEmissions", "uuid-1"},
+				},
+			},
+		})
+		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"stageUpdate", string(raw)}))
+		is.Equal(int32(errors.CodeConflict), resp.Status)
+		p := payload(resp.Message)
+		is.Equal(errors.CodeConflict, p.Code)
+		is.Equal("LOCKED", p.ID)
+		is.Equal(errors.Chaincode(emCCName), p.Chaincode)
+		is.Equal(errors.TxID("txID-2"), p.TxID)
+		is.Equal([]errors.Op{"DataLockChaincode.Invoke", "Method.stageUpdate", "Locker.lock"}, p.Ops)
+	})
+}
+
+func TestRequestTxID(t *testing.T) {
+	is := assert.New(t)
+	is.Equal("", requestTxID(nil))
+	is.Equal("txID-1", requestTxID([]string{"txID-1"}))
+	is.Equal("txID-1", requestTxID([]string{`{"tx_id":"txID-1","name":"stage"}`}))
+	is.Equal("", requestTxID([]string{`{"name":"stage"}`}))
+	is.Equal("123", requestTxID([]string{"123"}))
+}
+
+func TestOptions(t *testing.T) {
+	is := assert.New(t)
+	buf := new(bytes.Buffer)
+	registry := metrics.NewRegistry()
+	config := &model.Config{MaxKeys: 8, MaxStages: 1, Chaincodes: []string{}}
+	cc := New(
+		WithPrefix("datalock."),
+		WithLogger(logger.New(logger.Config{Level: "debug", Output: buf})),
+		WithClock(ClockFunc(func(shim.ChaincodeStubInterface) (int64, error) { return 4242, nil })),
+		WithConfigSource(ConfigFunc(func(shim.ChaincodeStubInterface) (*model.Config, error) { return config, nil })),
+		WithMetrics(registry),
+		WithMethods(Methods{"ping": func(shim.ChaincodeStubInterface, []string) ([]byte, error) { return []byte("pong"), nil }}),
+	)
+	stub := shimtest.NewMockStub("dataLockCC", cc)
+	stub.Creator = testCreator(t, "Org1MSP", "owner")
+	invoke := func(args ...string) (int32, []byte) {
+		resp := stub.MockInvoke("mockID", stringArgsToByte(args))
+		return resp.Status, resp.Payload
+	}
+
+	t.Run("prefix", func(t *testing.T) {
+		is.True(cc.Handles("datalock.ping"))
+		is.True(cc.Handles("datalock.stageUpdate"))
+		is.False(cc.Handles("stageUpdate"))
+		status, _ := invoke("startTransitionProcess", "txID-1")
+		is.Equal(int32(errors.CodeInvalidInput), status)
+		status, payload := invoke("datalock.ping")
+		is.Equal(int32(shim.OK), status)
+		is.Equal("pong", string(payload))
+	})
+
+	t.Run("clock", func(t *testing.T) {
+		status, _ := invoke("datalock.startTransitionProcess", "txID-1")
+		is.Equal(int32(shim.OK), status)
+		status, _ = invoke("datalock.abortTransitionProcess", "txID-1")
+		is.Equal(int32(shim.OK), status)
+		_, payload := invoke("datalock.getTxDetails", "txID-1")
+		var tx model.Transaction
+		is.NoError(json.Unmarshal(payload, &tx))
+		is.Equal(int64(4242), tx.AbortedAt)
+	})
+
+	t.Run("config-source", func(t *testing.T) {
+		_, payload := invoke("datalock.getConfig")
+		var got model.Config
+		is.NoError(json.Unmarshal(payload, &got))
+		is.Equal(*config, got)
+
+		invoke("datalock.startTransitionProcess", "txID-2")
+		raw, _ := json.Marshal(model.StageUpdateInput{TxID: "txID-2", Name: "stage-1"})
+		status, _ := invoke("datalock.stageUpdate", string(raw))
+		is.Equal(int32(shim.OK), status)
+		raw, _ = json.Marshal(model.StageUpdateInput{TxID: "txID-2", Name: "stage-2"})
+		status, _ = invoke("datalock.stageUpdate", string(raw))
+		is.Equal(int32(errors.CodeConflict), status)
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		out := new(bytes.Buffer)
+		is.NoError(registry.WriteText(out))
+		is.Contains(out.String(), `datalock_stage_updates_total{outcome="success"} 1`)
+		is.Contains(out.String(), `datalock_stage_updates_total{outcome="error"} 1`)
+	})
+
+	t.Run("logger", func(t *testing.T) {
+		is.Contains(buf.String(), `"method":"datalock.stageUpdate"`)
+		is.Contains(buf.String(), `"txID":"txID-2"`)
+	})
+}
+
+// TestHostKeys : mounted under a prefix, datalock shares the
+// namespace of the host chaincode and leaves its keys alone
+func TestHostKeys(t *testing.T) {
+	is := assert.New(t)
+	n := endorsement.NewNetwork("emissions-data", "Org1")
+	n.Deploy(dataLockCCName, New(WithPrefix("datalock."), WithConfigSource(adminConfig)))
+	n.Deploy(keysCCName, keysCC{})
+	host := map[string][]byte{"txID-1": []byte("host"), keysCCName + "::key-1": []byte("host")}
+	n.Seed(dataLockCCName, host)
+
+	admin := testCreator(t, "Org1MSP", "admin")
+	step := 0
+	submit := func(args ...string) pb.Response {
+		step++
+		p := endorsement.NewProposal(fmt.Sprintf("step-%d", step), dataLockCCName, args...)
+		p.Timestamp = &timestamp.Timestamp{Seconds: 1000}
+		p.Creator = admin
+		resp, _ := n.Submit(p)
+		is.Equal(int32(shim.OK), resp.Status, resp.Message)
+		return resp
+	}
+	checkLocks := func() string {
+		raw, _ := json.Marshal(model.CheckLocksInput{Chaincode: keysCCName, Keys: []string{"key-1"}})
+		return string(submit("datalock.checkLocks", string(raw)).Payload)
+	}
+
+	t.Run("transitions", func(t *testing.T) {
+		is.JSONEq(`{"locks":[{"key":"key-1"}],"allowed":true}`, checkLocks())
+		submit("datalock.startTransitionProcess", "txID-1")
+		raw, _ := json.Marshal(model.StageUpdateInput{
+			TxID:      "txID-1",
+			Name:      "lock",
+			DataLocks: map[string]model.DataChaincodeInput{keysCCName: {Keys: []string{"key-1"}, Params: []string{"lock", "key-1"}}},
+		})
+		submit("datalock.stageUpdate", string(raw))
+		is.JSONEq(`{"locks":[{"key":"key-1","tx_id":"txID-1","fence":1}],"allowed":false,"reason":"key = key-1 locked by txID = txID-1"}`, checkLocks())
+		is.NotEmpty(n.GetState(dataLockCCName, txKeyPrefix+"txID-1"))
+
+		raw, _ = json.Marshal(model.StageUpdateInput{
+			TxID:     "txID-1",
+			Name:     "free",
+			IsLast:   true,
+			DataFree: map[string]model.DataChaincodeInput{keysCCName: {Keys: []string{"key-1"}, Params: []string{"free", "key-1"}}},
+		})
+		submit("datalock.stageUpdate", string(raw))
+		submit("datalock.startTransitionProcess", "txID-2")
+		submit("datalock.abortTransitionProcess", "txID-2")
+	})
+
+	t.Run("archive", func(t *testing.T) {
+		var out model.ArchiveOutput
+		is.NoError(json.Unmarshal(submit("datalock.archiveFinished", `{"retention":0,"limit":1}`).Payload, &out))
+		is.Equal([]string{"txID-1"}, out.Archived)
+		is.Equal("txID-2", out.Bookmark)
+		raw, _ := json.Marshal(model.ArchiveInput{Bookmark: out.Bookmark, Limit: 1})
+		is.NoError(json.Unmarshal(submit("datalock.archiveFinished", string(raw)).Payload, &out))
+		is.Equal([]string{"txID-2"}, out.Archived)
+		is.Empty(out.Bookmark)
+	})
+
+	for key, value := range host {
+		is.Equal(value, n.GetState(dataLockCCName, key), key)
+	}
+}
@@ -0,0 +1,301 @@
+package internal
+
+import (
+	"datalock/model"
+	"datalock/pkg/canonical"
+	"datalock/pkg/errors"
+	"datalock/validation"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// The configuration is bootstrapped once, by Init or
+// bootstrapConfig, then changed by admins with setConfig. Every
+// version is kept, the current one under its own key. Until
+// bootstrapped, defaults apply and admin methods are closed.
+// bootstrapConfig is only open to the bootstrap MSP fixed at
+// deploy time, so that nobody can make itself admin first.
+
+const (
+	configObj        = "datalock~config"
+	configVersionObj = "config~version"
+)
+
+func configKey() string {
+	key, _ := shim.CreateCompositeKey(configObj, []string{})
+	return key
+}
+
+func configVersionKey(version uint64) string {
+	key, _ := shim.CreateCompositeKey(configVersionObj, []string{strconv.FormatUint(version, 10)})
+	return key
+}
+
+// defaultConfig : applies until datalock is bootstrapped
+func defaultConfig() *model.Config {
+	return &model.Config{
+		Admins:       []string{},
+		DefaultLease: model.DefaultQueueGrace,
+		MaxKeys:      validation.MaxKeys,
+		Chaincodes:   []string{},
+	}
+}
+
+// ConfigSource : configuration of datalock. Methods
+// changing the configuration only change the ledger
+// source, the default
+type ConfigSource interface {
+	Config(stub shim.ChaincodeStubInterface) (*model.Config, error)
+}
+
+type ConfigFunc func(stub shim.ChaincodeStubInterface) (*model.Config, error)
+
+func (f ConfigFunc) Config(stub shim.ChaincodeStubInterface) (*model.Config, error) {
+	return f(stub)
+}
+
+// getConfig : configuration read from the
+// source of the chaincode
+func getConfig(stub shim.ChaincodeStubInterface) (*model.Config, error) {
+	const op = errors.Op("Config.getConfig")
+	config, err := chaincodeOf(stub).config.Config(stub)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return config, nil
+}
+
+// ledgerConfig : current configuration on the
+// ledger, defaults if not bootstrapped
+func ledgerConfig(stub shim.ChaincodeStubInterface) (*model.Config, error) {
+	const op = errors.Op("Config.ledgerConfig")
+	config, err := readConfig(stub, configKey())
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	if config == nil {
+		return defaultConfig(), nil
+	}
+	return config, nil
+}
+
+// getConfigVersion : configuration at version
+func getConfigVersion(stub shim.ChaincodeStubInterface, version uint64) (*model.Config, error) {
+	const op = errors.Op("Config.getConfigVersion")
+	config, err := readConfig(stub, configVersionKey(version))
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	if config == nil {
+		return nil, errors.E(op, errors.CodeNotFound, fmt.Errorf("config version = %d not found", version), errors.SeverityDebug)
+	}
+	return config, nil
+}
+
+func readConfig(stub shim.ChaincodeStubInterface, key string) (*model.Config, error) {
+	const op = errors.Op("Config.readConfig")
+	raw, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to get config : %w", err),
+			errors.SeverityError,
+		)
+	}
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var config model.Config
+	err = json.Unmarshal(raw, &config)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("invalid config : %w", err),
+			errors.SeverityError,
+		)
+	}
+	return &config, nil
+}
+
+// putConfig : stores config as the current configuration and
+// under its version, limits left to 0 are set to their default
+func putConfig(stub shim.ChaincodeStubInterface, config *model.Config) error {
+	const op = errors.Op("Config.putConfig")
+	defaults := defaultConfig()
+	if config.DefaultLease == 0 {
+		config.DefaultLease = defaults.DefaultLease
+	}
+	if config.MaxKeys == 0 {
+		config.MaxKeys = defaults.MaxKeys
+	}
+	if config.Chaincodes == nil {
+		config.Chaincodes = []string{}
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	config.UpdatedAt = now
+	config.UpdatedBy, err = callerIdentity(stub)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	raw, err := canonical.Marshal(config)
+	if err != nil {
+		return errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to encode config : %w", err),
+			errors.SeverityError,
+		)
+	}
+	for _, key := range []string{configKey(), configVersionKey(config.Version)} {
+		err = stub.PutState(key, raw)
+		if err != nil {
+			return errors.E(
+				op,
+				errors.CodeUnexpected,
+				fmt.Errorf("failed to put config : %w", err),
+				errors.SeverityError,
+			)
+		}
+	}
+	return nil
+}
+
+// checkAdmin : error unless the caller belongs to an admin
+// MSP of config. Nobody is admin until bootstrapped
+func checkAdmin(stub shim.ChaincodeStubInterface, config *model.Config) error {
+	const op = errors.Op("Config.checkAdmin")
+	if len(config.Admins) == 0 {
+		return errors.E(op, errors.CodeConflict, fmt.Errorf("datalock is not configured yet"), errors.SeverityDebug)
+	}
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if caller == nil {
+		return errors.E(op, errors.CodeConflict, fmt.Errorf("caller has no identity"), errors.SeverityDebug)
+	}
+	if !slices.Contains(config.Admins, caller.MSPID) {
+		return errors.E(
+			op,
+			errors.CodeConflict,
+			fmt.Errorf("caller of MSP = %s is not admin", caller.MSPID),
+			errors.SeverityDebug,
+		)
+	}
+	return nil
+}
+
+// bootstrapTxConfig : sets the first configuration, whose
+// admins must include the caller, of the bootstrap MSP if set
+func bootstrapTxConfig(stub shim.ChaincodeStubInterface, config model.Config) (*model.Config, error) {
+	const op = errors.Op("Config.bootstrapTxConfig")
+	stored, err := readConfig(stub, configKey())
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	if stored != nil {
+		return nil, errors.E(op, errors.CodeConflict, fmt.Errorf("datalock is already configured"), errors.SeverityDebug)
+	}
+	if msp := chaincodeOf(stub).bootstrapMSP; msp != "" {
+		err = checkAdmin(stub, &model.Config{Admins: []string{msp}})
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+	err = checkAdmin(stub, &config)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	config.Version = 1
+	err = putConfig(stub, &config)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return &config, nil
+}
+
+// updateConfig : replaces the configuration, config.Version must
+// be the current version. Admins, old and new, must include the
+// caller, so that admins can't lock themselves out
+func updateConfig(stub shim.ChaincodeStubInterface, config model.Config) (*model.Config, error) {
+	const op = errors.Op("Config.updateConfig")
+	current, err := readConfig(stub, configKey())
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	if current == nil {
+		return nil, errors.E(op, errors.CodeConflict, fmt.Errorf("datalock is not configured yet"), errors.SeverityDebug)
+	}
+	for _, c := range []*model.Config{current, &config} {
+		err = checkAdmin(stub, c)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+	if config.Version != current.Version {
+		return nil, errors.E(
+			op,
+			errors.CodeConflict,
+			fmt.Errorf("config version = %d is not the current version = %d", config.Version, current.Version),
+			errors.SeverityDebug,
+		)
+	}
+	config.Version++
+	err = putConfig(stub, &config)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return &config, nil
+}
+
+// checkStageConfig : checks data chaincodes and keys of the stage
+// update, and the number of stage updates of tx, against config
+func checkStageConfig(config *model.Config, tx *model.Transaction, input model.StageUpdateInput) error {
+	const op = errors.Op("Config.checkStageConfig")
+	id := errors.TxID(tx.TxID)
+	if config.MaxStages != 0 && tx.StageUpdates >= config.MaxStages {
+		return errors.E(
+			op,
+			errors.CodeConflict,
+			fmt.Errorf("transition already has %d stage updates", tx.StageUpdates),
+			errors.SeverityDebug,
+			id,
+		)
+	}
+	for _, ccInputs := range []map[string]model.DataChaincodeInput{input.DataLocks, input.DataFree} {
+		for _, cc := range slices.Sorted(maps.Keys(ccInputs)) {
+			ccInput := ccInputs[cc]
+			if len(config.Chaincodes) != 0 && !slices.Contains(config.Chaincodes, cc) {
+				return errors.E(
+					op,
+					errors.CodeInvalidInput,
+					fmt.Errorf("data chaincode = %s is not allowed", cc),
+					errors.SeverityDebug,
+					id,
+					errors.Chaincode(cc),
+				)
+			}
+			if len(ccInput.Keys) > config.MaxKeys {
+				return errors.E(
+					op,
+					errors.CodeInvalidInput,
+					fmt.Errorf("more than %d keys", config.MaxKeys),
+					errors.SeverityDebug,
+					id,
+					errors.Chaincode(cc),
+				)
+			}
+		}
+	}
+	return nil
+}
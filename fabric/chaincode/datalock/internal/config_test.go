@@ -0,0 +1,204 @@
+package internal
+
+import (
+	"datalock/model"
+	"datalock/pkg/endorsement"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+// adminConfig : defaults, with clients of Org1MSP as admins
+var adminConfig = ConfigFunc(func(shim.ChaincodeStubInterface) (*model.Config, error) {
+	config := defaultConfig()
+	config.Admins = []string{"Org1MSP"}
+	return config, nil
+})
+
+func TestInitConfig(t *testing.T) {
+	is := assert.New(t)
+	stub := shimtest.NewMockStub(dataLockCCName, &DataLockChaincode{})
+	stub.Creator = testCreator(t, "Org1MSP", "admin")
+
+	is.Equal(int32(shim.OK), stub.MockInit("init-0", nil).Status)
+	raw, _ := json.Marshal(model.Config{Admins: []string{"Org1MSP"}, MaxStages: 4})
+	resp := stub.MockInit("init-1", [][]byte{[]byte("init"), raw})
+	is.Equal(int32(shim.OK), resp.Status, resp.Message)
+	var config model.Config
+	is.NoError(json.Unmarshal(resp.Payload, &config))
+	is.Equal(uint64(1), config.Version)
+	is.Equal(int64(model.DefaultQueueGrace), config.DefaultLease)
+	is.Equal(4, config.MaxStages)
+	is.Equal("Org1MSP", config.UpdatedBy.MSPID)
+
+	resp = stub.MockInit("init-2", [][]byte{[]byte("init"), raw})
+	is.Equal(int32(409), resp.Status)
+	is.Contains(resp.Message, "datalock is already configured")
+
+	t.Run("bootstrap-msp", func(t *testing.T) {
+		stub := shimtest.NewMockStub(dataLockCCName, New(WithBootstrapMSP("Org2MSP")))
+		stub.Creator = testCreator(t, "Org1MSP", "admin")
+		resp := stub.MockInit("init-1", [][]byte{[]byte("init"), raw})
+		is.Equal(int32(409), resp.Status)
+		is.Contains(resp.Message, "caller of MSP = Org1MSP is not admin")
+	})
+	t.Run("bootstrap-disabled", func(t *testing.T) {
+		stub := shimtest.NewMockStub(dataLockCCName, &DataLockChaincode{})
+		stub.Creator = testCreator(t, "Org1MSP", "admin")
+		resp := stub.MockInvoke("invoke-1", [][]byte{[]byte("bootstrapConfig"), raw})
+		is.Equal(int32(409), resp.Status)
+		is.Contains(resp.Message, "bootstrapConfig is disabled")
+	})
+}
+
+func TestConfig(t *testing.T) {
+	is := assert.New(t)
+	n := endorsement.NewNetwork("emissions-data", "Org1")
+	n.Deploy(dataLockCCName, New(WithBootstrapMSP("Org1MSP")))
+	n.Deploy(keysCCName, keysCC{})
+
+	admin := testCreator(t, "Org1MSP", "admin")
+	user := testCreator(t, "Org2MSP", "user")
+	step := 0
+	submit := func(creator []byte, args ...string) pb.Response {
+		step++
+		p := endorsement.NewProposal(fmt.Sprintf("step-%d", step), dataLockCCName, args...)
+		p.Creator = creator
+		resp, _ := n.Submit(p)
+		return resp
+	}
+	configure := func(creator []byte, method string, config model.Config) pb.Response {
+		raw, _ := json.Marshal(config)
+		return submit(creator, method, string(raw))
+	}
+	stage := func(txID, cc, method string, keys ...string) pb.Response {
+		input := model.StageUpdateInput{TxID: txID, Name: fmt.Sprintf("stage-%d", step)}
+		ccInput := map[string]model.DataChaincodeInput{cc: {Keys: keys, Params: append([]string{method}, keys...)}}
+		if method == "lock" {
+			input.DataLocks = ccInput
+		} else {
+			input.DataFree = ccInput
+		}
+		raw, _ := json.Marshal(input)
+		return submit(user, "stageUpdate", string(raw))
+	}
+	current := func(args ...string) (model.Config, pb.Response) {
+		resp := n.Evaluate(endorsement.NewProposal("query", dataLockCCName, append([]string{"getConfig"}, args...)...))
+		var config model.Config
+		json.Unmarshal(resp.Payload, &config)
+		return config, resp
+	}
+	config := model.Config{
+		Admins:       []string{"Org1MSP"},
+		DefaultLease: 60,
+		MaxKeys:      2,
+		MaxStages:    2,
+		Chaincodes:   []string{keysCCName},
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		got, resp := current()
+		is.Equal(int32(shim.OK), resp.Status, resp.Message)
+		is.Equal(uint64(0), got.Version)
+		is.Empty(got.Admins)
+		is.Equal(256, got.MaxKeys)
+
+		// admin methods are closed until bootstrapped
+		resp = submit(admin, "setFinishPolicy", `{"workflow":"issuance","on_locks":"reject"}`)
+		is.Equal(int32(409), resp.Status)
+		is.Contains(resp.Message, "datalock is not configured yet")
+		is.Equal(int32(409), submit(admin, "archiveFinished", `{}`).Status)
+	})
+
+	t.Run("bootstrap", func(t *testing.T) {
+		// only the bootstrap MSP, even if listed in admins
+		front := config
+		front.Admins = []string{"Org2MSP"}
+		resp := configure(user, "bootstrapConfig", front)
+		is.Equal(int32(409), resp.Status)
+		is.Contains(resp.Message, "caller of MSP = Org2MSP is not admin")
+		resp = configure(user, "bootstrapConfig", config)
+		is.Equal(int32(409), resp.Status)
+		is.Contains(resp.Message, "caller of MSP = Org2MSP is not admin")
+		resp = configure(admin, "bootstrapConfig", config)
+		is.Equal(int32(shim.OK), resp.Status, resp.Message)
+		is.Equal(int32(409), configure(admin, "bootstrapConfig", config).Status)
+		got, _ := current()
+		is.Equal(uint64(1), got.Version)
+		is.Equal(config.Admins, got.Admins)
+	})
+
+	t.Run("admin-methods", func(t *testing.T) {
+		policy := `{"workflow":"issuance","on_locks":"reject"}`
+		is.Equal(int32(409), submit(user, "setFinishPolicy", policy).Status)
+		is.Equal(int32(shim.OK), submit(admin, "setFinishPolicy", policy).Status)
+		is.Equal(int32(409), submit(user, "archiveFinished", `{}`).Status)
+		is.Equal(int32(shim.OK), submit(admin, "archiveFinished", `{}`).Status)
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		submit(user, "startTransitionProcess", "txID-1")
+		resp := stage("txID-1", keysCCName, "lock", "key-1", "key-2", "key-3")
+		is.Equal(int32(400), resp.Status)
+		is.Contains(resp.Message, "more than 2 keys")
+		resp = stage("txID-1", "OtherCC", "lock", "key-1")
+		is.Equal(int32(400), resp.Status)
+		is.Contains(resp.Message, "data chaincode = OtherCC is not allowed")
+
+		is.Equal(int32(shim.OK), stage("txID-1", keysCCName, "lock", "key-1").Status)
+		is.Equal(int32(shim.OK), stage("txID-1", keysCCName, "lock", "key-2").Status)
+		resp = stage("txID-1", keysCCName, "lock", "key-3")
+		is.Equal(int32(409), resp.Status)
+		is.Contains(resp.Message, "transition already has 2 stage updates")
+	})
+
+	t.Run("default-lease", func(t *testing.T) {
+		submit(user, "startTransitionProcess", "txID-2")
+		raw, _ := json.Marshal(model.QueueInput{TxID: "txID-2", Chaincode: keysCCName, Keys: []string{"key-1"}})
+		is.Equal(int32(shim.OK), submit(user, "joinQueue", string(raw)).Status)
+		resp := n.Evaluate(endorsement.NewProposal("query", dataLockCCName, "getQueue", keysCCName, "key-1"))
+		var queue model.KeyQueue
+		is.NoError(json.Unmarshal(resp.Payload, &queue))
+		is.Equal([]model.QueueWaiter{{TxID: "txID-2", Grace: 60}}, queue.Waiters)
+	})
+
+	t.Run("set", func(t *testing.T) {
+		update := config
+		update.MaxStages = 0
+		update.DisableEvents = true
+		resp := configure(admin, "setConfig", update)
+		is.Equal(int32(409), resp.Status)
+		is.Contains(resp.Message, "config version = 0 is not the current version = 1")
+
+		update.Version = 1
+		is.Equal(int32(409), configure(user, "setConfig", update).Status)
+		lockout := update
+		lockout.Admins = []string{"Org2MSP"}
+		is.Equal(int32(409), configure(admin, "setConfig", lockout).Status)
+
+		resp = configure(admin, "setConfig", update)
+		is.Equal(int32(shim.OK), resp.Status, resp.Message)
+		got, _ := current()
+		is.Equal(uint64(2), got.Version)
+		is.Equal(0, got.MaxStages)
+		is.Equal(int32(shim.OK), stage("txID-1", keysCCName, "lock", "key-3").Status)
+
+		// releasing key-1 makes txID-2 eligible, without event
+		events := len(n.Events())
+		is.Equal(int32(shim.OK), stage("txID-1", keysCCName, "free", "key-1").Status)
+		is.Len(n.Events(), events)
+
+		got, resp = current("1")
+		is.Equal(int32(shim.OK), resp.Status, resp.Message)
+		is.Equal(2, got.MaxStages)
+		_, resp = current("9")
+		is.Equal(int32(404), resp.Status)
+		_, resp = current("one")
+		is.Equal(int32(400), resp.Status)
+	})
+}
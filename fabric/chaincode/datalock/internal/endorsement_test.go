@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"datalock/mock"
+	"datalock/model"
+	"datalock/pkg/endorsement"
+	"datalock/pkg/errors"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	dataLockCCName  = "dataLockCC"
+	emissionsCCName = "EmissionsCC"
+)
+
+func newEndorsementNetwork(t *testing.T) *endorsement.Network {
+	n := endorsement.NewNetwork("emissions-data", "Org1", "Org2")
+	n.Deploy(dataLockCCName, &DataLockChaincode{})
+	n.Deploy(emissionsCCName, mock.MockEmissionsCC{})
+	records := map[string][]byte{}
+	for _, em := range mockEmissions {
+		raw, _ := json.Marshal(em)
+		records[em.UUID] = raw
+	}
+	n.Seed(emissionsCCName, records)
+	return n
+}
+
+func lockProposal(txID string, keys ...string) *endorsement.Proposal {
+	raw, _ := json.Marshal(model.StageUpdateInput{
+		TxID: txID,
+		Name: "GetValidEmissions",
+		DataLocks: map[string]model.DataChaincodeInput{
+			emissionsCCName: {
+				Keys:   keys,
+				Params: append([]string{"getValidEmissions"}, keys...),
+			},
+		},
+	})
+	return endorsement.NewProposal("lock-"+txID, dataLockCCName, "stageUpdate", string(raw))
+}
+
+func startTransitions(t *testing.T, n *endorsement.Network, txIDs ...string) {
+	for _, txID := range txIDs {
+		_, code := n.Submit(endorsement.NewProposal("start-"+txID, dataLockCCName, "startTransitionProcess", txID))
+		if code != pb.TxValidationCode_VALID {
+			t.Fatalf("failed to start %s : %s", txID, code)
+		}
+	}
+}
+
+// TestCompetingLocks : two transitions endorsed at the same
+// time against the same emissions key never both commit
+func TestCompetingLocks(t *testing.T) {
+	is := assert.New(t)
+	n := newEndorsementNetwork(t)
+	startTransitions(t, n, "txID-1", "txID-2")
+
+	// both endorsements see uuid-1 free
+	var tx1, tx2 *endorsement.Transaction
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); tx1 = n.Endorse(lockProposal("txID-1", "uuid-1", "uuid-2")) }()
+	go func() { defer wg.Done(); tx2 = n.Endorse(lockProposal("txID-2", "uuid-1", "uuid-3")) }()
+	wg.Wait()
+	is.Less(tx1.Response().Status, int32(400), tx1.Response().Message)
+	is.Less(tx2.Response().Status, int32(400), tx2.Response().Message)
+	is.Contains(tx1.RWSet().Reads[dataLockCCName], lockStateID(emissionsCCName, "uuid-1"))
+
+	is.Equal([]pb.TxValidationCode{
+		pb.TxValidationCode_VALID,
+		pb.TxValidationCode_MVCC_READ_CONFLICT,
+	}, n.Commit(tx1, tx2))
+	is.Equal("txID-1", string(n.GetState(dataLockCCName, lockStateID(emissionsCCName, "uuid-1"))))
+	is.Empty(n.GetState(dataLockCCName, lockStateID(emissionsCCName, "uuid-3")))
+
+	// retried by the loser, endorsement now fails on the lock
+	resp, code := n.Submit(lockProposal("txID-2", "uuid-1", "uuid-3"))
+	is.Equal(int32(errors.CodeConflict), resp.Status)
+	is.Equal(pb.TxValidationCode_ENDORSEMENT_POLICY_FAILURE, code)
+}
+
+// TestCompetingLocksRandom : random transitions competing for
+// overlapping keys, committed in random blocks
+func TestCompetingLocksRandom(t *testing.T) {
+	is := assert.New(t)
+	keys := []string{"uuid-1", "uuid-2", "uuid-3", "uuid-4"}
+
+	for round := int64(0); round < 20; round++ {
+		rnd := rand.New(rand.NewSource(round))
+		n := newEndorsementNetwork(t)
+		txIDs := make([]string, 6)
+		for i := range txIDs {
+			txIDs[i] = fmt.Sprintf("txID-%d", i)
+		}
+		startTransitions(t, n, txIDs...)
+
+		wanted := map[string][]string{}
+		txs := make([]*endorsement.Transaction, len(txIDs))
+		var wg sync.WaitGroup
+		for i, txID := range txIDs {
+			perm := rnd.Perm(len(keys))[:1+rnd.Intn(2)]
+			for _, p := range perm {
+				wanted[txID] = append(wanted[txID], keys[p])
+			}
+			wg.Add(1)
+			go func(i int, txID string, keys []string) {
+				defer wg.Done()
+				txs[i] = n.Endorse(lockProposal(txID, keys...))
+			}(i, txID, wanted[txID])
+		}
+		wg.Wait()
+
+		// commit in random order and block sizes
+		rnd.Shuffle(len(txs), func(i, j int) { txs[i], txs[j] = txs[j], txs[i] })
+		committed := map[string]bool{}
+		for len(txs) != 0 {
+			size := 1 + rnd.Intn(len(txs))
+			for i, code := range n.Commit(txs[:size]...) {
+				if code == pb.TxValidationCode_VALID {
+					var input model.StageUpdateInput
+					json.Unmarshal(txs[i].Proposal.Args[1], &input)
+					committed[input.TxID] = true
+				}
+			}
+			txs = txs[size:]
+		}
+
+		holders := map[string]string{}
+		for txID := range committed {
+			for _, key := range wanted[txID] {
+				is.Empty(holders[key], "round %d : %s locked by %s and %s", round, key, holders[key], txID)
+				holders[key] = txID
+			}
+		}
+		is.NotEmpty(committed, "round %d", round)
+		for _, key := range keys {
+			is.Equal(holders[key], string(n.GetState(dataLockCCName, lockStateID(emissionsCCName, key))), "round %d : %s", round, key)
+		}
+	}
+}
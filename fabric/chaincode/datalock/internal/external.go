@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"datalock/model"
+	"datalock/pkg/errors"
+	"fmt"
+	"strings"
+)
+
+// checkExternalReceipt : receipt must fulfill a commitment
+// made in an earlier stage of the transaction, which has not
+// been fulfilled yet
+func checkExternalReceipt(tx *model.Transaction, stage string, receipt *model.ExternalReceipt) error {
+	const op = errors.Op("External.checkExternalReceipt")
+	id := errors.TxID(tx.TxID)
+
+	if receipt.CommitmentStage == stage {
+		return errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("receipt must fulfill commitment of an earlier stage"),
+			errors.SeverityDebug,
+			id,
+		)
+	}
+	data, ok := tx.StageData[receipt.CommitmentStage]
+	if !ok || data.Commitment == nil {
+		return errors.E(
+			op,
+			errors.CodeNotFound,
+			fmt.Errorf("no commitment found at stage = %s", receipt.CommitmentStage),
+			errors.SeverityDebug,
+			id,
+		)
+	}
+	if name, _ := findReceiptStage(tx, receipt.CommitmentStage); name != "" {
+		return errors.E(
+			op,
+			errors.CodeConflict,
+			fmt.Errorf("commitment of stage = %s already fulfilled at stage = %s", receipt.CommitmentStage, name),
+			errors.SeverityDebug,
+			id,
+		)
+	}
+	if reason := receiptMismatch(data.Commitment, receipt); reason != "" {
+		return errors.E(
+			op,
+			errors.CodeConflict,
+			fmt.Errorf("receipt does not match commitment : %s", reason),
+			errors.SeverityDebug,
+			id,
+		)
+	}
+	return nil
+}
+
+// verifyExternalReceipt : verifies commitment made at given stage
+// against the receipt recorded for it, and against the receipt
+// observed on external ledger if provided
+func verifyExternalReceipt(tx *model.Transaction, stage string, observed *model.ExternalReceipt) model.ExternalVerification {
+	out := model.ExternalVerification{
+		TxID:            tx.TxID,
+		CommitmentStage: stage,
+	}
+	data, ok := tx.StageData[stage]
+	if !ok || data.Commitment == nil {
+		out.Reason = fmt.Sprintf("no commitment found at stage = %s", stage)
+		return out
+	}
+	out.Commitment = data.Commitment
+
+	out.ReceiptStage, out.Receipt = findReceiptStage(tx, stage)
+	if out.Receipt == nil {
+		out.Reason = "commitment not fulfilled yet"
+		return out
+	}
+	if reason := receiptMismatch(out.Commitment, out.Receipt); reason != "" {
+		out.Reason = reason
+		return out
+	}
+	if observed != nil {
+		switch {
+		case !sameHash(observed.TxHash, out.Receipt.TxHash):
+			out.Reason = "observed tx_hash does not match recorded receipt"
+			return out
+		case observed.ChainID != "" && observed.ChainID != out.Receipt.ChainID:
+			out.Reason = "observed chain_id does not match recorded receipt"
+			return out
+		case observed.BlockNumber != 0 && observed.BlockNumber != out.Receipt.BlockNumber:
+			out.Reason = "observed block_number does not match recorded receipt"
+			return out
+		case observed.PayloadHash != "" && !sameHash(observed.PayloadHash, out.Receipt.PayloadHash):
+			out.Reason = "observed payload_hash does not match recorded receipt"
+			return out
+		}
+	}
+	out.Valid = true
+	return out
+}
+
+func findReceiptStage(tx *model.Transaction, commitmentStage string) (string, *model.ExternalReceipt) {
+	for name, data := range tx.StageData {
+		if data.Receipt != nil && data.Receipt.CommitmentStage == commitmentStage {
+			return name, data.Receipt
+		}
+	}
+	return "", nil
+}
+
+func receiptMismatch(commitment *model.ExternalCommitment, receipt *model.ExternalReceipt) string {
+	if commitment.ChainID != receipt.ChainID {
+		return fmt.Sprintf("chain_id %s != %s", receipt.ChainID, commitment.ChainID)
+	}
+	if !sameHash(commitment.PayloadHash, receipt.PayloadHash) {
+		return "payload_hash differs from committed payload_hash"
+	}
+	return ""
+}
+
+// sameHash : compares hex encoded hashes, ignoring
+// case and 0x prefix
+func sameHash(a, b string) bool {
+	norm := func(h string) string {
+		return strings.TrimPrefix(strings.ToLower(h), "0x")
+	}
+	return norm(a) == norm(b)
+}
@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"datalock/model"
+	"datalock/pkg/errors"
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckExternalReceipt(t *testing.T) {
+	is := assert.New(t)
+	tx := &model.Transaction{
+		TxID: "txID-1",
+		StageData: map[string]*model.TxStageData{
+			"CommitMint": {
+				Commitment: &model.ExternalCommitment{
+					ChainID:     "1",
+					PayloadHash: "0xABCD",
+				},
+			},
+		},
+	}
+	receipt := model.ExternalReceipt{
+		ChainID:         "1",
+		TxHash:          "0xTxHash",
+		BlockNumber:     10,
+		PayloadHash:     "abcd",
+		CommitmentStage: "CommitMint",
+	}
+
+	t.Run("Match", func(t *testing.T) {
+		is.NoError(checkExternalReceipt(tx, "Minted", &receipt))
+	})
+	t.Run("SameStage", func(t *testing.T) {
+		is.Error(checkExternalReceipt(tx, "CommitMint", &receipt))
+	})
+	t.Run("CommitmentNotFound", func(t *testing.T) {
+		r := receipt
+		r.CommitmentStage = "not-found"
+		is.Error(checkExternalReceipt(tx, "Minted", &r))
+	})
+	t.Run("ChainMismatch", func(t *testing.T) {
+		r := receipt
+		r.ChainID = "5"
+		is.Error(checkExternalReceipt(tx, "Minted", &r))
+	})
+	t.Run("PayloadMismatch", func(t *testing.T) {
+		r := receipt
+		r.PayloadHash = "0xFFFF"
+		is.Error(checkExternalReceipt(tx, "Minted", &r))
+	})
+	t.Run("AlreadyFulfilled", func(t *testing.T) {
+		tx.StageData["Minted"] = &model.TxStageData{Receipt: &receipt}
+		is.Error(checkExternalReceipt(tx, "MintedAgain", &receipt))
+		delete(tx.StageData, "Minted")
+	})
+}
+
+func TestVerifyExternalCommitment(t *testing.T) {
+	is := assert.New(t)
+	txStub := shimtest.NewMockStub("dataLockCC", &DataLockChaincode{})
+
+	const txID = "txID-1"
+	const mockID = "mockID"
+	resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"startTransitionProcess", txID}))
+	is.Equal(shim.OK, int(resp.Status))
+
+	stageUpdate := func(input model.StageUpdateInput) int32 {
+		raw, _ := json.Marshal(input)
+		return txStub.MockInvoke(mockID, stringArgsToByte([]string{"stageUpdate", string(raw)})).Status
+	}
+	verify := func(args ...string) model.ExternalVerification {
+		resp := txStub.MockInvoke(mockID, stringArgsToByte(append([]string{"verifyExternalCommitment", txID}, args...)))
+		is.Equal(shim.OK, int(resp.Status))
+		var out model.ExternalVerification
+		is.NoError(json.Unmarshal(resp.Payload, &out))
+		return out
+	}
+
+	is.Equal(int32(errors.CodeInvalidInput), stageUpdate(model.StageUpdateInput{
+		TxID:       txID,
+		Name:       "CommitMint",
+		Commitment: &model.ExternalCommitment{ChainID: "1"},
+	}))
+	is.Equal(int32(shim.OK), stageUpdate(model.StageUpdateInput{
+		TxID:       txID,
+		Name:       "CommitMint",
+		Commitment: &model.ExternalCommitment{ChainID: "1", PayloadHash: "0xabcd"},
+	}))
+
+	out := verify("CommitMint")
+	is.False(out.Valid)
+	is.Equal("commitment not fulfilled yet", out.Reason)
+
+	receipt := &model.ExternalReceipt{
+		ChainID:         "1",
+		TxHash:          "0xTxHash",
+		BlockNumber:     10,
+		PayloadHash:     "0xabcd",
+		CommitmentStage: "CommitMint",
+	}
+	is.Equal(int32(errors.CodeConflict), stageUpdate(model.StageUpdateInput{
+		TxID: txID,
+		Name: "Minted",
+		Receipt: &model.ExternalReceipt{
+			ChainID:         "1",
+			TxHash:          "0xTxHash",
+			PayloadHash:     "0xffff",
+			CommitmentStage: "CommitMint",
+		},
+	}))
+	is.Equal(int32(shim.OK), stageUpdate(model.StageUpdateInput{
+		TxID:    txID,
+		Name:    "Minted",
+		Receipt: receipt,
+	}))
+
+	out = verify("CommitMint")
+	is.True(out.Valid)
+	is.Equal("Minted", out.ReceiptStage)
+	is.Equal(receipt, out.Receipt)
+
+	out = verify("CommitMint", `{"tx_hash":"0xtxhash","block_number":10}`)
+	is.True(out.Valid)
+
+	out = verify("CommitMint", `{"tx_hash":"0xOther"}`)
+	is.False(out.Valid)
+
+	out = verify("not-found")
+	is.False(out.Valid)
+
+	t.Run("InvalidObserved", func(t *testing.T) {
+		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"verifyExternalCommitment", txID, "CommitMint", "not-a-json"}))
+		is.Equal(int32(errors.CodeInvalidInput), resp.Status)
+	})
+	t.Run("TxNotFound", func(t *testing.T) {
+		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"verifyExternalCommitment", "not-found", "CommitMint"}))
+		is.Equal(int32(errors.CodeNotFound), resp.Status)
+	})
+}
@@ -0,0 +1,177 @@
+package internal
+
+import (
+	"datalock/model"
+	"datalock/pkg/errors"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+const (
+	finishPolicyObj = "workflow~policy"
+)
+
+func finishPolicyKey(workflow string) string {
+	key, _ := shim.CreateCompositeKey(finishPolicyObj, []string{workflow})
+	return key
+}
+
+func putFinishPolicy(stub shim.ChaincodeStubInterface, policy model.FinishPolicy) error {
+	const op = errors.Op("Finish.putFinishPolicy")
+	raw, err := json.Marshal(policy)
+	if err != nil {
+		return errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to encode finish policy : %w", err),
+			errors.SeverityError,
+		)
+	}
+	err = stub.PutState(finishPolicyKey(policy.Workflow), raw)
+	if err != nil {
+		return errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to put finish policy : %w", err),
+			errors.SeverityError,
+		)
+	}
+	return nil
+}
+
+// getFinishPolicy : policy of workflow, nil if it has none
+func getFinishPolicy(stub shim.ChaincodeStubInterface, workflow string) (*model.FinishPolicy, error) {
+	const op = errors.Op("Finish.getFinishPolicy")
+	if workflow == "" {
+		return nil, nil
+	}
+	raw, err := stub.GetState(finishPolicyKey(workflow))
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to get finish policy : %w", err),
+			errors.SeverityError,
+		)
+	}
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var policy model.FinishPolicy
+	err = json.Unmarshal(raw, &policy)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("invalid finish policy : %w", err),
+			errors.SeverityError,
+		)
+	}
+	return &policy, nil
+}
+
+// finishRelease : locks released by the finish policy,
+// with output of the free function of each chaincode
+type finishRelease struct {
+	lockIDs  []string
+	toStore  map[string]map[string]string
+	toClient map[string]string
+	eligible []model.EligibleWaiter
+}
+
+// applyFinishPolicy : handles locks still held by a finishing
+// transition, following the finish policy of its workflow.
+// locked and freed : lockIDs locked and freed by the last stage,
+// fabric doesn't let the transaction read its own writes.
+// Returns nil if there was nothing to release
+func applyFinishPolicy(stub shim.ChaincodeStubInterface, tx *model.Transaction, locked, freed []string) (*finishRelease, error) {
+	const op = errors.Op("Finish.applyFinishPolicy")
+	id := errors.TxID(tx.TxID)
+
+	committed, err := getAllLockState(stub, tx.TxID)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	lockIDs := []string{}
+	for _, lockID := range committed {
+		if !slices.Contains(freed, lockID) && !slices.Contains(locked, lockID) {
+			lockIDs = append(lockIDs, lockID)
+		}
+	}
+	held := append(slices.Clone(lockIDs), locked...)
+	if len(held) == 0 {
+		return nil, nil
+	}
+	policy, err := getFinishPolicy(stub, tx.Workflow)
+	if err != nil {
+		return nil, errors.E(op, err, id)
+	}
+	if policy == nil {
+		// locks are kept
+		return nil, nil
+	}
+	slices.Sort(held)
+	if policy.OnLocks == model.FinishReject {
+		return nil, errors.E(
+			op,
+			errors.CodeConflict,
+			fmt.Errorf("transition still holds locks : %s", strings.Join(held, ", ")),
+			errors.SeverityDebug,
+			id,
+		)
+	}
+	if len(locked) != 0 {
+		return nil, errors.E(
+			op,
+			errors.CodeConflict,
+			fmt.Errorf("locks taken by the last stage can't be released : %s", strings.Join(locked, ", ")),
+			errors.SeverityDebug,
+			id,
+		)
+	}
+
+	keys := map[string][]string{}
+	for _, lockID := range lockIDs {
+		cc, key, err := splitLockStateID(lockID)
+		if err != nil {
+			return nil, errors.E(op, err, id)
+		}
+		keys[cc] = append(keys[cc], key)
+	}
+	out := &finishRelease{
+		lockIDs:  lockIDs,
+		toStore:  map[string]map[string]string{},
+		toClient: map[string]string{},
+	}
+	for _, cc := range slices.Sorted(maps.Keys(keys)) {
+		free, ok := policy.Free[cc]
+		if !ok {
+			return nil, errors.E(
+				op,
+				errors.CodeConflict,
+				fmt.Errorf("no free function of chaincode = %s in finish policy of workflow = %s", cc, tx.Workflow),
+				errors.SeverityDebug,
+				id,
+				errors.Chaincode(cc),
+			)
+		}
+		toStore, toClient, freed, err := unlockKeys(stub, tx.TxID, cc, model.DataChaincodeInput{
+			Keys:   keys[cc],
+			Params: append(slices.Clone(free), keys[cc]...),
+		}, true)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+		if len(toClient) != 0 {
+			out.toClient[cc] = toClient
+			out.toStore[cc] = toStore
+		}
+		out.eligible = append(out.eligible, freed.eligible...)
+	}
+	return out, nil
+}
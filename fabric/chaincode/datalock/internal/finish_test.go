@@ -0,0 +1,150 @@
+package internal
+
+import (
+	"datalock/model"
+	"datalock/pkg/endorsement"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFinishPolicy(t *testing.T) {
+	is := assert.New(t)
+	n := endorsement.NewNetwork("emissions-data", "Org1")
+	n.Deploy(dataLockCCName, New(WithConfigSource(adminConfig)))
+	n.Deploy(keysCCName, keysCC{})
+
+	admin := testCreator(t, "Org1MSP", "admin")
+	step := 0
+	submit := func(args ...string) pb.Response {
+		step++
+		p := endorsement.NewProposal(fmt.Sprintf("step-%d", step), dataLockCCName, args...)
+		p.Creator = admin
+		resp, _ := n.Submit(p)
+		return resp
+	}
+	stage := func(txID string, last bool, locks, free []string) pb.Response {
+		input := model.StageUpdateInput{TxID: txID, Name: fmt.Sprintf("stage-%d", step), IsLast: last}
+		if len(locks) != 0 {
+			input.DataLocks = map[string]model.DataChaincodeInput{keysCCName: {Keys: locks, Params: append([]string{"lock"}, locks...)}}
+		}
+		if len(free) != 0 {
+			input.DataFree = map[string]model.DataChaincodeInput{keysCCName: {Keys: free, Params: append([]string{"free"}, free...)}}
+		}
+		raw, _ := json.Marshal(input)
+		return submit("stageUpdate", string(raw))
+	}
+	setPolicy := func(policy model.FinishPolicy) {
+		raw, _ := json.Marshal(policy)
+		resp := submit("setFinishPolicy", string(raw))
+		is.Equal(int32(shim.OK), resp.Status, resp.Message)
+	}
+	details := func(txID string) model.Transaction {
+		var tx model.Transaction
+		json.Unmarshal(n.GetState(dataLockCCName, txID), &tx)
+		return tx
+	}
+	lockID := func(key string) string {
+		return lockStateID(keysCCName, key)
+	}
+
+	setPolicy(model.FinishPolicy{Workflow: "strict", OnLocks: model.FinishReject})
+	setPolicy(model.FinishPolicy{
+		Workflow: "cleanup",
+		OnLocks:  model.FinishRelease,
+		Free:     map[string][]string{keysCCName: {"release"}},
+	})
+	setPolicy(model.FinishPolicy{
+		Workflow: "other-cc",
+		OnLocks:  model.FinishRelease,
+		Free:     map[string][]string{"OtherCC": {"release"}},
+	})
+
+	t.Run("get-policy", func(t *testing.T) {
+		resp := n.Evaluate(endorsement.NewProposal("query", dataLockCCName, "getFinishPolicy", "strict"))
+		is.Equal(int32(shim.OK), resp.Status, resp.Message)
+		is.JSONEq(`{"workflow":"strict","on_locks":"reject"}`, string(resp.Payload))
+		resp = n.Evaluate(endorsement.NewProposal("query", dataLockCCName, "getFinishPolicy", "unknown"))
+		is.Equal(int32(404), resp.Status)
+	})
+
+	t.Run("not-admin", func(t *testing.T) {
+		p := endorsement.NewProposal("overwrite", dataLockCCName, "setFinishPolicy", `{"workflow":"strict","on_locks":"release","free":{"KeysCC":["release"]}}`)
+		p.Creator = testCreator(t, "Org2MSP", "user")
+		resp, _ := n.Submit(p)
+		is.Equal(int32(409), resp.Status)
+		is.Contains(resp.Message, "caller of MSP = Org2MSP is not admin")
+		resp = n.Evaluate(endorsement.NewProposal("query", dataLockCCName, "getFinishPolicy", "strict"))
+		is.JSONEq(`{"workflow":"strict","on_locks":"reject"}`, string(resp.Payload))
+	})
+
+	t.Run("no-policy", func(t *testing.T) {
+		submit("startTransitionProcess", "txID-1")
+		stage("txID-1", false, []string{"key-1"}, nil)
+		is.Equal(int32(shim.OK), stage("txID-1", true, nil, nil).Status)
+		is.Equal(model.TxStateFINISHED, details("txID-1").State)
+		is.Equal([]byte("txID-1"), n.GetState(dataLockCCName, lockID("key-1")))
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		is.Equal(int32(shim.OK), submit("startTransitionProcess", "txID-2", "strict").Status)
+		stage("txID-2", false, []string{"key-2", "key-3"}, nil)
+		resp := stage("txID-2", true, []string{"key-4"}, nil)
+		is.Equal(int32(409), resp.Status)
+		is.Contains(resp.Message, fmt.Sprintf("transition still holds locks : %s, %s, %s", lockID("key-2"), lockID("key-3"), lockID("key-4")))
+		resp = stage("txID-2", true, nil, []string{"key-2"})
+		is.Equal(int32(409), resp.Status)
+		is.Contains(resp.Message, fmt.Sprintf("transition still holds locks : %s\"", lockID("key-3")))
+		is.Equal(model.TxStatePROCESSING, details("txID-2").State)
+
+		is.Equal(int32(shim.OK), stage("txID-2", true, nil, []string{"key-2", "key-3"}).Status)
+		is.Equal(model.TxStateFINISHED, details("txID-2").State)
+	})
+
+	t.Run("release", func(t *testing.T) {
+		submit("startTransitionProcess", "txID-3", "cleanup")
+		stage("txID-3", false, []string{"key-5", "key-6", "key-7"}, nil)
+		resp := stage("txID-3", true, nil, []string{"key-5"})
+		is.Equal(int32(shim.OK), resp.Status, resp.Message)
+		var out model.StageUpdateOutput
+		is.NoError(json.Unmarshal(resp.Payload, &out))
+		is.Equal([]string{lockID("key-6"), lockID("key-7")}, out.Released)
+
+		tx := details("txID-3")
+		is.Equal(model.TxStateFINISHED, tx.State)
+		is.Equal(out.Released, tx.ReleasedOnFinish)
+		for _, key := range []string{"key-5", "key-6", "key-7"} {
+			is.Empty(n.GetState(dataLockCCName, lockID(key)))
+		}
+		view := readLedger(n)
+		is.NoError(checkLockIndex(view))
+		is.Empty(view.index["txID-3"])
+	})
+
+	t.Run("release-locked-by-last-stage", func(t *testing.T) {
+		submit("startTransitionProcess", "txID-4", "cleanup")
+		resp := stage("txID-4", true, []string{"key-8"}, nil)
+		is.Equal(int32(409), resp.Status)
+		is.Contains(resp.Message, "locks taken by the last stage can't be released")
+	})
+
+	t.Run("release-without-free-function", func(t *testing.T) {
+		submit("startTransitionProcess", "txID-5", "other-cc")
+		stage("txID-5", false, []string{"key-9"}, nil)
+		resp := stage("txID-5", true, nil, nil)
+		is.Equal(int32(409), resp.Status)
+		is.Contains(resp.Message, "no free function of chaincode = KeysCC")
+	})
+
+	t.Run("workflow-mismatch", func(t *testing.T) {
+		submit("endTransitionProcess", "txID-5")
+		resp := submit("startTransitionProcess", "txID-5", "strict")
+		is.Equal(int32(409), resp.Status)
+		is.Contains(resp.Message, `transaction is part of workflow = \"other-cc\"`)
+		is.Equal(int32(shim.OK), submit("startTransitionProcess", "txID-5").Status)
+	})
+}
@@ -0,0 +1,236 @@
+package internal
+
+import (
+	"bytes"
+	"datalock/model"
+	"datalock/pkg/errors"
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const fuzzCCName = "FuzzCC"
+
+// fuzzCC : data chaincode answering every call with payload
+type fuzzCC struct {
+	payload []byte
+}
+
+func (cc *fuzzCC) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (cc *fuzzCC) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(cc.payload)
+}
+
+// newFuzzStub : datalock stub where txID-1 holds key-1 and
+// txID-2 holds key-2 of FuzzCC, txID-3 is finished
+// and txID-4 is paused
+func newFuzzStub(payload []byte) *shimtest.MockStub {
+	stub := shimtest.NewMockStub(dataLockCCName, &DataLockChaincode{})
+	stub.Invokables[fuzzCCName] = shimtest.NewMockStub(fuzzCCName, &fuzzCC{payload: payload})
+
+	stub.MockTransactionStart("fixture")
+	for _, txID := range []string{"txID-1", "txID-2", "txID-3", "txID-4"} {
+		txState(stub, txID, "", true)
+	}
+	putLockState(stub, "txID-1", fuzzCCName, "key-1")
+	putLockState(stub, "txID-2", fuzzCCName, "key-2")
+	tx, _ := getTx(stub, "txID-3")
+	tx.State = model.TxStateFINISHED
+	putTx(stub, tx)
+	pauseTx(stub, model.TxPauseInput{TxID: "txID-4", ReasonCode: "WAIT"})
+	stub.MockTransactionEnd("fixture")
+	return stub
+}
+
+// fuzzInvoke : invokes datalock and checks that the request
+// either failed, with an error payload, or left the state
+// consistent. Fabric discards writes of failed requests
+func fuzzInvoke(t *testing.T, stub *shimtest.MockStub, args []string) {
+	before := map[string][]byte{}
+	for key, value := range stub.State {
+		before[key] = value
+	}
+	resp := stub.MockInvoke("fuzz", stringArgsToByte(args))
+	if resp.Status >= shim.ERRORTHRESHOLD {
+		var p errors.Payload
+		if err := json.Unmarshal([]byte(resp.Message), &p); err != nil || p.ID == "" {
+			t.Fatalf("status %d with invalid error payload %q", resp.Status, resp.Message)
+		}
+		return
+	}
+	if resp.Status != shim.OK {
+		t.Fatalf("unexpected status %d : %s", resp.Status, resp.Message)
+	}
+
+	view := splitLedger(stub.State)
+	if err := checkLockIndex(view); err != nil {
+		t.Fatalf("%v after %q", err, args)
+	}
+	for _, txID := range sortedTxIDs(view.txs) {
+		raw := view.txs[txID]
+		if bytes.Equal(raw, before[txID]) {
+			continue
+		}
+		var tx model.Transaction
+		if err := json.Unmarshal(raw, &tx); err != nil || tx.TxID != txID {
+			t.Fatalf("transaction %q written as %q after %q", txID, raw, args)
+		}
+		if _, ok := before[txID]; !ok && tx.State != model.TxStatePROCESSING {
+			t.Fatalf("transaction %q created at %s after %q", txID, tx.State, args)
+		}
+	}
+	for _, lockID := range sortedLockIDs(view.locks) {
+		txID := view.locks[lockID]
+		if string(before[lockID]) == txID {
+			continue
+		}
+		var tx model.Transaction
+		if err := json.Unmarshal(view.txs[txID], &tx); err != nil || tx.State != model.TxStatePROCESSING {
+			t.Fatalf("%s locked by %q, not a processing transaction, after %q", lockID, txID, args)
+		}
+	}
+}
+
+func sortedLockIDs(locks map[string]string) []string {
+	out := make([]string, 0, len(locks))
+	for lockID := range locks {
+		out = append(out, lockID)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func stageUpdateSeed(input model.StageUpdateInput) string {
+	raw, _ := json.Marshal(input)
+	return string(raw)
+}
+
+// FuzzInvoke : every method entry point with up to three arguments
+func FuzzInvoke(f *testing.F) {
+	methods := make([]string, 0, len(defaultMethods()))
+	for name := range defaultMethods() {
+		methods = append(methods, name)
+	}
+	sort.Strings(methods)
+
+	pause, _ := json.Marshal(model.TxPauseInput{TxID: "txID-1", ReasonCode: "WAIT"})
+	seeds := [][]string{
+		{"startTransitionProcess", "txID-5"},
+		{"startTransitionProcess", "txID-3"},
+		{"endTransitionProcess", "txID-1"},
+		{"endTransitionProcess", "txID-3"},
+		{"pauseTransitionProcess", string(pause)},
+		{"resumeTransitionProcess", "txID-4"},
+		{"abortTransitionProcess", "txID-1"},
+		{"stageUpdate", stageUpdateSeed(model.StageUpdateInput{TxID: "txID-1", Name: "stage"})},
+		{"getTxDetails", "txID-1"},
+		{"getStageOutput", "txID-1", "stage"},
+		{"verifyExternalCommitment", "txID-1", "stage", `{"tx_hash":"0x1"}`},
+		{"getTxDigest", "txID-1"},
+		{"migrateTransitions", "txID-1", "txID-3"},
+		{"checkLocks", `{"chaincode":"FuzzCC","keys":["key-1","key-5"],"tx_id":"txID-1"}`},
+		{"joinQueue", `{"tx_id":"txID-3","chaincode":"FuzzCC","keys":["key-1","key-5"]}`},
+		{"joinQueue", `{"tx_id":"txID-1","chaincode":"FuzzCC","keys":["key-2"],"grace":60}`},
+		{"leaveQueue", `{"tx_id":"txID-1","chaincode":"FuzzCC","keys":["key-2"]}`},
+		{"getQueue", "FuzzCC", "key-1"},
+		{"startTransitionProcess", "txID-5", "workflow"},
+		{"setFinishPolicy", `{"workflow":"workflow","on_locks":"release","free":{"FuzzCC":["free"]}}`},
+		{"getFinishPolicy", "workflow"},
+		{"approveHandoff", `{"from":"txID-1","to":"txID-3","locks":{"FuzzCC":["key-1"]}}`},
+		{"handoffLocks", `{"from":"txID-1","to":"txID-3","locks":{"FuzzCC":["key-1"]}}`},
+		{"startChildTransition", "txID-1", "txID-6"},
+		{"startChildTransition", "txID-1", "txID-7", "workflow"},
+		{"getTxTree", "txID-1"},
+		{"simulateStageUpdate", stageUpdateSeed(model.StageUpdateInput{TxID: "txID-1", Name: "stage"})},
+		{"archiveFinished", `{"retention":0,"limit":2}`},
+		{"archiveFinished", `{"retention":3600,"bookmark":"txID-2"}`},
+		{"getTxArchive", "txID-3"},
+		{"bootstrapConfig", `{"admins":["Org1MSP"],"max_keys":2,"chaincodes":["FuzzCC"]}`},
+		{"setConfig", `{"version":1,"admins":["Org1MSP"],"disable_events":true}`},
+		{"getConfig"},
+		{"getConfig", "1"},
+		{"unknown"},
+		{"startTransitionProcess"},
+		{"getTxDetails", ""},
+	}
+	for _, seed := range seeds {
+		args := append(seed, "", "", "")[:4]
+		f.Add(args[0], args[1], args[2], args[3], uint8(len(seed)-1))
+	}
+
+	f.Fuzz(func(t *testing.T, method, arg1, arg2, arg3 string, n uint8) {
+		stub := newFuzzStub([]byte(`{"keys":[]}`))
+		args := []string{method, arg1, arg2, arg3}[:1+n%4]
+		fuzzInvoke(t, stub, args)
+
+		// same arguments on every known method
+		for _, name := range methods {
+			args[0] = name
+			fuzzInvoke(t, newFuzzStub([]byte(`{"keys":[]}`)), args)
+		}
+	})
+}
+
+// FuzzStageUpdate : client input of stageUpdate, with
+// transient storage of private stages
+func FuzzStageUpdate(f *testing.F) {
+	lock := map[string]model.DataChaincodeInput{fuzzCCName: {Keys: []string{"key-5"}, Params: []string{"lock"}}}
+	free := map[string]model.DataChaincodeInput{fuzzCCName: {Keys: []string{"key-1"}, Params: []string{"free"}}}
+	seeds := []model.StageUpdateInput{
+		{TxID: "txID-1", Name: "lock", DataLocks: lock},
+		{TxID: "txID-1", Name: "free", DataFree: free},
+		{TxID: "txID-2", Name: "free", DataFree: free},
+		{TxID: "txID-3", Name: "lock", DataLocks: lock},
+		{TxID: "txID-4", Name: "lock", DataLocks: lock},
+		{TxID: "txID-1", Name: "last", IsLast: true, Storage: map[string]string{"k": "v"}},
+		{TxID: "txID-1", Name: "private", Collection: "collection"},
+		{TxID: "txID-1", Name: "commit", Commitment: &model.ExternalCommitment{ChainID: "eth", PayloadHash: "0x1"}},
+		{TxID: "txID-1", Name: "receipt", Receipt: &model.ExternalReceipt{ChainID: "eth", TxHash: "0x2", PayloadHash: "0x1", CommitmentStage: "commit"}},
+	}
+	for _, seed := range seeds {
+		f.Add(stageUpdateSeed(seed), []byte(`{"k":"v"}`))
+	}
+	f.Add(`{"tx_id":"txID-1","name":"x","data_locks":{"":{"keys":[""]}}}`, []byte(nil))
+	f.Add(`{"tx_id":1}`, []byte(`[]`))
+	f.Add(`null`, []byte(`null`))
+
+	f.Fuzz(func(t *testing.T, input string, storage []byte) {
+		// data chaincode answers with the keys of the seeds
+		stub := newFuzzStub([]byte(`{"keys":["key-1","key-5"]}`))
+		stub.TransientMap = map[string][]byte{transientStorageKey: storage, transientSaltKey: []byte("fuzz-salt-0123456")}
+		fuzzInvoke(t, stub, []string{"stageUpdate", input})
+	})
+}
+
+// FuzzDataChaincodeOutput : response of data chaincode
+// decoded by lock and unlock
+func FuzzDataChaincodeOutput(f *testing.F) {
+	f.Add([]byte(`{"keys":["key-5"],"output_to_client":"out","output_to_store":{"k":"v"}}`), false)
+	f.Add([]byte(`{"keys":["key-1"]}`), true)
+	f.Add([]byte(`{"keys":["key-2"]}`), false)
+	f.Add([]byte(`{"keys":["key-2"]}`), true)
+	f.Add([]byte(`{"keys":["key-5","key-5"]}`), false)
+	f.Add([]byte(`{"keys":[""]}`), false)
+	f.Add([]byte("{\"keys\":[\"key\\u0000\"]}"), true)
+	f.Add([]byte(`{"keys":null,"output_to_store":{"":""}}`), true)
+	f.Add([]byte(`not json`), false)
+	f.Add([]byte(nil), true)
+
+	f.Fuzz(func(t *testing.T, payload []byte, free bool) {
+		stub := newFuzzStub(payload)
+		input := model.StageUpdateInput{TxID: "txID-1", Name: "stage"}
+		if free {
+			input.DataFree = map[string]model.DataChaincodeInput{fuzzCCName: {Keys: []string{"key-1"}, Params: []string{"free"}}}
+		} else {
+			input.DataLocks = map[string]model.DataChaincodeInput{fuzzCCName: {Keys: []string{"key-5"}, Params: []string{"lock"}}}
+		}
+		fuzzInvoke(t, stub, []string{"stageUpdate", stageUpdateSeed(input)})
+	})
+}
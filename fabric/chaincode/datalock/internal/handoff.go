@@ -0,0 +1,304 @@
+package internal
+
+import (
+	"datalock/model"
+	"datalock/pkg/errors"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Locks are handed off in two steps, so that both owners agree :
+// the owner of the transition holding the locks approves the
+// handoff, then the owner of the transition taking them over
+// moves them, in a single fabric transaction.
+
+const (
+	handoffObj = "from~to~handoff"
+)
+
+func handoffKey(from, to string) string {
+	key, _ := shim.CreateCompositeKey(handoffObj, []string{from, to})
+	return key
+}
+
+// callerIdentity : identity of the proposal creator,
+// nil if the proposal has no creator
+func callerIdentity(stub shim.ChaincodeStubInterface) (*model.Identity, error) {
+	const op = errors.Op("Handoff.callerIdentity")
+	creator, err := stub.GetCreator()
+	if err != nil || len(creator) == 0 {
+		return nil, nil
+	}
+	id, err := cid.New(stub)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid creator : %w", err),
+			errors.SeverityDebug,
+		)
+	}
+	mspID, err := id.GetMSPID()
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid creator : %w", err),
+			errors.SeverityDebug,
+		)
+	}
+	clientID, err := id.GetID()
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid creator : %w", err),
+			errors.SeverityDebug,
+		)
+	}
+	return &model.Identity{MSPID: mspID, ID: clientID}, nil
+}
+
+// checkOwner : error unless caller owns the transaction
+func checkOwner(tx *model.Transaction, caller *model.Identity) error {
+	const op = errors.Op("Handoff.checkOwner")
+	if tx.Owner == nil || caller == nil || *tx.Owner != *caller {
+		return errors.E(
+			op,
+			errors.CodeConflict,
+			fmt.Errorf("caller is not owner of the transition"),
+			errors.SeverityDebug,
+			errors.TxID(tx.TxID),
+		)
+	}
+	return nil
+}
+
+// handoffTx : transition taking part in a handoff, which must
+// be processing (or paused) and owned by caller
+func handoffTx(stub shim.ChaincodeStubInterface, txID string, caller *model.Identity) (*model.Transaction, error) {
+	const op = errors.Op("Handoff.handoffTx")
+	tx, err := getTx(stub, txID)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	if tx.State != model.TxStatePROCESSING && tx.State != model.TxStatePAUSED {
+		return nil, errors.E(
+			op,
+			errors.CodeConflict,
+			fmt.Errorf("transition is not at processing state, found at %s", tx.State),
+			errors.SeverityDebug,
+			errors.TxID(txID),
+		)
+	}
+	if caller != nil {
+		err = checkOwner(tx, caller)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+	return tx, nil
+}
+
+// checkHeld : error unless txID holds every lock of the input
+func checkHeld(stub shim.ChaincodeStubInterface, txID string, locks map[string][]string) error {
+	const op = errors.Op("Handoff.checkHeld")
+	for _, cc := range slices.Sorted(maps.Keys(locks)) {
+		for _, key := range locks[cc] {
+			holder, err := getLockStateTxID(stub, cc, key)
+			if err != nil {
+				return errors.E(op, err, errors.Chaincode(cc))
+			}
+			if holder != txID {
+				return errors.E(
+					op,
+					errors.CodeConflict,
+					fmt.Errorf("key = %s not locked by txID = %s", key, txID),
+					errors.SeverityDebug,
+					errors.TxID(txID),
+					errors.Chaincode(cc),
+				)
+			}
+		}
+	}
+	return nil
+}
+
+// approveTxHandoff : called by owner of input.From, allowing
+// input.To to take over the locks. Replaces an earlier approval
+func approveTxHandoff(stub shim.ChaincodeStubInterface, input model.HandoffInput) error {
+	const op = errors.Op("Handoff.approveTxHandoff")
+	id := errors.TxID(input.From)
+
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return errors.E(op, err, id)
+	}
+	if caller == nil {
+		return errors.E(op, errors.CodeConflict, fmt.Errorf("caller has no identity"), errors.SeverityDebug, id)
+	}
+	_, err = handoffTx(stub, input.From, caller)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	err = checkHeld(stub, input.From, input.Locks)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	raw, err := json.Marshal(model.HandoffApproval{HandoffInput: input, ApprovedBy: *caller})
+	if err != nil {
+		return errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to encode handoff approval : %w", err),
+			errors.SeverityError,
+			id,
+		)
+	}
+	err = stub.PutState(handoffKey(input.From, input.To), raw)
+	if err != nil {
+		return errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to put handoff approval : %w", err),
+			errors.SeverityError,
+			id,
+		)
+	}
+	return nil
+}
+
+// getHandoffApproval : nil if the handoff is not approved
+func getHandoffApproval(stub shim.ChaincodeStubInterface, from, to string) (*model.HandoffApproval, error) {
+	const op = errors.Op("Handoff.getHandoffApproval")
+	raw, err := stub.GetState(handoffKey(from, to))
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to get handoff approval : %w", err),
+			errors.SeverityError,
+			errors.TxID(from),
+		)
+	}
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var approval model.HandoffApproval
+	err = json.Unmarshal(raw, &approval)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("invalid handoff approval : %w", err),
+			errors.SeverityError,
+			errors.TxID(from),
+		)
+	}
+	return &approval, nil
+}
+
+// handoffTxLocks : called by owner of input.To, moves the locks from
+// input.From, which must have approved the handoff unless both
+// transitions have the same owner. Locks get new fencing numbers
+func handoffTxLocks(stub shim.ChaincodeStubInterface, input model.HandoffInput) (*model.HandoffOutput, error) {
+	const op = errors.Op("Handoff.handoffTxLocks")
+	id := errors.TxID(input.To)
+
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return nil, errors.E(op, err, id)
+	}
+	if caller == nil {
+		return nil, errors.E(op, errors.CodeConflict, fmt.Errorf("caller has no identity"), errors.SeverityDebug, id)
+	}
+	to, err := handoffTx(stub, input.To, caller)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	from, err := handoffTx(stub, input.From, nil)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	if checkOwner(from, caller) != nil {
+		// owner of from must have approved every lock
+		approval, err := getHandoffApproval(stub, input.From, input.To)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+		if approval == nil || checkOwner(from, &approval.ApprovedBy) != nil {
+			return nil, errors.E(
+				op,
+				errors.CodeConflict,
+				fmt.Errorf("handoff not approved by owner of txID = %s", input.From),
+				errors.SeverityDebug,
+				id,
+			)
+		}
+		for cc, keys := range input.Locks {
+			for _, key := range keys {
+				if !slices.Contains(approval.Locks[cc], key) {
+					return nil, errors.E(
+						op,
+						errors.CodeConflict,
+						fmt.Errorf("handoff of key = %s not approved", key),
+						errors.SeverityDebug,
+						id,
+						errors.Chaincode(cc),
+					)
+				}
+			}
+		}
+	}
+	err = checkHeld(stub, input.From, input.Locks)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	out := &model.HandoffOutput{LockIDs: []string{}, Fences: map[string]map[string]uint64{}}
+	for _, cc := range slices.Sorted(maps.Keys(input.Locks)) {
+		out.Fences[cc] = map[string]uint64{}
+		for _, key := range input.Locks[cc] {
+			lockID := lockStateID(cc, key)
+			err = deleteLockState(stub, input.From, lockID)
+			if err != nil {
+				return nil, errors.E(op, err)
+			}
+			fence, err := putLockState(stub, input.To, cc, key)
+			if err != nil {
+				return nil, errors.E(op, err)
+			}
+			out.LockIDs = append(out.LockIDs, lockID)
+			out.Fences[cc][key] = fence
+		}
+	}
+	err = stub.DelState(handoffKey(input.From, input.To))
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to delete handoff approval : %w", err),
+			errors.SeverityError,
+			id,
+		)
+	}
+
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return nil, errors.E(op, err, id)
+	}
+	handoff := model.Handoff{From: input.From, To: input.To, LockIDs: out.LockIDs, At: now}
+	for _, tx := range []*model.Transaction{from, to} {
+		tx.Handoffs = append(tx.Handoffs, handoff)
+		_, err = putTx(stub, tx)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+	return out, nil
+}
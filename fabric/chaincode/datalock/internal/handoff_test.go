@@ -0,0 +1,210 @@
+package internal
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"datalock/model"
+	"datalock/pkg/canonical"
+	"datalock/pkg/endorsement"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+// testCreator : serialized identity of a self-signed client of mspID
+func testCreator(t *testing.T, mspID, name string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name, Organization: []string{mspID}},
+		NotBefore:    time.Unix(0, 0),
+		NotAfter:     time.Unix(1<<32, 0),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   mspID,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestHandoffLocks(t *testing.T) {
+	is := assert.New(t)
+	n := endorsement.NewNetwork("emissions-data", "Org1")
+	n.Deploy(dataLockCCName, &DataLockChaincode{})
+	n.Deploy(keysCCName, keysCC{})
+
+	alice := testCreator(t, "Org1MSP", "alice")
+	bob := testCreator(t, "Org2MSP", "bob")
+	step := 0
+	submit := func(creator []byte, args ...string) pb.Response {
+		step++
+		p := endorsement.NewProposal(fmt.Sprintf("step-%d", step), dataLockCCName, args...)
+		p.Creator = creator
+		resp, _ := n.Submit(p)
+		return resp
+	}
+	lock := func(creator []byte, txID string, keys ...string) {
+		raw, _ := json.Marshal(model.StageUpdateInput{
+			TxID:      txID,
+			Name:      "lock",
+			DataLocks: map[string]model.DataChaincodeInput{keysCCName: {Keys: keys, Params: append([]string{"lock"}, keys...)}},
+		})
+		resp := submit(creator, "stageUpdate", string(raw))
+		is.Equal(int32(shim.OK), resp.Status, resp.Message)
+	}
+	handoff := func(creator []byte, method, from, to string, keys ...string) pb.Response {
+		raw, _ := json.Marshal(model.HandoffInput{From: from, To: to, Locks: map[string][]string{keysCCName: keys}})
+		return submit(creator, method, string(raw))
+	}
+	details := func(txID string) model.Transaction {
+		var tx model.Transaction
+		json.Unmarshal(n.GetState(dataLockCCName, txID), &tx)
+		return tx
+	}
+	lockID := func(key string) string {
+		return lockStateID(keysCCName, key)
+	}
+
+	submit(alice, "startTransitionProcess", "txID-1")
+	submit(bob, "startTransitionProcess", "txID-2")
+	submit(alice, "startTransitionProcess", "txID-3")
+	lock(alice, "txID-1", "key-1", "key-2", "key-3")
+	is.Equal("Org1MSP", details("txID-1").Owner.MSPID)
+
+	t.Run("not-approved", func(t *testing.T) {
+		resp := handoff(bob, "handoffLocks", "txID-1", "txID-2", "key-1")
+		is.Equal(int32(409), resp.Status)
+		is.Contains(resp.Message, "handoff not approved by owner of txID = txID-1")
+	})
+
+	t.Run("not-owner", func(t *testing.T) {
+		resp := handoff(bob, "approveHandoff", "txID-1", "txID-2", "key-1")
+		is.Equal(int32(409), resp.Status)
+		is.Contains(resp.Message, "caller is not owner of the transition")
+		is.Equal(int32(shim.OK), handoff(alice, "approveHandoff", "txID-1", "txID-2", "key-1", "key-2").Status)
+		resp = handoff(alice, "handoffLocks", "txID-1", "txID-2", "key-1")
+		is.Equal(int32(409), resp.Status)
+		is.Contains(resp.Message, "caller is not owner of the transition")
+	})
+
+	t.Run("not-held", func(t *testing.T) {
+		resp := handoff(alice, "approveHandoff", "txID-1", "txID-2", "key-4")
+		is.Equal(int32(409), resp.Status)
+		is.Contains(resp.Message, "key = key-4 not locked by txID = txID-1")
+		resp = handoff(bob, "handoffLocks", "txID-1", "txID-2", "key-3")
+		is.Equal(int32(409), resp.Status)
+		is.Contains(resp.Message, "handoff of key = key-3 not approved")
+	})
+
+	t.Run("handoff", func(t *testing.T) {
+		resp := handoff(bob, "handoffLocks", "txID-1", "txID-2", "key-1", "key-2")
+		is.Equal(int32(shim.OK), resp.Status, resp.Message)
+		var out model.HandoffOutput
+		is.NoError(json.Unmarshal(resp.Payload, &out))
+		is.Equal([]string{lockID("key-1"), lockID("key-2")}, out.LockIDs)
+		is.Equal(map[string]map[string]uint64{keysCCName: {"key-1": 2, "key-2": 2}}, out.Fences)
+
+		is.Equal([]byte("txID-2"), n.GetState(dataLockCCName, lockID("key-1")))
+		view := readLedger(n)
+		is.NoError(checkLockIndex(view))
+		is.ElementsMatch([]string{lockID("key-3")}, view.index["txID-1"])
+		is.ElementsMatch([]string{lockID("key-1"), lockID("key-2")}, view.index["txID-2"])
+		is.Empty(n.GetState(dataLockCCName, handoffKey("txID-1", "txID-2")))
+
+		for _, txID := range []string{"txID-1", "txID-2"} {
+			handoffs := details(txID).Handoffs
+			is.Len(handoffs, 1)
+			is.Equal(out.LockIDs, handoffs[0].LockIDs)
+			is.Equal("txID-1", handoffs[0].From)
+		}
+
+		// approval is used up
+		resp = handoff(bob, "handoffLocks", "txID-1", "txID-2", "key-3")
+		is.Equal(int32(409), resp.Status)
+	})
+
+	t.Run("same-owner", func(t *testing.T) {
+		resp := handoff(alice, "handoffLocks", "txID-1", "txID-3", "key-3")
+		is.Equal(int32(shim.OK), resp.Status, resp.Message)
+		is.Equal([]byte("txID-3"), n.GetState(dataLockCCName, lockID("key-3")))
+	})
+
+	t.Run("no-owner", func(t *testing.T) {
+		submit(nil, "startTransitionProcess", "txID-4")
+		resp := handoff(alice, "handoffLocks", "txID-3", "txID-4", "key-3")
+		is.Equal(int32(409), resp.Status)
+		resp = handoff(nil, "approveHandoff", "txID-3", "txID-4", "key-3")
+		is.Equal(int32(409), resp.Status)
+		is.Contains(resp.Message, "caller has no identity")
+	})
+}
+
+// TestClaimLegacyOwner : transitions created before owners were
+// recorded have no owner, an admin claims them by migrating
+func TestClaimLegacyOwner(t *testing.T) {
+	is := assert.New(t)
+	n := endorsement.NewNetwork("emissions-data", "Org1")
+	n.Deploy(dataLockCCName, New(WithConfigSource(adminConfig)))
+
+	admin := testCreator(t, "Org1MSP", "admin")
+	user := testCreator(t, "Org2MSP", "user")
+	step := 0
+	submit := func(creator []byte, args ...string) pb.Response {
+		step++
+		p := endorsement.NewProposal(fmt.Sprintf("step-%d", step), dataLockCCName, args...)
+		p.Creator = creator
+		resp, _ := n.Submit(p)
+		return resp
+	}
+	approve := func(creator []byte) pb.Response {
+		raw, _ := json.Marshal(model.HandoffInput{From: "txID-1", To: "txID-2", Locks: map[string][]string{keysCCName: {"key-1"}}})
+		return submit(creator, "approveHandoff", string(raw))
+	}
+
+	legacy := model.Transaction{TxID: "txID-1", State: model.TxStatePROCESSING, Revision: 1}
+	legacy.Digest, _ = txDigest(&legacy)
+	raw, _ := canonical.Marshal(legacy)
+	n.Seed(dataLockCCName, map[string][]byte{"txID-1": raw, lockStateID(keysCCName, "key-1"): []byte("txID-1")})
+	submit(user, "startTransitionProcess", "txID-2")
+
+	resp := approve(admin)
+	is.Equal(int32(409), resp.Status)
+	is.Contains(resp.Message, "caller is not owner of the transition")
+
+	// only admins claim
+	resp = submit(user, "migrateTransitions", "txID-1")
+	is.Equal(int32(shim.OK), resp.Status, resp.Message)
+	is.JSONEq(`{"migrated":[]}`, string(resp.Payload))
+	resp = submit(admin, "migrateTransitions", "txID-1", "txID-2")
+	is.Equal(int32(shim.OK), resp.Status, resp.Message)
+	is.JSONEq(`{"migrated":["txID-1"]}`, string(resp.Payload))
+
+	var tx model.Transaction
+	is.NoError(json.Unmarshal(n.GetState(dataLockCCName, "txID-1"), &tx))
+	is.Equal("Org1MSP", tx.Owner.MSPID)
+	is.Equal(int32(409), approve(user).Status)
+	resp = approve(admin)
+	is.Equal(int32(shim.OK), resp.Status, resp.Message)
+}
@@ -1,31 +1,89 @@
 package internal
 
 import (
+	"datalock/model"
 	"datalock/pkg/errors"
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/hyperledger/fabric-chaincode-go/shim"
 )
 
 const (
+	// lockStateObj : txID holding a lockID, when mounted
+	lockStateObj      = "lockID~holder"
 	lockStateIndexObj = "txID~lockID"
+	// lockFenceObj : fencing number of a lockID, kept
+	// after the lock is released so that it only grows
+	lockFenceObj = "lockID~fence"
 )
 
 func lockStateID(cc, key string) string {
 	return fmt.Sprintf("%s::%s", cc, key)
 }
 
+// splitLockStateID : chaincode and key of lockID, validation
+// rejects chaincode names containing ':', keys may contain it
+func splitLockStateID(lockID string) (string, string, error) {
+	const op = errors.Op("LockState.splitLockStateID")
+	cc, key, ok := strings.Cut(lockID, "::")
+	if !ok {
+		return "", "", errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("invalid lockID %q", lockID),
+			errors.SeverityError,
+		)
+	}
+	return cc, key, nil
+}
+
+// lockStateKey : key of the txID holding lockID. Mounted under a
+// prefix, chaincode::key may be a key of the host chaincode, so
+// the holder is kept under a composite key instead
+func lockStateKey(stub shim.ChaincodeStubInterface, lockID string) string {
+	if !mounted(stub) {
+		return lockID
+	}
+	key, _ := shim.CreateCompositeKey(lockStateObj, []string{lockID})
+	return key
+}
+
 func lockStateIndex(txID, lockID string) string {
 	lockIndex, _ := shim.CreateCompositeKey(lockStateIndexObj, []string{txID, lockID})
 	return lockIndex
 }
 
-func putLockState(stub shim.ChaincodeStubInterface, txID, cc, key string) error {
+func lockFenceKey(lockID string) string {
+	key, _ := shim.CreateCompositeKey(lockFenceObj, []string{lockID})
+	return key
+}
+
+// putLockState : locks key of cc for txID, returns the fencing
+// number assigned to the lock, greater than the one of every
+// earlier lock of the key
+func putLockState(stub shim.ChaincodeStubInterface, txID, cc, key string) (uint64, error) {
 	const op = errors.Op("LockState.putLockState")
 	lockID := lockStateID(cc, key)
-	err := stub.PutState(lockID, []byte(txID))
+	fence, err := getLockFence(stub, cc, key)
 	if err != nil {
-		return errors.E(
+		return 0, errors.E(op, err, errors.TxID(txID))
+	}
+	fence++
+	err = stub.PutState(lockFenceKey(lockID), []byte(strconv.FormatUint(fence, 10)))
+	if err != nil {
+		return 0, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to put lock fence : %w", err),
+			errors.SeverityError,
+			errors.TxID(txID),
+		)
+	}
+	err = stub.PutState(lockStateKey(stub, lockID), []byte(txID))
+	if err != nil {
+		return 0, errors.E(
 			op,
 			errors.CodeUnexpected,
 			fmt.Errorf("failed to put lock state : %w", err),
@@ -36,7 +94,7 @@ func putLockState(stub shim.ChaincodeStubInterface, txID, cc, key string) error
 	index := lockStateIndex(txID, lockID)
 	err = stub.PutState(index, []byte{0x00})
 	if err != nil {
-		return errors.E(
+		return 0, errors.E(
 			op,
 			errors.CodeUnexpected,
 			fmt.Errorf("failed to put lock state : %w", err),
@@ -44,13 +102,41 @@ func putLockState(stub shim.ChaincodeStubInterface, txID, cc, key string) error
 			errors.TxID(txID),
 		)
 	}
-	return nil
+	return fence, nil
+}
+
+// getLockFence : fencing number of the latest lock
+// of key of cc, 0 if it has never been locked
+func getLockFence(stub shim.ChaincodeStubInterface, cc, key string) (uint64, error) {
+	const op = errors.Op("LockState.getLockFence")
+	raw, err := stub.GetState(lockFenceKey(lockStateID(cc, key)))
+	if err != nil {
+		return 0, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to get lock fence : %w", err),
+			errors.SeverityError,
+		)
+	}
+	if len(raw) == 0 {
+		return 0, nil
+	}
+	fence, err := strconv.ParseUint(string(raw), 10, 64)
+	if err != nil {
+		return 0, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("invalid lock fence : %w", err),
+			errors.SeverityError,
+		)
+	}
+	return fence, nil
 }
 
 func isLockStateExists(stub shim.ChaincodeStubInterface, cc, key string) (bool, error) {
 	const op = errors.Op("LockState.isLockStateExists")
 	lockID := lockStateID(cc, key)
-	raw, err := stub.GetState(lockID)
+	raw, err := stub.GetState(lockStateKey(stub, lockID))
 	if err != nil {
 		return false, errors.E(
 			op,
@@ -65,7 +151,7 @@ func isLockStateExists(stub shim.ChaincodeStubInterface, cc, key string) (bool,
 func getLockStateTxID(stub shim.ChaincodeStubInterface, cc, key string) (string, error) {
 	const op = errors.Op("LockState.getLockStateTxID")
 	lockID := lockStateID(cc, key)
-	raw, err := stub.GetState(lockID)
+	raw, err := stub.GetState(lockStateKey(stub, lockID))
 	if err != nil {
 		return "", errors.E(
 			op,
@@ -79,7 +165,7 @@ func getLockStateTxID(stub shim.ChaincodeStubInterface, cc, key string) (string,
 
 func deleteLockState(stub shim.ChaincodeStubInterface, txID, lockID string) error {
 	const op = errors.Op("LockState.getLockStateTxID")
-	err := stub.DelState(lockID)
+	err := stub.DelState(lockStateKey(stub, lockID))
 	if err != nil {
 		return errors.E(
 			op,
@@ -117,9 +203,100 @@ func getAllLockState(stub shim.ChaincodeStubInterface, txID string) ([]string, e
 	out := []string{}
 	defer itr.Close()
 	for itr.HasNext() {
-		kv, _ := itr.Next()
-		_, args, _ := stub.SplitCompositeKey(kv.Key)
+		kv, err := itr.Next()
+		if err != nil {
+			return nil, errors.E(
+				op,
+				errors.CodeUnexpected,
+				fmt.Errorf("failed to iterate tx index : %w", err),
+				errors.SeverityError,
+				errors.TxID(txID),
+			)
+		}
+		_, args, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil || len(args) != 2 {
+			return nil, errors.E(
+				op,
+				errors.CodeUnexpected,
+				fmt.Errorf("invalid tx index key %q", kv.Key),
+				errors.SeverityError,
+				errors.TxID(txID),
+			)
+		}
 		out = append(out, args[1])
 	}
 	return out, nil
 }
+
+// checkKeyLocks : holder of each key, and whether the
+// caller acting for input.TxID may write the keys
+func checkKeyLocks(stub shim.ChaincodeStubInterface, input model.CheckLocksInput) (*model.CheckLocksOutput, error) {
+	const op = errors.Op("LockState.checkKeyLocks")
+	out := &model.CheckLocksOutput{
+		Locks:   make([]model.KeyLock, len(input.Keys)),
+		Allowed: true,
+	}
+	held := false
+	for i, key := range input.Keys {
+		txID, err := getLockStateTxID(stub, input.Chaincode, key)
+		if err != nil {
+			return nil, errors.E(op, err, errors.Chaincode(input.Chaincode))
+		}
+		fence, err := getLockFence(stub, input.Chaincode, key)
+		if err != nil {
+			return nil, errors.E(op, err, errors.Chaincode(input.Chaincode))
+		}
+		out.Locks[i] = model.KeyLock{Key: key, TxID: txID, Fence: fence}
+		if txID == "" {
+			// a free key may be reserved for the eligible
+			// waiter, as lock and unlockKeys check
+			queue, err := getKeyQueue(stub, input.Chaincode, key)
+			if err != nil {
+				return nil, errors.E(op, err, errors.Chaincode(input.Chaincode))
+			}
+			eligible, err := reservedFor(stub, queue)
+			if err != nil {
+				return nil, errors.E(op, err, errors.Chaincode(input.Chaincode))
+			}
+			out.Locks[i].ReservedFor = eligible
+			if eligible != "" && eligible != input.TxID && out.Allowed {
+				out.Allowed = false
+				out.Reason = fmt.Sprintf("key = %s reserved for txID = %s", key, eligible)
+			}
+			continue
+		}
+		if txID != input.TxID {
+			if out.Allowed {
+				out.Allowed = false
+				out.Reason = fmt.Sprintf("key = %s locked by txID = %s", key, txID)
+			}
+			continue
+		}
+		held = true
+	}
+	if !out.Allowed || !held {
+		return out, nil
+	}
+
+	// holder may only write while processing, and only
+	// the owner of the transition may act for it. TxID comes
+	// from the transient map, so it is not proof on its own
+	tx, err := getTx(stub, input.TxID)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	if checkOwner(tx, caller) != nil {
+		out.Allowed = false
+		out.Reason = fmt.Sprintf("caller is not owner of txID = %s", tx.TxID)
+		return out, nil
+	}
+	if tx.State != model.TxStatePROCESSING {
+		out.Allowed = false
+		out.Reason = fmt.Sprintf("txID = %s is at %s state", tx.TxID, tx.State)
+	}
+	return out, nil
+}
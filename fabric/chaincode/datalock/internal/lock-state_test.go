@@ -19,7 +19,7 @@ func TestLockState(t *testing.T) {
 
 	t.Run("Put", func(t *testing.T) {
 		stub.MockTransactionStart("put")
-		err := putLockState(stub, txID, ccName, key)
+		_, err := putLockState(stub, txID, ccName, key)
 		stub.MockTransactionEnd("put")
 		is.NoError(err)
 		gotTxID, ok := stub.State[lockId]
@@ -3,41 +3,63 @@ package internal
 import (
 	"datalock/model"
 	"datalock/pkg/errors"
+	"datalock/pkg/metrics"
+	"datalock/validation"
 	"encoding/json"
 	"fmt"
+	"slices"
+	"time"
 
 	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
 )
 
-func lock(stub shim.ChaincodeStubInterface, txID, cc string, ccInput model.DataChaincodeInput) (map[string]string, string, error) {
+func lock(stub shim.ChaincodeStubInterface, txID, cc string, ccInput model.DataChaincodeInput) (toStore map[string]string, toClient string, fences map[string]uint64, err error) {
 	const op = errors.Op("Locker.lock")
 	ccName := errors.Chaincode(cc)
+	defer func() { chaincodeOf(stub).metrics.LockAttempt(cc, metrics.OutcomeOf(err)) }()
 	// lock state check
 	// invoke chaincode
 	// lock returned keys
 
 	// 1.
+	next := map[string]uint64{}
 	for _, key := range ccInput.Keys {
 		ok, err := isLockStateExists(stub, cc, key)
 		if err != nil {
-			return nil, "", errors.E(op, err, ccName)
+			return nil, "", nil, errors.E(op, err, ccName)
 		}
 		if ok {
-			return nil, "", errors.E(
+			return nil, "", nil, errors.E(
 				op,
 				errors.CodeConflict,
-				fmt.Errorf("key = %s already locked", key),
+				fmt.Errorf("key = %s %w", key, errors.ErrLocked),
 				errors.SeverityDebug,
 				errors.TxID(txID),
 				ccName,
 			)
 		}
+		err = checkReservation(stub, txID, cc, key)
+		if err != nil {
+			return nil, "", nil, errors.E(op, err, ccName)
+		}
+		if ccInput.Fencing {
+			fence, err := getLockFence(stub, cc, key)
+			if err != nil {
+				return nil, "", nil, errors.E(op, err, ccName)
+			}
+			next[key] = fence + 1
+		}
 	}
 
 	// 2.
-	resp := stub.InvokeChaincode(cc, stringArgsToByte(ccInput.Params), "")
+	params, err := fencedParams(ccInput, next)
+	if err != nil {
+		return nil, "", nil, errors.E(op, err, errors.TxID(txID), ccName)
+	}
+	resp := invokeChaincode(stub, cc, params)
 	if resp.GetStatus() != shim.OK {
-		return nil, "", errors.E(
+		return nil, "", nil, errors.E(
 			op,
 			errors.CodeConflict,
 			fmt.Errorf("failed to execute chaincode : %v", resp.Message),
@@ -49,9 +71,20 @@ func lock(stub shim.ChaincodeStubInterface, txID, cc string, ccInput model.DataC
 
 	// 3.
 	var ccOutput model.DataChaincodeOutput
-	err := json.Unmarshal(resp.Payload, &ccOutput)
+	err = json.Unmarshal(resp.Payload, &ccOutput)
 	if err != nil {
-		return nil, "", errors.E(
+		return nil, "", nil, errors.E(
+			op,
+			errors.CodeConflict,
+			fmt.Errorf("invalid response from data chaincode : %w", err),
+			errors.SeverityDebug,
+			errors.TxID(txID),
+			ccName,
+		)
+	}
+	err = validation.DataChaincodeOutput(ccOutput)
+	if err != nil {
+		return nil, "", nil, errors.E(
 			op,
 			errors.CodeConflict,
 			fmt.Errorf("invalid response from data chaincode : %w", err),
@@ -61,34 +94,79 @@ func lock(stub shim.ChaincodeStubInterface, txID, cc string, ccInput model.DataC
 		)
 	}
 	for _, key := range ccOutput.Keys {
-		err := putLockState(stub, txID, cc, key)
+		if slices.Contains(ccInput.Keys, key) {
+			continue
+		}
+		// key not checked at 1.
+		ok, err := isLockStateExists(stub, cc, key)
 		if err != nil {
-			return nil, "", errors.E(
+			return nil, "", nil, errors.E(op, err, ccName)
+		}
+		if ok {
+			return nil, "", nil, errors.E(
+				op,
+				errors.CodeConflict,
+				fmt.Errorf("key = %s %w", key, errors.ErrLocked),
+				errors.SeverityDebug,
+				errors.TxID(txID),
+				ccName,
+			)
+		}
+		err = checkReservation(stub, txID, cc, key)
+		if err != nil {
+			return nil, "", nil, errors.E(op, err, ccName)
+		}
+	}
+	fences = make(map[string]uint64, len(ccOutput.Keys))
+	for _, key := range ccOutput.Keys {
+		fence, err := putLockState(stub, txID, cc, key)
+		if err != nil {
+			return nil, "", nil, errors.E(
 				op,
 				err,
 				ccName,
 			)
 		}
+		fences[key] = fence
+		err = leaveKeyQueue(stub, txID, cc, key)
+		if err != nil {
+			return nil, "", nil, errors.E(op, err, ccName)
+		}
 	}
-	return ccOutput.OutputToStore, ccOutput.OutputToClient, nil
+	return ccOutput.OutputToStore, ccOutput.OutputToClient, fences, nil
+}
+
+// released : keys freed by unlock, and waiters
+// made eligible for them
+type released struct {
+	keys     []string
+	eligible []model.EligibleWaiter
+}
+
+func unlock(stub shim.ChaincodeStubInterface, txID, cc string, ccInput model.DataChaincodeInput) (map[string]string, string, *released, error) {
+	return unlockKeys(stub, txID, cc, ccInput, false)
 }
 
-func unlock(stub shim.ChaincodeStubInterface, txID, cc string, ccInput model.DataChaincodeInput) (map[string]string, string, error) {
+// unlockKeys : unlocks keys returned by data chaincode, if all
+// is true it must return every key of the input
+func unlockKeys(stub shim.ChaincodeStubInterface, txID, cc string, ccInput model.DataChaincodeInput, all bool) (toStore map[string]string, toClient string, freed *released, err error) {
 	const op = errors.Op("Locker.unlock")
 	ccName := errors.Chaincode(cc)
+	defer func() { chaincodeOf(stub).metrics.Unlock(cc, metrics.OutcomeOf(err)) }()
 	// check locked state of each key
 	// invoke chaincode
 	// unlock keys
 
 	// 1.
+	current := map[string]uint64{}
 	for _, key := range ccInput.Keys {
 		gotTxID, err := getLockStateTxID(stub, cc, key)
 		if err != nil {
-			return nil, "", errors.E(op, err, ccName)
+			return nil, "", nil, errors.E(op, err, ccName)
 		}
 
 		if gotTxID != txID {
-			return nil, "", errors.E(
+			return nil, "", nil, errors.E(
 				op,
 				errors.CodeConflict,
 				fmt.Errorf("data not locked for txID = %s", txID),
@@ -97,12 +175,22 @@ func unlock(stub shim.ChaincodeStubInterface, txID, cc string, ccInput model.Dat
 				ccName,
 			)
 		}
+		if ccInput.Fencing {
+			current[key], err = getLockFence(stub, cc, key)
+			if err != nil {
+				return nil, "", nil, errors.E(op, err, ccName)
+			}
+		}
 	}
 
 	// 2.
-	resp := stub.InvokeChaincode(cc, stringArgsToByte(ccInput.Params), "")
+	params, err := fencedParams(ccInput, current)
+	if err != nil {
+		return nil, "", nil, errors.E(op, err, errors.TxID(txID), ccName)
+	}
+	resp := invokeChaincode(stub, cc, params)
 	if resp.GetStatus() != shim.OK {
-		return nil, "", errors.E(
+		return nil, "", nil, errors.E(
 			op,
 			errors.CodeConflict,
 			fmt.Errorf("failed to execute chaincode : %v", resp.Message),
@@ -114,9 +202,20 @@ func unlock(stub shim.ChaincodeStubInterface, txID, cc string, ccInput model.Dat
 
 	// 3.
 	var ccOutput model.DataChaincodeOutput
-	err := json.Unmarshal(resp.Payload, &ccOutput)
+	err = json.Unmarshal(resp.Payload, &ccOutput)
+	if err != nil {
+		return nil, "", nil, errors.E(
+			op,
+			errors.CodeConflict,
+			fmt.Errorf("invalid response from data chaincode : %w", err),
+			errors.SeverityDebug,
+			errors.TxID(txID),
+			ccName,
+		)
+	}
+	err = validation.DataChaincodeOutput(ccOutput)
 	if err != nil {
-		return nil, "", errors.E(
+		return nil, "", nil, errors.E(
 			op,
 			errors.CodeConflict,
 			fmt.Errorf("invalid response from data chaincode : %w", err),
@@ -125,17 +224,80 @@ func unlock(stub shim.ChaincodeStubInterface, txID, cc string, ccInput model.Dat
 			ccName,
 		)
 	}
+	for _, key := range ccInput.Keys {
+		if all && !slices.Contains(ccOutput.Keys, key) {
+			return nil, "", nil, errors.E(
+				op,
+				errors.CodeConflict,
+				fmt.Errorf("key = %s not freed by data chaincode", key),
+				errors.SeverityDebug,
+				errors.TxID(txID),
+				ccName,
+			)
+		}
+	}
 	for _, key := range ccOutput.Keys {
-		err := deleteLockState(stub, txID, lockStateID(cc, key))
+		if slices.Contains(ccInput.Keys, key) {
+			continue
+		}
+		// key not checked at 1.
+		gotTxID, err := getLockStateTxID(stub, cc, key)
 		if err != nil {
-			return nil, "", errors.E(
+			return nil, "", nil, errors.E(op, err, ccName)
+		}
+		if gotTxID != txID {
+			return nil, "", nil, errors.E(
+				op,
+				errors.CodeConflict,
+				fmt.Errorf("data not locked for txID = %s", txID),
+				errors.SeverityDebug,
+				errors.TxID(txID),
+				ccName,
+			)
+		}
+	}
+	freed = &released{keys: ccOutput.Keys}
+	for _, key := range ccOutput.Keys {
+		next, err := releaseLock(stub, txID, lockStateID(cc, key), nil)
+		if err != nil {
+			return nil, "", nil, errors.E(
 				op,
 				err,
 				ccName,
 			)
 		}
+		if next != nil {
+			freed.eligible = append(freed.eligible, *next)
+		}
+	}
+	return ccOutput.OutputToStore, ccOutput.OutputToClient, freed, nil
+}
+
+// fencedParams : params of data chaincode, with fencing
+// numbers of keys appended if requested by the input
+func fencedParams(ccInput model.DataChaincodeInput, fences map[string]uint64) ([]string, error) {
+	const op = errors.Op("Locker.fencedParams")
+	if !ccInput.Fencing {
+		return ccInput.Params, nil
+	}
+	raw, err := json.Marshal(fences)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to encode fencing numbers : %w", err),
+			errors.SeverityError,
+		)
 	}
-	return ccOutput.OutputToStore, ccOutput.OutputToClient, nil
+	return append(slices.Clone(ccInput.Params), string(raw)), nil
+}
+
+// invokeChaincode : invokes data chaincode recording its duration
+func invokeChaincode(stub shim.ChaincodeStubInterface, cc string, params []string) pb.Response {
+	start := time.Now()
+	resp := stub.InvokeChaincode(cc, stringArgsToByte(params), "")
+	chaincodeOf(stub).metrics.InvokeDuration(cc, time.Since(start))
+	return resp
 }
 
 func stringArgsToByte(args []string) [][]byte {
@@ -1,9 +1,11 @@
 package internal
 
 import (
+	"bytes"
 	"container/list"
 	"datalock/mock"
 	"datalock/model"
+	"datalock/pkg/metrics"
 	"encoding/base64"
 	"encoding/json"
 	"testing"
@@ -61,7 +63,7 @@ func TestLockerLock(t *testing.T) {
 	/////////////////////////////////////
 	txID := "txID-1"
 	reqStub.MockTransactionStart("mock-lock")
-	toStore, toClient, err := lock(reqStub, txID, emCCName, model.DataChaincodeInput{
+	toStore, toClient, fences, err := lock(reqStub, txID, emCCName, model.DataChaincodeInput{
 		Keys:   []string{"uuid-1", "uuid-3", "uuid-5"},
 		Params: []string{"getValidEmissions", "uuid-1", "uuid-3", "uuid-5"},
 	})
@@ -85,9 +87,10 @@ func TestLockerLock(t *testing.T) {
 	err = json.Unmarshal(raw, &validUUIDs)
 	is.NoError(err)
 	is.Len(validUUIDs, 2)
+	is.Equal(map[string]uint64{"uuid-1": 1, "uuid-3": 1}, fences)
 
-	// test on state
-	is.Len(reqStub.State, 4)
+	// test on state : lock, index and fence of each key
+	is.Len(reqStub.State, 6)
 	raw, ok = reqStub.State["EmissionsCC::uuid-1"]
 	is.True(ok)
 	is.Equal(txID, string(raw))
@@ -113,7 +116,7 @@ func TestLockerLockFail(t *testing.T) {
 		txStub.MockTransactionStart("setup")
 		putLockState(txStub, txID, emCCName, "uuid-1")
 		txStub.MockTransactionEnd("setup")
-		toStore, toClient, err := lock(txStub, txID, emCCName, model.DataChaincodeInput{
+		toStore, toClient, _, err := lock(txStub, txID, emCCName, model.DataChaincodeInput{
 			Keys:   []string{"uuid-1", "uuid-3", "uuid-5"},
 			Params: []string{"getValidEmissions", "uuid-1", "uuid-3", "uuid-5"},
 		})
@@ -125,7 +128,7 @@ func TestLockerLockFail(t *testing.T) {
 	})
 
 	t.Run("BusinessLogicfail", func(t *testing.T) {
-		toStore, toClient, err := lock(txStub, txID, emCCName, model.DataChaincodeInput{
+		toStore, toClient, _, err := lock(txStub, txID, emCCName, model.DataChaincodeInput{
 			Keys:   []string{"uuid-1", "uuid-3", "uuid-5"},
 			Params: []string{"method-not-found", "uuid-1", "uuid-3", "uuid-5"},
 		})
@@ -135,7 +138,7 @@ func TestLockerLockFail(t *testing.T) {
 	})
 
 	t.Run("InvalidResponse", func(t *testing.T) {
-		toStore, toClient, err := lock(txStub, txID, emCCName, model.DataChaincodeInput{
+		toStore, toClient, _, err := lock(txStub, txID, emCCName, model.DataChaincodeInput{
 			Keys:   []string{"uuid-1", "uuid-3", "uuid-5"},
 			Params: []string{"method-invalid-response", "uuid-1", "uuid-3", "uuid-5"},
 		})
@@ -145,6 +148,40 @@ func TestLockerLockFail(t *testing.T) {
 	})
 }
 
+func TestLockerMetrics(t *testing.T) {
+	is := assert.New(t)
+	registry := metrics.NewRegistry()
+	cc := New(WithMetrics(registry))
+
+	emCCName := "EmissionsCC"
+	emStub := shimtest.NewMockStub(emCCName, mock.MockEmissionsCC{})
+	loadMockEmissions(emStub)
+
+	txStub := buildEmptyMockStub()
+	txStub.Invokables[emCCName] = emStub
+
+	input := model.DataChaincodeInput{
+		Keys:   []string{"uuid-1"},
+		Params: []string{"getValidEmissions", "uuid-1"},
+	}
+	txStub.MockTransactionStart("tx-1")
+	_, _, _, err := lock(requestStub{txStub, cc}, "txID-1", emCCName, input)
+	txStub.MockTransactionEnd("tx-1")
+	is.NoError(err)
+
+	txStub.MockTransactionStart("tx-2")
+	_, _, _, err = lock(requestStub{txStub, cc}, "txID-2", emCCName, input)
+	txStub.MockTransactionEnd("tx-2")
+	is.Error(err)
+
+	buf := new(bytes.Buffer)
+	is.NoError(registry.WriteText(buf))
+	is.Contains(buf.String(), `datalock_lock_attempts_total{chaincode="EmissionsCC",outcome="success"} 1`)
+	is.Contains(buf.String(), `datalock_lock_attempts_total{chaincode="EmissionsCC",outcome="conflict"} 1`)
+	// data chaincode is invoked only when keys are free
+	is.Contains(buf.String(), `datalock_invoke_duration_seconds_count{chaincode="EmissionsCC"} 1`)
+}
+
 func TestLockerUnlock(t *testing.T) {
 	is := assert.New(t)
 
@@ -165,7 +202,7 @@ func TestLockerUnlock(t *testing.T) {
 	partyID := "partyID-1"
 	tokenID := "tokenID-1"
 	txStub.MockTransactionStart("tx")
-	toStore, toClient, err := unlock(txStub, txID, emCCName, model.DataChaincodeInput{
+	toStore, toClient, _, err := unlock(txStub, txID, emCCName, model.DataChaincodeInput{
 		Keys:   []string{"uuid-1", "uuid-2"},
 		Params: []string{"UpdateEmissionsWithToken", tokenID, partyID, "uuid-1", "uuid-2"},
 	})
@@ -206,7 +243,7 @@ func TestLockerUnlockFail(t *testing.T) {
 
 	txID := "txId-1"
 	t.Run("notLocked", func(t *testing.T) {
-		toStore, toClient, err := unlock(txStub, txID, emCCName, model.DataChaincodeInput{
+		toStore, toClient, _, err := unlock(txStub, txID, emCCName, model.DataChaincodeInput{
 			Keys:   []string{"uuid-1"},
 			Params: []string{},
 		})
@@ -219,7 +256,7 @@ func TestLockerUnlockFail(t *testing.T) {
 	txStub.MockTransactionStart("setup")
 
 	t.Run("BusinessLogicfail", func(t *testing.T) {
-		toStore, toClient, err := unlock(txStub, txID, emCCName, model.DataChaincodeInput{
+		toStore, toClient, _, err := unlock(txStub, txID, emCCName, model.DataChaincodeInput{
 			Keys:   []string{"uuid-1"},
 			Params: []string{"UpdateEmissionsWithToken"},
 		})
@@ -230,7 +267,7 @@ func TestLockerUnlockFail(t *testing.T) {
 	})
 
 	t.Run("invlaidResponse", func(t *testing.T) {
-		toStore, toClient, err := unlock(txStub, txID, emCCName, model.DataChaincodeInput{
+		toStore, toClient, _, err := unlock(txStub, txID, emCCName, model.DataChaincodeInput{
 			Keys:   []string{"uuid-1"},
 			Params: []string{"method-invalid-response"},
 		})
@@ -241,3 +278,39 @@ func TestLockerUnlockFail(t *testing.T) {
 	})
 
 }
+
+func TestLockerFencing(t *testing.T) {
+	is := assert.New(t)
+
+	txStub := buildEmptyMockStub()
+	txStub.Invokables[fuzzCCName] = shimtest.NewMockStub(fuzzCCName, &fuzzCC{payload: []byte(`{"keys":["key-1"]}`)})
+	input := model.DataChaincodeInput{Keys: []string{"key-1"}, Params: []string{"update"}, Fencing: true}
+
+	// fencing number grows with every lock of the key
+	for i, txID := range []string{"txID-1", "txID-2"} {
+		txStub.MockTransactionStart("lock")
+		_, _, fences, err := lock(txStub, txID, fuzzCCName, input)
+		txStub.MockTransactionEnd("lock")
+		is.NoError(err)
+		is.Equal(map[string]uint64{"key-1": uint64(i + 1)}, fences)
+
+		out, err := checkKeyLocks(txStub, model.CheckLocksInput{Chaincode: fuzzCCName, Keys: []string{"key-1"}})
+		is.NoError(err)
+		is.Equal(uint64(i+1), out.Locks[0].Fence)
+
+		txStub.MockTransactionStart("unlock")
+		_, _, _, err = unlock(txStub, txID, fuzzCCName, input)
+		txStub.MockTransactionEnd("unlock")
+		is.NoError(err)
+	}
+
+	params, err := fencedParams(input, map[string]uint64{"key-1": 3})
+	is.NoError(err)
+	is.Equal([]string{"update", `{"key-1":3}`}, params)
+	is.Equal([]string{"update"}, input.Params)
+
+	input.Fencing = false
+	params, err = fencedParams(input, nil)
+	is.NoError(err)
+	is.Equal([]string{"update"}, params)
+}
@@ -2,32 +2,83 @@ package internal
 
 import (
 	"datalock/model"
+	"datalock/pkg/canonical"
 	"datalock/pkg/errors"
+	"datalock/pkg/metrics"
+	"datalock/validation"
 	"encoding/json"
 	"fmt"
+	"strconv"
 
 	"github.com/hyperledger/fabric-chaincode-go/shim"
 )
 
-var methodMap = map[string]func(stub shim.ChaincodeStubInterface, args []string) ([]byte, error){
-	"startTransitionProcess": startTransitionProcess,
-	"endTransitionProcess":   endTransitionProcess,
-	"stageUpdate":            stageUpdate,
-	"getTxDetails":           getTxDetails,
+// Method : serves a request with the arguments
+// following the method name
+type Method func(stub shim.ChaincodeStubInterface, args []string) ([]byte, error)
+
+// Methods : method by name
+type Methods map[string]Method
+
+// defaultMethods : datalock methods
+func defaultMethods() Methods {
+	return Methods{
+		"startTransitionProcess":   startTransitionProcess,
+		"endTransitionProcess":     endTransitionProcess,
+		"pauseTransitionProcess":   pauseTransitionProcess,
+		"resumeTransitionProcess":  resumeTransitionProcess,
+		"abortTransitionProcess":   abortTransitionProcess,
+		"stageUpdate":              stageUpdate,
+		"getTxDetails":             getTxDetails,
+		"getStageOutput":           getStageOutput,
+		"verifyExternalCommitment": verifyExternalCommitment,
+		"getTxDigest":              getTxDigest,
+		"migrateTransitions":       migrateTransitions,
+		"checkLocks":               checkLocks,
+		"joinQueue":                joinQueue,
+		"leaveQueue":               leaveQueue,
+		"getQueue":                 getQueue,
+		"setFinishPolicy":          setFinishPolicy,
+		"getFinishPolicy":          getWorkflowFinishPolicy,
+		"approveHandoff":           approveHandoff,
+		"handoffLocks":             handoffLocks,
+		"startChildTransition":     startChildTransition,
+		"getTxTree":                getTxTree,
+		"simulateStageUpdate":      simulateStageUpdate,
+		"archiveFinished":          archiveFinished,
+		"getTxArchive":             getTxArchiveSummary,
+		"bootstrapConfig":          bootstrapConfig,
+		"setConfig":                setConfig,
+		"getConfig":                getCurrentConfig,
+	}
 }
 
+// startTransitionProcess : args : txID, [workflow]. The workflow,
+// set when the transition is created, selects its finish policy
 func startTransitionProcess(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	const op = errors.Op("Method.startTransitionProcess")
-	if len(args) != 1 {
+	if len(args) != 1 && len(args) != 2 {
 		return nil, errors.E(
 			op,
 			errors.CodeInvalidInput,
-			fmt.Errorf("invalid number of input, require 1, but provided %s", args),
+			fmt.Errorf("invalid number of input, require 1 or 2, but provided %s", args),
 			errors.SeverityDebug,
 		)
 	}
 	txID := args[0]
-	raw, err := txState(stub, txID, true)
+	err := validation.TxID(txID)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	workflow := ""
+	if len(args) == 2 {
+		workflow = args[1]
+		err = validation.Workflow(workflow)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+	raw, err := txState(stub, txID, workflow, true)
 	if err != nil {
 		return nil, errors.E(op, err)
 	}
@@ -45,15 +96,19 @@ func endTransitionProcess(stub shim.ChaincodeStubInterface, args []string) ([]by
 		)
 	}
 	txID := args[0]
-	_, err := txState(stub, txID, false)
+	err := validation.TxID(txID)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	_, err = txState(stub, txID, "", false)
 	if err != nil {
 		return nil, errors.E(op, err)
 	}
 	return nil, nil
 }
 
-func stageUpdate(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	const op = errors.Op("Method.stageUpdate")
+func pauseTransitionProcess(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	const op = errors.Op("Method.pauseTransitionProcess")
 	if len(args) != 1 {
 		return nil, errors.E(
 			op,
@@ -62,7 +117,7 @@ func stageUpdate(stub shim.ChaincodeStubInterface, args []string) ([]byte, error
 			errors.SeverityDebug,
 		)
 	}
-	var input model.StageUpdateInput
+	var input model.TxPauseInput
 	err := json.Unmarshal([]byte(args[0]), &input)
 	if err != nil {
 		return nil, errors.E(
@@ -72,41 +127,194 @@ func stageUpdate(stub shim.ChaincodeStubInterface, args []string) ([]byte, error
 			errors.SeverityDebug,
 		)
 	}
+	err = validation.TxPauseInput(input)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	raw, err := pauseTx(stub, input)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return raw, nil
+}
 
-	raw, err := stub.GetState(input.TxID)
-	if err != nil || len(raw) == 0 {
+// resumeTransitionProcess : args : txID, [input]. The input
+// {"reason_code": ..., "checkpoint": {...}} records why and
+// from where the transition is resumed
+func resumeTransitionProcess(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	const op = errors.Op("Method.resumeTransitionProcess")
+	if len(args) != 1 && len(args) != 2 {
 		return nil, errors.E(
 			op,
-			errors.CodeNotFound,
-			fmt.Errorf("transition not found"),
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid number of input, require 1 or 2, but provided %s", args),
+			errors.SeverityDebug,
+		)
+	}
+	var input model.TxResumeInput
+	if len(args) == 2 {
+		err := json.Unmarshal([]byte(args[1]), &input)
+		if err != nil {
+			return nil, errors.E(
+				op,
+				errors.CodeInvalidInput,
+				fmt.Errorf("invalid input object : %w", err),
+				errors.SeverityDebug,
+			)
+		}
+	}
+	input.TxID = args[0]
+	err := validation.TxResumeInput(input)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	raw, err := resumeTx(stub, input)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return raw, nil
+}
+
+func abortTransitionProcess(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	const op = errors.Op("Method.abortTransitionProcess")
+	if len(args) != 1 {
+		return nil, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid number of input, require 1, but provided %s", args),
+			errors.SeverityDebug,
+		)
+	}
+	err := validation.TxID(args[0])
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	raw, err := abortTx(stub, args[0])
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return raw, nil
+}
+
+func stageUpdate(stub shim.ChaincodeStubInterface, args []string) (out []byte, err error) {
+	const op = errors.Op("Method.stageUpdate")
+	defer func() { chaincodeOf(stub).metrics.StageUpdate(metrics.OutcomeOf(err)) }()
+	if len(args) != 1 {
+		return nil, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid number of input, require 1, but provided %s", args),
+			errors.SeverityDebug,
+		)
+	}
+	var input model.StageUpdateInput
+	err = json.Unmarshal([]byte(args[0]), &input)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid input object : %w", err),
 			errors.SeverityDebug,
+		)
+	}
+
+	err = validation.StageUpdateInput(input)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+
+	output, err := updateStage(stub, op, input)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(output)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to encode stage output : %w", err),
+			errors.SeverityError,
 			errors.TxID(input.TxID),
 		)
 	}
-	var tx model.Transaction
-	json.Unmarshal(raw, &tx)
+	return raw, nil
+}
+
+// updateStage : locks and frees data of the stage through data
+// chaincodes and records the stage on the transaction. Errors
+// are wrapped with op of the calling method
+func updateStage(stub shim.ChaincodeStubInterface, op errors.Op, input model.StageUpdateInput) (*model.StageUpdateOutput, error) {
+	tx, err := getTx(stub, input.TxID)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
 	if tx.State != model.TxStatePROCESSING {
 		return nil, errors.E(
 			op,
-			errors.CodeNotFound,
+			errors.CodeConflict,
 			fmt.Errorf("transition is not at processing state, found at %s", tx.State),
 			errors.SeverityDebug,
 			errors.TxID(input.TxID),
 		)
 	}
 
+	config, err := getConfig(stub)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	err = checkStageConfig(config, tx, input)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	tx.StageUpdates++
+
+	if input.IsLast {
+		err = checkChildrenDone(stub, tx.TxID)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+
+	if input.Receipt != nil {
+		// receipt is checked against data of every stage
+		view := *tx
+		view.StageData, err = getAllStageData(stub, tx)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+		err = checkExternalReceipt(&view, input.Name, input.Receipt)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+
 	tx.CurrentStage = input.Name
 	output := model.StageUpdateOutput{
 		DataLocks: map[string]string{},
 		DataFree:  map[string]string{},
+		Fences:    map[string]map[string]uint64{},
 	}
 	stageData := model.TxStageData{
 		Output: map[string]map[string]string{},
 	}
 	stageData.Storage = input.Storage
+	stageData.Commitment = input.Commitment
+	stageData.Receipt = input.Receipt
+	if input.Collection != "" {
+		stageData.Storage, err = transientStorage(stub)
+		if err != nil {
+			return nil, errors.E(op, err, errors.TxID(input.TxID))
+		}
+		stageData.Salt, err = transientSalt(stub)
+		if err != nil {
+			return nil, errors.E(op, err, errors.TxID(input.TxID))
+		}
+	}
 
+	// lockIDs locked and freed by the stage
+	locked, freed := []string{}, []string{}
 	for ccName, ccInput := range input.DataLocks {
-		toStore, toClient, err := lock(stub, tx.TxID, ccName, ccInput)
+		toStore, toClient, fences, err := lock(stub, tx.TxID, ccName, ccInput)
 		if err != nil {
 			return nil, errors.E(
 				op,
@@ -116,12 +324,19 @@ func stageUpdate(stub shim.ChaincodeStubInterface, args []string) ([]byte, error
 		if len(toClient) != 0 {
 			output.DataLocks[ccName] = toClient
 		}
+		for key := range fences {
+			locked = append(locked, lockStateID(ccName, key))
+		}
+		if len(fences) != 0 {
+			output.Fences[ccName] = fences
+		}
 		if len(toClient) != 0 {
 			stageData.Output[ccName] = toStore
 		}
 	}
+	eligible := []model.EligibleWaiter{}
 	for ccName, ccInput := range input.DataFree {
-		toStore, toClient, err := unlock(stub, tx.TxID, ccName, ccInput)
+		toStore, toClient, next, err := unlock(stub, tx.TxID, ccName, ccInput)
 		if err != nil {
 			return nil, errors.E(
 				op,
@@ -131,51 +346,941 @@ func stageUpdate(stub shim.ChaincodeStubInterface, args []string) ([]byte, error
 		if len(toClient) != 0 {
 			output.DataFree[ccName] = toClient
 		}
+		for _, key := range next.keys {
+			freed = append(freed, lockStateID(ccName, key))
+		}
+		eligible = append(eligible, next.eligible...)
 		if len(toClient) != 0 {
 			stageData.Output[ccName] = toStore
 		}
 	}
-	if len(stageData.Output) != 0 || len(stageData.Storage) != 0 {
-		tx.StageData[input.Name] = &stageData
+	if input.IsLast {
+		release, err := applyFinishPolicy(stub, tx, locked, freed)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+		if release != nil {
+			// output of the stage itself takes precedence
+			for ccName, toClient := range release.toClient {
+				if _, ok := output.DataFree[ccName]; !ok {
+					output.DataFree[ccName] = toClient
+				}
+				if stageData.Output[ccName] == nil {
+					stageData.Output[ccName] = map[string]string{}
+				}
+				for k, v := range release.toStore[ccName] {
+					if _, ok := stageData.Output[ccName][k]; !ok {
+						stageData.Output[ccName][k] = v
+					}
+				}
+			}
+			eligible = append(eligible, release.eligible...)
+			tx.ReleasedOnFinish = release.lockIDs
+			output.Released = release.lockIDs
+		}
+	}
+	if len(stageData.Output) != 0 || len(stageData.Storage) != 0 ||
+		stageData.Commitment != nil || stageData.Receipt != nil {
+		if input.Collection != "" {
+			public, err := putPrivateStageData(stub, tx.TxID, input.Name, input.Collection, &stageData)
+			if err != nil {
+				return nil, errors.E(op, err)
+			}
+			tx.StageData[input.Name] = public
+		} else {
+			tx.StageData[input.Name] = &stageData
+		}
 	}
 	if input.IsLast {
+		now, err := txTimestamp(stub)
+		if err != nil {
+			return nil, errors.E(op, err, errors.TxID(tx.TxID))
+		}
 		tx.State = model.TxStateFINISHED
+		tx.FinishedAt = now
+	}
+	_, err = putTx(stub, tx)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	err = setQueueEvent(stub, eligible)
+	if err != nil {
+		return nil, errors.E(op, err, errors.TxID(tx.TxID))
+	}
+	return &output, nil
+}
+
+func getTxDetails(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	const op = errors.Op("Method.getTxDetails")
+	if len(args) != 1 {
+		return nil, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid number of input, require 1, but provided %s", args),
+			errors.SeverityDebug,
+		)
+	}
+	txID := args[0]
+	err := validation.TxID(txID)
+	if err != nil {
+		return nil, errors.E(op, err)
 	}
-	raw, _ = json.Marshal(tx)
-	err = stub.PutState(tx.TxID, raw)
+	tx, err := getTxView(stub, txID)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	raw, err := canonical.Marshal(tx)
 	if err != nil {
 		return nil, errors.E(
 			op,
 			errors.CodeUnexpected,
-			fmt.Errorf("failed to put transaction state : %w", err),
+			fmt.Errorf("failed to encode transaction : %w", err),
 			errors.SeverityError,
-			errors.TxID(tx.TxID),
+			errors.TxID(txID),
 		)
 	}
-	raw, _ = json.Marshal(output)
 	return raw, nil
 }
 
-func getTxDetails(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	const op = errors.Op("Method.getTxDetails")
-	if len(args) != 1 {
+// getStageOutput : returns storage and outputs of a stage,
+// reading the values from private data collection
+// for private stages
+func getStageOutput(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	const op = errors.Op("Method.getStageOutput")
+	if len(args) != 2 {
 		return nil, errors.E(
 			op,
 			errors.CodeInvalidInput,
-			fmt.Errorf("invalid number of input, require 1, but provided %s", args),
+			fmt.Errorf("invalid number of input, require 2, but provided %s", args),
 			errors.SeverityDebug,
 		)
 	}
-	txID := args[0]
-	raw, err := stub.GetState(txID)
-	if err != nil || len(raw) == 0 {
+	txID, stage := args[0], args[1]
+	err := validation.Stage(txID, stage)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	tx, err := getTx(stub, txID)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	data, err := getStageData(stub, tx, stage)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	if data == nil {
 		return nil, errors.E(
 			op,
 			errors.CodeNotFound,
-			fmt.Errorf("transition not found"),
+			fmt.Errorf("stage = %s has no data", stage),
+			errors.SeverityDebug,
+			errors.TxID(txID),
+		)
+	}
+	if data.Collection != "" {
+		data, err = getPrivateStageData(stub, txID, stage, data)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to encode stage data : %w", err),
+			errors.SeverityError,
+			errors.TxID(txID),
+		)
+	}
+	return raw, nil
+}
+
+// verifyExternalCommitment : lets auditors check that the fabric
+// and external ledger halves of a transition match
+// args : txID, commitment stage, [observed receipt]
+func verifyExternalCommitment(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	const op = errors.Op("Method.verifyExternalCommitment")
+	if len(args) != 2 && len(args) != 3 {
+		return nil, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid number of input, require 2 or 3, but provided %s", args),
 			errors.SeverityDebug,
+		)
+	}
+	txID, stage := args[0], args[1]
+	err := validation.Stage(txID, stage)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	var observed *model.ExternalReceipt
+	if len(args) == 3 {
+		observed = new(model.ExternalReceipt)
+		err = json.Unmarshal([]byte(args[2]), observed)
+		if err != nil {
+			return nil, errors.E(
+				op,
+				errors.CodeInvalidInput,
+				fmt.Errorf("invalid observed receipt : %w", err),
+				errors.SeverityDebug,
+				errors.TxID(txID),
+			)
+		}
+		err = validation.ObservedReceipt(*observed)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+	tx, err := getTxView(stub, txID)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	out := verifyExternalReceipt(tx, stage, observed)
+	raw, err := json.Marshal(out)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to encode verification : %w", err),
+			errors.SeverityError,
 			errors.TxID(txID),
 		)
 	}
 	return raw, nil
 }
+
+// getTxDigest : returns revision and content hash of a
+// transaction, so that peers or an off-chain archive can
+// confirm they hold the same transaction state
+func getTxDigest(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	const op = errors.Op("Method.getTxDigest")
+	if len(args) != 1 {
+		return nil, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid number of input, require 1, but provided %s", args),
+			errors.SeverityDebug,
+		)
+	}
+	err := validation.TxID(args[0])
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	tx, err := getTx(stub, args[0])
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	digest, err := txDigest(tx)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	stages, err := validStageDigests(stub, tx)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	out := model.TxDigest{
+		TxID:     tx.TxID,
+		Revision: tx.Revision,
+		Digest:   tx.Digest,
+		Valid:    digest == tx.Digest && stages,
+	}
+	raw, err := json.Marshal(out)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to encode digest : %w", err),
+			errors.SeverityError,
+			errors.TxID(tx.TxID),
+		)
+	}
+	return raw, nil
+}
+
+// migrateTransitions : moves stage data of transactions stored
+// in the old layout, inline in the transaction, to its own keys.
+// Called by an admin, the caller also becomes owner of the
+// transitions created before owners were recorded
+// args : txID, ... (at most validation.MaxKeys)
+func migrateTransitions(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	const op = errors.Op("Method.migrateTransitions")
+	if len(args) == 0 || len(args) > validation.MaxKeys {
+		return nil, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid number of input, require 1 to %d, but provided %d", validation.MaxKeys, len(args)),
+			errors.SeverityDebug,
+		)
+	}
+	for _, txID := range args {
+		err := validation.TxID(txID)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+	claimant, err := adminCaller(stub)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	out := model.TxMigrationOutput{Migrated: []string{}}
+	for _, txID := range args {
+		migrated, err := migrateTx(stub, txID, claimant)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+		if migrated {
+			out.Migrated = append(out.Migrated, txID)
+		}
+	}
+	raw, err := json.Marshal(out)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to encode migration output : %w", err),
+			errors.SeverityError,
+		)
+	}
+	return raw, nil
+}
+
+// checkLocks : read only, called by data chaincodes (see pkg/guard)
+// before writing keys, so that locks are enforced rather than
+// advisory
+func checkLocks(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	const op = errors.Op("Method.checkLocks")
+	if len(args) != 1 {
+		return nil, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid number of input, require 1, but provided %s", args),
+			errors.SeverityDebug,
+		)
+	}
+	var input model.CheckLocksInput
+	err := json.Unmarshal([]byte(args[0]), &input)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid input object : %w", err),
+			errors.SeverityDebug,
+		)
+	}
+	err = validation.CheckLocksInput(input)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	out, err := checkKeyLocks(stub, input)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	raw, err := json.Marshal(out)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to encode lock check : %w", err),
+			errors.SeverityError,
+		)
+	}
+	return raw, nil
+}
+
+// joinQueue : queues the transition for locked keys, instead
+// of retrying lock, see queue.go
+func joinQueue(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	const op = errors.Op("Method.joinQueue")
+	input, err := queueInput(op, args)
+	if err != nil {
+		return nil, err
+	}
+	out, err := enqueueTx(stub, input)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	raw, err := json.Marshal(out)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to encode queue positions : %w", err),
+			errors.SeverityError,
+			errors.TxID(input.TxID),
+		)
+	}
+	return raw, nil
+}
+
+func leaveQueue(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	const op = errors.Op("Method.leaveQueue")
+	input, err := queueInput(op, args)
+	if err != nil {
+		return nil, err
+	}
+	eligible, err := dequeueTx(stub, input)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	err = setQueueEvent(stub, eligible)
+	if err != nil {
+		return nil, errors.E(op, err, errors.TxID(input.TxID))
+	}
+	return nil, nil
+}
+
+func queueInput(op errors.Op, args []string) (model.QueueInput, error) {
+	var input model.QueueInput
+	if len(args) != 1 {
+		return input, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid number of input, require 1, but provided %s", args),
+			errors.SeverityDebug,
+		)
+	}
+	err := json.Unmarshal([]byte(args[0]), &input)
+	if err != nil {
+		return input, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid input object : %w", err),
+			errors.SeverityDebug,
+		)
+	}
+	err = validation.QueueInput(input)
+	if err != nil {
+		return input, errors.E(op, err)
+	}
+	return input, nil
+}
+
+// getQueue : waiters of a key and the one it is reserved for
+// args : chaincode, key
+func getQueue(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	const op = errors.Op("Method.getQueue")
+	if len(args) != 2 {
+		return nil, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid number of input, require 2, but provided %s", args),
+			errors.SeverityDebug,
+		)
+	}
+	err := validation.CheckLocksInput(model.CheckLocksInput{Chaincode: args[0], Keys: args[1:]})
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	queue, err := getKeyQueue(stub, args[0], args[1])
+	if err != nil {
+		return nil, errors.E(op, err, errors.Chaincode(args[0]))
+	}
+	raw, err := json.Marshal(queue)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to encode key queue : %w", err),
+			errors.SeverityError,
+		)
+	}
+	return raw, nil
+}
+
+// setFinishPolicy : admin, sets what finishing a transition of the
+// workflow does with the locks it still holds
+func setFinishPolicy(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	const op = errors.Op("Method.setFinishPolicy")
+	if len(args) != 1 {
+		return nil, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid number of input, require 1, but provided %s", args),
+			errors.SeverityDebug,
+		)
+	}
+	var policy model.FinishPolicy
+	err := json.Unmarshal([]byte(args[0]), &policy)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid input object : %w", err),
+			errors.SeverityDebug,
+		)
+	}
+	err = validation.FinishPolicy(policy)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	err = checkAdminMethod(stub)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	err = putFinishPolicy(stub, policy)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return nil, nil
+}
+
+// getWorkflowFinishPolicy : args : workflow
+func getWorkflowFinishPolicy(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	const op = errors.Op("Method.getFinishPolicy")
+	if len(args) != 1 {
+		return nil, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid number of input, require 1, but provided %s", args),
+			errors.SeverityDebug,
+		)
+	}
+	err := validation.Workflow(args[0])
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	policy, err := getFinishPolicy(stub, args[0])
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	if policy == nil {
+		return nil, errors.E(
+			op,
+			errors.CodeNotFound,
+			fmt.Errorf("workflow = %s has no finish policy", args[0]),
+			errors.SeverityDebug,
+		)
+	}
+	raw, err := json.Marshal(policy)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to encode finish policy : %w", err),
+			errors.SeverityError,
+		)
+	}
+	return raw, nil
+}
+
+// approveHandoff : called by owner of the transition holding
+// the locks, allowing the other one to take them over
+func approveHandoff(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	const op = errors.Op("Method.approveHandoff")
+	input, err := handoffInput(op, args)
+	if err != nil {
+		return nil, err
+	}
+	err = approveTxHandoff(stub, input)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return nil, nil
+}
+
+// handoffLocks : called by owner of the transition taking over
+// the locks, returns the moved locks and their fencing numbers
+func handoffLocks(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	const op = errors.Op("Method.handoffLocks")
+	input, err := handoffInput(op, args)
+	if err != nil {
+		return nil, err
+	}
+	out, err := handoffTxLocks(stub, input)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	raw, err := json.Marshal(out)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to encode handoff output : %w", err),
+			errors.SeverityError,
+			errors.TxID(input.To),
+		)
+	}
+	return raw, nil
+}
+
+func handoffInput(op errors.Op, args []string) (model.HandoffInput, error) {
+	var input model.HandoffInput
+	if len(args) != 1 {
+		return input, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid number of input, require 1, but provided %s", args),
+			errors.SeverityDebug,
+		)
+	}
+	err := json.Unmarshal([]byte(args[0]), &input)
+	if err != nil {
+		return input, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid input object : %w", err),
+			errors.SeverityDebug,
+		)
+	}
+	err = validation.HandoffInput(input)
+	if err != nil {
+		return input, errors.E(op, err)
+	}
+	return input, nil
+}
+
+// startChildTransition : args : parent txID, txID, [workflow].
+// The child is resumed, paused or ended as any transition
+func startChildTransition(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	const op = errors.Op("Method.startChildTransition")
+	if len(args) != 2 && len(args) != 3 {
+		return nil, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid number of input, require 2 or 3, but provided %s", args),
+			errors.SeverityDebug,
+		)
+	}
+	for _, txID := range args[:2] {
+		err := validation.TxID(txID)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+	if args[0] == args[1] {
+		return nil, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("transition can't be its own parent"),
+			errors.SeverityDebug,
+			errors.TxID(args[1]),
+		)
+	}
+	workflow := ""
+	if len(args) == 3 {
+		workflow = args[2]
+		err := validation.Workflow(workflow)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+	}
+	raw, err := startChildTx(stub, args[0], args[1], workflow)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return raw, nil
+}
+
+// getTxTree : args : txID, returns the transition and
+// its descendants, with their states
+func getTxTree(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	const op = errors.Op("Method.getTxTree")
+	if len(args) != 1 {
+		return nil, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid number of input, require 1, but provided %s", args),
+			errors.SeverityDebug,
+		)
+	}
+	err := validation.TxID(args[0])
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	tree, err := getTree(stub, args[0])
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	raw, err := json.Marshal(tree)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to encode transition tree : %w", err),
+			errors.SeverityError,
+			errors.TxID(args[0]),
+		)
+	}
+	return raw, nil
+}
+
+// simulateStageUpdate : evaluate only, runs the checks and data
+// chaincode calls of stageUpdate writing nothing, and returns
+// the locks it would take, conflicts and outputs
+func simulateStageUpdate(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	const op = errors.Op("Method.simulateStageUpdate")
+	if len(args) != 1 {
+		return nil, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid number of input, require 1, but provided %s", args),
+			errors.SeverityDebug,
+		)
+	}
+	var input model.StageUpdateInput
+	err := json.Unmarshal([]byte(args[0]), &input)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid input object : %w", err),
+			errors.SeverityDebug,
+		)
+	}
+	err = validation.StageUpdateInput(input)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	out, err := simulateStage(stub, op, input)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(out)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to encode stage simulation : %w", err),
+			errors.SeverityError,
+			errors.TxID(input.TxID),
+		)
+	}
+	return raw, nil
+}
+
+// archiveFinished : admin, deletes a page of finished or aborted
+// transactions past the retention period, see internal/archive.go
+func archiveFinished(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	const op = errors.Op("Method.archiveFinished")
+	if len(args) != 1 {
+		return nil, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid number of input, require 1, but provided %s", args),
+			errors.SeverityDebug,
+		)
+	}
+	var input model.ArchiveInput
+	err := json.Unmarshal([]byte(args[0]), &input)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid input object : %w", err),
+			errors.SeverityDebug,
+		)
+	}
+	err = validation.ArchiveInput(input)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	err = checkAdminMethod(stub)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	out, err := archiveTxs(stub, input)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	raw, err := json.Marshal(out)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to encode archive output : %w", err),
+			errors.SeverityError,
+		)
+	}
+	return raw, nil
+}
+
+// getTxArchiveSummary : args : txID, returns the summary
+// kept for an archived transaction
+func getTxArchiveSummary(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	const op = errors.Op("Method.getTxArchive")
+	if len(args) != 1 {
+		return nil, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid number of input, require 1, but provided %s", args),
+			errors.SeverityDebug,
+		)
+	}
+	err := validation.TxID(args[0])
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	summary, err := getTxArchive(stub, args[0])
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	raw, err := json.Marshal(summary)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to encode transaction archive : %w", err),
+			errors.SeverityError,
+			errors.TxID(args[0]),
+		)
+	}
+	return raw, nil
+}
+
+// checkAdminMethod : error unless caller is admin
+// under the current configuration
+func checkAdminMethod(stub shim.ChaincodeStubInterface) error {
+	const op = errors.Op("Method.checkAdminMethod")
+	config, err := getConfig(stub)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	err = checkAdmin(stub, config)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	return nil
+}
+
+// adminCaller : identity of the caller if admin under
+// the current configuration, nil for other callers
+func adminCaller(stub shim.ChaincodeStubInterface) (*model.Identity, error) {
+	const op = errors.Op("Method.adminCaller")
+	err := checkAdminMethod(stub)
+	if errors.ErrCode(err) == errors.CodeConflict {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return caller, nil
+}
+
+// bootstrapConfig : sets the first configuration of datalock,
+// same as Init with the configuration as argument. Only open
+// to clients of the bootstrap MSP, set at deploy time
+func bootstrapConfig(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	const op = errors.Op("Method.bootstrapConfig")
+	if chaincodeOf(stub).bootstrapMSP == "" {
+		return nil, errors.E(
+			op,
+			errors.CodeConflict,
+			fmt.Errorf("bootstrapConfig is disabled, bootstrap with Init or set DATALOCK_BOOTSTRAP_MSP"),
+			errors.SeverityDebug,
+		)
+	}
+	raw, err := initConfig(stub, args)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return raw, nil
+}
+
+// initConfig : sets the first configuration of datalock
+func initConfig(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	const op = errors.Op("Method.initConfig")
+	config, err := configInput(op, args)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := bootstrapTxConfig(stub, config)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return encodeConfig(op, stored)
+}
+
+// setConfig : admin, replaces the configuration. Its version
+// must be the current one, and is incremented
+func setConfig(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	const op = errors.Op("Method.setConfig")
+	config, err := configInput(op, args)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := updateConfig(stub, config)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return encodeConfig(op, stored)
+}
+
+// getCurrentConfig : args : [version], returns the current
+// configuration, or the one at version
+func getCurrentConfig(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	const op = errors.Op("Method.getConfig")
+	if len(args) > 1 {
+		return nil, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid number of input, require 0 or 1, but provided %s", args),
+			errors.SeverityDebug,
+		)
+	}
+	if len(args) == 0 {
+		config, err := getConfig(stub)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+		return encodeConfig(op, config)
+	}
+	version, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			errors.Field("version"),
+			fmt.Errorf("version : %w", err),
+			errors.SeverityDebug,
+		)
+	}
+	config, err := getConfigVersion(stub, version)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return encodeConfig(op, config)
+}
+
+func configInput(op errors.Op, args []string) (model.Config, error) {
+	var config model.Config
+	if len(args) != 1 {
+		return config, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid number of input, require 1, but provided %s", args),
+			errors.SeverityDebug,
+		)
+	}
+	err := json.Unmarshal([]byte(args[0]), &config)
+	if err != nil {
+		return config, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid input object : %w", err),
+			errors.SeverityDebug,
+		)
+	}
+	err = validation.Config(config)
+	if err != nil {
+		return config, errors.E(op, err)
+	}
+	return config, nil
+}
+
+func encodeConfig(op errors.Op, config *model.Config) ([]byte, error) {
+	raw, err := json.Marshal(config)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to encode config : %w", err),
+			errors.SeverityError,
+		)
+	}
+	return raw, nil
+}
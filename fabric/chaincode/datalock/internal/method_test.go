@@ -3,7 +3,7 @@ package internal
 import (
 	"datalock/mock"
 	"datalock/model"
-	"datalock/pkg/logger"
+	"datalock/pkg/errors"
 	"encoding/base64"
 	"encoding/json"
 	"testing"
@@ -15,7 +15,6 @@ import (
 
 func TestE2E(t *testing.T) {
 	is := assert.New(t)
-	logger.NewAppLogger("DEBUG")
 	emCCName := "EmissionsCC"
 	emStub := shimtest.NewMockStub(emCCName, mock.MockEmissionsCC{})
 	loadMockEmissions(emStub)
@@ -110,6 +109,34 @@ func TestE2E(t *testing.T) {
 		json.Unmarshal(resp.Payload, &tx)
 		is.Equal(tx.CurrentStage, "MintedEmissionsToken")
 	}
+
+	// pause while waiting for the minted token
+	// to be confirmed on ethereum
+	raw, _ = json.Marshal(model.TxPauseInput{
+		TxID:       txID,
+		ReasonCode: "WAITING_CONFIRMATION",
+		Checkpoint: map[string]string{"tokenID": tokenId},
+	})
+	resp = txStub.MockInvoke(mockID, stringArgsToByte([]string{"pauseTransitionProcess", string(raw)}))
+	is.Equal(shim.OK, int(resp.Status))
+
+	raw, _ = json.Marshal(model.TxResumeInput{
+		ReasonCode: "CONFIRMED",
+		Checkpoint: map[string]string{"block": "42"},
+	})
+	resp = txStub.MockInvoke(mockID, stringArgsToByte([]string{"resumeTransitionProcess", txID, string(raw)}))
+	is.Equal(shim.OK, int(resp.Status))
+	{
+		json.Unmarshal(resp.Payload, &tx)
+		is.Equal(model.TxStatePROCESSING, tx.State)
+		is.Equal(tokenId, tx.Pause.Checkpoint["tokenID"])
+		is.Equal("CONFIRMED", tx.Resume.ReasonCode)
+		is.Equal("42", tx.Resume.Checkpoint["block"])
+	}
+	// stage data is only part of the tx details
+	resp = txStub.MockInvoke(mockID, stringArgsToByte([]string{"getTxDetails", txID}))
+	is.Equal(shim.OK, int(resp.Status))
+	json.Unmarshal(resp.Payload, &tx)
 	validUUIDsraw := tx.StageData["GetValidEmissions"].Output["EmissionsCC"]["validUUIDs"]
 	var uuids []string
 	{
@@ -136,7 +163,6 @@ func TestE2E(t *testing.T) {
 
 func TestMethodFail(t *testing.T) {
 	is := assert.New(t)
-	logger.NewAppLogger("DEBUG")
 	emCCName := "EmissionsCC"
 	emStub := shimtest.NewMockStub(emCCName, mock.MockEmissionsCC{})
 	loadMockEmissions(emStub)
@@ -152,68 +178,111 @@ func TestMethodFail(t *testing.T) {
 	})
 	t.Run("methodNotSupported", func(t *testing.T) {
 		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"methodNotSupported"}))
-		is.Equal(shim.ERROR, int(resp.Status))
+		is.Equal(int32(errors.CodeInvalidInput), resp.Status)
 	})
 
 	t.Run("endTxInvalidInput", func(t *testing.T) {
 		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"endTransitionProcess"}))
-		is.Equal(shim.ERROR, int(resp.Status))
+		is.Equal(int32(errors.CodeInvalidInput), resp.Status)
 	})
 
 	t.Run("txNotFound", func(t *testing.T) {
 		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"endTransitionProcess", txID}))
-		is.Equal(shim.ERROR, int(resp.Status))
+		is.Equal(int32(errors.CodeNotFound), resp.Status)
 	})
 
 	t.Run("startTxInvalidInput", func(t *testing.T) {
 		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"startTransitionProcess"}))
-		is.Equal(shim.ERROR, int(resp.Status))
+		is.Equal(int32(errors.CodeInvalidInput), resp.Status)
 	})
 
 	t.Run("startRunningProcess", func(t *testing.T) {
 		txStub.MockTransactionStart(mockID)
-		txState(txStub, txID, true)
+		txState(txStub, txID, "", true)
 		txStub.MockTransactionEnd(mockID)
 		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"startTransitionProcess", txID}))
-		is.Equal(shim.ERROR, int(resp.Status))
+		is.Equal(int32(errors.CodeConflict), resp.Status)
 		// clear out state
 	})
+	t.Run("pauseInvalidInput", func(t *testing.T) {
+		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"pauseTransitionProcess", "not-a-json"}))
+		is.Equal(int32(errors.CodeInvalidInput), resp.Status)
+	})
+	t.Run("pauseWithoutReason", func(t *testing.T) {
+		raw, _ := json.Marshal(model.TxPauseInput{TxID: txID})
+		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"pauseTransitionProcess", string(raw)}))
+		is.Equal(int32(errors.CodeInvalidInput), resp.Status)
+	})
+	t.Run("resumeInvalidInput", func(t *testing.T) {
+		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"resumeTransitionProcess"}))
+		is.Equal(int32(errors.CodeInvalidInput), resp.Status)
+		resp = txStub.MockInvoke(mockID, stringArgsToByte([]string{"resumeTransitionProcess", txID, "not-a-json"}))
+		is.Equal(int32(errors.CodeInvalidInput), resp.Status)
+	})
+	t.Run("resumeCheckpointWithoutReason", func(t *testing.T) {
+		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"resumeTransitionProcess", txID, `{"checkpoint":{"k":"v"}}`}))
+		is.Equal(int32(errors.CodeInvalidInput), resp.Status)
+	})
+	t.Run("resumeNotPaused", func(t *testing.T) {
+		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"resumeTransitionProcess", txID}))
+		is.Equal(int32(errors.CodeConflict), resp.Status)
+	})
 	t.Run("stageUpdateInvalidNumberOfInput", func(t *testing.T) {
 		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"stageUpdate"}))
-		is.Equal(shim.ERROR, int(resp.Status))
+		is.Equal(int32(errors.CodeInvalidInput), resp.Status)
 	})
 	t.Run("stageUpdateInvalidInput", func(t *testing.T) {
 		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"stageUpdate", "not-a-json"}))
-		is.Equal(shim.ERROR, int(resp.Status))
+		is.Equal(int32(errors.CodeInvalidInput), resp.Status)
+	})
+
+	t.Run("stageUpdate:lockAndFreeSameChaincode", func(t *testing.T) {
+		input := model.StageUpdateInput{
+			TxID: txID,
+			Name: "stageUpdate:lockAndFreeSameChaincode",
+			DataLocks: map[string]model.DataChaincodeInput{
+				emCCName: {Keys: []string{"uuid-1"}},
+			},
+			DataFree: map[string]model.DataChaincodeInput{
+				emCCName: {Keys: []string{"uuid-2"}},
+			},
+		}
+		raw, _ := json.Marshal(input)
+		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"stageUpdate", string(raw)}))
+		is.Equal(int32(errors.CodeInvalidInput), resp.Status)
+		is.Contains(resp.Message, "chaincode also present in data_free")
 	})
 
 	t.Run("stageUpdate:txNotFound", func(t *testing.T) {
 		input := model.StageUpdateInput{
 			TxID: "not-found",
+			Name: "stageUpdate:txNotFound",
 		}
 		raw, _ := json.Marshal(input)
 		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"stageUpdate", string(raw)}))
-		is.Equal(shim.ERROR, int(resp.Status))
+		is.Equal(int32(errors.CodeNotFound), resp.Status)
 	})
 
 	t.Run("stageUpdate:notProcessing", func(t *testing.T) {
 		txStub.MockTransactionStart(mockID)
-		txState(txStub, txID, false)
+		txState(txStub, txID, "", false)
 		txStub.MockTransactionEnd(mockID)
 		input := model.StageUpdateInput{
 			TxID: txID,
+			Name: "stageUpdate:notProcessing",
 		}
 		raw, _ := json.Marshal(input)
 		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"stageUpdate", string(raw)}))
-		is.Equal(shim.ERROR, int(resp.Status))
+		is.Equal(int32(errors.CodeConflict), resp.Status)
 	})
 
 	t.Run("stageUpdate:ccLockDataInput", func(t *testing.T) {
 		txStub.MockTransactionStart(mockID)
-		txState(txStub, txID, true)
+		txState(txStub, txID, "", true)
 		txStub.MockTransactionEnd(mockID)
 		input := model.StageUpdateInput{
 			TxID: txID,
+			Name: "stageUpdate:ccLockDataInput",
 			DataLocks: map[string]model.DataChaincodeInput{
 				emCCName: {
 					Keys:   []string{"uuid-1"},
@@ -223,15 +292,16 @@ func TestMethodFail(t *testing.T) {
 		}
 		raw, _ := json.Marshal(input)
 		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"stageUpdate", string(raw)}))
-		is.Equal(shim.ERROR, int(resp.Status))
+		is.Equal(int32(errors.CodeConflict), resp.Status)
 	})
 
 	t.Run("stageUpdate:ccFreeDataInput", func(t *testing.T) {
 		txStub.MockTransactionStart(mockID)
-		txState(txStub, txID, true)
+		txState(txStub, txID, "", true)
 		txStub.MockTransactionEnd(mockID)
 		input := model.StageUpdateInput{
 			TxID: txID,
+			Name: "stageUpdate:ccFreeDataInput",
 			DataFree: map[string]model.DataChaincodeInput{
 				emCCName: {
 					Keys:   []string{"uuid-1"},
@@ -241,7 +311,7 @@ func TestMethodFail(t *testing.T) {
 		}
 		raw, _ := json.Marshal(input)
 		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"stageUpdate", string(raw)}))
-		is.Equal(shim.ERROR, int(resp.Status))
+		is.Equal(int32(errors.CodeConflict), resp.Status)
 	})
 
 	t.Run("stageUpdate:ccFreeDataInput-2", func(t *testing.T) {
@@ -267,15 +337,16 @@ func TestMethodFail(t *testing.T) {
 	t.Run("stageUpdate:Fineshed", func(t *testing.T) {
 		input := model.StageUpdateInput{
 			TxID: txID,
+			Name: "stageUpdate:Fineshed",
 		}
 		raw, _ := json.Marshal(input)
 		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"stageUpdate", string(raw)}))
-		is.Equal(shim.ERROR, int(resp.Status))
+		is.Equal(int32(errors.CodeConflict), resp.Status)
 	})
 
 	t.Run("startFineshTx", func(t *testing.T) {
 		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"startTransitionProcess", txID}))
-		is.Equal(shim.ERROR, int(resp.Status))
+		is.Equal(int32(errors.CodeConflict), resp.Status)
 
 	})
 }
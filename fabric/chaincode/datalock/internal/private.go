@@ -0,0 +1,209 @@
+package internal
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"datalock/model"
+	"datalock/pkg/canonical"
+	"datalock/pkg/errors"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+const (
+	// transientStorageKey : key of the transient map holding
+	// json encoded storage of a private stage
+	transientStorageKey = "storage"
+	// transientSaltKey : key of the transient map holding
+	// the random salt of the hashes of a private stage
+	transientSaltKey = "salt"
+	// minSaltSize : bytes of the salt at least
+	minSaltSize = 16
+)
+
+// valueHash : hmac of key and value with the salt of the stage,
+// so that low entropy values can't be guessed from the hash
+func valueHash(salt, key, value string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	fmt.Fprintf(mac, "%d:%s%s", len(key), key, value)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// transientSalt : reads the salt of a private stage from the
+// transient map. The salt must be random and secret, the chaincode
+// can't generate it, as every endorsing peer must get the same
+func transientSalt(stub shim.ChaincodeStubInterface) (string, error) {
+	const op = errors.Op("Private.transientSalt")
+	tMap, err := stub.GetTransient()
+	if err != nil {
+		return "", errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to get transient map : %w", err),
+			errors.SeverityError,
+		)
+	}
+	salt := tMap[transientSaltKey]
+	if len(salt) < minSaltSize {
+		return "", errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("private stage requires a salt of at least %d bytes in transient map", minSaltSize),
+			errors.SeverityDebug,
+		)
+	}
+	return hex.EncodeToString(salt), nil
+}
+
+// transientStorage : reads storage of a private stage
+// from transient map, so that it never becomes part
+// of the transaction proposal
+func transientStorage(stub shim.ChaincodeStubInterface) (map[string]string, error) {
+	const op = errors.Op("Private.transientStorage")
+	tMap, err := stub.GetTransient()
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to get transient map : %w", err),
+			errors.SeverityError,
+		)
+	}
+	raw, ok := tMap[transientStorageKey]
+	if !ok {
+		return nil, nil
+	}
+	var storage map[string]string
+	err = json.Unmarshal(raw, &storage)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeInvalidInput,
+			fmt.Errorf("invalid transient storage : %w", err),
+			errors.SeverityDebug,
+		)
+	}
+	return storage, nil
+}
+
+// putPrivateStageData : writes stage data into private data
+// collection and returns the public copy, which only
+// contains salted hash of the values
+func putPrivateStageData(stub shim.ChaincodeStubInterface, txID, stage, collection string, data *model.TxStageData) (*model.TxStageData, error) {
+	const op = errors.Op("Private.putPrivateStageData")
+	id := errors.TxID(txID)
+
+	data.Collection = collection
+	raw, err := canonical.Marshal(data)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to encode stage data : %w", err),
+			errors.SeverityError,
+			id,
+		)
+	}
+	err = stub.PutPrivateData(collection, stageDataKey(txID, stage), raw)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to put private stage data : %w", err),
+			errors.SeverityError,
+			id,
+		)
+	}
+
+	public := &model.TxStageData{
+		Storage:    hashValues(data.Salt, data.Storage),
+		Output:     map[string]map[string]string{},
+		Collection: collection,
+		Commitment: data.Commitment,
+		Receipt:    data.Receipt,
+	}
+	for ccName, output := range data.Output {
+		public.Output[ccName] = hashValues(data.Salt, output)
+	}
+	return public, nil
+}
+
+// getPrivateStageData : reads stage data from private data
+// collection, and verifies it against the hashes kept
+// on the public copy
+func getPrivateStageData(stub shim.ChaincodeStubInterface, txID, stage string, public *model.TxStageData) (*model.TxStageData, error) {
+	const op = errors.Op("Private.getPrivateStageData")
+	id := errors.TxID(txID)
+
+	raw, err := stub.GetPrivateData(public.Collection, stageDataKey(txID, stage))
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to get private stage data : %w", err),
+			errors.SeverityError,
+			id,
+		)
+	}
+	if len(raw) == 0 {
+		return nil, errors.E(
+			op,
+			errors.CodeNotFound,
+			fmt.Errorf("private stage data not found in collection %s", public.Collection),
+			errors.SeverityDebug,
+			id,
+		)
+	}
+	var data model.TxStageData
+	err = json.Unmarshal(raw, &data)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("invalid private stage data : %w", err),
+			errors.SeverityError,
+			id,
+		)
+	}
+
+	match := equalValues(hashValues(data.Salt, data.Storage), public.Storage)
+	for ccName, output := range data.Output {
+		match = match && equalValues(hashValues(data.Salt, output), public.Output[ccName])
+	}
+	if !match || len(data.Output) != len(public.Output) {
+		return nil, errors.E(
+			op,
+			errors.CodeConflict,
+			fmt.Errorf("private stage data does not match hash on transaction"),
+			errors.SeverityWarn,
+			id,
+		)
+	}
+	return &data, nil
+}
+
+func hashValues(salt string, values map[string]string) map[string]string {
+	if values == nil {
+		return nil
+	}
+	out := make(map[string]string, len(values))
+	for k, v := range values {
+		out[k] = valueHash(salt, k, v)
+	}
+	return out
+}
+
+func equalValues(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if got, ok := b[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,188 @@
+package internal
+
+import (
+	"datalock/mock"
+	"datalock/model"
+	"datalock/pkg/errors"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrivateStageData(t *testing.T) {
+	is := assert.New(t)
+	stub := shimtest.NewMockStub("dataLockCC", &DataLockChaincode{})
+
+	txID := "txID-1"
+	stage := "RecordEmissions"
+	collection := "emissionsCollection"
+	salt := "73616c742d30313233343536373839"
+	data := &model.TxStageData{
+		Salt:    salt,
+		Storage: map[string]string{"amount": "120"},
+		Output: map[string]map[string]string{
+			"EmissionsCC": {"validUUIDs": "dXVpZC0x"},
+		},
+	}
+
+	stub.MockTransactionStart("put")
+	public, err := putPrivateStageData(stub, txID, stage, collection, data)
+	stub.MockTransactionEnd("put")
+	is.NoError(err)
+	is.Equal(collection, public.Collection)
+	is.Equal(valueHash(salt, "amount", "120"), public.Storage["amount"])
+	is.Equal(valueHash(salt, "validUUIDs", "dXVpZC0x"), public.Output["EmissionsCC"]["validUUIDs"])
+	is.Empty(public.Salt)
+	// same value, other salt or key, other hash
+	is.NotEqual(valueHash("other", "amount", "120"), public.Storage["amount"])
+	is.NotEqual(valueHash(salt, "total", "120"), public.Storage["amount"])
+
+	t.Run("Get", func(t *testing.T) {
+		got, err := getPrivateStageData(stub, txID, stage, public)
+		is.NoError(err)
+		is.Equal(data.Storage, got.Storage)
+		is.Equal(data.Output, got.Output)
+	})
+
+	t.Run("Get::NotFound", func(t *testing.T) {
+		got, err := getPrivateStageData(stub, txID, "not-found", public)
+		is.Error(err)
+		is.Nil(got)
+	})
+
+	t.Run("Get::HashMismatch", func(t *testing.T) {
+		tampered := &model.TxStageData{
+			Storage:    map[string]string{"amount": valueHash(salt, "amount", "100")},
+			Output:     public.Output,
+			Collection: collection,
+		}
+		got, err := getPrivateStageData(stub, txID, stage, tampered)
+		is.Error(err)
+		is.Nil(got)
+	})
+}
+
+func TestPrivateStageUpdate(t *testing.T) {
+	is := assert.New(t)
+	emCCName := "EmissionsCC"
+	emStub := shimtest.NewMockStub(emCCName, mock.MockEmissionsCC{})
+	loadMockEmissions(emStub)
+
+	txStub := shimtest.NewMockStub("dataLockCC", &DataLockChaincode{})
+	txStub.Invokables[emCCName] = emStub
+
+	const txID = "txID-1"
+	const mockID = "mockID"
+	const collection = "emissionsCollection"
+	resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"startTransitionProcess", txID}))
+	is.Equal(shim.OK, int(resp.Status))
+
+	t.Run("PlainStorage", func(t *testing.T) {
+		input := model.StageUpdateInput{
+			TxID:       txID,
+			Name:       "PlainStorage",
+			Collection: collection,
+			Storage:    map[string]string{"amount": "120"},
+		}
+		raw, _ := json.Marshal(input)
+		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"stageUpdate", string(raw)}))
+		is.Equal(int32(errors.CodeInvalidInput), resp.Status)
+	})
+
+	input := model.StageUpdateInput{
+		TxID:       txID,
+		Name:       "GetValidEmissions",
+		Collection: collection,
+		DataLocks: map[string]model.DataChaincodeInput{
+			emCCName: {
+				Keys:   []string{"uuid-1", "uuid-2"},
+				Params: []string{"getValidEmissions", "uuid-1", "uuid-2"},
+			},
+		},
+	}
+	raw, _ := json.Marshal(input)
+	t.Run("NoSalt", func(t *testing.T) {
+		txStub.TransientMap = map[string][]byte{
+			transientStorageKey: []byte(`{"amount":"120"}`),
+			transientSaltKey:    []byte("short"),
+		}
+		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"stageUpdate", string(raw)}))
+		is.Equal(int32(errors.CodeInvalidInput), resp.Status)
+		is.Contains(resp.Message, "requires a salt")
+	})
+	txStub.TransientMap = map[string][]byte{
+		transientStorageKey: []byte(`{"amount":"120"}`),
+		transientSaltKey:    []byte("salt-0123456789ab"),
+	}
+	resp = txStub.MockInvoke(mockID, stringArgsToByte([]string{"stageUpdate", string(raw)}))
+	txStub.TransientMap = nil
+	is.Equal(shim.OK, int(resp.Status))
+
+	{
+		// public record only keeps hash
+		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"getTxDetails", txID}))
+		var tx model.Transaction
+		err := json.Unmarshal(resp.Payload, &tx)
+		is.NoError(err)
+		data := tx.StageData[input.Name]
+		is.Equal(collection, data.Collection)
+		is.Equal(valueHash(hex.EncodeToString([]byte("salt-0123456789ab")), "amount", "120"), data.Storage["amount"])
+		is.NotContains(string(resp.Payload), `"120"`)
+	}
+	{
+		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"getStageOutput", txID, input.Name}))
+		is.Equal(shim.OK, int(resp.Status))
+		var data model.TxStageData
+		err := json.Unmarshal(resp.Payload, &data)
+		is.NoError(err)
+		is.Equal("120", data.Storage["amount"])
+		_, ok := data.Output[emCCName]["validUUIDs"]
+		is.True(ok)
+	}
+
+	t.Run("getStageOutput::InvalidInput", func(t *testing.T) {
+		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"getStageOutput", txID}))
+		is.Equal(int32(errors.CodeInvalidInput), resp.Status)
+	})
+	t.Run("getStageOutput::StageNotFound", func(t *testing.T) {
+		resp := txStub.MockInvoke(mockID, stringArgsToByte([]string{"getStageOutput", txID, "not-found"}))
+		is.Equal(int32(errors.CodeNotFound), resp.Status)
+	})
+}
+
+func TestEmptyStorageValue(t *testing.T) {
+	is := assert.New(t)
+	txStub := shimtest.NewMockStub("dataLockCC", &DataLockChaincode{})
+	const collection = "emissionsCollection"
+	txStub.MockInvoke("mockID", stringArgsToByte([]string{"startTransitionProcess", "txID-1"}))
+
+	stage := func(name, collection string) {
+		input := model.StageUpdateInput{TxID: "txID-1", Name: name, Collection: collection}
+		if collection == "" {
+			input.Storage = map[string]string{"k": ""}
+		} else {
+			txStub.TransientMap = map[string][]byte{
+				transientStorageKey: []byte(`{"k":""}`),
+				transientSaltKey:    []byte("salt-0123456789ab"),
+			}
+		}
+		raw, _ := json.Marshal(input)
+		resp := txStub.MockInvoke("mockID", stringArgsToByte([]string{"stageUpdate", string(raw)}))
+		txStub.TransientMap = nil
+		is.Equal(shim.OK, int(resp.Status), resp.Message)
+	}
+	stage("public", "")
+	stage("private", collection)
+
+	for _, name := range []string{"public", "private"} {
+		resp := txStub.MockInvoke("mockID", stringArgsToByte([]string{"getStageOutput", "txID-1", name}))
+		is.Equal(shim.OK, int(resp.Status), resp.Message)
+		var data model.TxStageData
+		is.NoError(json.Unmarshal(resp.Payload, &data))
+		is.Equal(map[string]string{"k": ""}, data.Storage, name)
+	}
+}
@@ -0,0 +1,338 @@
+package internal
+
+import (
+	"datalock/model"
+	"datalock/pkg/endorsement"
+	"datalock/pkg/proptest"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const keysCCName = "KeysCC"
+
+var (
+	propTxIDs = []string{"txID-0", "txID-1", "txID-2"}
+	propKeys  = []string{"key-0", "key-1", "key-2", "key-3"}
+)
+
+// keysCC : data chaincode returning the keys it is called with
+type keysCC struct{}
+
+func (keysCC) Init(stub shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (keysCC) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	_, keys := stub.GetFunctionAndParameters()
+	raw, _ := json.Marshal(model.DataChaincodeOutput{Keys: keys})
+	return shim.Success(raw)
+}
+
+type propOpKind string
+
+const (
+	propStart  propOpKind = "start"
+	propEnd    propOpKind = "end"
+	propLock   propOpKind = "lock"
+	propFree   propOpKind = "free"
+	propFinish propOpKind = "finish"
+	propAbort  propOpKind = "abort"
+)
+
+var propOpKinds = []propOpKind{propStart, propEnd, propLock, propFree, propFinish, propAbort}
+
+// propOp : one call to the datalock chaincode
+type propOp struct {
+	Kind propOpKind
+	TxID string
+	Keys []string
+}
+
+func (o propOp) String() string {
+	if len(o.Keys) == 0 {
+		return fmt.Sprintf("%s %s", o.Kind, o.TxID)
+	}
+	return fmt.Sprintf("%s %s %v", o.Kind, o.TxID, o.Keys)
+}
+
+func genPropOp(rnd *rand.Rand) propOp {
+	op := propOp{
+		Kind: propOpKinds[rnd.Intn(len(propOpKinds))],
+		TxID: propTxIDs[rnd.Intn(len(propTxIDs))],
+	}
+	if op.Kind == propLock || op.Kind == propFree {
+		for _, i := range rnd.Perm(len(propKeys))[:1+rnd.Intn(2)] {
+			op.Keys = append(op.Keys, propKeys[i])
+		}
+	}
+	return op
+}
+
+func shrinkPropOp(op propOp) []propOp {
+	out := []propOp{}
+	if len(op.Keys) > 1 {
+		for _, key := range op.Keys {
+			out = append(out, propOp{Kind: op.Kind, TxID: op.TxID, Keys: []string{key}})
+		}
+	}
+	return out
+}
+
+// proposal : of the step, made by creator, the
+// client owning every transition
+func (o propOp) proposal(step int, creator []byte) *endorsement.Proposal {
+	p := o.newProposal(fmt.Sprintf("step-%d", step))
+	p.Creator = creator
+	return p
+}
+
+func (o propOp) newProposal(id string) *endorsement.Proposal {
+	switch o.Kind {
+	case propStart:
+		return endorsement.NewProposal(id, dataLockCCName, "startTransitionProcess", o.TxID)
+	case propEnd:
+		return endorsement.NewProposal(id, dataLockCCName, "endTransitionProcess", o.TxID)
+	case propAbort:
+		return endorsement.NewProposal(id, dataLockCCName, "abortTransitionProcess", o.TxID)
+	}
+	input := model.StageUpdateInput{TxID: o.TxID, Name: string(o.Kind), IsLast: o.Kind == propFinish}
+	ccInput := map[string]model.DataChaincodeInput{
+		keysCCName: {Keys: o.Keys, Params: append([]string{string(o.Kind)}, o.Keys...)},
+	}
+	if o.Kind == propLock {
+		input.DataLocks = ccInput
+	} else if o.Kind == propFree {
+		input.DataFree = ccInput
+	}
+	raw, _ := json.Marshal(input)
+	return endorsement.NewProposal(id, dataLockCCName, "stageUpdate", string(raw))
+}
+
+// lockModel : reference model of the datalock state machine
+type lockModel struct {
+	states  map[string]model.TxState
+	holders map[string]string
+}
+
+// apply : updates the model, returning whether op is expected to succeed
+func (m *lockModel) apply(op propOp) bool {
+	state, exists := m.states[op.TxID]
+	switch op.Kind {
+	case propStart:
+		if exists && state != model.TxStateNOTPROCESSING {
+			return false
+		}
+		m.states[op.TxID] = model.TxStatePROCESSING
+	case propEnd:
+		if state == model.TxStateFINISHED {
+			return true
+		}
+		if state != model.TxStatePROCESSING {
+			return false
+		}
+		m.states[op.TxID] = model.TxStateNOTPROCESSING
+	case propLock:
+		if state != model.TxStatePROCESSING {
+			return false
+		}
+		for _, key := range op.Keys {
+			if m.holders[key] != "" {
+				return false
+			}
+		}
+		for _, key := range op.Keys {
+			m.holders[key] = op.TxID
+		}
+	case propFree:
+		if state != model.TxStatePROCESSING {
+			return false
+		}
+		for _, key := range op.Keys {
+			if m.holders[key] != op.TxID {
+				return false
+			}
+		}
+		for _, key := range op.Keys {
+			delete(m.holders, key)
+		}
+	case propFinish:
+		if state != model.TxStatePROCESSING {
+			return false
+		}
+		m.states[op.TxID] = model.TxStateFINISHED
+	case propAbort:
+		if !exists || state == model.TxStateFINISHED || state == model.TxStateABORTED {
+			return false
+		}
+		m.states[op.TxID] = model.TxStateABORTED
+		for key, holder := range m.holders {
+			if holder == op.TxID {
+				delete(m.holders, key)
+			}
+		}
+	}
+	return true
+}
+
+// ledgerView : datalock namespace split by kind of key
+type ledgerView struct {
+	// locks : lockID -> holding txID
+	locks map[string]string
+	// index : txID -> lockIDs
+	index map[string][]string
+	txs   map[string][]byte
+}
+
+func readLedger(n *endorsement.Network) ledgerView {
+	state := map[string][]byte{}
+	for _, key := range n.Keys(dataLockCCName) {
+		state[key] = n.GetState(dataLockCCName, key)
+	}
+	return splitLedger(state)
+}
+
+func splitLedger(state map[string][]byte) ledgerView {
+	view := ledgerView{
+		locks: map[string]string{},
+		index: map[string][]string{},
+		txs:   map[string][]byte{},
+	}
+	for key, value := range state {
+		switch {
+		case strings.HasPrefix(key, "\x00"):
+			attrs := strings.Split(strings.Trim(key, "\x00"), "\x00")
+			if len(attrs) == 3 && attrs[0] == lockStateIndexObj {
+				view.index[attrs[1]] = append(view.index[attrs[1]], attrs[2])
+			}
+		case strings.Contains(key, "::"):
+			view.locks[key] = string(value)
+		default:
+			view.txs[key] = value
+		}
+	}
+	return view
+}
+
+// checkLockIndex : a lock and its index entry exist together
+func checkLockIndex(view ledgerView) error {
+	for lockID, txID := range view.locks {
+		if !contains(view.index[txID], lockID) {
+			return fmt.Errorf("lock %s held by %s has no index entry", lockID, txID)
+		}
+	}
+	for txID, lockIDs := range view.index {
+		for _, lockID := range lockIDs {
+			if view.locks[lockID] != txID {
+				return fmt.Errorf("orphan index entry %s of %s, lock held by %q", lockID, txID, view.locks[lockID])
+			}
+		}
+	}
+	return nil
+}
+
+// checkInvariants : of the ledger after a step, terminal
+// holds raw transactions already seen in a terminal state
+func checkInvariants(view ledgerView, m *lockModel, terminal map[string][]byte) error {
+	err := checkLockIndex(view)
+	if err != nil {
+		return err
+	}
+
+	// every key has the holder of the model
+	holders := map[string]string{}
+	for key, txID := range m.holders {
+		holders[lockStateID(keysCCName, key)] = txID
+	}
+	if !reflect.DeepEqual(holders, view.locks) {
+		return fmt.Errorf("holders %v, expected %v", view.locks, holders)
+	}
+
+	for _, txID := range sortedTxIDs(view.txs) {
+		var tx model.Transaction
+		err := json.Unmarshal(view.txs[txID], &tx)
+		if err != nil {
+			return fmt.Errorf("invalid transaction %s : %w", txID, err)
+		}
+		if tx.State != m.states[txID] {
+			return fmt.Errorf("transaction %s at %s, expected %s", txID, tx.State, m.states[txID])
+		}
+		// finished and aborted are terminal
+		if raw, ok := terminal[txID]; ok && string(raw) != string(view.txs[txID]) {
+			return fmt.Errorf("terminal transaction %s changed", txID)
+		}
+		if tx.State == model.TxStateFINISHED || tx.State == model.TxStateABORTED {
+			terminal[txID] = view.txs[txID]
+		}
+		if tx.State == model.TxStateABORTED && len(view.index[txID]) != 0 {
+			return fmt.Errorf("aborted transaction %s holds %v", txID, view.index[txID])
+		}
+	}
+	if len(view.txs) != len(m.states) {
+		return fmt.Errorf("%d transactions on ledger, expected %d", len(view.txs), len(m.states))
+	}
+	return nil
+}
+
+// checkLockSequence : replays ops on a fresh network,
+// comparing every step with the reference model
+func checkLockSequence(ops []propOp, creator []byte) error {
+	n := endorsement.NewNetwork("emissions-data", "Org1")
+	n.Deploy(dataLockCCName, &DataLockChaincode{})
+	n.Deploy(keysCCName, keysCC{})
+	m := &lockModel{states: map[string]model.TxState{}, holders: map[string]string{}}
+	terminal := map[string][]byte{}
+
+	for i, op := range ops {
+		resp, code := n.Submit(op.proposal(i, creator))
+		want := m.apply(op)
+		if got := code == pb.TxValidationCode_VALID; got != want {
+			return fmt.Errorf("step %d %v : committed %v (%s %s), expected %v", i+1, op, got, code, resp.Message, want)
+		}
+		err := checkInvariants(readLedger(n), m, terminal)
+		if err != nil {
+			return fmt.Errorf("step %d %v : %w", i+1, op, err)
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedTxIDs(txs map[string][]byte) []string {
+	out := make([]string, 0, len(txs))
+	for txID := range txs {
+		out = append(out, txID)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// TestLockStateMachine : random sequences of transitions
+// competing for keys keep datalock consistent with its model
+func TestLockStateMachine(t *testing.T) {
+	runs := 200
+	if testing.Short() {
+		runs = 20
+	}
+	creator := testCreator(t, "Org1MSP", "owner")
+	proptest.Check(t, proptest.Config{Runs: runs, Steps: 40}, proptest.Property[propOp]{
+		Gen:    genPropOp,
+		Shrink: shrinkPropOp,
+		Check:  func(ops []propOp) error { return checkLockSequence(ops, creator) },
+	})
+}
@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"datalock/mock"
+	"datalock/model"
+	"datalock/pkg/mockstub"
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRichQuery : transactions and data chaincode records
+// written through datalock can be found with mango queries
+func TestRichQuery(t *testing.T) {
+	is := assert.New(t)
+
+	emCCName := "EmissionsCC"
+	emStub := mockstub.NewMockStub(emCCName, mock.MockEmissionsCC{})
+	emStub.MockTransactionStart("mock-load")
+	for _, em := range mockEmissions {
+		raw, _ := json.Marshal(em)
+		emStub.PutState(em.UUID, raw)
+	}
+	emStub.MockTransactionEnd("mock-load")
+
+	txStub := mockstub.NewMockStub("dataLockCC", &DataLockChaincode{})
+	txStub.MockPeerChaincode(emCCName, emStub, "")
+
+	invoke := func(args ...string) []byte {
+		resp := txStub.MockInvoke("mockID", stringArgsToByte(args))
+		is.Equal(shim.OK, int(resp.Status), resp.Message)
+		return resp.Payload
+	}
+	stageUpdate := func(input model.StageUpdateInput) {
+		raw, _ := json.Marshal(input)
+		invoke("stageUpdate", string(raw))
+	}
+
+	for _, txID := range []string{"txID-3", "txID-1", "txID-2"} {
+		invoke("startTransitionProcess", txID)
+	}
+	stageUpdate(model.StageUpdateInput{
+		TxID: "txID-1",
+		Name: "GetValidEmissions",
+		DataLocks: map[string]model.DataChaincodeInput{
+			emCCName: {
+				Keys:   []string{"uuid-1", "uuid-2"},
+				Params: []string{"getValidEmissions", "uuid-1", "uuid-2"},
+			},
+		},
+	})
+	stageUpdate(model.StageUpdateInput{
+		TxID:   "txID-1",
+		Name:   "UpdateMintedTokenRecords",
+		IsLast: true,
+		DataFree: map[string]model.DataChaincodeInput{
+			emCCName: {
+				Keys:   []string{"uuid-1", "uuid-2"},
+				Params: []string{"UpdateEmissionsWithToken", "0xTokenId", "partyID", "uuid-1", "uuid-2"},
+			},
+		},
+	})
+	invoke("endTransitionProcess", "txID-2")
+
+	txIDs := func(query string) []string {
+		iter, err := txStub.GetQueryResult(query)
+		is.NoError(err)
+		defer iter.Close()
+		var out []string
+		for iter.HasNext() {
+			kv, _ := iter.Next()
+			var tx model.Transaction
+			is.NoError(json.Unmarshal(kv.Value, &tx))
+			out = append(out, tx.TxID)
+		}
+		return out
+	}
+	is.Equal([]string{"txID-3"}, txIDs(`{"selector":{"state":"PROCESSING"}}`))
+	is.Equal([]string{"txID-1"}, txIDs(`{"selector":{"state":"FINISHED","current_stage":"UpdateMintedTokenRecords"}}`))
+	is.Equal([]string{"txID-3", "txID-2", "txID-1"}, txIDs(`{"selector":{"tx_id":{"$regex":"^txID-"}},"sort":[{"tx_id":"desc"}]}`))
+
+	iter, meta, err := emStub.GetQueryResultWithPagination(`{"selector":{"TokenId":{"$ne":""}},"sort":["TokenId"]}`, 2, "")
+	is.NoError(err)
+	is.Equal(int32(2), meta.FetchedRecordsCount)
+	kv, _ := iter.Next()
+	is.Equal("uuid-1", kv.Key)
+	kv, _ = iter.Next()
+	is.Equal("uuid-2", kv.Key)
+
+	iter, meta, err = emStub.GetQueryResultWithPagination(`{"selector":{"TokenId":{"$ne":""}},"sort":["TokenId"]}`, 2, meta.Bookmark)
+	is.NoError(err)
+	is.Equal(int32(2), meta.FetchedRecordsCount)
+	kv, _ = iter.Next()
+	is.Equal("uuid-5", kv.Key)
+}
@@ -0,0 +1,390 @@
+package internal
+
+import (
+	"datalock/model"
+	"datalock/pkg/errors"
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Transitions waiting for a locked key join its queue. When the key
+// is released the first waiter still processing (or paused) becomes
+// eligible: only it may lock the key for its grace period, after
+// which the reservation lapses and the key is free for anyone. The
+// eligible event tells the waiter it can go.
+
+const (
+	lockQueueObj = "lockID~queue"
+	// queueEventName : event listing waiters made eligible
+	queueEventName = "datalock.eligible"
+	// maxQueueLength : of waiters of a key
+	maxQueueLength = 256
+)
+
+func lockQueueKey(lockID string) string {
+	key, _ := shim.CreateCompositeKey(lockQueueObj, []string{lockID})
+	return key
+}
+
+// getKeyQueue : queue of key of cc, empty if nobody waits
+func getKeyQueue(stub shim.ChaincodeStubInterface, cc, key string) (*model.KeyQueue, error) {
+	const op = errors.Op("Queue.getKeyQueue")
+	raw, err := stub.GetState(lockQueueKey(lockStateID(cc, key)))
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to get key queue : %w", err),
+			errors.SeverityError,
+		)
+	}
+	queue := &model.KeyQueue{Waiters: []model.QueueWaiter{}}
+	if len(raw) == 0 {
+		return queue, nil
+	}
+	err = json.Unmarshal(raw, queue)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("invalid key queue : %w", err),
+			errors.SeverityError,
+		)
+	}
+	return queue, nil
+}
+
+// putKeyQueue : stores queue of key of cc, deleting it once empty
+func putKeyQueue(stub shim.ChaincodeStubInterface, cc, key string, queue *model.KeyQueue) error {
+	const op = errors.Op("Queue.putKeyQueue")
+	queueKey := lockQueueKey(lockStateID(cc, key))
+	if len(queue.Waiters) == 0 && queue.Eligible == "" {
+		err := stub.DelState(queueKey)
+		if err != nil {
+			return errors.E(
+				op,
+				errors.CodeUnexpected,
+				fmt.Errorf("failed to delete key queue : %w", err),
+				errors.SeverityError,
+			)
+		}
+		return nil
+	}
+	raw, err := json.Marshal(queue)
+	if err != nil {
+		return errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to encode key queue : %w", err),
+			errors.SeverityError,
+		)
+	}
+	err = stub.PutState(queueKey, raw)
+	if err != nil {
+		return errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to put key queue : %w", err),
+			errors.SeverityError,
+		)
+	}
+	return nil
+}
+
+// reservedFor : waiter a free key is reserved for, empty
+// if there is none or its grace period is over
+func reservedFor(stub shim.ChaincodeStubInterface, queue *model.KeyQueue) (string, error) {
+	const op = errors.Op("Queue.reservedFor")
+	if queue.Eligible == "" {
+		return "", nil
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return "", errors.E(op, err)
+	}
+	if now > queue.EligibleUntil {
+		return "", nil
+	}
+	return queue.Eligible, nil
+}
+
+// checkReservation : error wrapping ErrLocked if the free
+// key is reserved for a waiter other than txID
+func checkReservation(stub shim.ChaincodeStubInterface, txID, cc, key string) error {
+	const op = errors.Op("Queue.checkReservation")
+	queue, err := getKeyQueue(stub, cc, key)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	eligible, err := reservedFor(stub, queue)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if eligible != "" && eligible != txID {
+		return errors.E(
+			op,
+			errors.CodeConflict,
+			fmt.Errorf("key = %s %w, reserved for txID = %s", key, errors.ErrLocked, eligible),
+			errors.SeverityDebug,
+			errors.TxID(txID),
+		)
+	}
+	return nil
+}
+
+// leaveKeyQueue : removes txID from queue of the key, once it
+// has locked the key. A lapsed reservation is dropped as well
+func leaveKeyQueue(stub shim.ChaincodeStubInterface, txID, cc, key string) error {
+	const op = errors.Op("Queue.leaveKeyQueue")
+	queue, err := getKeyQueue(stub, cc, key)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if len(queue.Waiters) == 0 && queue.Eligible == "" {
+		return nil
+	}
+	queue.Waiters = slices.DeleteFunc(queue.Waiters, func(w model.QueueWaiter) bool {
+		return w.TxID == txID
+	})
+	queue.Eligible, queue.EligibleUntil = "", 0
+	err = putKeyQueue(stub, cc, key, queue)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	return nil
+}
+
+// promoteWaiter : makes the first waiter still processing (or
+// paused) eligible for the released key, nil if nobody waits.
+// aborted : txIDs aborted by the request, skipped as fabric
+// doesn't let the transaction read its own writes
+func promoteWaiter(stub shim.ChaincodeStubInterface, cc, key string, aborted []string) (*model.EligibleWaiter, error) {
+	const op = errors.Op("Queue.promoteWaiter")
+	queue, err := getKeyQueue(stub, cc, key)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	if len(queue.Waiters) == 0 && queue.Eligible == "" {
+		return nil, nil
+	}
+	queue.Eligible, queue.EligibleUntil = "", 0
+	var eligible *model.EligibleWaiter
+	for len(queue.Waiters) != 0 && eligible == nil {
+		waiter := queue.Waiters[0]
+		queue.Waiters = queue.Waiters[1:]
+		if slices.Contains(aborted, waiter.TxID) {
+			continue
+		}
+		tx, err := getTx(stub, waiter.TxID)
+		if errors.Is(err, errors.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+		if tx.State != model.TxStatePROCESSING && tx.State != model.TxStatePAUSED {
+			continue
+		}
+		now, err := txTimestamp(stub)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+		queue.Eligible = waiter.TxID
+		queue.EligibleUntil = now + waiter.Grace
+		eligible = &model.EligibleWaiter{
+			TxID:      waiter.TxID,
+			Chaincode: cc,
+			Key:       key,
+			Until:     queue.EligibleUntil,
+		}
+	}
+	err = putKeyQueue(stub, cc, key, queue)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return eligible, nil
+}
+
+// releaseLock : deletes the lock and makes the next waiter
+// of the key, other than the aborted ones, eligible
+func releaseLock(stub shim.ChaincodeStubInterface, txID, lockID string, aborted []string) (*model.EligibleWaiter, error) {
+	const op = errors.Op("Queue.releaseLock")
+	err := deleteLockState(stub, txID, lockID)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	cc, key, err := splitLockStateID(lockID)
+	if err != nil {
+		return nil, errors.E(op, err, errors.TxID(txID))
+	}
+	eligible, err := promoteWaiter(stub, cc, key, aborted)
+	if err != nil {
+		return nil, errors.E(op, err, errors.TxID(txID))
+	}
+	return eligible, nil
+}
+
+// enqueueTx : adds the transition to queue of each key, returning
+// its position. Joining again keeps the position
+func enqueueTx(stub shim.ChaincodeStubInterface, input model.QueueInput) (*model.QueueOutput, error) {
+	const op = errors.Op("Queue.enqueueTx")
+	id := errors.TxID(input.TxID)
+	ccName := errors.Chaincode(input.Chaincode)
+
+	tx, err := getTx(stub, input.TxID)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	if tx.State != model.TxStatePROCESSING {
+		return nil, errors.E(
+			op,
+			errors.CodeConflict,
+			fmt.Errorf("transition is not at processing state, found at %s", tx.State),
+			errors.SeverityDebug,
+			id,
+		)
+	}
+	grace := input.Grace
+	if grace == 0 {
+		config, err := getConfig(stub)
+		if err != nil {
+			return nil, errors.E(op, err, id)
+		}
+		grace = config.DefaultLease
+	}
+	out := &model.QueueOutput{Positions: make(map[string]int, len(input.Keys))}
+	for _, key := range input.Keys {
+		holder, err := getLockStateTxID(stub, input.Chaincode, key)
+		if err != nil {
+			return nil, errors.E(op, err, ccName)
+		}
+		if holder == input.TxID {
+			return nil, errors.E(
+				op,
+				errors.CodeConflict,
+				fmt.Errorf("key = %s already locked by the transition", key),
+				errors.SeverityDebug,
+				id,
+				ccName,
+			)
+		}
+		queue, err := getKeyQueue(stub, input.Chaincode, key)
+		if err != nil {
+			return nil, errors.E(op, err, ccName)
+		}
+		eligible, err := reservedFor(stub, queue)
+		if err != nil {
+			return nil, errors.E(op, err, ccName)
+		}
+		if holder == "" && (eligible == "" || eligible == input.TxID) {
+			out.Positions[key] = 0
+			continue
+		}
+		i := slices.IndexFunc(queue.Waiters, func(w model.QueueWaiter) bool {
+			return w.TxID == input.TxID
+		})
+		if i < 0 {
+			if len(queue.Waiters) >= maxQueueLength {
+				return nil, errors.E(
+					op,
+					errors.CodeConflict,
+					fmt.Errorf("queue of key = %s is full", key),
+					errors.SeverityDebug,
+					id,
+					ccName,
+				)
+			}
+			queue.Waiters = append(queue.Waiters, model.QueueWaiter{TxID: input.TxID, Grace: grace})
+			err = putKeyQueue(stub, input.Chaincode, key, queue)
+			if err != nil {
+				return nil, errors.E(op, err, id, ccName)
+			}
+			i = len(queue.Waiters) - 1
+		}
+		out.Positions[key] = i + 1
+	}
+	return out, nil
+}
+
+// dequeueTx : removes the transition from queue of each key, giving
+// up its reservation, if any, to the next waiter
+func dequeueTx(stub shim.ChaincodeStubInterface, input model.QueueInput) ([]model.EligibleWaiter, error) {
+	const op = errors.Op("Queue.dequeueTx")
+	ccName := errors.Chaincode(input.Chaincode)
+	eligible := []model.EligibleWaiter{}
+	for _, key := range input.Keys {
+		queue, err := getKeyQueue(stub, input.Chaincode, key)
+		if err != nil {
+			return nil, errors.E(op, err, ccName)
+		}
+		queue.Waiters = slices.DeleteFunc(queue.Waiters, func(w model.QueueWaiter) bool {
+			return w.TxID == input.TxID
+		})
+		if queue.Eligible != input.TxID {
+			err = putKeyQueue(stub, input.Chaincode, key, queue)
+			if err != nil {
+				return nil, errors.E(op, err, errors.TxID(input.TxID), ccName)
+			}
+			continue
+		}
+		queue.Eligible, queue.EligibleUntil = "", 0
+		err = putKeyQueue(stub, input.Chaincode, key, queue)
+		if err != nil {
+			return nil, errors.E(op, err, errors.TxID(input.TxID), ccName)
+		}
+		ok, err := isLockStateExists(stub, input.Chaincode, key)
+		if err != nil {
+			return nil, errors.E(op, err, ccName)
+		}
+		if ok {
+			continue
+		}
+		next, err := promoteWaiter(stub, input.Chaincode, key, nil)
+		if err != nil {
+			return nil, errors.E(op, err, errors.TxID(input.TxID), ccName)
+		}
+		if next != nil {
+			eligible = append(eligible, *next)
+		}
+	}
+	return eligible, nil
+}
+
+// setQueueEvent : sets event telling waiters they can lock
+// released keys. Fabric keeps a single event per transaction,
+// so all waiters made eligible by a request share it
+func setQueueEvent(stub shim.ChaincodeStubInterface, eligible []model.EligibleWaiter) error {
+	const op = errors.Op("Queue.setQueueEvent")
+	if len(eligible) == 0 {
+		return nil
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	if config.DisableEvents {
+		return nil
+	}
+	raw, err := json.Marshal(eligible)
+	if err != nil {
+		return errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to encode eligible event : %w", err),
+			errors.SeverityError,
+		)
+	}
+	err = stub.SetEvent(queueEventName, raw)
+	if err != nil {
+		return errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to set eligible event : %w", err),
+			errors.SeverityError,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"datalock/model"
+	"datalock/pkg/endorsement"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestKeyQueue : a released key is reserved for the first waiter
+// for its grace period, then free for anyone
+func TestKeyQueue(t *testing.T) {
+	is := assert.New(t)
+	n := endorsement.NewNetwork("emissions-data", "Org1")
+	n.Deploy(dataLockCCName, &DataLockChaincode{})
+	n.Deploy(keysCCName, keysCC{})
+
+	step := 0
+	now := int64(1000)
+	creator := testCreator(t, "Org1MSP", "owner")
+	submit := func(args ...string) pb.Response {
+		step++
+		p := endorsement.NewProposal(fmt.Sprintf("step-%d", step), dataLockCCName, args...)
+		p.Creator = creator
+		p.Timestamp = &timestamp.Timestamp{Seconds: now}
+		resp, _ := n.Submit(p)
+		return resp
+	}
+	stage := func(txID string, lock bool, keys ...string) pb.Response {
+		input := model.StageUpdateInput{TxID: txID, Name: "stage"}
+		ccInput := map[string]model.DataChaincodeInput{keysCCName: {Keys: keys, Params: append([]string{"op"}, keys...)}}
+		if lock {
+			input.DataLocks = ccInput
+		} else {
+			input.DataFree = ccInput
+		}
+		raw, _ := json.Marshal(input)
+		return submit("stageUpdate", string(raw))
+	}
+	join := func(txID string, grace int64, keys ...string) model.QueueOutput {
+		raw, _ := json.Marshal(model.QueueInput{TxID: txID, Chaincode: keysCCName, Keys: keys, Grace: grace})
+		resp := submit("joinQueue", string(raw))
+		is.Equal(int32(shim.OK), resp.Status, resp.Message)
+		var out model.QueueOutput
+		is.NoError(json.Unmarshal(resp.Payload, &out))
+		return out
+	}
+	queue := func(key string) model.KeyQueue {
+		resp := n.Evaluate(endorsement.NewProposal("query", dataLockCCName, "getQueue", keysCCName, key))
+		is.Equal(int32(shim.OK), resp.Status, resp.Message)
+		var out model.KeyQueue
+		is.NoError(json.Unmarshal(resp.Payload, &out))
+		return out
+	}
+	lastEvent := func() []model.EligibleWaiter {
+		events := n.Events()
+		is.NotEmpty(events)
+		e := events[len(events)-1]
+		is.Equal(queueEventName, e.EventName)
+		var out []model.EligibleWaiter
+		is.NoError(json.Unmarshal(e.Payload, &out))
+		return out
+	}
+
+	for _, txID := range []string{"txID-1", "txID-2", "txID-3", "txID-4"} {
+		submit("startTransitionProcess", txID)
+	}
+	is.Equal(int32(shim.OK), stage("txID-1", true, "key-1").Status)
+
+	t.Run("join", func(t *testing.T) {
+		is.Equal(map[string]int{"key-1": 1, "key-2": 0}, join("txID-2", 60, "key-1", "key-2").Positions)
+		is.Equal(map[string]int{"key-1": 2}, join("txID-3", 0, "key-1").Positions)
+		// joining again keeps the position
+		is.Equal(map[string]int{"key-1": 1}, join("txID-2", 60, "key-1").Positions)
+		is.Equal([]model.QueueWaiter{{TxID: "txID-2", Grace: 60}, {TxID: "txID-3", Grace: model.DefaultQueueGrace}}, queue("key-1").Waiters)
+
+		raw, _ := json.Marshal(model.QueueInput{TxID: "txID-1", Chaincode: keysCCName, Keys: []string{"key-1"}})
+		resp := submit("joinQueue", string(raw))
+		is.Equal(int32(409), resp.Status)
+	})
+
+	t.Run("release", func(t *testing.T) {
+		is.Equal(int32(shim.OK), stage("txID-1", false, "key-1").Status)
+		is.Equal([]model.EligibleWaiter{{TxID: "txID-2", Chaincode: keysCCName, Key: "key-1", Until: now + 60}}, lastEvent())
+		q := queue("key-1")
+		is.Equal("txID-2", q.Eligible)
+		is.Equal([]model.QueueWaiter{{TxID: "txID-3", Grace: model.DefaultQueueGrace}}, q.Waiters)
+	})
+
+	t.Run("reserved", func(t *testing.T) {
+		resp := stage("txID-4", true, "key-1")
+		is.Equal(int32(409), resp.Status)
+		is.Contains(resp.Message, "reserved for txID = txID-2")
+		is.Equal(int32(409), stage("txID-3", true, "key-1").Status)
+
+		// data chaincodes checking locks see the reservation
+		check := func(txID string) model.CheckLocksOutput {
+			raw, _ := json.Marshal(model.CheckLocksInput{Chaincode: keysCCName, Keys: []string{"key-1"}, TxID: txID})
+			p := endorsement.NewProposal("check", dataLockCCName, "checkLocks", string(raw))
+			p.Creator = creator
+			p.Timestamp = &timestamp.Timestamp{Seconds: now}
+			resp := n.Evaluate(p)
+			is.Equal(int32(shim.OK), resp.Status, resp.Message)
+			var out model.CheckLocksOutput
+			is.NoError(json.Unmarshal(resp.Payload, &out))
+			return out
+		}
+		out := check("txID-4")
+		is.False(out.Allowed)
+		is.Equal("key = key-1 reserved for txID = txID-2", out.Reason)
+		is.Equal("txID-2", out.Locks[0].ReservedFor)
+		is.True(check("txID-2").Allowed)
+
+		// waiter which took the key leaves the queue
+		is.Equal(int32(shim.OK), stage("txID-2", true, "key-1").Status, "eligible waiter")
+		q := queue("key-1")
+		is.Empty(q.Eligible)
+		is.Equal([]model.QueueWaiter{{TxID: "txID-3", Grace: model.DefaultQueueGrace}}, q.Waiters)
+	})
+
+	t.Run("grace-over", func(t *testing.T) {
+		join("txID-4", 0, "key-1")
+		// txID-3 is made eligible by the abort of txID-2
+		is.Equal(int32(shim.OK), submit("abortTransitionProcess", "txID-2").Status)
+		is.Equal("txID-3", lastEvent()[0].TxID)
+
+		now += model.DefaultQueueGrace + 1
+		is.Equal(int32(shim.OK), stage("txID-1", true, "key-1").Status)
+		is.Equal([]model.QueueWaiter{{TxID: "txID-4", Grace: model.DefaultQueueGrace}}, queue("key-1").Waiters)
+	})
+
+	t.Run("skip-finished", func(t *testing.T) {
+		submit("abortTransitionProcess", "txID-4")
+		is.Equal(int32(shim.OK), stage("txID-1", false, "key-1").Status)
+		is.Equal(model.KeyQueue{Waiters: []model.QueueWaiter{}}, queue("key-1"))
+		is.Empty(n.GetState(dataLockCCName, lockQueueKey(lockStateID(keysCCName, "key-1"))))
+	})
+
+	t.Run("leave", func(t *testing.T) {
+		is.Equal(int32(shim.OK), stage("txID-1", true, "key-2").Status)
+		join("txID-3", 0, "key-2")
+		submit("startTransitionProcess", "txID-5")
+		join("txID-5", 0, "key-2")
+		is.Equal(int32(shim.OK), stage("txID-1", false, "key-2").Status)
+		is.Equal("txID-3", queue("key-2").Eligible)
+
+		// eligible waiter leaving hands the key to the next one
+		raw, _ := json.Marshal(model.QueueInput{TxID: "txID-3", Chaincode: keysCCName, Keys: []string{"key-2"}})
+		is.Equal(int32(shim.OK), submit("leaveQueue", string(raw)).Status)
+		is.Equal("txID-5", queue("key-2").Eligible)
+		is.Equal("txID-5", lastEvent()[0].TxID)
+	})
+}
@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"datalock/model"
+	"datalock/pkg/errors"
+	"datalock/pkg/metrics"
+	"maps"
+	"slices"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// dryRunStub : discards writes of datalock, so that a stage update
+// runs its checks and data chaincode calls without changing
+// anything, and records no metrics. Data chaincodes can't be
+// stopped from writing, pkg/guard tells them the call is a dry
+// run and rejects their writes, simulations are meant to be
+// evaluated
+type dryRunStub struct {
+	shim.ChaincodeStubInterface
+	cc *DataLockChaincode
+}
+
+func newDryRunStub(stub shim.ChaincodeStubInterface) dryRunStub {
+	cc := *chaincodeOf(stub)
+	cc.metrics = metrics.Nop{}
+	return dryRunStub{stub, &cc}
+}
+
+func (dryRunStub) PutState(string, []byte) error                                  { return nil }
+func (dryRunStub) DelState(string) error                                          { return nil }
+func (dryRunStub) SetStateValidationParameter(string, []byte) error               { return nil }
+func (dryRunStub) PutPrivateData(string, string, []byte) error                    { return nil }
+func (dryRunStub) DelPrivateData(string, string) error                            { return nil }
+func (dryRunStub) SetPrivateDataValidationParameter(string, string, []byte) error { return nil }
+func (dryRunStub) SetEvent(string, []byte) error                                  { return nil }
+
+// simulateStage : runs the stage update against a dryRunStub,
+// reporting a failure of the update in the simulation. Only
+// unexpected errors are returned
+func simulateStage(stub shim.ChaincodeStubInterface, op errors.Op, input model.StageUpdateInput) (*model.StageSimulation, error) {
+	out := &model.StageSimulation{
+		Locks:     map[string][]string{},
+		Conflicts: []model.LockConflict{},
+	}
+	for _, cc := range slices.Sorted(maps.Keys(input.DataLocks)) {
+		conflicts, err := lockConflicts(stub, input.TxID, cc, input.DataLocks[cc].Keys)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+		out.Conflicts = append(out.Conflicts, conflicts...)
+	}
+
+	output, err := updateStage(newDryRunStub(stub), op, input)
+	if err != nil {
+		if errors.ErrCode(err) == errors.CodeUnexpected {
+			return nil, err
+		}
+		out.Error = errors.ID(err)
+		out.Reason = err.Error()
+		return out, nil
+	}
+	out.Allowed = true
+	out.Output = output
+	for cc, fences := range output.Fences {
+		out.Locks[cc] = slices.Sorted(maps.Keys(fences))
+	}
+	return out, nil
+}
+
+// lockConflicts : keys of cc already locked, or
+// reserved for a transition other than txID
+func lockConflicts(stub shim.ChaincodeStubInterface, txID, cc string, keys []string) ([]model.LockConflict, error) {
+	const op = errors.Op("Simulate.lockConflicts")
+	out := []model.LockConflict{}
+	for _, key := range keys {
+		holder, err := getLockStateTxID(stub, cc, key)
+		if err != nil {
+			return nil, errors.E(op, err, errors.Chaincode(cc))
+		}
+		if holder != "" {
+			// locking a key again fails, even for its holder
+			out = append(out, model.LockConflict{Chaincode: cc, Key: key, TxID: holder})
+			continue
+		}
+		queue, err := getKeyQueue(stub, cc, key)
+		if err != nil {
+			return nil, errors.E(op, err, errors.Chaincode(cc))
+		}
+		eligible, err := reservedFor(stub, queue)
+		if err != nil {
+			return nil, errors.E(op, err, errors.Chaincode(cc))
+		}
+		if eligible != "" && eligible != txID {
+			out = append(out, model.LockConflict{Chaincode: cc, Key: key, TxID: eligible, Reserved: true})
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"bytes"
+	"datalock/model"
+	"datalock/pkg/endorsement"
+	"datalock/pkg/metrics"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulateStageUpdate(t *testing.T) {
+	is := assert.New(t)
+	n := endorsement.NewNetwork("emissions-data", "Org1")
+	registry := metrics.NewRegistry()
+	n.Deploy(dataLockCCName, New(WithMetrics(registry)))
+	n.Deploy(keysCCName, keysCC{})
+
+	step := 0
+	submit := func(args ...string) pb.Response {
+		step++
+		resp, _ := n.Submit(endorsement.NewProposal(fmt.Sprintf("step-%d", step), dataLockCCName, args...))
+		return resp
+	}
+	input := func(txID string, keys ...string) string {
+		raw, _ := json.Marshal(model.StageUpdateInput{
+			TxID:      txID,
+			Name:      "stage",
+			DataLocks: map[string]model.DataChaincodeInput{keysCCName: {Keys: keys, Params: append([]string{"lock"}, keys...)}},
+		})
+		return string(raw)
+	}
+	simulate := func(txID string, keys ...string) model.StageSimulation {
+		resp := n.Evaluate(endorsement.NewProposal("query", dataLockCCName, "simulateStageUpdate", input(txID, keys...)))
+		is.Equal(int32(shim.OK), resp.Status, resp.Message)
+		var out model.StageSimulation
+		is.NoError(json.Unmarshal(resp.Payload, &out))
+		return out
+	}
+
+	submit("startTransitionProcess", "txID-1")
+	submit("startTransitionProcess", "txID-2")
+	is.Equal(int32(shim.OK), submit("stageUpdate", input("txID-1", "key-1")).Status)
+
+	t.Run("allowed", func(t *testing.T) {
+		before := n.GetState(dataLockCCName, "txID-2")
+		out := simulate("txID-2", "key-2", "key-3")
+		is.True(out.Allowed)
+		is.Empty(out.Conflicts)
+		is.Equal(map[string][]string{keysCCName: {"key-2", "key-3"}}, out.Locks)
+		is.Equal(map[string]uint64{"key-2": 1, "key-3": 1}, out.Output.Fences[keysCCName])
+
+		// nothing is written, even if submitted
+		is.Equal(int32(shim.OK), submit("simulateStageUpdate", input("txID-2", "key-2")).Status)
+		is.Equal(before, n.GetState(dataLockCCName, "txID-2"))
+		is.Empty(n.GetState(dataLockCCName, lockStateID(keysCCName, "key-2")))
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		out := simulate("txID-2", "key-1", "key-2")
+		is.False(out.Allowed)
+		is.Equal("LOCKED", out.Error)
+		is.Contains(out.Reason, "key = key-1 already locked")
+		is.Equal([]model.LockConflict{{Chaincode: keysCCName, Key: "key-1", TxID: "txID-1"}}, out.Conflicts)
+		is.Empty(out.Locks)
+		is.Nil(out.Output)
+
+		// locking again fails for the holder as well
+		out = simulate("txID-1", "key-1")
+		is.False(out.Allowed)
+		is.Equal([]model.LockConflict{{Chaincode: keysCCName, Key: "key-1", TxID: "txID-1"}}, out.Conflicts)
+	})
+
+	t.Run("not-found", func(t *testing.T) {
+		out := simulate("txID-3", "key-2")
+		is.False(out.Allowed)
+		is.Equal("NOT_FOUND", out.Error)
+	})
+
+	t.Run("invalid-input", func(t *testing.T) {
+		resp := n.Evaluate(endorsement.NewProposal("query", dataLockCCName, "simulateStageUpdate", `{"tx_id":""}`))
+		is.Equal(int32(400), resp.Status)
+	})
+
+	t.Run("no-metrics", func(t *testing.T) {
+		// only the stage update of txID-1 is recorded
+		out := new(bytes.Buffer)
+		is.NoError(registry.WriteText(out))
+		is.Contains(out.String(), `datalock_lock_attempts_total{chaincode="KeysCC",outcome="success"} 1`+"\n")
+		is.NotContains(out.String(), `outcome="conflict"`)
+	})
+}
@@ -0,0 +1,241 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"datalock/model"
+	"datalock/pkg/canonical"
+	"datalock/pkg/errors"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Stage data of a transaction is stored under its own key, one
+// per stage, so that a stage update only reads and writes the
+// small transaction header and the updated stage. Records of
+// the old layout keep stage data inline in the header, until
+// their next write (or migrateTransitions) splits it out.
+// The header keeps a hash of every stage record (StageDigests),
+// so that its digest covers the stage data as well.
+
+const (
+	stageDataObj = "txID~stage"
+)
+
+func stageDataKey(txID, stage string) string {
+	key, _ := shim.CreateCompositeKey(stageDataObj, []string{txID, stage})
+	return key
+}
+
+// stageDigest : hex encoded sha256 of a stored stage record
+func stageDigest(raw []byte) string {
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// putStageData : stores canonical encoding of stage
+// data, returns the digest of the stored record
+func putStageData(stub shim.ChaincodeStubInterface, txID, stage string, data *model.TxStageData) (string, error) {
+	const op = errors.Op("Stage.putStageData")
+	id := errors.TxID(txID)
+
+	raw, err := canonical.Marshal(data)
+	if err != nil {
+		return "", errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to encode stage data : %w", err),
+			errors.SeverityError,
+			id,
+		)
+	}
+	err = stub.PutState(stageDataKey(txID, stage), raw)
+	if err != nil {
+		return "", errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to put stage data : %w", err),
+			errors.SeverityError,
+			id,
+		)
+	}
+	return stageDigest(raw), nil
+}
+
+// getStageData : data of a stage, nil if the stage has no data
+func getStageData(stub shim.ChaincodeStubInterface, tx *model.Transaction, stage string) (*model.TxStageData, error) {
+	const op = errors.Op("Stage.getStageData")
+	if data, ok := tx.StageData[stage]; ok {
+		// old layout
+		return data, nil
+	}
+	raw, err := stub.GetState(stageDataKey(tx.TxID, stage))
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to get stage data : %w", err),
+			errors.SeverityError,
+			errors.TxID(tx.TxID),
+		)
+	}
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	return decodeStageData(op, tx.TxID, raw)
+}
+
+// getStageRecords : stored stage records of the
+// transaction (new layout), by stage name
+func getStageRecords(stub shim.ChaincodeStubInterface, txID string) (map[string][]byte, error) {
+	const op = errors.Op("Stage.getStageRecords")
+	id := errors.TxID(txID)
+
+	itr, err := stub.GetStateByPartialCompositeKey(stageDataObj, []string{txID})
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to create stage data iterator : %w", err),
+			errors.SeverityError,
+			id,
+		)
+	}
+	defer itr.Close()
+	out := map[string][]byte{}
+	for itr.HasNext() {
+		kv, err := itr.Next()
+		if err != nil {
+			return nil, errors.E(
+				op,
+				errors.CodeUnexpected,
+				fmt.Errorf("failed to iterate stage data : %w", err),
+				errors.SeverityError,
+				id,
+			)
+		}
+		_, attrs, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil || len(attrs) != 2 {
+			return nil, errors.E(
+				op,
+				errors.CodeUnexpected,
+				fmt.Errorf("invalid stage data key %q", kv.Key),
+				errors.SeverityError,
+				id,
+			)
+		}
+		out[attrs[1]] = kv.Value
+	}
+	return out, nil
+}
+
+// getAllStageData : data of every stage of the transaction
+func getAllStageData(stub shim.ChaincodeStubInterface, tx *model.Transaction) (map[string]*model.TxStageData, error) {
+	const op = errors.Op("Stage.getAllStageData")
+
+	out := make(map[string]*model.TxStageData, len(tx.StageData))
+	for stage, data := range tx.StageData {
+		out[stage] = data
+	}
+	records, err := getStageRecords(stub, tx.TxID)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	for stage, raw := range records {
+		data, err := decodeStageData(op, tx.TxID, raw)
+		if err != nil {
+			return nil, err
+		}
+		out[stage] = data
+	}
+	return out, nil
+}
+
+// validStageDigests : true, if the stored stage records
+// are exactly the ones hashed on the transaction header
+func validStageDigests(stub shim.ChaincodeStubInterface, tx *model.Transaction) (bool, error) {
+	const op = errors.Op("Stage.validStageDigests")
+	records, err := getStageRecords(stub, tx.TxID)
+	if err != nil {
+		return false, errors.E(op, err)
+	}
+	if len(records) != len(tx.StageDigests) {
+		return false, nil
+	}
+	for stage, raw := range records {
+		if tx.StageDigests[stage] != stageDigest(raw) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func decodeStageData(op errors.Op, txID string, raw []byte) (*model.TxStageData, error) {
+	var data model.TxStageData
+	err := json.Unmarshal(raw, &data)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("invalid stage data : %w", err),
+			errors.SeverityError,
+			errors.TxID(txID),
+		)
+	}
+	return &data, nil
+}
+
+// getTxView : transaction header along with
+// data of all of its stages
+func getTxView(stub shim.ChaincodeStubInterface, txID string) (*model.Transaction, error) {
+	const op = errors.Op("Stage.getTxView")
+	tx, err := getTx(stub, txID)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	tx.StageData, err = getAllStageData(stub, tx)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return tx, nil
+}
+
+// migrateTx : moves inline stage data of a record in the old
+// layout to its own keys, and hashes stage records written
+// before the header kept their digests. A transition created
+// before owners were recorded gets claimant, if not nil, as
+// owner. Returns false if already migrated
+func migrateTx(stub shim.ChaincodeStubInterface, txID string, claimant *model.Identity) (bool, error) {
+	const op = errors.Op("Stage.migrateTx")
+	tx, err := getTx(stub, txID)
+	if err != nil {
+		return false, errors.E(op, err)
+	}
+	claim := tx.Owner == nil && claimant != nil
+	if claim {
+		tx.Owner = claimant
+	}
+	records, err := getStageRecords(stub, txID)
+	if err != nil {
+		return false, errors.E(op, err)
+	}
+	hashed := true
+	for stage := range records {
+		_, ok := tx.StageDigests[stage]
+		hashed = hashed && ok
+	}
+	if len(tx.StageData) == 0 && hashed && !claim {
+		return false, nil
+	}
+	tx.StageData, err = getAllStageData(stub, tx)
+	if err != nil {
+		return false, errors.E(op, err)
+	}
+	_, err = putTx(stub, tx)
+	if err != nil {
+		return false, errors.E(op, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"datalock/model"
+	"datalock/pkg/canonical"
+	"datalock/pkg/endorsement"
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+func stageProposal(txID, stage string, storage map[string]string) *endorsement.Proposal {
+	raw, _ := json.Marshal(model.StageUpdateInput{TxID: txID, Name: stage, Storage: storage})
+	return endorsement.NewProposal("stage-"+stage, dataLockCCName, "stageUpdate", string(raw))
+}
+
+// TestStageLayout : a stage update only touches the
+// transaction header and its own stage, reading the
+// configuration as well
+func TestStageLayout(t *testing.T) {
+	is := assert.New(t)
+	n := newEndorsementNetwork(t)
+	startTransitions(t, n, "txID-1")
+
+	_, code := n.Submit(stageProposal("txID-1", "first", map[string]string{"k": "1"}))
+	is.Equal(pb.TxValidationCode_VALID, code)
+
+	tx := n.Endorse(stageProposal("txID-1", "second", map[string]string{"k": "2"}))
+	rwset := tx.RWSet()
+	is.ElementsMatch([]string{"txID-1", configKey()}, keysOf(rwset.Reads[dataLockCCName]))
+	is.ElementsMatch([]string{"txID-1", stageDataKey("txID-1", "second")}, rwset.WriteKeys(dataLockCCName))
+	is.Equal([]pb.TxValidationCode{pb.TxValidationCode_VALID}, n.Commit(tx))
+
+	var header model.Transaction
+	is.NoError(json.Unmarshal(n.GetState(dataLockCCName, "txID-1"), &header))
+	is.Empty(header.StageData)
+	is.Equal("second", header.CurrentStage)
+
+	resp := n.Evaluate(endorsement.NewProposal("details", dataLockCCName, "getTxDetails", "txID-1"))
+	is.Equal(int32(shim.OK), resp.Status, resp.Message)
+	var view model.Transaction
+	is.NoError(json.Unmarshal(resp.Payload, &view))
+	is.Equal(header.Revision, view.Revision)
+	is.Len(view.StageData, 2)
+	is.Equal("1", view.StageData["first"].Storage["k"])
+	is.Equal("2", view.StageData["second"].Storage["k"])
+}
+
+func keysOf[V any](m map[string]V) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
+
+func TestMigrateTx(t *testing.T) {
+	is := assert.New(t)
+	stub := shimtest.NewMockStub(dataLockCCName, &DataLockChaincode{})
+	const mockID = "mockID"
+
+	// record of the old layout
+	legacy := model.Transaction{
+		TxID:         "txID-1",
+		State:        model.TxStatePROCESSING,
+		CurrentStage: "first",
+		StageData: map[string]*model.TxStageData{
+			"first": {Storage: map[string]string{"k": "1"}},
+		},
+		Revision: 3,
+	}
+	legacy.Digest, _ = txDigest(&legacy)
+	raw, _ := canonical.Marshal(legacy)
+	stub.MockTransactionStart(mockID)
+	stub.PutState("txID-1", raw)
+	stub.MockTransactionEnd(mockID)
+
+	details := func() model.Transaction {
+		resp := stub.MockInvoke(mockID, stringArgsToByte([]string{"getTxDetails", "txID-1"}))
+		is.Equal(shim.OK, int(resp.Status), resp.Message)
+		var tx model.Transaction
+		is.NoError(json.Unmarshal(resp.Payload, &tx))
+		return tx
+	}
+	migrate := func() model.TxMigrationOutput {
+		resp := stub.MockInvoke(mockID, stringArgsToByte([]string{"migrateTransitions", "txID-1"}))
+		is.Equal(shim.OK, int(resp.Status), resp.Message)
+		var out model.TxMigrationOutput
+		is.NoError(json.Unmarshal(resp.Payload, &out))
+		return out
+	}
+
+	t.Run("read-old-layout", func(t *testing.T) {
+		is.Equal(legacy.StageData, details().StageData)
+		resp := stub.MockInvoke(mockID, stringArgsToByte([]string{"getStageOutput", "txID-1", "first"}))
+		is.Equal(shim.OK, int(resp.Status), resp.Message)
+		resp = stub.MockInvoke(mockID, stringArgsToByte([]string{"getTxDigest", "txID-1"}))
+		is.Contains(string(resp.Payload), `"valid":true`)
+	})
+
+	t.Run("migrate", func(t *testing.T) {
+		is.Equal([]string{"txID-1"}, migrate().Migrated)
+		var header model.Transaction
+		is.NoError(json.Unmarshal(stub.State["txID-1"], &header))
+		is.Empty(header.StageData)
+		is.Equal(uint64(4), header.Revision)
+		is.NotEmpty(stub.State[stageDataKey("txID-1", "first")])
+
+		is.Equal(legacy.StageData, details().StageData)
+		is.Empty(migrate().Migrated)
+	})
+
+	t.Run("migrate-not-found", func(t *testing.T) {
+		resp := stub.MockInvoke(mockID, stringArgsToByte([]string{"migrateTransitions", "txID-1", "txID-2"}))
+		is.Equal(int32(404), resp.Status)
+		resp = stub.MockInvoke(mockID, stringArgsToByte([]string{"migrateTransitions"}))
+		is.Equal(int32(400), resp.Status)
+	})
+
+	t.Run("stage-update-splits", func(t *testing.T) {
+		stub.MockTransactionStart(mockID)
+		legacy.TxID = "txID-2"
+		raw, _ := canonical.Marshal(legacy)
+		stub.PutState("txID-2", raw)
+		stub.MockTransactionEnd(mockID)
+
+		input, _ := json.Marshal(model.StageUpdateInput{TxID: "txID-2", Name: "second", Storage: map[string]string{"k": "2"}})
+		resp := stub.MockInvoke(mockID, stringArgsToByte([]string{"stageUpdate", string(input)}))
+		is.Equal(shim.OK, int(resp.Status), resp.Message)
+		is.NotEmpty(stub.State[stageDataKey("txID-2", "first")])
+		is.NotEmpty(stub.State[stageDataKey("txID-2", "second")])
+		is.NotContains(string(stub.State["txID-2"]), "stage_data")
+	})
+	t.Run("hash-stage-records", func(t *testing.T) {
+		// header written before it kept digests of stage records
+		var header model.Transaction
+		is.NoError(json.Unmarshal(stub.State["txID-1"], &header))
+		is.Len(header.StageDigests, 1)
+		header.StageDigests = nil
+		header.Digest, _ = txDigest(&header)
+		stub.State["txID-1"], _ = canonical.Marshal(header)
+		resp := stub.MockInvoke(mockID, stringArgsToByte([]string{"getTxDigest", "txID-1"}))
+		is.Contains(string(resp.Payload), `"valid":false`)
+
+		is.Equal([]string{"txID-1"}, migrate().Migrated)
+		resp = stub.MockInvoke(mockID, stringArgsToByte([]string{"getTxDigest", "txID-1"}))
+		is.Contains(string(resp.Payload), `"valid":true`)
+		is.Equal(legacy.StageData, details().StageData)
+		is.Empty(migrate().Migrated)
+	})
+}
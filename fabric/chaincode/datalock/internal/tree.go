@@ -0,0 +1,254 @@
+package internal
+
+import (
+	"datalock/model"
+	"datalock/pkg/errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// A transition can fan out into child transitions, each with its
+// own stages and locks. Children are indexed under their parent
+// (parent~child), so starting siblings doesn't write the parent.
+// A parent finishes once every child is finished or aborted, and
+// aborting it aborts the children still running.
+
+const (
+	txChildObj = "parent~child"
+	// maxTxTreeDepth : of transitions, a top-level one included
+	maxTxTreeDepth = 3
+	// maxTxChildren : of a transition
+	maxTxChildren = 128
+)
+
+func txChildKey(parent, child string) string {
+	key, _ := shim.CreateCompositeKey(txChildObj, []string{parent, child})
+	return key
+}
+
+// getTxChildren : txIDs of the children of txID
+func getTxChildren(stub shim.ChaincodeStubInterface, txID string) ([]string, error) {
+	const op = errors.Op("Tree.getTxChildren")
+	itr, err := stub.GetStateByPartialCompositeKey(txChildObj, []string{txID})
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to create child index iterator : %w", err),
+			errors.SeverityError,
+			errors.TxID(txID),
+		)
+	}
+	out := []string{}
+	defer itr.Close()
+	for itr.HasNext() {
+		kv, err := itr.Next()
+		if err != nil {
+			return nil, errors.E(
+				op,
+				errors.CodeUnexpected,
+				fmt.Errorf("failed to iterate child index : %w", err),
+				errors.SeverityError,
+				errors.TxID(txID),
+			)
+		}
+		_, args, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil || len(args) != 2 {
+			return nil, errors.E(
+				op,
+				errors.CodeUnexpected,
+				fmt.Errorf("invalid child index key %q", kv.Key),
+				errors.SeverityError,
+				errors.TxID(txID),
+			)
+		}
+		out = append(out, args[1])
+	}
+	return out, nil
+}
+
+// startChildTx : creates txID as a processing child of parent,
+// which must itself be processing and owned by the caller
+func startChildTx(stub shim.ChaincodeStubInterface, parent, txID, workflow string) ([]byte, error) {
+	const op = errors.Op("Tree.startChildTx")
+	id := errors.TxID(txID)
+
+	_, err := getTx(stub, txID)
+	if err == nil {
+		return nil, errors.E(
+			op,
+			errors.CodeConflict,
+			fmt.Errorf("transaction already exists"),
+			errors.SeverityDebug,
+			id,
+		)
+	}
+	if !errors.Is(err, errors.ErrNotFound) {
+		return nil, errors.E(op, err)
+	}
+	ptx, err := getTx(stub, parent)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	err = checkOwner(ptx, caller)
+	if err != nil {
+		return nil, errors.E(op, err, id)
+	}
+	if ptx.State != model.TxStatePROCESSING {
+		return nil, errors.E(
+			op,
+			errors.CodeConflict,
+			fmt.Errorf("parent transition is not at processing state, found at %s", ptx.State),
+			errors.SeverityDebug,
+			id,
+		)
+	}
+	depth := 2
+	for ancestor := ptx.Parent; ancestor != ""; depth++ {
+		if depth >= maxTxTreeDepth {
+			return nil, errors.E(
+				op,
+				errors.CodeConflict,
+				fmt.Errorf("transitions can't be nested more than %d deep", maxTxTreeDepth),
+				errors.SeverityDebug,
+				id,
+			)
+		}
+		atx, err := getTx(stub, ancestor)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+		ancestor = atx.Parent
+	}
+	children, err := getTxChildren(stub, parent)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	if len(children) >= maxTxChildren {
+		return nil, errors.E(
+			op,
+			errors.CodeConflict,
+			fmt.Errorf("parent transition already has %d children", len(children)),
+			errors.SeverityDebug,
+			id,
+		)
+	}
+
+	tx, err := newTx(stub, txID, workflow)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	tx.Parent = parent
+	err = stub.PutState(txChildKey(parent, txID), []byte{0x00})
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to put child index : %w", err),
+			errors.SeverityError,
+			id,
+		)
+	}
+	raw, err := putTx(stub, tx)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return raw, nil
+}
+
+// checkChildrenDone : error unless every child of
+// txID is finished or aborted
+func checkChildrenDone(stub shim.ChaincodeStubInterface, txID string) error {
+	const op = errors.Op("Tree.checkChildrenDone")
+	children, err := getTxChildren(stub, txID)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	running := []string{}
+	for _, child := range children {
+		tx, err := getTx(stub, child)
+		if err != nil {
+			return errors.E(op, err)
+		}
+		if tx.State != model.TxStateFINISHED && tx.State != model.TxStateABORTED {
+			running = append(running, child)
+		}
+	}
+	if len(running) != 0 {
+		return errors.E(
+			op,
+			errors.CodeConflict,
+			fmt.Errorf("child transitions still running : %s", strings.Join(running, ", ")),
+			errors.SeverityDebug,
+			errors.TxID(txID),
+		)
+	}
+	return nil
+}
+
+// runningTxTree : tx followed by its descendants neither finished
+// nor aborted. A finished transition has no running descendant
+func runningTxTree(stub shim.ChaincodeStubInterface, tx *model.Transaction) ([]*model.Transaction, error) {
+	const op = errors.Op("Tree.runningTxTree")
+	out := []*model.Transaction{tx}
+	for i := 0; i < len(out); i++ {
+		children, err := getTxChildren(stub, out[i].TxID)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+		for _, child := range children {
+			ctx, err := getTx(stub, child)
+			if err != nil {
+				return nil, errors.E(op, err)
+			}
+			if ctx.State != model.TxStateFINISHED && ctx.State != model.TxStateABORTED {
+				out = append(out, ctx)
+			}
+		}
+	}
+	return out, nil
+}
+
+// getTree : txID and its descendants, with their states
+func getTree(stub shim.ChaincodeStubInterface, txID string) (*model.TxTree, error) {
+	const op = errors.Op("Tree.getTree")
+	tx, err := getTx(stub, txID)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	root := &model.TxTree{TxID: tx.TxID, State: tx.State, Parent: tx.Parent}
+	err = fillTree(stub, root)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return root, nil
+}
+
+func fillTree(stub shim.ChaincodeStubInterface, node *model.TxTree) error {
+	const op = errors.Op("Tree.fillTree")
+	children, err := getTxChildren(stub, node.TxID)
+	if err != nil {
+		return errors.E(op, err)
+	}
+	node.Children = make([]model.TxTree, 0, len(children))
+	for _, child := range children {
+		tx, err := getTx(stub, child)
+		if err != nil {
+			return errors.E(op, err)
+		}
+		node.Children = append(node.Children, model.TxTree{TxID: tx.TxID, State: tx.State, Parent: node.TxID})
+	}
+	for i := range node.Children {
+		err = fillTree(stub, &node.Children[i])
+		if err != nil {
+			return errors.E(op, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"datalock/model"
+	"datalock/pkg/endorsement"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxTree(t *testing.T) {
+	is := assert.New(t)
+	n := endorsement.NewNetwork("emissions-data", "Org1")
+	n.Deploy(dataLockCCName, &DataLockChaincode{})
+	n.Deploy(keysCCName, keysCC{})
+
+	owner := testCreator(t, "Org1MSP", "owner")
+	step := 0
+	submitAs := func(creator []byte, args ...string) pb.Response {
+		step++
+		p := endorsement.NewProposal(fmt.Sprintf("step-%d", step), dataLockCCName, args...)
+		p.Creator = creator
+		resp, _ := n.Submit(p)
+		return resp
+	}
+	submit := func(args ...string) pb.Response {
+		return submitAs(owner, args...)
+	}
+	stage := func(txID string, last bool, locks ...string) pb.Response {
+		input := model.StageUpdateInput{TxID: txID, Name: fmt.Sprintf("stage-%d", step), IsLast: last}
+		if len(locks) != 0 {
+			input.DataLocks = map[string]model.DataChaincodeInput{keysCCName: {Keys: locks, Params: append([]string{"lock"}, locks...)}}
+		}
+		raw, _ := json.Marshal(input)
+		return submit("stageUpdate", string(raw))
+	}
+	tree := func(txID string) model.TxTree {
+		resp := n.Evaluate(endorsement.NewProposal("query", dataLockCCName, "getTxTree", txID))
+		is.Equal(int32(shim.OK), resp.Status, resp.Message)
+		var out model.TxTree
+		is.NoError(json.Unmarshal(resp.Payload, &out))
+		return out
+	}
+
+	submit("startTransitionProcess", "parent-1")
+	for _, child := range []string{"child-1", "child-2"} {
+		resp := submit("startChildTransition", "parent-1", child)
+		is.Equal(int32(shim.OK), resp.Status, resp.Message)
+	}
+	is.Equal(int32(shim.OK), submit("startChildTransition", "child-1", "grandchild-1").Status)
+
+	t.Run("start", func(t *testing.T) {
+		resp := submit("startChildTransition", "grandchild-1", "too-deep")
+		is.Equal(int32(409), resp.Status)
+		is.Contains(resp.Message, "can't be nested more than 3 deep")
+		is.Equal(int32(409), submit("startChildTransition", "parent-1", "child-1").Status)
+		is.Equal(int32(404), submit("startChildTransition", "unknown", "child-3").Status)
+		is.Equal(int32(400), submit("startChildTransition", "child-3", "child-3").Status)
+
+		resp = submitAs(testCreator(t, "Org1MSP", "other"), "startChildTransition", "parent-1", "child-3")
+		is.Equal(int32(409), resp.Status)
+		is.Contains(resp.Message, "caller is not owner of the transition")
+	})
+
+	t.Run("tree", func(t *testing.T) {
+		is.Equal(model.TxTree{
+			TxID:  "parent-1",
+			State: model.TxStatePROCESSING,
+			Children: []model.TxTree{
+				{TxID: "child-1", State: model.TxStatePROCESSING, Parent: "parent-1", Children: []model.TxTree{
+					{TxID: "grandchild-1", State: model.TxStatePROCESSING, Parent: "child-1", Children: []model.TxTree{}},
+				}},
+				{TxID: "child-2", State: model.TxStatePROCESSING, Parent: "parent-1", Children: []model.TxTree{}},
+			},
+		}, tree("parent-1"))
+		is.Equal("parent-1", tree("child-1").Parent)
+	})
+
+	t.Run("finish", func(t *testing.T) {
+		resp := stage("parent-1", true)
+		is.Equal(int32(409), resp.Status)
+		is.Contains(resp.Message, "child transitions still running : child-1, child-2")
+		is.Equal(int32(shim.OK), stage("child-2", true).Status)
+		is.Equal(int32(409), stage("child-1", true).Status)
+	})
+
+	t.Run("abort", func(t *testing.T) {
+		is.Equal(int32(shim.OK), stage("child-1", false, "key-1").Status)
+		is.Equal(int32(shim.OK), stage("grandchild-1", false, "key-2").Status)
+		resp := submit("abortTransitionProcess", "parent-1")
+		is.Equal(int32(shim.OK), resp.Status, resp.Message)
+
+		got := tree("parent-1")
+		is.Equal(model.TxStateABORTED, got.State)
+		is.Equal(model.TxStateABORTED, got.Children[0].State)
+		is.Equal(model.TxStateABORTED, got.Children[0].Children[0].State)
+		is.Equal(model.TxStateFINISHED, got.Children[1].State)
+		view := readLedger(n)
+		is.NoError(checkLockIndex(view))
+		is.Empty(n.GetState(dataLockCCName, lockStateID(keysCCName, "key-1")))
+		is.Empty(n.GetState(dataLockCCName, lockStateID(keysCCName, "key-2")))
+	})
+
+	t.Run("abort-child", func(t *testing.T) {
+		submit("startTransitionProcess", "parent-2")
+		submit("startChildTransition", "parent-2", "child-3")
+		is.Equal(int32(shim.OK), submit("abortTransitionProcess", "child-3").Status)
+		is.Equal(int32(shim.OK), stage("parent-2", true).Status)
+		is.Equal(int32(409), submit("startChildTransition", "parent-2", "child-4").Status)
+	})
+}
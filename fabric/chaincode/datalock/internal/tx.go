@@ -2,45 +2,64 @@ package internal
 
 import (
 	"datalock/model"
+	"datalock/pkg/canonical"
 	"datalock/pkg/errors"
 	"encoding/json"
 	"fmt"
+	"maps"
+	"slices"
 
 	"github.com/hyperledger/fabric-chaincode-go/shim"
 )
 
+// txKeyPrefix : of the key holding a transaction header when datalock
+// is mounted under a prefix. It then shares the namespace of the host
+// chaincode, the prefix keeps host keys apart from transactions and
+// lets archiving scan them by range. Deployed on its own, datalock
+// owns its namespace and keeps the header at the txID
+const txKeyPrefix = "datalock/tx/"
+
+func txKey(stub shim.ChaincodeStubInterface, txID string) string {
+	if !mounted(stub) {
+		return txID
+	}
+	return txKeyPrefix + txID
+}
+
 // before application can start locking/unlock using datalock
 // process will have to set state of transaction to processing
 // and when done, will again have to call datalock to change the
 // state to not-processing
 // id : identifier of tx
+// workflow : the transaction is part of, set on creation, empty if none
 // processing : true, setting the state to process and not-processing otherwise
-func txState(stub shim.ChaincodeStubInterface, txID string, processing bool) ([]byte, error) {
+func txState(stub shim.ChaincodeStubInterface, txID, workflow string, processing bool) ([]byte, error) {
 	const op = errors.Op("internal.txState")
 	id := errors.TxID(txID)
 
-	raw, err := stub.GetState(txID)
-	if err != nil {
-		errors.Wrap(err, "failed to fetch transaction")
-		return nil, errors.E(op, errors.CodeUnexpected, err, errors.SeverityError, id)
-	}
-	if len(raw) == 0 && !processing {
-		return nil, errors.E(op, errors.CodeNotFound, fmt.Errorf("transaction not found"), errors.SeverityDebug, id)
-	}
-	var tx model.Transaction
-	if len(raw) == 0 {
-		tx = model.Transaction{
-			TxID:      txID,
-			State:     model.TxStatePROCESSING,
-			StageData: map[string]*model.TxStageData{},
+	tx, err := getTx(stub, txID)
+	if errors.Is(err, errors.ErrNotFound) && processing {
+		tx, err = newTx(stub, txID, workflow)
+		if err != nil {
+			return nil, errors.E(op, err)
 		}
+	} else if err != nil {
+		return nil, errors.E(op, err)
 	} else {
-		json.Unmarshal(raw, &tx)
-		if processing && tx.State == model.TxStateFINISHED {
+		if workflow != "" && tx.Workflow != workflow {
+			return nil, errors.E(
+				op,
+				errors.CodeConflict,
+				fmt.Errorf("transaction is part of workflow = %q", tx.Workflow),
+				errors.SeverityDebug,
+				id,
+			)
+		}
+		if processing && (tx.State == model.TxStateFINISHED || tx.State == model.TxStateABORTED) {
 			return nil, errors.E(
 				op,
 				errors.CodeConflict,
-				fmt.Errorf("transaction is already at finished state"),
+				fmt.Errorf("transaction is already at %s state", tx.State),
 				errors.SeverityDebug,
 				id,
 			)
@@ -69,11 +88,331 @@ func txState(stub shim.ChaincodeStubInterface, txID string, processing bool) ([]
 			tx.State = model.TxStateNOTPROCESSING
 		}
 	}
-	raw, _ = json.Marshal(tx)
-	err = stub.PutState(txID, raw)
+	raw, err := putTx(stub, tx)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	return raw, nil
+}
+
+// pauseTx : moves a processing transaction to paused state,
+// recording the reason and checkpoint data of the pause
+func pauseTx(stub shim.ChaincodeStubInterface, input model.TxPauseInput) ([]byte, error) {
+	const op = errors.Op("internal.pauseTx")
+	id := errors.TxID(input.TxID)
+
+	tx, err := getTx(stub, input.TxID)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	if tx.State != model.TxStatePROCESSING {
+		return nil, errors.E(
+			op,
+			errors.CodeConflict,
+			fmt.Errorf("transaction is not at processing state, found at %s", tx.State),
+			errors.SeverityDebug,
+			id,
+		)
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return nil, errors.E(op, err, id)
+	}
+	tx.State = model.TxStatePAUSED
+	tx.PauseCount++
+	tx.Pause = &model.TxPause{
+		ReasonCode: input.ReasonCode,
+		Checkpoint: input.Checkpoint,
+		PausedAt:   now,
+	}
+	return putTx(stub, tx)
+}
+
+// resumeTx : moves a paused transaction back to processing
+// state, recording the reason and checkpoint of the resume
+// if any. Returned transaction carries the checkpoint of
+// the pause
+func resumeTx(stub shim.ChaincodeStubInterface, input model.TxResumeInput) ([]byte, error) {
+	const op = errors.Op("internal.resumeTx")
+	id := errors.TxID(input.TxID)
+
+	tx, err := getTx(stub, input.TxID)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	if tx.State != model.TxStatePAUSED {
+		return nil, errors.E(
+			op,
+			errors.CodeConflict,
+			fmt.Errorf("transaction is not at paused state, found at %s", tx.State),
+			errors.SeverityDebug,
+			id,
+		)
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return nil, errors.E(op, err, id)
+	}
+	tx.State = model.TxStatePROCESSING
+	tx.ResumedAt = append(tx.ResumedAt, now)
+	tx.Resume = nil
+	if input.ReasonCode != "" {
+		tx.Resume = &model.TxResume{
+			ReasonCode: input.ReasonCode,
+			Checkpoint: input.Checkpoint,
+			ResumedAt:  now,
+		}
+	}
+	return putTx(stub, tx)
+}
+
+// abortTx : releases every lock held by the transaction, without
+// calling data chaincodes, and moves it to the terminal aborted
+// state, along with its child transitions still running. Only
+// the owner of the transaction, or an admin, may abort it
+func abortTx(stub shim.ChaincodeStubInterface, txID string) ([]byte, error) {
+	const op = errors.Op("internal.abortTx")
+	id := errors.TxID(txID)
+
+	tx, err := getTx(stub, txID)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	if tx.State == model.TxStateFINISHED || tx.State == model.TxStateABORTED {
+		return nil, errors.E(
+			op,
+			errors.CodeConflict,
+			fmt.Errorf("transaction is already at %s state", tx.State),
+			errors.SeverityDebug,
+			id,
+		)
+	}
+	caller, err := callerIdentity(stub)
+	if err != nil {
+		return nil, errors.E(op, err, id)
+	}
+	err = checkOwner(tx, caller)
+	if err != nil {
+		admin, aerr := adminCaller(stub)
+		if aerr != nil {
+			return nil, errors.E(op, aerr, id)
+		}
+		if admin == nil {
+			return nil, errors.E(op, err)
+		}
+	}
+	// children still running are aborted along
+	tree, err := runningTxTree(stub, tx)
+	if err != nil {
+		return nil, errors.E(op, err)
+	}
+	aborted := make([]string, 0, len(tree))
+	for _, t := range tree {
+		aborted = append(aborted, t.TxID)
+	}
+	now, err := txTimestamp(stub)
+	if err != nil {
+		return nil, errors.E(op, err, id)
+	}
+	eligible := []model.EligibleWaiter{}
+	var raw []byte
+	for _, t := range tree {
+		lockIDs, err := getAllLockState(stub, t.TxID)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+		for _, lockID := range lockIDs {
+			next, err := releaseLock(stub, t.TxID, lockID, aborted)
+			if err != nil {
+				return nil, errors.E(op, err)
+			}
+			if next != nil {
+				eligible = append(eligible, *next)
+			}
+		}
+		t.State = model.TxStateABORTED
+		t.AbortedAt = now
+		out, err := putTx(stub, t)
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+		if t.TxID == txID {
+			raw = out
+		}
+	}
+	err = setQueueEvent(stub, eligible)
 	if err != nil {
-		errors.Wrap(err, "putting transaction into worldstate")
-		return nil, errors.E(op, errors.CodeUnexpected, err, errors.SeverityError, id)
+		return nil, errors.E(op, err, id)
 	}
 	return raw, nil
 }
+
+// newTx : processing transition, owned by the
+// creator of the proposal, handing off its locks. The txID
+// of an archived transaction can't be used again, its
+// archive summary would be overwritten
+func newTx(stub shim.ChaincodeStubInterface, txID, workflow string) (*model.Transaction, error) {
+	const op = errors.Op("internal.newTx")
+	_, err := getTxArchive(stub, txID)
+	if err == nil {
+		return nil, errors.E(
+			op,
+			errors.CodeConflict,
+			fmt.Errorf("transaction was archived"),
+			errors.SeverityDebug,
+			errors.TxID(txID),
+		)
+	}
+	if !errors.Is(err, errors.ErrNotFound) {
+		return nil, errors.E(op, err)
+	}
+	owner, err := callerIdentity(stub)
+	if err != nil {
+		return nil, errors.E(op, err, errors.TxID(txID))
+	}
+	return &model.Transaction{
+		TxID:      txID,
+		State:     model.TxStatePROCESSING,
+		Workflow:  workflow,
+		Owner:     owner,
+		StageData: map[string]*model.TxStageData{},
+	}, nil
+}
+
+func getTx(stub shim.ChaincodeStubInterface, txID string) (*model.Transaction, error) {
+	const op = errors.Op("internal.getTx")
+	id := errors.TxID(txID)
+
+	raw, err := stub.GetState(txKey(stub, txID))
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			errors.Wrap(err, "failed to fetch transaction"),
+			errors.SeverityError,
+			id,
+		)
+	}
+	if len(raw) == 0 {
+		return nil, errors.E(op, errors.CodeNotFound, fmt.Errorf("transaction not found"), errors.SeverityDebug, id)
+	}
+	var tx model.Transaction
+	err = json.Unmarshal(raw, &tx)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("invalid transaction state : %w", err),
+			errors.SeverityError,
+			id,
+		)
+	}
+	if tx.StageData == nil {
+		// empty fields are not stored
+		tx.StageData = map[string]*model.TxStageData{}
+	}
+	return &tx, nil
+}
+
+// putTx : stores canonical encoding of the transaction header
+// as a new revision, along with its digest. Stage data set on
+// tx is moved to the own key of each stage
+func putTx(stub shim.ChaincodeStubInterface, tx *model.Transaction) ([]byte, error) {
+	const op = errors.Op("internal.putTx")
+	id := errors.TxID(tx.TxID)
+
+	for _, stage := range slices.Sorted(maps.Keys(tx.StageData)) {
+		digest, err := putStageData(stub, tx.TxID, stage, tx.StageData[stage])
+		if err != nil {
+			return nil, errors.E(op, err)
+		}
+		if tx.StageDigests == nil {
+			tx.StageDigests = map[string]string{}
+		}
+		tx.StageDigests[stage] = digest
+	}
+	tx.StageData = map[string]*model.TxStageData{}
+
+	tx.Revision++
+	digest, err := txDigest(tx)
+	if err != nil {
+		return nil, errors.E(op, err, id)
+	}
+	tx.Digest = digest
+	raw, err := canonical.Marshal(tx)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to encode transaction : %w", err),
+			errors.SeverityError,
+			id,
+		)
+	}
+	err = stub.PutState(txKey(stub, tx.TxID), raw)
+	if err != nil {
+		return nil, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to put transaction state : %w", err),
+			errors.SeverityError,
+			id,
+		)
+	}
+	return raw, nil
+}
+
+// Clock : time of a request, it must be the
+// same on every endorsing peer
+type Clock interface {
+	// Now : unix time (seconds)
+	Now(stub shim.ChaincodeStubInterface) (int64, error)
+}
+
+type ClockFunc func(stub shim.ChaincodeStubInterface) (int64, error)
+
+func (f ClockFunc) Now(stub shim.ChaincodeStubInterface) (int64, error) {
+	return f(stub)
+}
+
+// txTimestamp : unix time (seconds) of the request,
+// read from the clock of the chaincode
+func txTimestamp(stub shim.ChaincodeStubInterface) (int64, error) {
+	return chaincodeOf(stub).clock.Now(stub)
+}
+
+// txClock : unix time (seconds) of the fabric
+// transaction, default clock
+func txClock(stub shim.ChaincodeStubInterface) (int64, error) {
+	const op = errors.Op("internal.txClock")
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return 0, errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to get tx timestamp : %w", err),
+			errors.SeverityError,
+		)
+	}
+	return ts.GetSeconds(), nil
+}
+
+// txDigest : hash of canonical encoding of the
+// transaction, computed with empty Digest
+func txDigest(tx *model.Transaction) (string, error) {
+	const op = errors.Op("internal.txDigest")
+	digest := tx.Digest
+	tx.Digest = ""
+	hash, err := canonical.Hash(tx)
+	tx.Digest = digest
+	if err != nil {
+		return "", errors.E(
+			op,
+			errors.CodeUnexpected,
+			fmt.Errorf("failed to hash transaction : %w", err),
+			errors.SeverityError,
+			errors.TxID(tx.TxID),
+		)
+	}
+	return hash, nil
+}
@@ -2,21 +2,24 @@ package internal
 
 import (
 	"datalock/model"
-	"datalock/pkg/logger"
+	"datalock/pkg/canonical"
+	"datalock/pkg/errors"
 	"encoding/json"
 	"testing"
 
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestTxState(t *testing.T) {
 	is := assert.New(t)
 	stub := buildEmptyMockStub()
-	logger.NewAppLogger("DEBUG")
 
 	t.Run("end-non-existing", func(t *testing.T) {
 		stub.MockTransactionStart("end-non-existing")
-		raw, err := txState(stub, "non-existsing", false)
+		raw, err := txState(stub, "non-existsing", "", false)
 		stub.MockTransactionEnd("end-non-existing")
 		is.Nil(raw)
 		is.Error(err)
@@ -25,7 +28,7 @@ func TestTxState(t *testing.T) {
 	txID := "uuid-1"
 	t.Run("start-non-existing", func(t *testing.T) {
 		stub.MockTransactionStart("start-non-existing")
-		raw, err := txState(stub, txID, true)
+		raw, err := txState(stub, txID, "", true)
 		stub.MockTransactionEnd("start-non-existing")
 		is.NoError(err)
 		is.NotNil(raw)
@@ -37,7 +40,7 @@ func TestTxState(t *testing.T) {
 
 	t.Run("start-processing", func(t *testing.T) {
 		stub.MockTransactionStart("start-processing")
-		raw, err := txState(stub, txID, true)
+		raw, err := txState(stub, txID, "", true)
 		stub.MockTransactionEnd("start-processing")
 		is.Equal("transaction is not at non-processing state, found at PROCESSING", err.Error())
 		is.Nil(raw)
@@ -45,7 +48,7 @@ func TestTxState(t *testing.T) {
 
 	t.Run("end-processing", func(t *testing.T) {
 		stub.MockTransactionStart("end-processing")
-		raw, err := txState(stub, txID, false)
+		raw, err := txState(stub, txID, "", false)
 		stub.MockTransactionEnd("end-processing")
 		is.NoError(err)
 		is.NotNil(raw)
@@ -57,7 +60,7 @@ func TestTxState(t *testing.T) {
 
 	t.Run("end-non-processing", func(t *testing.T) {
 		stub.MockTransactionStart("end-non-processing")
-		raw, err := txState(stub, txID, false)
+		raw, err := txState(stub, txID, "", false)
 		stub.MockTransactionEnd("end-non-processing")
 		is.Equal(
 			"transaction is not at processing state, found at NOT-PROCESSING",
@@ -68,7 +71,7 @@ func TestTxState(t *testing.T) {
 
 	t.Run("start-not-processing", func(t *testing.T) {
 		stub.MockTransactionStart("start-not-processing")
-		raw, err := txState(stub, txID, true)
+		raw, err := txState(stub, txID, "", true)
 		stub.MockTransactionEnd("start-not-processing")
 		is.NoError(err)
 		is.NotNil(raw)
@@ -78,3 +81,263 @@ func TestTxState(t *testing.T) {
 		is.Equal(model.TxStatePROCESSING, tx.State)
 	})
 }
+
+func TestTxPauseResume(t *testing.T) {
+	is := assert.New(t)
+	stub := buildEmptyMockStub()
+
+	txID := "uuid-1"
+	input := model.TxPauseInput{
+		TxID:       txID,
+		ReasonCode: "TOKEN_MINT_PENDING",
+		Checkpoint: map[string]string{
+			"ethTxHash": "0xHash",
+		},
+	}
+
+	t.Run("pause-non-existing", func(t *testing.T) {
+		stub.MockTransactionStart("pause-non-existing")
+		raw, err := pauseTx(stub, input)
+		stub.MockTransactionEnd("pause-non-existing")
+		is.Nil(raw)
+		is.Equal("transaction not found", err.Error())
+	})
+
+	stub.MockTransactionStart("start")
+	txState(stub, txID, "", true)
+	stub.MockTransactionEnd("start")
+
+	t.Run("resume-processing", func(t *testing.T) {
+		stub.MockTransactionStart("resume-processing")
+		raw, err := resumeTx(stub, model.TxResumeInput{TxID: txID})
+		stub.MockTransactionEnd("resume-processing")
+		is.Nil(raw)
+		is.Equal("transaction is not at paused state, found at PROCESSING", err.Error())
+	})
+
+	t.Run("pause-processing", func(t *testing.T) {
+		stub.MockTransactionStart("pause-processing")
+		raw, err := pauseTx(stub, input)
+		stub.MockTransactionEnd("pause-processing")
+		is.NoError(err)
+		var tx model.Transaction
+		err = json.Unmarshal(raw, &tx)
+		is.NoError(err)
+		is.Equal(model.TxStatePAUSED, tx.State)
+		is.Equal(1, tx.PauseCount)
+		is.Equal(input.ReasonCode, tx.Pause.ReasonCode)
+		is.Equal(input.Checkpoint, tx.Pause.Checkpoint)
+		is.NotZero(tx.Pause.PausedAt)
+	})
+
+	t.Run("pause-paused", func(t *testing.T) {
+		stub.MockTransactionStart("pause-paused")
+		raw, err := pauseTx(stub, input)
+		stub.MockTransactionEnd("pause-paused")
+		is.Nil(raw)
+		is.Equal("transaction is not at processing state, found at PAUSED", err.Error())
+	})
+
+	t.Run("start-paused", func(t *testing.T) {
+		stub.MockTransactionStart("start-paused")
+		raw, err := txState(stub, txID, "", true)
+		stub.MockTransactionEnd("start-paused")
+		is.Nil(raw)
+		is.Error(err)
+	})
+
+	t.Run("resume-paused", func(t *testing.T) {
+		stub.MockTransactionStart("resume-paused")
+		raw, err := resumeTx(stub, model.TxResumeInput{TxID: txID})
+		stub.MockTransactionEnd("resume-paused")
+		is.NoError(err)
+		var tx model.Transaction
+		err = json.Unmarshal(raw, &tx)
+		is.NoError(err)
+		is.Equal(model.TxStatePROCESSING, tx.State)
+		is.Len(tx.ResumedAt, 1)
+		is.Nil(tx.Resume)
+		// checkpoint is kept for the resuming worker
+		is.Equal(input.Checkpoint, tx.Pause.Checkpoint)
+	})
+
+	t.Run("pause-again", func(t *testing.T) {
+		stub.MockTransactionStart("pause-again")
+		_, err := pauseTx(stub, input)
+		is.NoError(err)
+		_, err = resumeTx(stub, model.TxResumeInput{
+			TxID:       txID,
+			ReasonCode: "TOKEN_MINTED",
+			Checkpoint: map[string]string{"ethBlock": "42"},
+		})
+		stub.MockTransactionEnd("pause-again")
+		is.NoError(err)
+		var tx model.Transaction
+		err = json.Unmarshal(stub.State[txID], &tx)
+		is.NoError(err)
+		is.Equal(2, tx.PauseCount)
+		is.Len(tx.ResumedAt, 2)
+		is.Equal("TOKEN_MINTED", tx.Resume.ReasonCode)
+		is.Equal(map[string]string{"ethBlock": "42"}, tx.Resume.Checkpoint)
+		is.Equal(tx.ResumedAt[1], tx.Resume.ResumedAt)
+	})
+}
+
+func TestTxAbort(t *testing.T) {
+	is := assert.New(t)
+	stub := buildEmptyMockStub()
+	stub.Creator = testCreator(t, "Org1MSP", "owner")
+
+	txID := "uuid-1"
+	t.Run("abort-non-existing", func(t *testing.T) {
+		stub.MockTransactionStart("abort-non-existing")
+		raw, err := abortTx(stub, txID)
+		stub.MockTransactionEnd("abort-non-existing")
+		is.Nil(raw)
+		is.Equal("transaction not found", err.Error())
+	})
+
+	stub.MockTransactionStart("start")
+	txState(stub, txID, "", true)
+	putLockState(stub, txID, "EmissionsCC", "uuid-1")
+	putLockState(stub, txID, "EmissionsCC", "uuid-2")
+	putLockState(stub, "uuid-2", "EmissionsCC", "uuid-3")
+	stub.MockTransactionEnd("start")
+
+	t.Run("abort-processing", func(t *testing.T) {
+		stub.MockTransactionStart("abort-processing")
+		raw, err := abortTx(stub, txID)
+		stub.MockTransactionEnd("abort-processing")
+		is.NoError(err)
+		var tx model.Transaction
+		err = json.Unmarshal(raw, &tx)
+		is.NoError(err)
+		is.Equal(model.TxStateABORTED, tx.State)
+		is.NotZero(tx.AbortedAt)
+
+		locks, _ := getAllLockState(stub, txID)
+		is.Empty(locks)
+		holder, _ := getLockStateTxID(stub, "EmissionsCC", "uuid-3")
+		is.Equal("uuid-2", holder)
+	})
+
+	t.Run("abort-aborted", func(t *testing.T) {
+		stub.MockTransactionStart("abort-aborted")
+		raw, err := abortTx(stub, txID)
+		stub.MockTransactionEnd("abort-aborted")
+		is.Nil(raw)
+		is.Equal("transaction is already at ABORTED state", err.Error())
+	})
+
+	t.Run("start-aborted", func(t *testing.T) {
+		stub.MockTransactionStart("start-aborted")
+		raw, err := txState(stub, txID, "", true)
+		stub.MockTransactionEnd("start-aborted")
+		is.Nil(raw)
+		is.Equal("transaction is already at ABORTED state", err.Error())
+	})
+}
+
+func TestTxDigest(t *testing.T) {
+	is := assert.New(t)
+	stub := shimtest.NewMockStub("dataLockCC", &DataLockChaincode{})
+
+	txID := "uuid-1"
+	const mockID = "mockID"
+	getDigest := func() model.TxDigest {
+		resp := stub.MockInvoke(mockID, stringArgsToByte([]string{"getTxDigest", txID}))
+		is.Equal(shim.OK, int(resp.Status))
+		var out model.TxDigest
+		is.NoError(json.Unmarshal(resp.Payload, &out))
+		return out
+	}
+
+	resp := stub.MockInvoke(mockID, stringArgsToByte([]string{"getTxDigest", txID}))
+	is.Equal(int32(errors.CodeNotFound), resp.Status)
+
+	stub.MockInvoke(mockID, stringArgsToByte([]string{"startTransitionProcess", txID}))
+	first := getDigest()
+	is.Equal(uint64(1), first.Revision)
+	is.True(first.Valid)
+	is.Len(first.Digest, 64)
+
+	stub.MockInvoke(mockID, stringArgsToByte([]string{"endTransitionProcess", txID}))
+	second := getDigest()
+	is.Equal(uint64(2), second.Revision)
+	is.True(second.Valid)
+	is.NotEqual(first.Digest, second.Digest)
+
+	t.Run("Canonical", func(t *testing.T) {
+		// stored record has sorted keys and no empty fields
+		raw := stub.State[txID]
+		var tx model.Transaction
+		is.NoError(json.Unmarshal(raw, &tx))
+		want, err := canonical.Marshal(tx)
+		is.NoError(err)
+		is.Equal(string(want), string(raw))
+		is.NotContains(string(raw), "stage_data")
+	})
+
+	t.Run("TamperedStage", func(t *testing.T) {
+		stub.MockInvoke(mockID, stringArgsToByte([]string{"startTransitionProcess", "uuid-2"}))
+		input, _ := json.Marshal(model.StageUpdateInput{TxID: "uuid-2", Name: "first", Storage: map[string]string{"k": "1"}})
+		resp := stub.MockInvoke(mockID, stringArgsToByte([]string{"stageUpdate", string(input)}))
+		is.Equal(shim.OK, int(resp.Status), resp.Message)
+		txID = "uuid-2"
+		is.True(getDigest().Valid)
+
+		key := stageDataKey(txID, "first")
+		stored := stub.State[key]
+		stub.State[key] = []byte(`{"output":{},"storage":{"k":"2"}}`)
+		is.False(getDigest().Valid)
+		stub.State[key] = stored
+		is.True(getDigest().Valid)
+
+		// extra and missing stage records
+		extra := stageDataKey(txID, "second")
+		stub.MockTransactionStart("tamper")
+		stub.PutState(extra, stored)
+		stub.MockTransactionEnd("tamper")
+		is.False(getDigest().Valid)
+		stub.MockTransactionStart("tamper")
+		stub.DelState(extra)
+		stub.DelState(key)
+		stub.MockTransactionEnd("tamper")
+		is.False(getDigest().Valid)
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		var tx model.Transaction
+		is.NoError(json.Unmarshal(stub.State[txID], &tx))
+		tx.CurrentStage = "tampered"
+		stub.State[txID], _ = canonical.Marshal(tx)
+		is.False(getDigest().Valid)
+	})
+}
+
+// TestAbortOwner : only the owner of a transition,
+// or an admin, may abort it
+func TestAbortOwner(t *testing.T) {
+	is := assert.New(t)
+	stub := shimtest.NewMockStub(dataLockCCName, New(WithConfigSource(adminConfig)))
+	owner := testCreator(t, "Org2MSP", "owner")
+	invoke := func(creator []byte, args ...string) pb.Response {
+		stub.Creator = creator
+		return stub.MockInvoke("mockID", stringArgsToByte(args))
+	}
+	for _, txID := range []string{"txID-1", "txID-2"} {
+		resp := invoke(owner, "startTransitionProcess", txID)
+		is.Equal(int32(shim.OK), resp.Status, resp.Message)
+	}
+
+	resp := invoke(testCreator(t, "Org2MSP", "other"), "abortTransitionProcess", "txID-1")
+	is.Equal(int32(409), resp.Status)
+	is.Contains(resp.Message, "caller is not owner of the transition")
+	resp = invoke(nil, "abortTransitionProcess", "txID-1")
+	is.Equal(int32(409), resp.Status)
+
+	resp = invoke(owner, "abortTransitionProcess", "txID-1")
+	is.Equal(int32(shim.OK), resp.Status, resp.Message)
+	resp = invoke(testCreator(t, "Org1MSP", "admin"), "abortTransitionProcess", "txID-2")
+	is.Equal(int32(shim.OK), resp.Status, resp.Message)
+}
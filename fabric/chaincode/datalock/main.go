@@ -1,23 +1,36 @@
 package main
 
 import (
+	"context"
 	"datalock/internal"
 	"datalock/pkg/logger"
+	"datalock/pkg/metrics"
+	"datalock/pkg/server"
+	"flag"
 	"os"
-
-	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
-	level := os.Getenv("DATALOCK_LOG_LEVEL")
-	if level == "" {
-		level = "INFO"
+	log := logger.New(logger.ConfigFromEnv())
+	cfg := server.ConfigFromEnv()
+	cfg.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	cfg.Log = log
+	opts := []internal.Option{internal.WithLogger(log)}
+	if cfg.OperationsAddress != "" {
+		cfg.Metrics = metrics.NewRegistry()
+		opts = append(opts, internal.WithMetrics(cfg.Metrics))
 	}
-	logger.NewAppLogger(level)
-	cc := new(internal.DataLockChaincode)
 
-	logger.Infof("Starting chaincode server")
-	if err := shim.Start(cc); err != nil {
-		panic(err)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := server.Run(ctx, internal.New(opts...), cfg); err != nil {
+		log.Errorf("chaincode stopped : %v", err)
+		stop()
+		os.Exit(1)
 	}
 }
@@ -58,7 +58,9 @@ func getValidEmissions(stub shim.ChaincodeStubInterface, args []string) peer.Res
 			return shim.Error(fmt.Sprintf("emissions record with uuid = %s not found", uuid))
 		}
 		var emissions Emissions
-		json.Unmarshal(raw, &emissions)
+		if err := json.Unmarshal(raw, &emissions); err != nil {
+			return shim.Error(fmt.Sprintf("invalid emissions record with uuid = %s : %v", uuid, err))
+		}
 		if len(emissions.TokenId) != 0 {
 			// token already minted
 			continue
@@ -103,7 +105,9 @@ func UpdateEmissionsWithToken(stub shim.ChaincodeStubInterface, args []string) p
 			return shim.Error(fmt.Sprintf("%s emissions not found", uuid))
 		}
 		var emissions Emissions
-		json.Unmarshal(raw, &emissions)
+		if err := json.Unmarshal(raw, &emissions); err != nil {
+			return shim.Error(fmt.Sprintf("invalid emissions record with uuid = %s : %v", uuid, err))
+		}
 		emissions.PartyId = partyid
 		emissions.TokenId = tokenId
 		raw, _ = json.Marshal(emissions)
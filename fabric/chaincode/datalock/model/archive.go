@@ -0,0 +1,59 @@
+package model
+
+import "encoding/json"
+
+const (
+	// DefaultArchiveLimit : of keys scanned by one archiveFinished call
+	DefaultArchiveLimit = 100
+)
+
+type ArchiveInput struct {
+	// Retention : seconds a transaction is kept
+	// once finished or aborted
+	Retention int64 `json:"retention"`
+	// Bookmark : key the scan resumes from, txID when
+	// datalock is mounted, empty for the first page
+	Bookmark string `json:"bookmark"`
+	// Limit : of keys scanned, DefaultArchiveLimit if 0
+	Limit int `json:"limit"`
+}
+
+type ArchiveOutput struct {
+	// Archived : txIDs deleted from world state
+	Archived []string `json:"archived"`
+	// Kept : txIDs past retention, kept as they still
+	// hold locks or have child transitions left
+	Kept []string `json:"kept"`
+	// Bookmark : of the next page, empty once
+	// every key has been scanned
+	Bookmark string `json:"bookmark"`
+}
+
+// TxArchive : summary kept on-chain for an archived transaction
+type TxArchive struct {
+	TxID     string  `json:"tx_id"`
+	State    TxState `json:"state"`
+	Workflow string  `json:"workflow,omitempty"`
+	Parent   string  `json:"parent,omitempty"`
+	// EndedAt : unix time (seconds) the transaction
+	// finished or aborted, 0 if not recorded
+	EndedAt int64 `json:"ended_at,omitempty"`
+	// Stages : names of the stages holding data
+	Stages []string `json:"stages"`
+	// Revision and Digest : of the transaction header
+	Revision uint64 `json:"revision"`
+	Digest   string `json:"digest"`
+	// PayloadHash : hex encoded sha256 of the archived payload,
+	// canonical encoding of the transaction with data of every
+	// stage, as returned by getTxDetails
+	PayloadHash string `json:"payload_hash"`
+	// ArchivedAt : unix time (seconds)
+	ArchivedAt int64 `json:"archived_at"`
+}
+
+// ArchivedTx : entry of the archive event, for off-chain storage
+type ArchivedTx struct {
+	Archive TxArchive `json:"archive"`
+	// Payload : hashed into Archive.PayloadHash
+	Payload json.RawMessage `json:"payload"`
+}
@@ -0,0 +1,28 @@
+package model
+
+// Config : configuration of datalock, stored on-chain. Set once by
+// Init or bootstrapConfig, then changed by admins with setConfig
+type Config struct {
+	// Version : of the configuration, incremented on every
+	// change. setConfig must carry the current version
+	Version uint64 `json:"version"`
+	// Admins : MSP IDs of clients allowed to call admin methods
+	Admins []string `json:"admins"`
+	// DefaultLease : seconds a released key stays reserved for
+	// the eligible waiter, when joinQueue doesn't set a grace
+	DefaultLease int64 `json:"default_lease"`
+	// MaxKeys : per data chaincode input of a stage update
+	MaxKeys int `json:"max_keys"`
+	// MaxStages : stage updates of a transition, 0 for no limit
+	MaxStages int `json:"max_stages"`
+	// DisableEvents : true, if no chaincode event is set
+	DisableEvents bool `json:"disable_events"`
+	// Chaincodes : data chaincodes stages and finish
+	// policies may call, any if empty
+	Chaincodes []string `json:"chaincodes"`
+
+	// UpdatedAt : unix time (seconds) and UpdatedBy :
+	// client, of the change, set by datalock
+	UpdatedAt int64     `json:"updated_at,omitempty"`
+	UpdatedBy *Identity `json:"updated_by,omitempty"`
+}
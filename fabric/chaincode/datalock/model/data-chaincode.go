@@ -21,4 +21,44 @@ type DataChaincodeInput struct {
 	// Params : method and chaincode specific
 	// list of argument.
 	Params []string `json:"params"`
+
+	// Fencing : if true, json encoded fencing number of each
+	// key (key => number) is appended to Params. On lock, the
+	// numbers the keys are about to be locked with, on unlock,
+	// the numbers of the current locks
+	Fencing bool `json:"fencing,omitempty"`
+}
+
+// CheckLocksInput : keys a data chaincode is about to write
+type CheckLocksInput struct {
+	// Chaincode : name of the data chaincode
+	Chaincode string `json:"chaincode"`
+	// Keys : on data chaincode
+	Keys []string `json:"keys"`
+	// TxID : optional, transition the caller acts for,
+	// the caller must be its owner
+	TxID string `json:"tx_id,omitempty"`
+}
+
+// KeyLock : holder of a key, TxID is empty
+// if the key is not locked
+type KeyLock struct {
+	Key  string `json:"key"`
+	TxID string `json:"tx_id,omitempty"`
+	// Fence : fencing number of the latest lock of the key
+	Fence uint64 `json:"fence,omitempty"`
+	// ReservedFor : waiter the free key is reserved for,
+	// during its grace period (see joinQueue)
+	ReservedFor string `json:"reserved_for,omitempty"`
+}
+
+type CheckLocksOutput struct {
+	// Locks : holder of each key, in input order
+	Locks []KeyLock `json:"locks"`
+	// Allowed : true, if every key is free, and not reserved
+	// for another waiter, or held by TxID of the input, while
+	// it is processing and the caller is its owner
+	Allowed bool `json:"allowed"`
+	// Reason : why writing is not allowed
+	Reason string `json:"reason,omitempty"`
 }
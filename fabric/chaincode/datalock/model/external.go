@@ -0,0 +1,43 @@
+package model
+
+// ExternalCommitment : made by a stage before executing
+// a step on an external ledger (eg minting token on ethereum)
+type ExternalCommitment struct {
+	// ChainID : of the external ledger
+	ChainID string `json:"chain_id"`
+	// PayloadHash : hex encoded hash of the payload
+	// to be submitted to external ledger
+	PayloadHash string `json:"payload_hash"`
+}
+
+// ExternalReceipt : recorded by a stage after a step
+// has been executed on an external ledger
+type ExternalReceipt struct {
+	// ChainID : of the external ledger
+	ChainID string `json:"chain_id"`
+	// TxHash : hash of the transaction on external ledger
+	TxHash string `json:"tx_hash"`
+	// BlockNumber : at which TxHash was included
+	BlockNumber uint64 `json:"block_number"`
+	// PayloadHash : hex encoded hash of the payload
+	// submitted to external ledger
+	PayloadHash string `json:"payload_hash"`
+	// CommitmentStage : name of earlier stage holding the
+	// commitment fulfilled by this receipt
+	CommitmentStage string `json:"commitment_stage"`
+}
+
+// ExternalVerification : result of verifying a commitment
+// against the receipt recorded for it
+type ExternalVerification struct {
+	TxID            string              `json:"tx_id"`
+	CommitmentStage string              `json:"commitment_stage"`
+	ReceiptStage    string              `json:"receipt_stage,omitempty"`
+	Commitment      *ExternalCommitment `json:"commitment,omitempty"`
+	Receipt         *ExternalReceipt    `json:"receipt,omitempty"`
+	// Valid : true, if receipt matches the commitment
+	// and the observed receipt (if provided)
+	Valid bool `json:"valid"`
+	// Reason : why verification failed
+	Reason string `json:"reason,omitempty"`
+}
@@ -0,0 +1,24 @@
+package model
+
+// FinishPolicy : of a workflow, what finishing a transition
+// (stage update with is_last) does with the locks it still holds.
+// Transitions of a workflow without policy finish keeping them
+type FinishPolicy struct {
+	Workflow string       `json:"workflow"`
+	OnLocks  FinishAction `json:"on_locks"`
+	// Free : key (ccName), value (method and leading arguments)
+	// of the free function of each data chaincode, called
+	// with the remaining keys appended on release
+	Free map[string][]string `json:"free,omitempty"`
+}
+
+type FinishAction string
+
+const (
+	// FinishReject : finishing fails, listing the remaining locks
+	FinishReject FinishAction = "reject"
+	// FinishRelease : remaining locks are freed, calling the
+	// free function of their data chaincode, whose output is
+	// recorded as output of the last stage
+	FinishRelease FinishAction = "release"
+)
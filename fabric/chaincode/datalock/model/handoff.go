@@ -0,0 +1,41 @@
+package model
+
+// Identity : of a client, taken from the
+// certificate of the proposal creator
+type Identity struct {
+	MSPID string `json:"msp_id"`
+	ID    string `json:"id"`
+}
+
+// HandoffInput : input of approveHandoff and handoffLocks
+type HandoffInput struct {
+	// From : transition holding the locks
+	From string `json:"from"`
+	// To : transition taking the locks over
+	To string `json:"to"`
+	// Locks : key (ccName), value (keys) moved
+	Locks map[string][]string `json:"locks"`
+}
+
+// HandoffApproval : locks the owner of From allows To to take over
+type HandoffApproval struct {
+	HandoffInput
+	ApprovedBy Identity `json:"approved_by"`
+}
+
+// Handoff : locks moved between transitions, logged
+// on both transaction records
+type Handoff struct {
+	From    string   `json:"from"`
+	To      string   `json:"to"`
+	LockIDs []string `json:"lock_ids"`
+	// At : unix time (seconds) of the handoff
+	At int64 `json:"at"`
+}
+
+type HandoffOutput struct {
+	LockIDs []string `json:"lock_ids"`
+	// Fences : key (ccName), value (key => fencing number)
+	// of the locks under their new holder
+	Fences map[string]map[string]uint64 `json:"fences"`
+}
@@ -0,0 +1,53 @@
+package model
+
+// QueueInput : input of joinQueue and leaveQueue
+type QueueInput struct {
+	// TxID : ID of the waiting transition
+	TxID string `json:"tx_id"`
+	// Chaincode : data chaincode of the keys
+	Chaincode string   `json:"chaincode"`
+	Keys      []string `json:"keys"`
+	// Grace : seconds the transition may take a released
+	// key for, before others can, DefaultQueueGrace if zero
+	Grace int64 `json:"grace,omitempty"`
+}
+
+const (
+	// DefaultQueueGrace : seconds a released key is
+	// reserved for the next waiter
+	DefaultQueueGrace = 300
+	// MaxQueueGrace : upper bound of QueueInput.Grace
+	MaxQueueGrace = 24 * 60 * 60
+)
+
+// QueueOutput : position of the transition in queue of each key,
+// 1 for the next waiter. 0 if the key can be locked right away
+type QueueOutput struct {
+	Positions map[string]int `json:"positions"`
+}
+
+// KeyQueue : transitions waiting for a locked key, in the order
+// they joined. When the key is released the first waiter becomes
+// eligible, and only it may lock the key until EligibleUntil
+type KeyQueue struct {
+	Waiters []QueueWaiter `json:"waiters"`
+	// Eligible : waiter the released key is reserved for
+	Eligible string `json:"eligible,omitempty"`
+	// EligibleUntil : unix time (seconds) the reservation ends
+	EligibleUntil int64 `json:"eligible_until,omitempty"`
+}
+
+type QueueWaiter struct {
+	TxID  string `json:"tx_id"`
+	Grace int64  `json:"grace"`
+}
+
+// EligibleWaiter : payload of the eligible event
+// telling a waiter it can lock the key
+type EligibleWaiter struct {
+	TxID      string `json:"tx_id"`
+	Chaincode string `json:"chaincode"`
+	Key       string `json:"key"`
+	// Until : unix time (seconds) the key is reserved until
+	Until int64 `json:"until"`
+}
@@ -2,10 +2,67 @@ package model
 
 // Transaction : a multi blockchain tx
 type Transaction struct {
-	TxID         string                  `json:"tx_id"`
-	State        TxState                 `json:"state"`
-	CurrentStage string                  `json:"current_stage"`
-	StageData    map[string]*TxStageData `json:"stage_data"`
+	TxID         string  `json:"tx_id"`
+	State        TxState `json:"state"`
+	CurrentStage string  `json:"current_stage"`
+	// StageUpdates : number of stage updates of the transition
+	StageUpdates int `json:"stage_updates,omitempty"`
+	// Workflow : the transition is part of, selects
+	// the finish policy. Empty if none
+	Workflow string `json:"workflow,omitempty"`
+	// Parent : txID of the transition this one is a child of,
+	// set on creation. Empty for a top-level transition
+	Parent string `json:"parent,omitempty"`
+	// Owner : client which created the transition,
+	// its permission is needed to hand locks off
+	Owner *Identity `json:"owner,omitempty"`
+	// StageData : data of each stage, stored under own key
+	// of the stage (txID~stage) and only assembled by
+	// getTxDetails. Records of the old layout keep it inline
+	StageData map[string]*TxStageData `json:"stage_data,omitempty"`
+	// StageDigests : hex encoded sha256 of each stored
+	// stage record, by stage name
+	StageDigests map[string]string `json:"stage_digests,omitempty"`
+
+	// PauseCount : number of times the transition
+	// has been paused
+	PauseCount int `json:"pause_count"`
+	// Pause : reason and checkpoint of the latest pause
+	Pause *TxPause `json:"pause,omitempty"`
+	// ResumedAt : unix time (seconds) of each resume
+	ResumedAt []int64 `json:"resumed_at,omitempty"`
+	// Resume : reason and checkpoint of the latest resume,
+	// nil if it was resumed without any
+	Resume *TxResume `json:"resume,omitempty"`
+	// AbortedAt : unix time (seconds) of the abort
+	AbortedAt int64 `json:"aborted_at,omitempty"`
+	// FinishedAt : unix time (seconds) of the last stage update
+	FinishedAt int64 `json:"finished_at,omitempty"`
+	// ReleasedOnFinish : lockIDs (chaincode::key) still held
+	// when the transition finished, released by the
+	// finish policy of its workflow
+	ReleasedOnFinish []string `json:"released_on_finish,omitempty"`
+	// Handoffs : locks handed to or taken over
+	// from other transitions
+	Handoffs []Handoff `json:"handoffs,omitempty"`
+
+	// Revision : incremented on every write of the transaction
+	Revision uint64 `json:"revision"`
+	// Digest : hex encoded sha256 of canonical encoding
+	// of the stored transaction header, with Digest itself
+	// left empty. Stage records of the new layout are
+	// covered through StageDigests
+	Digest string `json:"digest"`
+}
+
+// TxDigest : revision and content hash of a transaction
+type TxDigest struct {
+	TxID     string `json:"tx_id"`
+	Revision uint64 `json:"revision"`
+	Digest   string `json:"digest"`
+	// Valid : true, if stored digest matches the stored
+	// transaction, and its stage records match StageDigests
+	Valid bool `json:"valid"`
 }
 
 type TxState string
@@ -14,6 +71,10 @@ const (
 	TxStateFINISHED      TxState = "FINISHED"
 	TxStatePROCESSING    TxState = "PROCESSING"
 	TxStateNOTPROCESSING TxState = "NOT-PROCESSING"
+	TxStatePAUSED        TxState = "PAUSED"
+	// TxStateABORTED : terminal, locks of the transaction
+	// are released without calling data chaincodes
+	TxStateABORTED TxState = "ABORTED"
 )
 
 type TxStageData struct {
@@ -24,4 +85,48 @@ type TxStageData struct {
 	// Output : genereate from data chaincode
 	// which are required for further stages
 	Output map[string]map[string]string `json:"output"`
+
+	// Collection : name of the private data collection
+	// holding storage and output of the stage, if set
+	// Storage and Output only keep hash of the values
+	Collection string `json:"collection,omitempty"`
+	// Salt : hex encoded salt of the hashes on the public copy,
+	// only kept in the private data collection
+	Salt string `json:"salt,omitempty"`
+
+	// Commitment : to a step executed on external ledger
+	Commitment *ExternalCommitment `json:"commitment,omitempty"`
+	// Receipt : of a step executed on external ledger
+	Receipt *ExternalReceipt `json:"receipt,omitempty"`
+}
+
+// TxMigrationOutput : transactions moved
+// to the per-stage layout
+type TxMigrationOutput struct {
+	Migrated []string `json:"migrated"`
+}
+
+// TxPause : records why and where a transition
+// was paused, so that the off-chain worker resuming
+// the flow knows where to continue
+type TxPause struct {
+	// ReasonCode : application defined code of the pause
+	ReasonCode string `json:"reason_code"`
+	// Checkpoint : application defined data, eg ethereum
+	// tx hash of a token mint that is still pending
+	Checkpoint map[string]string `json:"checkpoint"`
+	// PausedAt : unix time (seconds) of the pause
+	PausedAt int64 `json:"paused_at"`
+}
+
+// TxResume : records why a transition was resumed,
+// and the checkpoint the worker resumed it from
+type TxResume struct {
+	// ReasonCode : application defined code of the resume
+	ReasonCode string `json:"reason_code"`
+	// Checkpoint : application defined data, eg ethereum
+	// block number the token mint was confirmed at
+	Checkpoint map[string]string `json:"checkpoint"`
+	// ResumedAt : unix time (seconds) of the resume
+	ResumedAt int64 `json:"resumed_at"`
 }
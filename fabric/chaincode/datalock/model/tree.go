@@ -0,0 +1,10 @@
+package model
+
+// TxTree : a transition and its child transitions
+type TxTree struct {
+	TxID  string  `json:"tx_id"`
+	State TxState `json:"state"`
+	// Parent : txID of the parent, empty if top-level
+	Parent   string   `json:"parent,omitempty"`
+	Children []TxTree `json:"children"`
+}
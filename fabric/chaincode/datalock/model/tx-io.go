@@ -16,6 +16,18 @@ type StageUpdateInput struct {
 	// Storage : data to be stored for tx
 	// to use in further stages
 	Storage map[string]string `json:"storage"`
+	// Collection : name of private data collection, if set
+	// storage is read from the transient map and storage
+	// and outputs are written to the collection, keeping
+	// only their hash on the transaction
+	Collection string `json:"collection"`
+
+	// Commitment : commit to a step which will be executed
+	// on external ledger in further stages
+	Commitment *ExternalCommitment `json:"commitment,omitempty"`
+	// Receipt : of a step executed on external ledger,
+	// must match commitment of an earlier stage
+	Receipt *ExternalReceipt `json:"receipt,omitempty"`
 }
 
 type StageUpdateOutput struct {
@@ -26,4 +38,63 @@ type StageUpdateOutput struct {
 	// DataFree : key (ccName),value(key => base64) returned by
 	// data chancode after calling before unlocking data
 	DataFree map[string]string `json:"data_free"`
+
+	// Fences : key (ccName), value (key => fencing number)
+	// assigned to keys locked by the stage. Numbers of a key
+	// only grow, so data chaincodes can reject an operation
+	// carrying the number of an earlier lock
+	Fences map[string]map[string]uint64 `json:"fences,omitempty"`
+
+	// Released : lockIDs (chaincode::key) released by the finish
+	// policy of the workflow, output of their free function
+	// is in DataFree
+	Released []string `json:"released,omitempty"`
+}
+
+// StageSimulation : what submitting a stage update would do,
+// returned by simulateStageUpdate which writes nothing
+type StageSimulation struct {
+	// Allowed : true, if the stage update would succeed
+	Allowed bool `json:"allowed"`
+	// Error : client error identifier (e.g. LOCKED) and
+	// Reason : message, of the error it would fail with
+	Error  string `json:"error,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// Locks : key (ccName), value keys the stage would lock,
+	// empty if the update would fail
+	Locks map[string][]string `json:"locks"`
+	// Conflicts : keys of the input already locked,
+	// or reserved for another transition
+	Conflicts []LockConflict `json:"conflicts"`
+	// Output : the stage update would return, nil if it would fail
+	Output *StageUpdateOutput `json:"output,omitempty"`
+}
+
+// LockConflict : key of a data chaincode held by another transition
+type LockConflict struct {
+	Chaincode string `json:"chaincode"`
+	Key       string `json:"key"`
+	// TxID : holding the lock, or the waiter
+	// the free key is reserved for
+	TxID     string `json:"tx_id"`
+	Reserved bool   `json:"reserved,omitempty"`
+}
+
+type TxPauseInput struct {
+	// TxID : ID of transition
+	TxID string `json:"tx_id"`
+	// ReasonCode : why the transition is paused
+	ReasonCode string `json:"reason_code"`
+	// Checkpoint : data required to resume the transition
+	Checkpoint map[string]string `json:"checkpoint"`
+}
+
+type TxResumeInput struct {
+	// TxID : ID of transition
+	TxID string `json:"tx_id"`
+	// ReasonCode : why the transition is resumed, empty
+	// if resumed without reason
+	ReasonCode string `json:"reason_code"`
+	// Checkpoint : data the transition is resumed from
+	Checkpoint map[string]string `json:"checkpoint"`
 }
@@ -0,0 +1,208 @@
+// Package canonical : deterministic json encoding of records
+// stored into worldstate, such that every peer (and any
+// off-chain archive) encoding the same record gets the
+// same bytes and hash
+package canonical
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Marshal : json encodes v with object keys sorted and
+// empty struct fields (null, "", false, 0, {} and [])
+// removed. Entries of maps are kept, empty or not
+func Marshal(v interface{}) (out []byte, err error) {
+	defer func() {
+		// reflect panics on values json can't encode either
+		if r := recover(); r != nil {
+			out, err = nil, fmt.Errorf("failed to encode : %v", r)
+		}
+	}()
+	doc, _, err := normalize(reflect.ValueOf(v))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode : %w", err)
+	}
+	if doc == nil {
+		return []byte("{}"), nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	// encoding/json writes map keys in sorted order
+	err = enc.Encode(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode : %w", err)
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Hash : hex encoded sha256 of canonical encoding of v
+func Hash(v interface{}) (string, error) {
+	raw, err := Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+var (
+	marshalerType     = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// normalize : value encoding to the same json as v, with empty
+// struct fields removed. Returned bool is true if v is empty
+func normalize(v reflect.Value) (interface{}, bool, error) {
+	if !v.IsValid() {
+		return nil, true, nil
+	}
+	if v.Type().Implements(marshalerType) || v.Type().Implements(textMarshalerType) {
+		if v.Kind() == reflect.Pointer && v.IsNil() {
+			return nil, true, nil
+		}
+		return opaque(v.Interface())
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil, true, nil
+		}
+		return normalize(v.Elem())
+	case reflect.Struct:
+		obj := map[string]interface{}{}
+		err := structFields(v, obj)
+		return obj, len(obj) == 0, err
+	case reflect.Map:
+		if v.IsNil() {
+			return nil, true, nil
+		}
+		obj := make(map[string]interface{}, v.Len())
+		itr := v.MapRange()
+		for itr.Next() {
+			key, err := mapKey(itr.Key())
+			if err != nil {
+				return nil, false, err
+			}
+			obj[key], _, err = normalize(itr.Value())
+			if err != nil {
+				return nil, false, err
+			}
+		}
+		return obj, len(obj) == 0, nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil, true, nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 && v.Kind() == reflect.Slice {
+			// base64 string, as encoding/json
+			return opaque(v.Interface())
+		}
+		// position of array elements is meaningful,
+		// so only the elements are pruned
+		arr := make([]interface{}, v.Len())
+		for i := range arr {
+			var err error
+			arr[i], _, err = normalize(v.Index(i))
+			if err != nil {
+				return nil, false, err
+			}
+		}
+		return arr, len(arr) == 0, nil
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return v.Interface(), v.IsZero(), nil
+	default:
+		return nil, false, fmt.Errorf("unsupported type %s", v.Type())
+	}
+}
+
+// structFields : adds the non empty fields of struct v to obj,
+// named and flattened as encoding/json does
+func structFields(v reflect.Value, obj map[string]interface{}) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		fv := v.Field(i)
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				if fv.IsNil() {
+					continue
+				}
+				ft, fv = ft.Elem(), fv.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if err := structFields(fv, obj); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		field, empty, err := normalize(fv)
+		if err != nil {
+			return err
+		}
+		if !empty {
+			obj[name] = field
+		}
+	}
+	return nil
+}
+
+func mapKey(k reflect.Value) (string, error) {
+	if k.Kind() == reflect.String {
+		return k.String(), nil
+	}
+	if tm, ok := k.Interface().(encoding.TextMarshaler); ok {
+		raw, err := tm.MarshalText()
+		return string(raw), err
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	default:
+		return "", fmt.Errorf("unsupported map key type %s", k.Type())
+	}
+}
+
+// opaque : value of types with their own json encoding,
+// decoded as is
+func opaque(v interface{}) (interface{}, bool, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, false, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var doc interface{}
+	err = dec.Decode(&doc)
+	if err != nil {
+		return nil, false, err
+	}
+	return doc, doc == nil || doc == "", nil
+}
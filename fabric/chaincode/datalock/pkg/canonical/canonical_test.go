@@ -0,0 +1,94 @@
+package canonical
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type record struct {
+	ID     string            `json:"id"`
+	Count  int               `json:"count"`
+	Done   bool              `json:"done"`
+	Values map[string]string `json:"values"`
+	List   []string          `json:"list"`
+	Nested *record           `json:"nested"`
+}
+
+func TestMarshal(t *testing.T) {
+	is := assert.New(t)
+
+	t.Run("SortedAndPruned", func(t *testing.T) {
+		raw, err := Marshal(record{
+			ID: "id-1",
+			Values: map[string]string{
+				"z": "1",
+				"a": "2",
+				"m": "",
+			},
+			List:   []string{"b", "", "a"},
+			Nested: &record{},
+		})
+		is.NoError(err)
+		// entries of maps are kept, even empty
+		is.Equal(`{"id":"id-1","list":["b","","a"],"values":{"a":"2","m":"","z":"1"}}`, string(raw))
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		raw, err := Marshal(record{})
+		is.NoError(err)
+		is.Equal("{}", string(raw))
+	})
+
+	t.Run("NoHTMLEscape", func(t *testing.T) {
+		raw, err := Marshal(map[string]string{"k": "a<b>&c"})
+		is.NoError(err)
+		is.Equal(`{"k":"a<b>&c"}`, string(raw))
+	})
+
+	t.Run("MapOfStructs", func(t *testing.T) {
+		raw, err := Marshal(map[string]interface{}{
+			"r": record{ID: "id-2"},
+			"e": record{},
+			"n": 0,
+			"m": map[string]interface{}{"b": false},
+		})
+		is.NoError(err)
+		is.Equal(`{"e":{},"m":{"b":false},"n":0,"r":{"id":"id-2"}}`, string(raw))
+	})
+
+	t.Run("Embedded", func(t *testing.T) {
+		type inner struct {
+			A string `json:"a"`
+		}
+		type outer struct {
+			inner
+			B    string          `json:"b"`
+			Raw  json.RawMessage `json:"raw"`
+			Skip string          `json:"-"`
+		}
+		raw, err := Marshal(outer{inner: inner{A: "x"}, Raw: json.RawMessage(`{"z":1,"y":""}`), Skip: "s"})
+		is.NoError(err)
+		is.Equal(`{"a":"x","raw":{"y":"","z":1}}`, string(raw))
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		_, err := Marshal(map[string]interface{}{"ch": make(chan int)})
+		is.Error(err)
+	})
+}
+
+func TestHash(t *testing.T) {
+	is := assert.New(t)
+	a, err := Hash(map[string]interface{}{"x": 1, "y": map[string]string{"b": "2", "a": "1"}})
+	is.NoError(err)
+	b, err := Hash(map[string]interface{}{"y": map[string]string{"a": "1", "b": "2"}, "x": 1})
+	is.NoError(err)
+	is.Equal(a, b)
+	is.Len(a, 64)
+
+	c, err := Hash(map[string]interface{}{"x": 2})
+	is.NoError(err)
+	is.NotEqual(a, c)
+}
@@ -0,0 +1,221 @@
+// Package endorsement : simulates endorsing peers of several orgs
+// executing chaincode against one world state. Every proposal is
+// simulated by one peer of each org, producing a read-write set,
+// and transactions are validated at commit as fabric does
+// (endorsement agreement, duplicate txID, MVCC and phantom reads)
+package endorsement
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// Proposal : chaincode invocation sent by a client
+type Proposal struct {
+	TxID      string
+	Chaincode string
+	Args      [][]byte
+	Transient map[string][]byte
+	Creator   []byte
+	Timestamp *timestamp.Timestamp
+}
+
+// Endorsement : result of simulating a proposal on one peer
+type Endorsement struct {
+	Org      string
+	Response pb.Response
+	RWSet    *RWSet
+	Event    *pb.ChaincodeEvent
+}
+
+// Transaction : proposal with the endorsements of every org
+type Transaction struct {
+	Proposal     *Proposal
+	Endorsements []*Endorsement
+}
+
+// Response : chaincode response of the first endorsing peer
+func (tx *Transaction) Response() pb.Response {
+	return tx.Endorsements[0].Response
+}
+
+// RWSet : read-write set of the first endorsing peer
+func (tx *Transaction) RWSet() *RWSet {
+	return tx.Endorsements[0].RWSet
+}
+
+// Network : channel with committed world state, deployed
+// chaincodes and one endorsing peer per org
+type Network struct {
+	channel    string
+	orgs       []string
+	chaincodes map[string]shim.Chaincode
+
+	mu       sync.RWMutex
+	state    worldState
+	blockNum uint64
+	txIDs    map[string]bool
+	events   []*pb.ChaincodeEvent
+}
+
+func NewNetwork(channel string, orgs ...string) *Network {
+	if len(orgs) == 0 {
+		orgs = []string{"Org1"}
+	}
+	return &Network{
+		channel:    channel,
+		orgs:       orgs,
+		chaincodes: map[string]shim.Chaincode{},
+		state:      worldState{},
+		txIDs:      map[string]bool{},
+	}
+}
+
+// Deploy : installs cc as name on every peer, must be called
+// before any proposal is endorsed
+func (n *Network) Deploy(name string, cc shim.Chaincode) {
+	n.chaincodes[name] = cc
+}
+
+// Seed : commits key value pairs of namespace ns in a block,
+// without executing any chaincode
+func (n *Network) Seed(ns string, kvs map[string][]byte) {
+	rw := newRWSet()
+	for k, v := range kvs {
+		rw.addWrite(ns, k, v)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.blockNum++
+	n.state.apply(rw, Version{BlockNum: n.blockNum})
+}
+
+// NewProposal : proposal invoking function of cc with args
+func NewProposal(txID, cc string, args ...string) *Proposal {
+	raw := make([][]byte, len(args))
+	for i, arg := range args {
+		raw[i] = []byte(arg)
+	}
+	return &Proposal{
+		TxID:      txID,
+		Chaincode: cc,
+		Args:      raw,
+		Timestamp: &timestamp.Timestamp{Seconds: time.Now().Unix()},
+	}
+}
+
+// Endorse : simulates p on the peer of every org concurrently,
+// against the state committed when the simulation starts
+func (n *Network) Endorse(p *Proposal) *Transaction {
+	tx := &Transaction{Proposal: p, Endorsements: make([]*Endorsement, len(n.orgs))}
+	var wg sync.WaitGroup
+	for i, org := range n.orgs {
+		wg.Add(1)
+		go func(i int, org string) {
+			defer wg.Done()
+			tx.Endorsements[i] = n.simulate(org, p)
+		}(i, org)
+	}
+	wg.Wait()
+	return tx
+}
+
+func (n *Network) simulate(org string, p *Proposal) *Endorsement {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	e := &Endorsement{Org: org, RWSet: newRWSet()}
+	cc, ok := n.chaincodes[p.Chaincode]
+	if !ok {
+		e.Response = shim.Error(fmt.Sprintf("chaincode = %s not deployed", p.Chaincode))
+		return e
+	}
+	sim := &simulation{network: n, state: n.state, proposal: p, rwset: e.RWSet}
+	e.Response = cc.Invoke(&stub{sim: sim, namespace: p.Chaincode, args: p.Args})
+	e.Event = sim.event
+	return e
+}
+
+// Commit : validates txs in order as one block, writes of valid
+// transactions are applied to the world state. Returns the
+// validation code of each transaction
+func (n *Network) Commit(txs ...*Transaction) []pb.TxValidationCode {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.blockNum++
+	codes := make([]pb.TxValidationCode, len(txs))
+	for i, tx := range txs {
+		codes[i] = n.validate(tx)
+		if codes[i] != pb.TxValidationCode_VALID {
+			continue
+		}
+		n.txIDs[tx.Proposal.TxID] = true
+		n.state.apply(tx.RWSet(), Version{BlockNum: n.blockNum, TxNum: uint64(i)})
+		if e := tx.Endorsements[0].Event; e != nil {
+			n.events = append(n.events, e)
+		}
+	}
+	return codes
+}
+
+func (n *Network) validate(tx *Transaction) pb.TxValidationCode {
+	if n.txIDs[tx.Proposal.TxID] {
+		return pb.TxValidationCode_DUPLICATE_TXID
+	}
+	// every org must endorse, peers refuse to endorse error
+	// responses, and all peers must agree on the result
+	first := tx.Endorsements[0]
+	for _, e := range tx.Endorsements {
+		if e.Response.Status >= shim.ERRORTHRESHOLD ||
+			e.Response.Status != first.Response.Status ||
+			!bytes.Equal(e.Response.Payload, first.Response.Payload) ||
+			!e.RWSet.equal(first.RWSet) {
+			return pb.TxValidationCode_ENDORSEMENT_POLICY_FAILURE
+		}
+	}
+	if !n.state.mvccValid(first.RWSet) {
+		return pb.TxValidationCode_MVCC_READ_CONFLICT
+	}
+	if !n.state.phantomValid(first.RWSet) {
+		return pb.TxValidationCode_PHANTOM_READ_CONFLICT
+	}
+	return pb.TxValidationCode_VALID
+}
+
+// Submit : endorses and commits p alone in a block
+func (n *Network) Submit(p *Proposal) (pb.Response, pb.TxValidationCode) {
+	tx := n.Endorse(p)
+	return tx.Response(), n.Commit(tx)[0]
+}
+
+// Evaluate : simulates p on the first peer without committing it
+func (n *Network) Evaluate(p *Proposal) pb.Response {
+	return n.simulate(n.orgs[0], p).Response
+}
+
+// GetState : committed value of key in namespace ns
+func (n *Network) GetState(ns, key string) []byte {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	v, _ := n.state.get(ns, key)
+	return bytes.Clone(v.value)
+}
+
+// Keys : committed keys of namespace ns, in order
+func (n *Network) Keys(ns string) []string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.state.keys(ns, "", "")
+}
+
+// Events : chaincode events of valid transactions, in commit order
+func (n *Network) Events() []*pb.ChaincodeEvent {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return append([]*pb.ChaincodeEvent(nil), n.events...)
+}
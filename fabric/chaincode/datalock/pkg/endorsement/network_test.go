@@ -0,0 +1,225 @@
+package endorsement
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+// counterCC : increments counters, counts keys by range
+// and calls itself through InvokeChaincode
+type counterCC struct {
+	calls *atomic.Int64
+}
+
+func (counterCC) Init(shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (cc counterCC) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	fn, args := stub.GetFunctionAndParameters()
+	switch fn {
+	case "inc":
+		raw, _ := stub.GetState(args[0])
+		n, _ := strconv.Atoi(string(raw))
+		stub.PutState(args[0], []byte(strconv.Itoa(n+1)))
+		// own writes are not visible
+		raw, _ = stub.GetState(args[0])
+		return shim.Success(raw)
+	case "count":
+		iter, err := stub.GetStateByRange(args[0], args[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		defer iter.Close()
+		n := 0
+		for iter.HasNext() {
+			iter.Next()
+			n++
+		}
+		stub.PutState("count", []byte(strconv.Itoa(n)))
+		return shim.Success([]byte(strconv.Itoa(n)))
+	case "event":
+		stub.SetEvent("first", nil)
+		stub.SetEvent(args[0], []byte(stub.GetTxID()))
+		return shim.Success(nil)
+	case "remote":
+		return stub.InvokeChaincode(args[0], [][]byte{[]byte("inc"), []byte(args[1])}, "")
+	case "nondeterministic":
+		stub.PutState("n", []byte(strconv.FormatInt(cc.calls.Add(1), 10)))
+		return shim.Success(nil)
+	case "proposal":
+		// name of the chaincode targeted by the proposal
+		sp, err := stub.GetSignedProposal()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var prop pb.Proposal
+		var payload pb.ChaincodeProposalPayload
+		var cis pb.ChaincodeInvocationSpec
+		proto.Unmarshal(sp.ProposalBytes, &prop)
+		proto.Unmarshal(prop.Payload, &payload)
+		proto.Unmarshal(payload.Input, &cis)
+		return shim.Success([]byte(cis.ChaincodeSpec.ChaincodeId.Name))
+	case "remote-proposal":
+		return stub.InvokeChaincode(args[0], [][]byte{[]byte("proposal")}, "")
+	case "fail":
+		return shim.Error("failed")
+	}
+	return shim.Error("method not supported")
+}
+
+func newTestNetwork() *Network {
+	n := NewNetwork("emissions-data", "Org1", "Org2", "Org3")
+	cc := counterCC{calls: new(atomic.Int64)}
+	n.Deploy("counter", cc)
+	n.Deploy("other", cc)
+	return n
+}
+
+func TestMVCCReadConflict(t *testing.T) {
+	is := assert.New(t)
+	n := newTestNetwork()
+
+	t.Run("SameBlock", func(t *testing.T) {
+		tx1 := n.Endorse(NewProposal("tx-1", "counter", "inc", "a"))
+		tx2 := n.Endorse(NewProposal("tx-2", "counter", "inc", "a"))
+		tx3 := n.Endorse(NewProposal("tx-3", "counter", "inc", "b"))
+		is.Nil(tx1.RWSet().Reads["counter"]["a"])
+		is.Empty(tx1.Response().Payload)
+		is.Equal([]pb.TxValidationCode{
+			pb.TxValidationCode_VALID,
+			pb.TxValidationCode_MVCC_READ_CONFLICT,
+			pb.TxValidationCode_VALID,
+		}, n.Commit(tx1, tx2, tx3))
+		is.Equal("1", string(n.GetState("counter", "a")))
+	})
+
+	t.Run("LaterBlock", func(t *testing.T) {
+		tx1 := n.Endorse(NewProposal("tx-4", "counter", "inc", "a"))
+		tx2 := n.Endorse(NewProposal("tx-5", "counter", "inc", "a"))
+		is.Equal(pb.TxValidationCode_VALID, n.Commit(tx1)[0])
+		is.Equal(pb.TxValidationCode_MVCC_READ_CONFLICT, n.Commit(tx2)[0])
+		is.Equal("2", string(n.GetState("counter", "a")))
+
+		resp, code := n.Submit(NewProposal("tx-6", "counter", "inc", "a"))
+		is.Equal(pb.TxValidationCode_VALID, code)
+		is.Equal("2", string(resp.Payload))
+		is.Equal("3", string(n.GetState("counter", "a")))
+	})
+
+	t.Run("InvokedChaincode", func(t *testing.T) {
+		tx1 := n.Endorse(NewProposal("tx-7", "counter", "remote", "other", "x"))
+		tx2 := n.Endorse(NewProposal("tx-8", "other", "inc", "x"))
+		is.Contains(tx1.RWSet().Writes, "other")
+		is.NotContains(tx1.RWSet().Writes, "counter")
+		is.Equal([]pb.TxValidationCode{
+			pb.TxValidationCode_VALID,
+			pb.TxValidationCode_MVCC_READ_CONFLICT,
+		}, n.Commit(tx1, tx2))
+		is.Equal("1", string(n.GetState("other", "x")))
+		is.Empty(n.GetState("counter", "x"))
+	})
+}
+
+func TestPhantomReadConflict(t *testing.T) {
+	is := assert.New(t)
+	n := newTestNetwork()
+	n.Seed("counter", map[string][]byte{"k1": []byte("1"), "k3": []byte("1")})
+
+	count := n.Endorse(NewProposal("tx-1", "counter", "count", "k0", "k9"))
+	insert := n.Endorse(NewProposal("tx-2", "counter", "inc", "k2"))
+	is.Equal("2", string(count.Response().Payload))
+	is.Equal([]pb.TxValidationCode{
+		pb.TxValidationCode_VALID,
+		pb.TxValidationCode_PHANTOM_READ_CONFLICT,
+	}, n.Commit(insert, count))
+
+	resp, code := n.Submit(NewProposal("tx-3", "counter", "count", "k0", "k9"))
+	is.Equal(pb.TxValidationCode_VALID, code)
+	is.Equal("3", string(resp.Payload))
+}
+
+func TestEndorsementPolicyFailure(t *testing.T) {
+	is := assert.New(t)
+	n := newTestNetwork()
+
+	_, code := n.Submit(NewProposal("tx-1", "counter", "nondeterministic"))
+	is.Equal(pb.TxValidationCode_ENDORSEMENT_POLICY_FAILURE, code)
+	is.Empty(n.GetState("counter", "n"))
+
+	resp, code := n.Submit(NewProposal("tx-2", "counter", "fail"))
+	is.Equal(int32(shim.ERROR), resp.Status)
+	is.Equal(pb.TxValidationCode_ENDORSEMENT_POLICY_FAILURE, code)
+
+	_, code = n.Submit(NewProposal("tx-3", "unknown", "inc", "a"))
+	is.Equal(pb.TxValidationCode_ENDORSEMENT_POLICY_FAILURE, code)
+}
+
+func TestDuplicateTxID(t *testing.T) {
+	is := assert.New(t)
+	n := newTestNetwork()
+
+	tx1 := n.Endorse(NewProposal("tx-1", "counter", "inc", "a"))
+	tx2 := n.Endorse(NewProposal("tx-1", "counter", "inc", "b"))
+	is.Equal([]pb.TxValidationCode{
+		pb.TxValidationCode_VALID,
+		pb.TxValidationCode_DUPLICATE_TXID,
+	}, n.Commit(tx1, tx2))
+	is.Equal([]string{"a"}, n.Keys("counter"))
+}
+
+func TestEvents(t *testing.T) {
+	is := assert.New(t)
+	n := newTestNetwork()
+
+	tx1 := n.Endorse(NewProposal("tx-1", "counter", "event", "done"))
+	tx2 := n.Endorse(NewProposal("tx-1", "counter", "event", "duplicate"))
+	n.Commit(tx1, tx2)
+	events := n.Events()
+	is.Len(events, 1)
+	is.Equal("done", events[0].EventName)
+	is.Equal("tx-1", string(events[0].Payload))
+}
+
+func TestConcurrentEndorsement(t *testing.T) {
+	is := assert.New(t)
+	n := newTestNetwork()
+
+	const clients = 20
+	txs := make([]*Transaction, clients)
+	done := make(chan struct{})
+	for i := 0; i < clients; i++ {
+		go func(i int) {
+			txs[i] = n.Endorse(NewProposal(fmt.Sprintf("tx-%d", i), "counter", "inc", "a"))
+			done <- struct{}{}
+		}(i)
+	}
+	for i := 0; i < clients; i++ {
+		<-done
+	}
+	valid := 0
+	for _, code := range n.Commit(txs...) {
+		if code == pb.TxValidationCode_VALID {
+			valid++
+		}
+	}
+	is.Equal(1, valid)
+	is.Equal("1", string(n.GetState("counter", "a")))
+}
+
+func TestSignedProposal(t *testing.T) {
+	is := assert.New(t)
+	n := newTestNetwork()
+	resp := n.Evaluate(NewProposal("tx-1", "counter", "proposal"))
+	is.Equal("counter", string(resp.Payload))
+	// callee sees proposal of the caller
+	resp = n.Evaluate(NewProposal("tx-2", "counter", "remote-proposal", "other"))
+	is.Equal("counter", string(resp.Payload))
+}
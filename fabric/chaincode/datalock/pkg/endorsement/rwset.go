@@ -0,0 +1,167 @@
+package endorsement
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	compositeKeyNamespace = "\x00"
+	maxUnicodeRune        = string(utf8.MaxRune)
+)
+
+// Version : height of the transaction that last wrote a key
+type Version struct {
+	BlockNum uint64
+	TxNum    uint64
+}
+
+// Write : value written by a transaction, Value is nil on delete
+type Write struct {
+	Value    []byte
+	IsDelete bool
+}
+
+// RangeRead : range query executed by a transaction, validated
+// at commit by executing it again (phantom read check)
+type RangeRead struct {
+	Namespace string
+	StartKey  string
+	EndKey    string
+	// Results : version of every key returned by the query
+	Results map[string]Version
+}
+
+// RWSet : read-write set of a transaction, by namespace. Namespace
+// of private data is "<chaincode>$$p<collection>"
+type RWSet struct {
+	// Reads : version of keys read, nil for missing keys
+	Reads  map[string]map[string]*Version
+	Ranges []RangeRead
+	Writes map[string]map[string]Write
+}
+
+func newRWSet() *RWSet {
+	return &RWSet{
+		Reads:  map[string]map[string]*Version{},
+		Writes: map[string]map[string]Write{},
+	}
+}
+
+func (rw *RWSet) addRead(ns, key string, v *Version) {
+	if rw.Reads[ns] == nil {
+		rw.Reads[ns] = map[string]*Version{}
+	}
+	// version of first read is kept, as in fabric
+	if _, ok := rw.Reads[ns][key]; !ok {
+		rw.Reads[ns][key] = v
+	}
+}
+
+func (rw *RWSet) addWrite(ns, key string, value []byte) {
+	if rw.Writes[ns] == nil {
+		rw.Writes[ns] = map[string]Write{}
+	}
+	rw.Writes[ns][key] = Write{Value: value, IsDelete: value == nil}
+}
+
+// WriteKeys : sorted keys written in namespace ns
+func (rw *RWSet) WriteKeys(ns string) []string {
+	keys := make([]string, 0, len(rw.Writes[ns]))
+	for k := range rw.Writes[ns] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (rw *RWSet) equal(other *RWSet) bool {
+	return reflect.DeepEqual(rw, other)
+}
+
+func privateNamespace(cc, collection string) string {
+	return cc + "$$p" + collection
+}
+
+type versionedValue struct {
+	value   []byte
+	version Version
+}
+
+// worldState : committed key values by namespace
+type worldState map[string]map[string]versionedValue
+
+func (ws worldState) get(ns, key string) (versionedValue, bool) {
+	v, ok := ws[ns][key]
+	return v, ok
+}
+
+// keys : sorted keys of ns in [startKey, endKey), empty endKey means no upper bound
+func (ws worldState) keys(ns, startKey, endKey string) []string {
+	var keys []string
+	for k := range ws[ns] {
+		if k >= startKey && (endKey == "" || k < endKey) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (ws worldState) rangeResults(ns, startKey, endKey string, composite bool) map[string]Version {
+	out := map[string]Version{}
+	for _, k := range ws.keys(ns, startKey, endKey) {
+		if strings.HasPrefix(k, compositeKeyNamespace) != composite {
+			continue
+		}
+		out[k] = ws[ns][k].version
+	}
+	return out
+}
+
+// valid : reads of rw still match committed state
+func (ws worldState) mvccValid(rw *RWSet) bool {
+	for ns, reads := range rw.Reads {
+		for key, want := range reads {
+			got, ok := ws.get(ns, key)
+			if want == nil {
+				if ok {
+					return false
+				}
+				continue
+			}
+			if !ok || got.version != *want {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (ws worldState) phantomValid(rw *RWSet) bool {
+	for _, r := range rw.Ranges {
+		composite := strings.HasPrefix(r.StartKey, compositeKeyNamespace)
+		if !reflect.DeepEqual(ws.rangeResults(r.Namespace, r.StartKey, r.EndKey, composite), r.Results) {
+			return false
+		}
+	}
+	return true
+}
+
+func (ws worldState) apply(rw *RWSet, version Version) {
+	for ns, writes := range rw.Writes {
+		if ws[ns] == nil {
+			ws[ns] = map[string]versionedValue{}
+		}
+		for key, w := range writes {
+			if w.IsDelete {
+				delete(ws[ns], key)
+				continue
+			}
+			ws[ns][key] = versionedValue{value: bytes.Clone(w.Value), version: version}
+		}
+	}
+}
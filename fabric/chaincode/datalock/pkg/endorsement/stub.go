@@ -0,0 +1,324 @@
+package endorsement
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/common"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// simulation : execution of one proposal on one peer,
+// shared by chaincodes invoked from the proposal
+type simulation struct {
+	network  *Network
+	state    worldState
+	proposal *Proposal
+	rwset    *RWSet
+	event    *pb.ChaincodeEvent
+}
+
+// stub : shim.ChaincodeStubInterface of a chaincode taking part in a
+// simulation. As in fabric, reads return committed values, a
+// transaction does not read its own writes
+type stub struct {
+	sim       *simulation
+	namespace string
+	args      [][]byte
+}
+
+var _ shim.ChaincodeStubInterface = (*stub)(nil)
+
+func errNotSimulated(method string) error {
+	return fmt.Errorf("%s is not supported by the endorsement simulation", method)
+}
+
+func (s *stub) GetArgs() [][]byte {
+	return s.args
+}
+
+func (s *stub) GetStringArgs() []string {
+	out := make([]string, len(s.args))
+	for i, arg := range s.args {
+		out[i] = string(arg)
+	}
+	return out
+}
+
+func (s *stub) GetFunctionAndParameters() (string, []string) {
+	args := s.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+	return args[0], args[1:]
+}
+
+func (s *stub) GetArgsSlice() ([]byte, error) {
+	return bytes.Join(s.args, nil), nil
+}
+
+func (s *stub) GetTxID() string {
+	return s.sim.proposal.TxID
+}
+
+func (s *stub) GetChannelID() string {
+	return s.sim.network.channel
+}
+
+func (s *stub) InvokeChaincode(chaincodeName string, args [][]byte, channel string) pb.Response {
+	if channel != "" && channel != s.sim.network.channel {
+		return shim.Error(fmt.Sprintf("channel = %s is not simulated", channel))
+	}
+	cc, ok := s.sim.network.chaincodes[chaincodeName]
+	if !ok {
+		return shim.Error(fmt.Sprintf("chaincode = %s not deployed", chaincodeName))
+	}
+	return cc.Invoke(&stub{sim: s.sim, namespace: chaincodeName, args: args})
+}
+
+func (s *stub) getState(ns, key string) ([]byte, error) {
+	v, ok := s.sim.state.get(ns, key)
+	if !ok {
+		s.sim.rwset.addRead(ns, key, nil)
+		return nil, nil
+	}
+	version := v.version
+	s.sim.rwset.addRead(ns, key, &version)
+	return bytes.Clone(v.value), nil
+}
+
+func (s *stub) GetState(key string) ([]byte, error) {
+	return s.getState(s.namespace, key)
+}
+
+func (s *stub) PutState(key string, value []byte) error {
+	if key == "" {
+		return fmt.Errorf("key must not be an empty string")
+	}
+	if len(value) == 0 {
+		return s.DelState(key)
+	}
+	s.sim.rwset.addWrite(s.namespace, key, bytes.Clone(value))
+	return nil
+}
+
+func (s *stub) DelState(key string) error {
+	s.sim.rwset.addWrite(s.namespace, key, nil)
+	return nil
+}
+
+func (s *stub) SetStateValidationParameter(key string, ep []byte) error {
+	return errNotSimulated("SetStateValidationParameter")
+}
+
+func (s *stub) GetStateValidationParameter(key string) ([]byte, error) {
+	return nil, errNotSimulated("GetStateValidationParameter")
+}
+
+func (s *stub) rangeQuery(startKey, endKey string, composite bool) *iterator {
+	results := s.sim.state.rangeResults(s.namespace, startKey, endKey, composite)
+	s.sim.rwset.Ranges = append(s.sim.rwset.Ranges, RangeRead{
+		Namespace: s.namespace,
+		StartKey:  startKey,
+		EndKey:    endKey,
+		Results:   results,
+	})
+	it := &iterator{}
+	for _, key := range s.sim.state.keys(s.namespace, startKey, endKey) {
+		if _, ok := results[key]; ok {
+			v, _ := s.sim.state.get(s.namespace, key)
+			it.kvs = append(it.kvs, &queryresult.KV{Namespace: s.namespace, Key: key, Value: bytes.Clone(v.value)})
+		}
+	}
+	return it
+}
+
+func (s *stub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	for _, key := range []string{startKey, endKey} {
+		if strings.HasPrefix(key, compositeKeyNamespace) {
+			return nil, fmt.Errorf("first character of the key [%s] contains a null character which is not allowed", key)
+		}
+	}
+	return s.rangeQuery(startKey, endKey, false), nil
+}
+
+func (s *stub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	return nil, nil, errNotSimulated("GetStateByRangeWithPagination")
+}
+
+func (s *stub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	prefix, err := shim.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	return s.rangeQuery(prefix, prefix+maxUnicodeRune, true), nil
+}
+
+func (s *stub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	return nil, nil, errNotSimulated("GetStateByPartialCompositeKeyWithPagination")
+}
+
+func (s *stub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	return shim.CreateCompositeKey(objectType, attributes)
+}
+
+func (s *stub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	if !strings.HasPrefix(compositeKey, compositeKeyNamespace) {
+		return "", nil, fmt.Errorf("invalid composite key = %q", compositeKey)
+	}
+	parts := strings.Split(strings.TrimSuffix(compositeKey[1:], "\x00"), "\x00")
+	return parts[0], parts[1:], nil
+}
+
+// GetQueryResult : rich queries are not re-executed at commit by fabric,
+// they are not simulated
+func (s *stub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	return nil, errNotSimulated("GetQueryResult")
+}
+
+func (s *stub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	return nil, nil, errNotSimulated("GetQueryResultWithPagination")
+}
+
+func (s *stub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	return nil, errNotSimulated("GetHistoryForKey")
+}
+
+func (s *stub) GetPrivateData(collection, key string) ([]byte, error) {
+	return s.getState(privateNamespace(s.namespace, collection), key)
+}
+
+func (s *stub) GetPrivateDataHash(collection, key string) ([]byte, error) {
+	return nil, errNotSimulated("GetPrivateDataHash")
+}
+
+func (s *stub) PutPrivateData(collection string, key string, value []byte) error {
+	if len(value) == 0 {
+		return s.DelPrivateData(collection, key)
+	}
+	s.sim.rwset.addWrite(privateNamespace(s.namespace, collection), key, bytes.Clone(value))
+	return nil
+}
+
+func (s *stub) DelPrivateData(collection, key string) error {
+	s.sim.rwset.addWrite(privateNamespace(s.namespace, collection), key, nil)
+	return nil
+}
+
+func (s *stub) SetPrivateDataValidationParameter(collection, key string, ep []byte) error {
+	return errNotSimulated("SetPrivateDataValidationParameter")
+}
+
+func (s *stub) GetPrivateDataValidationParameter(collection, key string) ([]byte, error) {
+	return nil, errNotSimulated("GetPrivateDataValidationParameter")
+}
+
+func (s *stub) GetPrivateDataByRange(collection, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	return nil, errNotSimulated("GetPrivateDataByRange")
+}
+
+func (s *stub) GetPrivateDataByPartialCompositeKey(collection, objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	return nil, errNotSimulated("GetPrivateDataByPartialCompositeKey")
+}
+
+func (s *stub) GetPrivateDataQueryResult(collection, query string) (shim.StateQueryIteratorInterface, error) {
+	return nil, errNotSimulated("GetPrivateDataQueryResult")
+}
+
+func (s *stub) GetCreator() ([]byte, error) {
+	return s.sim.proposal.Creator, nil
+}
+
+func (s *stub) GetTransient() (map[string][]byte, error) {
+	return s.sim.proposal.Transient, nil
+}
+
+func (s *stub) GetBinding() ([]byte, error) {
+	return nil, errNotSimulated("GetBinding")
+}
+
+func (s *stub) GetDecorations() map[string][]byte {
+	return nil
+}
+
+// GetSignedProposal : unsigned proposal carrying header, target
+// chaincode, args and transient map of the simulated proposal
+func (s *stub) GetSignedProposal() (*pb.SignedProposal, error) {
+	p := s.sim.proposal
+	var err error
+	marshal := func(m proto.Message) []byte {
+		raw, e := proto.Marshal(m)
+		if err == nil {
+			err = e
+		}
+		return raw
+	}
+	header := marshal(&common.Header{
+		ChannelHeader: marshal(&common.ChannelHeader{
+			Type:      int32(common.HeaderType_ENDORSER_TRANSACTION),
+			ChannelId: s.sim.network.channel,
+			TxId:      p.TxID,
+			Timestamp: p.Timestamp,
+		}),
+		SignatureHeader: marshal(&common.SignatureHeader{Creator: p.Creator}),
+	})
+	payload := marshal(&pb.ChaincodeProposalPayload{
+		Input: marshal(&pb.ChaincodeInvocationSpec{
+			ChaincodeSpec: &pb.ChaincodeSpec{
+				ChaincodeId: &pb.ChaincodeID{Name: p.Chaincode},
+				Input:       &pb.ChaincodeInput{Args: p.Args},
+			},
+		}),
+		TransientMap: p.Transient,
+	})
+	raw := marshal(&pb.Proposal{Header: header, Payload: payload})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode proposal : %w", err)
+	}
+	return &pb.SignedProposal{ProposalBytes: raw}, nil
+}
+
+func (s *stub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return s.sim.proposal.Timestamp, nil
+}
+
+// SetEvent : as in fabric, only the last event of a transaction is kept
+func (s *stub) SetEvent(name string, payload []byte) error {
+	if name == "" {
+		return fmt.Errorf("event name can not be empty string")
+	}
+	s.sim.event = &pb.ChaincodeEvent{
+		ChaincodeId: s.namespace,
+		TxId:        s.sim.proposal.TxID,
+		EventName:   name,
+		Payload:     bytes.Clone(payload),
+	}
+	return nil
+}
+
+type iterator struct {
+	kvs  []*queryresult.KV
+	next int
+}
+
+func (it *iterator) HasNext() bool {
+	return it.next < len(it.kvs)
+}
+
+func (it *iterator) Next() (*queryresult.KV, error) {
+	if it.next >= len(it.kvs) {
+		return nil, fmt.Errorf("no more results")
+	}
+	kv := it.kvs[it.next]
+	it.next++
+	return kv, nil
+}
+
+func (it *iterator) Close() error {
+	return nil
+}
@@ -10,3 +10,36 @@ const (
 	CodeConflict     Code = http.StatusConflict
 	CodeUnexpected   Code = http.StatusInternalServerError
 )
+
+// fabric treats response status in [400, 600) as error
+const (
+	minStatus = 400
+	maxStatus = 599
+)
+
+// Status : fabric response status of err
+func Status(err error) int32 {
+	code := ErrCode(err)
+	if code < minStatus || code > maxStatus {
+		return int32(CodeUnexpected)
+	}
+	return int32(code)
+}
+
+// ID : stable, machine readable identifier of err
+// for clients to act on
+func ID(err error) string {
+	if Is(err, ErrLocked) {
+		return "LOCKED"
+	}
+	switch ErrCode(err) {
+	case CodeNotFound:
+		return "NOT_FOUND"
+	case CodeInvalidInput:
+		return "INVALID_INPUT"
+	case CodeConflict:
+		return "CONFLICT"
+	default:
+		return "UNEXPECTED"
+	}
+}
@@ -1,6 +1,9 @@
 package errors
 
-import "fmt"
+import (
+	stderrors "errors"
+	"fmt"
+)
 
 type (
 	Op       string
@@ -12,6 +15,8 @@ type (
 type (
 	Chaincode string
 	TxID      string
+	// Field : path of the invalid input field
+	Field string
 )
 
 const (
@@ -21,6 +26,30 @@ const (
 	SeverityDebug
 )
 
+// sentinel : error category, which can be matched
+// using Is on any error stack carrying the same code
+type sentinel struct {
+	msg    string
+	code   Code
+	parent error
+}
+
+func (s *sentinel) Error() string {
+	return s.msg
+}
+
+func (s *sentinel) Is(target error) bool {
+	return s.parent != nil && s.parent == target
+}
+
+var (
+	ErrNotFound error = &sentinel{msg: "not found", code: CodeNotFound}
+	ErrConflict error = &sentinel{msg: "conflict", code: CodeConflict}
+	// ErrLocked : data already locked by another transaction,
+	// also matches ErrConflict
+	ErrLocked error = &sentinel{msg: "already locked", parent: ErrConflict}
+)
+
 type Error struct {
 	op       Op       // operations , name of the functions that is calling
 	code     Code     // category of errors
@@ -30,19 +59,56 @@ type Error struct {
 	// specific paramaters
 	chaincode Chaincode
 	txID      TxID
+	field     Field
 }
 
 // Error : implementing error interface
 // provided by go.
 func (e *Error) Error() string {
+	if e.err == nil {
+		return string(e.op)
+	}
 	return e.err.Error()
 }
 
-func Wrap(err error, msg string) {
+// Unwrap : returns next error on the stack
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// Is : error matches a sentinel having the same code
+func (e *Error) Is(target error) bool {
+	s, ok := target.(*sentinel)
+	return ok && s.code != 0 && s.code == e.code
+}
+
+// Wrap : adds msg to the error, returned error
+// keeps err on its stack
+func Wrap(err error, msg string) error {
+	if err == nil {
+		return nil
+	}
 	e, ok := err.(*Error)
 	if ok {
 		e.err = fmt.Errorf("%s : %w", msg, e.err)
+		return e
 	}
+	return fmt.Errorf("%s : %w", msg, err)
+}
+
+// Is : reports whether any error in err's stack matches target
+func Is(err, target error) bool {
+	return stderrors.Is(err, target)
+}
+
+// As : finds first error in err's stack that matches target
+func As(err error, target interface{}) bool {
+	return stderrors.As(err, target)
+}
+
+// Unwrap : returns next error on err's stack
+func Unwrap(err error) error {
+	return stderrors.Unwrap(err)
 }
 
 // E : creates a new error
@@ -64,6 +130,8 @@ func E(args ...interface{}) error {
 			e.chaincode = arg
 		case TxID:
 			e.txID = arg
+		case Field:
+			e.field = arg
 		default:
 			panic("bad call to E")
 		}
@@ -71,70 +139,101 @@ func E(args ...interface{}) error {
 	return e
 }
 
+// walk : visits err and every error on its stack (depth first,
+// following both Unwrap() error and Unwrap() []error) until
+// visit returns true
+func walk(err error, visit func(e *Error) bool) bool {
+	for err != nil {
+		if e, ok := err.(*Error); ok && visit(e) {
+			return true
+		}
+		switch u := err.(type) {
+		case interface{ Unwrap() []error }:
+			for _, sub := range u.Unwrap() {
+				if walk(sub, visit) {
+					return true
+				}
+			}
+			return false
+		case interface{ Unwrap() error }:
+			err = u.Unwrap()
+		default:
+			return false
+		}
+	}
+	return false
+}
+
 // Ops : returns list of operations from
 // the error stack
 func Ops(err error) []Op {
-	e, ok := err.(*Error)
-	if !ok {
-		return nil
-	}
-	res := []Op{e.op}
-	subErr, ok := e.err.(*Error)
-	if !ok {
-		return res
-	}
-	res = append(res, Ops(subErr)...)
+	var res []Op
+	walk(err, func(e *Error) bool {
+		if e.op != "" {
+			res = append(res, e.op)
+		}
+		return false
+	})
 	return res
 }
 
 // ErrCode : return first found error code
 // in error stack
 func ErrCode(err error) Code {
-	e, ok := err.(*Error)
-	if !ok {
-		return CodeUnexpected
-	}
-	if e.code > 0 {
-		return e.code
-	}
-	return ErrCode(e.err)
+	code := CodeUnexpected
+	walk(err, func(e *Error) bool {
+		if e.code > 0 {
+			code = e.code
+			return true
+		}
+		return false
+	})
+	return code
 }
 
-// ErrCode : return first found Severity
+// Level : return first found Severity
 // in error stack
 func Level(err error) Severity {
-	e, ok := err.(*Error)
-	if !ok {
-		return SeverityError
-	}
-	if e.severity != 0 {
-		return e.severity
-	}
-	return Level(e.err)
+	level := SeverityError
+	walk(err, func(e *Error) bool {
+		if e.severity != 0 {
+			level = e.severity
+			return true
+		}
+		return false
+	})
+	return level
 }
 
-// ErrCode : return first found chaincode name
+// CC : return first found chaincode name
 // in error stack
 func CC(err error) Chaincode {
-	e, ok := err.(*Error)
-	if !ok {
-		return Chaincode("")
-	}
-	if e.chaincode != "" {
-		return e.chaincode
-	}
-	return CC(e.err)
+	var cc Chaincode
+	walk(err, func(e *Error) bool {
+		cc = e.chaincode
+		return cc != ""
+	})
+	return cc
 }
 
-// ErrCode : return first found txID
+// GetTxID : return first found txID
 // in error stack
 func GetTxID(err error) TxID {
-	e, ok := err.(*Error)
-	if !ok {
-		return TxID("")
-	}
-	if e.txID != "" {
-		return e.txID
-	}
-	return GetTxID(e.err)
+	var txID TxID
+	walk(err, func(e *Error) bool {
+		txID = e.txID
+		return txID != ""
+	})
+	return txID
+}
+
+// GetField : return first found field path
+// in error stack
+func GetField(err error) Field {
+	var field Field
+	walk(err, func(e *Error) bool {
+		field = e.field
+		return field != ""
+	})
+	return field
 }
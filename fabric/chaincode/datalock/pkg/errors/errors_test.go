@@ -0,0 +1,112 @@
+package errors
+
+import (
+	stderrors "errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStack(t *testing.T) {
+	is := assert.New(t)
+
+	inner := E(Op("inner"), CodeNotFound, fmt.Errorf("tx not found"), SeverityDebug, TxID("tx-1"), Chaincode("cc"))
+	wrapped := fmt.Errorf("failed to get : %w", inner)
+	outer := E(Op("outer"), wrapped)
+
+	is.Equal("failed to get : tx not found", outer.Error())
+	is.Equal([]Op{"outer", "inner"}, Ops(outer))
+	is.Equal(CodeNotFound, ErrCode(outer))
+	is.Equal(SeverityDebug, Level(outer))
+	is.Equal(Chaincode("cc"), CC(outer))
+	is.Equal(TxID("tx-1"), GetTxID(outer))
+
+	var e *Error
+	is.True(As(wrapped, &e))
+	is.Equal(inner, e)
+	is.Equal(wrapped, Unwrap(outer))
+
+	t.Run("Defaults", func(t *testing.T) {
+		err := fmt.Errorf("plain")
+		is.Nil(Ops(err))
+		is.Equal(CodeUnexpected, ErrCode(err))
+		is.Equal(SeverityError, Level(err))
+		is.Zero(CC(err))
+		is.Zero(GetTxID(err))
+		is.Zero(GetField(err))
+	})
+
+	t.Run("Joined", func(t *testing.T) {
+		err := E(Op("validate"), stderrors.Join(
+			fmt.Errorf("plain"),
+			E(CodeInvalidInput, Field("name"), fmt.Errorf("required")),
+		))
+		is.Equal(CodeInvalidInput, ErrCode(err))
+		is.Equal(Field("name"), GetField(err))
+	})
+}
+
+func TestSentinel(t *testing.T) {
+	is := assert.New(t)
+
+	notFound := E(Op("op"), fmt.Errorf("wrap : %w", E(CodeNotFound, fmt.Errorf("missing"))))
+	is.True(Is(notFound, ErrNotFound))
+	is.False(Is(notFound, ErrConflict))
+
+	locked := E(Op("lock"), CodeConflict, fmt.Errorf("key = k %w", ErrLocked))
+	is.True(Is(locked, ErrLocked))
+	is.True(Is(locked, ErrConflict))
+	is.Equal("key = k already locked", locked.Error())
+
+	conflict := E(CodeConflict, fmt.Errorf("not processing"))
+	is.True(Is(conflict, ErrConflict))
+	is.False(Is(conflict, ErrLocked))
+}
+
+func TestWrap(t *testing.T) {
+	is := assert.New(t)
+
+	is.Nil(Wrap(nil, "msg"))
+
+	plain := fmt.Errorf("plain")
+	err := Wrap(plain, "msg")
+	is.Equal("msg : plain", err.Error())
+	is.True(Is(err, plain))
+
+	e := E(Op("op"), CodeConflict, plain)
+	err = Wrap(e, "msg")
+	is.Equal("msg : plain", err.Error())
+	is.Equal(CodeConflict, ErrCode(err))
+	is.True(Is(err, plain))
+}
+
+func TestPayload(t *testing.T) {
+	is := assert.New(t)
+
+	locked := E(Op("stageUpdate"), E(Op("lock"), CodeConflict, fmt.Errorf("key = k %w", ErrLocked), Chaincode("cc"), TxID("tx-1")))
+	p := NewPayload(locked)
+	is.Equal(CodeConflict, p.Code)
+	is.Equal("LOCKED", p.ID)
+	is.Equal("key = k already locked", p.Message)
+	is.Equal([]Op{"stageUpdate", "lock"}, p.Ops)
+	is.Equal(Chaincode("cc"), p.Chaincode)
+	is.Equal(TxID("tx-1"), p.TxID)
+	is.Equal(int32(409), Status(locked))
+
+	invalid := E(Op("validate"), CodeInvalidInput, stderrors.Join(
+		E(CodeInvalidInput, Field("tx_id"), fmt.Errorf("tx_id : is required")),
+		E(CodeInvalidInput, Field("name"), fmt.Errorf("name : is required")),
+	))
+	p = NewPayload(invalid)
+	is.Equal("INVALID_INPUT", p.ID)
+	is.Equal([]Field{"tx_id", "name"}, p.Fields)
+	is.Equal(int32(400), Status(invalid))
+
+	p = NewPayload(fmt.Errorf("plain"))
+	is.Equal("UNEXPECTED", p.ID)
+	is.Equal(int32(500), Status(fmt.Errorf("plain")))
+	is.Equal("NOT_FOUND", ID(E(CodeNotFound, fmt.Errorf("missing"))))
+	is.Equal("CONFLICT", ID(E(CodeConflict, fmt.Errorf("processing"))))
+	is.Equal(int32(500), Status(E(Code(302), fmt.Errorf("redirect"))))
+}
@@ -0,0 +1,33 @@
+package errors
+
+// Payload : json body of error response
+// returned to fabric clients
+type Payload struct {
+	Code      Code      `json:"code"`
+	ID        string    `json:"error"`
+	Message   string    `json:"message"`
+	Ops       []Op      `json:"ops,omitempty"`
+	Chaincode Chaincode `json:"chaincode,omitempty"`
+	TxID      TxID      `json:"tx_id,omitempty"`
+	// Fields : paths of invalid input fields
+	Fields []Field `json:"fields,omitempty"`
+}
+
+// NewPayload : builds client payload from error stack
+func NewPayload(err error) Payload {
+	p := Payload{
+		Code:      ErrCode(err),
+		ID:        ID(err),
+		Message:   err.Error(),
+		Ops:       Ops(err),
+		Chaincode: CC(err),
+		TxID:      GetTxID(err),
+	}
+	walk(err, func(e *Error) bool {
+		if e.field != "" {
+			p.Fields = append(p.Fields, e.field)
+		}
+		return false
+	})
+	return p
+}
@@ -0,0 +1,103 @@
+package guard
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// fenceObj : highest fencing number seen by the
+// data chaincode for a key
+const fenceObj = "datalock~fence"
+
+// ErrStaleFence : operation carries the fencing number
+// of a lock older than one already seen for the key
+var ErrStaleFence = errors.New("stale fencing number")
+
+// fromDataLock : fencing numbers are arguments any client can
+// set, they are only trusted when datalock appended them, ie
+// the proposal targets datalock
+func (g *Guard) fromDataLock(stub shim.ChaincodeStubInterface) error {
+	target, err := proposalChaincode(stub)
+	if err != nil {
+		return err
+	}
+	if target != g.DataLock {
+		return fmt.Errorf("fencing numbers are only accepted from %s, proposal targets %s", g.DataLock, target)
+	}
+	return nil
+}
+
+// Fences : fencing number of each key, appended by datalock as
+// the last argument when the data chaincode input has fencing.
+// Fails unless the proposal targets datalock
+func (g *Guard) Fences(stub shim.ChaincodeStubInterface) (map[string]uint64, error) {
+	err := g.fromDataLock(stub)
+	if err != nil {
+		return nil, err
+	}
+	args := stub.GetStringArgs()
+	if len(args) == 0 {
+		return nil, fmt.Errorf("missing fencing numbers")
+	}
+	var fences map[string]uint64
+	err = json.Unmarshal([]byte(args[len(args)-1]), &fences)
+	if err != nil {
+		return nil, fmt.Errorf("invalid fencing numbers : %w", err)
+	}
+	return fences, nil
+}
+
+// AcceptFences : records fencing number of each key, returns error
+// wrapping ErrStaleFence if a greater number was seen for a key.
+// Fails unless the proposal targets datalock, only checks the
+// numbers on a dry run
+func (g *Guard) AcceptFences(stub shim.ChaincodeStubInterface, fences map[string]uint64) error {
+	err := g.fromDataLock(stub)
+	if err != nil {
+		return err
+	}
+	dryRun, err := g.DryRun(stub)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(fences))
+	for key := range fences {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fenceKey, err := stub.CreateCompositeKey(fenceObj, []string{key})
+		if err != nil {
+			return fmt.Errorf("invalid key %q : %w", key, err)
+		}
+		raw, err := stub.GetState(fenceKey)
+		if err != nil {
+			return fmt.Errorf("failed to get fencing number : %w", err)
+		}
+		if len(raw) != 0 {
+			seen, err := strconv.ParseUint(string(raw), 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid fencing number of key = %s : %w", key, err)
+			}
+			if fences[key] < seen {
+				return fmt.Errorf("key = %s fence %d, seen %d : %w", key, fences[key], seen, ErrStaleFence)
+			}
+			if fences[key] == seen {
+				continue
+			}
+		}
+		if dryRun {
+			continue
+		}
+		err = stub.PutState(fenceKey, []byte(strconv.FormatUint(fences[key], 10)))
+		if err != nil {
+			return fmt.Errorf("failed to put fencing number : %w", err)
+		}
+	}
+	return nil
+}
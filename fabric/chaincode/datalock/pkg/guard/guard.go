@@ -0,0 +1,202 @@
+// Package guard : lets a data chaincode enforce datalock locks.
+// Before writing keys, the data chaincode asks datalock (method
+// checkLocks) whether the keys are free or held by the transition
+// the caller acts for, so that a locked key can't be changed by
+// calling the data chaincode directly.
+//
+//	var locks = guard.New("datalock", "EmissionsCC")
+//
+//	func update(stub shim.ChaincodeStubInterface, uuids []string) pb.Response {
+//		if err := locks.Enforce(stub, uuids...); err != nil {
+//			return shim.Error(err.Error())
+//		}
+//		...
+//	}
+//
+// Calls made by datalock itself (stageUpdate locking or unlocking
+// keys) are always allowed, datalock checks the keys before calling
+// the data chaincode and after, against the returned keys. A client
+// calling the data chaincode directly for a transition holding the
+// keys passes its txID in the transient map under TransientTxIDKey,
+// and is allowed only if it is the owner of that transition.
+//
+// When datalock simulates a stage update, its calls are a dry run :
+// DryRun tells the data chaincode to answer without writing, and
+// Enforce rejects writes with ErrDryRun.
+//
+// Data chaincode inputs with fencing get the fencing number of
+// each key as the last argument, read by Fences. AcceptFences
+// rejects numbers older than the greatest one seen for a key.
+// Both only trust numbers of calls made by datalock itself.
+package guard
+
+import (
+	"datalock/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+// TransientTxIDKey : key of the transient map holding
+// datalock txID the caller acts for
+const TransientTxIDKey = "datalock_tx_id"
+
+// ErrLocked : keys are locked by another transition
+var ErrLocked = errors.New("locked by datalock")
+
+// ErrDryRun : keys are written while datalock
+// simulates a stage update
+var ErrDryRun = errors.New("dry run of datalock")
+
+// dryRunMethod : datalock method simulating a stage update
+const dryRunMethod = "simulateStageUpdate"
+
+// Guard : of a data chaincode
+type Guard struct {
+	// DataLock : name of datalock chaincode
+	DataLock string
+	// Chaincode : name of the guarded data chaincode,
+	// as used in data_locks and data_free of stageUpdate
+	Chaincode string
+	// Channel : of datalock, empty for the same channel
+	Channel string
+}
+
+// New : guard of chaincode, checking locks on datalock
+func New(datalock, chaincode string) *Guard {
+	return &Guard{DataLock: datalock, Chaincode: chaincode}
+}
+
+// Check : holder of each key, and whether the
+// current transaction is allowed to write them
+func (g *Guard) Check(stub shim.ChaincodeStubInterface, keys ...string) (*model.CheckLocksOutput, error) {
+	if len(keys) == 0 {
+		return &model.CheckLocksOutput{Locks: []model.KeyLock{}, Allowed: true}, nil
+	}
+	target, err := proposalChaincode(stub)
+	if err != nil {
+		return nil, err
+	}
+	if target == g.DataLock {
+		// called by datalock while locking or unlocking
+		out := &model.CheckLocksOutput{Allowed: true}
+		for _, key := range keys {
+			out.Locks = append(out.Locks, model.KeyLock{Key: key})
+		}
+		dryRun, err := g.DryRun(stub)
+		if err != nil {
+			return nil, err
+		}
+		if dryRun {
+			out.Allowed = false
+			out.Reason = "dry run of datalock"
+		}
+		return out, nil
+	}
+
+	tMap, err := stub.GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transient map : %w", err)
+	}
+	raw, err := json.Marshal(model.CheckLocksInput{
+		Chaincode: g.Chaincode,
+		Keys:      keys,
+		TxID:      string(tMap[TransientTxIDKey]),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode lock check : %w", err)
+	}
+	resp := stub.InvokeChaincode(g.DataLock, [][]byte{[]byte("checkLocks"), raw}, g.Channel)
+	if resp.Status >= shim.ERRORTHRESHOLD {
+		return nil, fmt.Errorf("failed to check locks on %s : %s", g.DataLock, resp.Message)
+	}
+	var out model.CheckLocksOutput
+	err = json.Unmarshal(resp.Payload, &out)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lock check from %s : %w", g.DataLock, err)
+	}
+	return &out, nil
+}
+
+// Enforce : returns error wrapping ErrLocked, if the current
+// transaction is not allowed to write keys, or ErrDryRun
+// if datalock is simulating a stage update
+func (g *Guard) Enforce(stub shim.ChaincodeStubInterface, keys ...string) error {
+	if len(keys) != 0 {
+		dryRun, err := g.DryRun(stub)
+		if err != nil {
+			return err
+		}
+		if dryRun {
+			return fmt.Errorf("writing %d keys : %w", len(keys), ErrDryRun)
+		}
+	}
+	out, err := g.Check(stub, keys...)
+	if err != nil {
+		return err
+	}
+	if !out.Allowed {
+		return fmt.Errorf("%s : %w", out.Reason, ErrLocked)
+	}
+	return nil
+}
+
+// DryRun : true if the call comes from datalock simulating a stage
+// update (simulateStageUpdate). Writes of the data chaincode would
+// land if the simulation were submitted, so it should answer
+// without writing
+func (g *Guard) DryRun(stub shim.ChaincodeStubInterface) (bool, error) {
+	spec, err := proposalSpec(stub)
+	if err != nil {
+		return false, err
+	}
+	if spec.GetChaincodeId().GetName() != g.DataLock {
+		return false, nil
+	}
+	args := spec.GetInput().GetArgs()
+	// datalock may be mounted with its methods under a prefix
+	return len(args) != 0 && strings.HasSuffix(string(args[0]), dryRunMethod), nil
+}
+
+// proposalChaincode : name of the chaincode targeted by the
+// client proposal, chaincodes invoked from it see the same
+// proposal
+func proposalChaincode(stub shim.ChaincodeStubInterface) (string, error) {
+	spec, err := proposalSpec(stub)
+	if err != nil {
+		return "", err
+	}
+	return spec.GetChaincodeId().GetName(), nil
+}
+
+// proposalSpec : chaincode and arguments of the client proposal
+func proposalSpec(stub shim.ChaincodeStubInterface) (*pb.ChaincodeSpec, error) {
+	sp, err := stub.GetSignedProposal()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get signed proposal : %w", err)
+	}
+	if sp == nil {
+		return nil, fmt.Errorf("missing signed proposal")
+	}
+	var prop pb.Proposal
+	err = proto.Unmarshal(sp.ProposalBytes, &prop)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proposal : %w", err)
+	}
+	var payload pb.ChaincodeProposalPayload
+	err = proto.Unmarshal(prop.Payload, &payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proposal payload : %w", err)
+	}
+	var cis pb.ChaincodeInvocationSpec
+	err = proto.Unmarshal(payload.Input, &cis)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chaincode invocation spec : %w", err)
+	}
+	return cis.GetChaincodeSpec(), nil
+}
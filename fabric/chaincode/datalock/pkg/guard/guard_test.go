@@ -0,0 +1,287 @@
+package guard
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"datalock/internal"
+	"datalock/model"
+	"datalock/pkg/endorsement"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-protos-go/msp"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	dataLockCCName = "dataLockCC"
+	recordCCName   = "RecordCC"
+)
+
+// recordCC : data chaincode guarding its records
+type recordCC struct {
+	guard *Guard
+}
+
+func (recordCC) Init(shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (cc recordCC) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	fn, keys := stub.GetFunctionAndParameters()
+	if fn == "fenced" {
+		fences, err := cc.guard.Fences(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if err := cc.guard.AcceptFences(stub, fences); err != nil {
+			return shim.Error(err.Error())
+		}
+		keys = keys[:len(keys)-1]
+	}
+	if fn == "update" {
+		if err := cc.guard.Enforce(stub, keys...); err != nil {
+			return shim.Error(err.Error())
+		}
+		for _, key := range keys {
+			stub.PutState(key, []byte(stub.GetTxID()))
+		}
+	}
+	if fn == "write" {
+		// answers a dry run without writing
+		dryRun, err := cc.guard.DryRun(stub)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		for _, key := range keys {
+			if !dryRun {
+				stub.PutState(key, []byte(stub.GetTxID()))
+			}
+		}
+	}
+	raw, _ := json.Marshal(model.DataChaincodeOutput{Keys: keys})
+	return shim.Success(raw)
+}
+
+// testCreator : serialized identity of a self-signed client of mspID
+func testCreator(t *testing.T, mspID, name string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name, Organization: []string{mspID}},
+		NotBefore:    time.Unix(0, 0),
+		NotAfter:     time.Unix(1<<32, 0),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   mspID,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestGuard(t *testing.T) {
+	is := assert.New(t)
+	n := endorsement.NewNetwork("emissions-data", "Org1")
+	n.Deploy(dataLockCCName, &internal.DataLockChaincode{})
+	n.Deploy(recordCCName, recordCC{guard: New(dataLockCCName, recordCCName)})
+
+	owner := testCreator(t, "Org1MSP", "owner")
+	other := testCreator(t, "Org1MSP", "other")
+	step := 0
+	submit := func(p *endorsement.Proposal) pb.Response {
+		resp, _ := n.Submit(p)
+		return resp
+	}
+	proposal := func(cc string, args ...string) *endorsement.Proposal {
+		step++
+		p := endorsement.NewProposal(fmt.Sprintf("step-%d", step), cc, args...)
+		p.Creator = owner
+		return p
+	}
+	stageUpdate := func(input model.StageUpdateInput) pb.Response {
+		raw, _ := json.Marshal(input)
+		return submit(proposal(dataLockCCName, "stageUpdate", string(raw)))
+	}
+	update := func(txID string, keys ...string) pb.Response {
+		p := proposal(recordCCName, append([]string{"update"}, keys...)...)
+		if txID != "" {
+			p.Transient = map[string][]byte{TransientTxIDKey: []byte(txID)}
+		}
+		return submit(p)
+	}
+
+	submit(proposal(dataLockCCName, "startTransitionProcess", "txID-1"))
+	submit(proposal(dataLockCCName, "startTransitionProcess", "txID-2"))
+	resp := stageUpdate(model.StageUpdateInput{
+		TxID: "txID-1",
+		Name: "lock",
+		DataLocks: map[string]model.DataChaincodeInput{
+			recordCCName: {Keys: []string{"key-1"}, Params: []string{"lock", "key-1"}},
+		},
+	})
+	is.Equal(int32(shim.OK), resp.Status, resp.Message)
+
+	t.Run("free", func(t *testing.T) {
+		resp := update("", "key-2")
+		is.Equal(int32(shim.OK), resp.Status, resp.Message)
+	})
+	t.Run("locked", func(t *testing.T) {
+		resp := update("", "key-2", "key-1")
+		is.Equal(int32(shim.ERROR), resp.Status)
+		is.Equal("key = key-1 locked by txID = txID-1 : locked by datalock", resp.Message)
+		is.Empty(n.GetState(recordCCName, "key-1"))
+
+		resp = update("txID-2", "key-1")
+		is.Equal(int32(shim.ERROR), resp.Status)
+	})
+	t.Run("not-owner", func(t *testing.T) {
+		p := proposal(recordCCName, "update", "key-1")
+		p.Transient = map[string][]byte{TransientTxIDKey: []byte("txID-1")}
+		p.Creator = other
+		resp := submit(p)
+		is.Equal(int32(shim.ERROR), resp.Status)
+		is.Equal("caller is not owner of txID = txID-1 : locked by datalock", resp.Message)
+		is.Empty(n.GetState(recordCCName, "key-1"))
+	})
+	t.Run("holder", func(t *testing.T) {
+		resp := update("txID-1", "key-1", "key-3")
+		is.Equal(int32(shim.OK), resp.Status, resp.Message)
+		is.NotEmpty(n.GetState(recordCCName, "key-1"))
+	})
+	t.Run("check-locks", func(t *testing.T) {
+		raw, _ := json.Marshal(model.CheckLocksInput{Chaincode: recordCCName, Keys: []string{"key-1", "key-2"}})
+		tx := n.Endorse(proposal(dataLockCCName, "checkLocks", string(raw)))
+		is.Empty(tx.RWSet().WriteKeys(dataLockCCName))
+		var out model.CheckLocksOutput
+		is.NoError(json.Unmarshal(tx.Response().Payload, &out))
+		is.Equal([]model.KeyLock{{Key: "key-1", TxID: "txID-1", Fence: 1}, {Key: "key-2"}}, out.Locks)
+		is.False(out.Allowed)
+	})
+	t.Run("through-datalock", func(t *testing.T) {
+		resp := stageUpdate(model.StageUpdateInput{
+			TxID: "txID-1",
+			Name: "free",
+			DataFree: map[string]model.DataChaincodeInput{
+				recordCCName: {Keys: []string{"key-1"}, Params: []string{"update", "key-1"}},
+			},
+		})
+		is.Equal(int32(shim.OK), resp.Status, resp.Message)
+		is.Empty(n.GetState(dataLockCCName, recordCCName+"::key-1"))
+	})
+	t.Run("holder-not-processing", func(t *testing.T) {
+		stageUpdate(model.StageUpdateInput{
+			TxID: "txID-2",
+			Name: "lock",
+			DataLocks: map[string]model.DataChaincodeInput{
+				recordCCName: {Keys: []string{"key-4"}, Params: []string{"lock", "key-4"}},
+			},
+		})
+		submit(proposal(dataLockCCName, "endTransitionProcess", "txID-2"))
+		resp := update("txID-2", "key-4")
+		is.Equal(int32(shim.ERROR), resp.Status)
+		is.Contains(resp.Message, "txID = txID-2 is at NOT-PROCESSING state")
+	})
+	t.Run("dry-run", func(t *testing.T) {
+		simulate := func(method string) model.StageSimulation {
+			raw, _ := json.Marshal(model.StageUpdateInput{
+				TxID: "txID-1",
+				Name: "dry-run",
+				DataLocks: map[string]model.DataChaincodeInput{
+					recordCCName: {Keys: []string{"key-5"}, Params: []string{method, "key-5"}},
+				},
+			})
+			// submitted, data chaincode writes would land
+			resp := submit(proposal(dataLockCCName, "simulateStageUpdate", string(raw)))
+			is.Equal(int32(shim.OK), resp.Status, resp.Message)
+			var out model.StageSimulation
+			is.NoError(json.Unmarshal(resp.Payload, &out))
+			return out
+		}
+		out := simulate("update")
+		is.False(out.Allowed)
+		is.Contains(out.Reason, ErrDryRun.Error())
+		is.Empty(n.GetState(recordCCName, "key-5"))
+
+		out = simulate("write")
+		is.True(out.Allowed, out.Reason)
+		is.Empty(n.GetState(recordCCName, "key-5"))
+	})
+	t.Run("no-proposal", func(t *testing.T) {
+		stub := shimtest.NewMockStub(recordCCName, nil)
+		_, err := New(dataLockCCName, recordCCName).Check(stub, "key-2")
+		is.EqualError(err, "missing signed proposal")
+	})
+}
+
+func TestFences(t *testing.T) {
+	is := assert.New(t)
+	n := endorsement.NewNetwork("emissions-data", "Org1")
+	n.Deploy(dataLockCCName, &internal.DataLockChaincode{})
+	n.Deploy(recordCCName, recordCC{guard: New(dataLockCCName, recordCCName)})
+
+	step := 0
+	submit := func(cc string, args ...string) pb.Response {
+		step++
+		resp, _ := n.Submit(endorsement.NewProposal(fmt.Sprintf("step-%d", step), cc, args...))
+		return resp
+	}
+	fenced := model.DataChaincodeInput{Keys: []string{"key-1"}, Params: []string{"fenced", "key-1"}, Fencing: true}
+	stageUpdate := func(txID string, locks, free map[string]model.DataChaincodeInput) model.StageUpdateOutput {
+		raw, _ := json.Marshal(model.StageUpdateInput{TxID: txID, Name: "stage", DataLocks: locks, DataFree: free})
+		resp := submit(dataLockCCName, "stageUpdate", string(raw))
+		is.Equal(int32(shim.OK), resp.Status, resp.Message)
+		var out model.StageUpdateOutput
+		json.Unmarshal(resp.Payload, &out)
+		return out
+	}
+
+	submit(dataLockCCName, "startTransitionProcess", "txID-1")
+	submit(dataLockCCName, "startTransitionProcess", "txID-2")
+	out := stageUpdate("txID-1", map[string]model.DataChaincodeInput{recordCCName: fenced}, nil)
+	is.Equal(map[string]map[string]uint64{recordCCName: {"key-1": 1}}, out.Fences)
+	stageUpdate("txID-1", nil, map[string]model.DataChaincodeInput{recordCCName: fenced})
+	out = stageUpdate("txID-2", map[string]model.DataChaincodeInput{recordCCName: fenced}, nil)
+	is.Equal(map[string]map[string]uint64{recordCCName: {"key-1": 2}}, out.Fences)
+
+	t.Run("direct", func(t *testing.T) {
+		// fencing numbers set by the client are not trusted
+		resp := submit(recordCCName, "fenced", "key-1", `{"key-1":99}`)
+		is.Equal(int32(shim.ERROR), resp.Status)
+		is.Equal("fencing numbers are only accepted from dataLockCC, proposal targets RecordCC", resp.Message)
+		fenceKey, _ := shim.CreateCompositeKey(fenceObj, []string{"key-1"})
+		is.Equal("2", string(n.GetState(recordCCName, fenceKey)))
+	})
+	t.Run("stale", func(t *testing.T) {
+		fenceKey, _ := shim.CreateCompositeKey(fenceObj, []string{"key-1"})
+		n.Seed(recordCCName, map[string][]byte{fenceKey: []byte("5")})
+		raw, _ := json.Marshal(model.StageUpdateInput{
+			TxID:     "txID-2",
+			Name:     "stale",
+			DataFree: map[string]model.DataChaincodeInput{recordCCName: fenced},
+		})
+		resp := submit(dataLockCCName, "stageUpdate", string(raw))
+		is.NotEqual(int32(shim.OK), resp.Status)
+		is.Contains(resp.Message, ErrStaleFence.Error())
+	})
+}
@@ -2,13 +2,15 @@ package logger
 
 import (
 	"datalock/pkg/errors"
+	"io"
+	"os"
+	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/sirupsen/logrus"
 )
 
-var lg *logger
-
 type Interface interface {
 	Debugf(format string, args ...interface{})
 	Infof(format string, args ...interface{})
@@ -18,13 +20,49 @@ type Interface interface {
 
 type Level string
 
-type logger struct {
-	l *logrus.Logger
+// Fields : correlation fields added to every line
+// logged by a request logger
+type Fields map[string]interface{}
+
+// Config : of a logger
+type Config struct {
+	// Level : error, warn, info or debug
+	Level string
+	// Format : json (default) or text
+	Format string
+	// Timestamp : true, to add timestamp to every line
+	Timestamp bool
+	// DebugSampling : only 1 out of every DebugSampling
+	// debug lines is logged, all if <= 1
+	DebugSampling uint64
+	// Output : defaults to stderr
+	Output io.Writer
+}
+
+// Logger : leveled logger with sampling of debug lines
+type Logger struct {
+	l        *logrus.Logger
+	sampling uint64
+	debugs   uint64
 }
 
-func NewAppLogger(level string) {
+// ConfigFromEnv : reads logger config from DATALOCK_LOG_LEVEL,
+// DATALOCK_LOG_FORMAT, DATALOCK_LOG_TIMESTAMP and
+// DATALOCK_LOG_DEBUG_SAMPLING
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Level:  os.Getenv("DATALOCK_LOG_LEVEL"),
+		Format: os.Getenv("DATALOCK_LOG_FORMAT"),
+	}
+	cfg.Timestamp, _ = strconv.ParseBool(os.Getenv("DATALOCK_LOG_TIMESTAMP"))
+	cfg.DebugSampling, _ = strconv.ParseUint(os.Getenv("DATALOCK_LOG_DEBUG_SAMPLING"), 10, 64)
+	return cfg
+}
+
+// New : creates logger from cfg
+func New(cfg Config) *Logger {
 	var l logrus.Level
-	switch strings.ToLower(level) {
+	switch strings.ToLower(cfg.Level) {
 	case "error":
 		l = logrus.ErrorLevel
 	case "warn":
@@ -38,65 +76,113 @@ func NewAppLogger(level string) {
 	}
 	log := logrus.New()
 	log.SetLevel(l)
-	format := logrus.JSONFormatter{
-		DisableTimestamp: true,
+	switch strings.ToLower(cfg.Format) {
+	case "text":
+		log.SetFormatter(&logrus.TextFormatter{
+			DisableTimestamp: !cfg.Timestamp,
+			FullTimestamp:    cfg.Timestamp,
+		})
+	default:
+		log.SetFormatter(&logrus.JSONFormatter{
+			DisableTimestamp: !cfg.Timestamp,
+		})
+	}
+	if cfg.Output != nil {
+		log.SetOutput(cfg.Output)
 	}
-	log.SetFormatter(&format)
 	log.SetReportCaller(false)
-	lg = &logger{log}
+	return &Logger{l: log, sampling: cfg.DebugSampling}
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	if l.sampled() {
+		l.l.Debugf(format, args...)
+	}
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.l.Infof(format, args...)
 }
 
-func Debugf(format string, args ...interface{}) {
-	lg.debugf(format, args...)
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.l.Warnf(format, args...)
 }
 
-func Infof(format string, args ...interface{}) {
-	lg.infof(format, args...)
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.l.Errorf(format, args...)
 }
 
-func Warnf(format string, args ...interface{}) {
-	lg.warnf(format, args...)
+// sampled : true, if next debug line should be logged
+func (l *Logger) sampled() bool {
+	if !l.l.IsLevelEnabled(logrus.DebugLevel) {
+		return false
+	}
+	if l.sampling <= 1 {
+		return true
+	}
+	return atomic.AddUint64(&l.debugs, 1)%l.sampling == 1
 }
 
-func Errorf(format string, args ...interface{}) {
-	lg.errorf(format, args...)
+// Request : logger bound to a single chaincode request,
+// every line carries the correlation fields of the request
+type Request struct {
+	lg    *Logger
+	entry *logrus.Entry
 }
 
-func (l *logger) debugf(format string, args ...interface{}) {
-	l.l.Debugf(format, args...)
+// ForRequest : creates logger bound to request
+// identified by fields
+func (l *Logger) ForRequest(fields Fields) *Request {
+	return &Request{lg: l, entry: l.l.WithFields(logrus.Fields(fields))}
 }
 
-func (l *logger) infof(format string, args ...interface{}) {
-	l.l.Infof(format, args...)
+func (r *Request) Debugf(format string, args ...interface{}) {
+	if r.lg.sampled() {
+		r.entry.Debugf(format, args...)
+	}
 }
 
-func (l *logger) warnf(format string, args ...interface{}) {
-	l.l.Warnf(format, args...)
+func (r *Request) Infof(format string, args ...interface{}) {
+	r.entry.Infof(format, args...)
 }
 
-func (l *logger) errorf(format string, args ...interface{}) {
-	l.l.Errorf(format, args...)
+func (r *Request) Warnf(format string, args ...interface{}) {
+	r.entry.Warnf(format, args...)
+}
+
+func (r *Request) Errorf(format string, args ...interface{}) {
+	r.entry.Errorf(format, args...)
+}
+
+// SystemErr : logs err along with correlation
+// fields of the request
+func (r *Request) SystemErr(err error) {
+	systemErr(r.lg, r.entry, err)
+}
+
+// SystemErr : logs err of method
+func (l *Logger) SystemErr(method string, err error) {
+	systemErr(l, l.l.WithField("method", method), err)
 }
 
-func SystemErr(method string, err error) {
-	_, ok := err.(*errors.Error)
-	if !ok {
-		lg.l.Error(err)
+func systemErr(l *Logger, entry *logrus.Entry, err error) {
+	var e *errors.Error
+	if !errors.As(err, &e) {
+		entry.Error(err)
 
 		return
 	}
 	fields := map[string]interface{}{
 		"operations": errors.Ops(err),
 		"code":       errors.ErrCode(err),
-		"method":     method,
 	}
 	if cc := errors.CC(err); cc != "" {
-		fields["chiancode"] = cc
+		fields["chaincode"] = cc
 	}
 	if txID := errors.GetTxID(err); txID != "" {
 		fields["txID"] = txID
 	}
-	entry := lg.l.WithFields(fields)
+	entry = entry.WithFields(fields)
 
 	//nolint:exhaustive //it's ok
 	switch errors.Level(err) {
@@ -105,7 +191,9 @@ func SystemErr(method string, err error) {
 	case errors.SeverityInfo:
 		entry.Infof("%v", err)
 	case errors.SeverityDebug:
-		entry.Debugf("%v", err)
+		if l.sampled() {
+			entry.Debugf("%v", err)
+		}
 	default:
 		entry.Errorf("%v", err)
 	}
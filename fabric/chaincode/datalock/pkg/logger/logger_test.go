@@ -0,0 +1,113 @@
+package logger
+
+import (
+	"bytes"
+	"datalock/pkg/errors"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func lines(buf *bytes.Buffer) []map[string]interface{} {
+	var out []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var fields map[string]interface{}
+		if json.Unmarshal([]byte(line), &fields) == nil {
+			out = append(out, fields)
+		}
+	}
+	return out
+}
+
+func TestRequestLogger(t *testing.T) {
+	is := assert.New(t)
+	buf := new(bytes.Buffer)
+	log := New(Config{Level: "debug", Output: buf}).ForRequest(Fields{
+		"fabricTxID": "fabric-tx-1",
+		"channel":    "emissions-data",
+		"method":     "stageUpdate",
+		"txID":       "tx-1",
+	})
+	log.Debugf("request received")
+	log.SystemErr(errors.E(
+		errors.Op("Method.stageUpdate"),
+		errors.CodeConflict,
+		fmt.Errorf("already locked"),
+		errors.SeverityWarn,
+		errors.Chaincode("EmissionsCC"),
+	))
+
+	got := lines(buf)
+	is.Len(got, 2)
+	for _, line := range got {
+		is.Equal("fabric-tx-1", line["fabricTxID"])
+		is.Equal("emissions-data", line["channel"])
+		is.Equal("stageUpdate", line["method"])
+		is.Equal("tx-1", line["txID"])
+		is.NotContains(line, "time")
+	}
+	is.Equal("EmissionsCC", got[1]["chaincode"])
+	is.Equal("warning", got[1]["level"])
+	is.EqualValues(409, got[1]["code"])
+}
+
+func TestDebugSampling(t *testing.T) {
+	is := assert.New(t)
+	buf := new(bytes.Buffer)
+	log := New(Config{Level: "debug", Output: buf, DebugSampling: 3, Timestamp: true})
+
+	for i := 0; i < 9; i++ {
+		log.Debugf("debug %d", i)
+	}
+	log.Infof("info")
+	got := lines(buf)
+	is.Len(got, 4)
+	is.Equal("debug 0", got[0]["msg"])
+	is.Equal("debug 3", got[1]["msg"])
+	is.Contains(got[0], "time")
+}
+
+func TestTextFormat(t *testing.T) {
+	is := assert.New(t)
+	buf := new(bytes.Buffer)
+	log := New(Config{Level: "info", Format: "text", Output: buf})
+
+	log.Debugf("hidden")
+	log.SystemErr("getTxDetails", errors.E(errors.CodeNotFound, fmt.Errorf("not found"), errors.TxID("tx-1")))
+	out := buf.String()
+	is.NotContains(out, "hidden")
+	is.Contains(out, "method=getTxDetails")
+	is.Contains(out, "txID=tx-1")
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	is := assert.New(t)
+	t.Setenv("DATALOCK_LOG_LEVEL", "DEBUG")
+	t.Setenv("DATALOCK_LOG_FORMAT", "text")
+	t.Setenv("DATALOCK_LOG_TIMESTAMP", "true")
+	t.Setenv("DATALOCK_LOG_DEBUG_SAMPLING", "10")
+	is.Equal(Config{
+		Level:         "DEBUG",
+		Format:        "text",
+		Timestamp:     true,
+		DebugSampling: 10,
+	}, ConfigFromEnv())
+}
+
+func TestNew(t *testing.T) {
+	is := assert.New(t)
+	buf := new(bytes.Buffer)
+	log := New(Config{Level: "info", Output: buf})
+
+	log.Debugf("hidden")
+	log.ForRequest(Fields{"method": "getTxDetails"}).Infof("request received")
+	got := lines(buf)
+	is.Len(got, 1)
+	is.Equal("getTxDetails", got[0]["method"])
+}
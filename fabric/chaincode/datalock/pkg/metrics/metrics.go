@@ -0,0 +1,49 @@
+// Package metrics : instrumentation of lock contention
+// and data chaincode latency
+package metrics
+
+import (
+	"datalock/pkg/errors"
+	"time"
+)
+
+type Outcome string
+
+const (
+	OutcomeSuccess  Outcome = "success"
+	OutcomeConflict Outcome = "conflict"
+	OutcomeError    Outcome = "error"
+)
+
+// Interface : metrics recorded by datalock
+type Interface interface {
+	// LockAttempt : counts attempt to lock keys of a chaincode
+	LockAttempt(cc string, outcome Outcome)
+	// Unlock : counts attempt to unlock keys of a chaincode
+	Unlock(cc string, outcome Outcome)
+	// StageUpdate : counts stage updates
+	StageUpdate(outcome Outcome)
+	// InvokeDuration : observes time taken by a data chaincode
+	InvokeDuration(cc string, d time.Duration)
+}
+
+// OutcomeOf : outcome of an operation returning err,
+// conflict only covers keys locked by another transaction
+func OutcomeOf(err error) Outcome {
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case errors.Is(err, errors.ErrLocked):
+		return OutcomeConflict
+	default:
+		return OutcomeError
+	}
+}
+
+// Nop : discards all metrics
+type Nop struct{}
+
+func (Nop) LockAttempt(string, Outcome)          {}
+func (Nop) Unlock(string, Outcome)               {}
+func (Nop) StageUpdate(Outcome)                  {}
+func (Nop) InvokeDuration(string, time.Duration) {}
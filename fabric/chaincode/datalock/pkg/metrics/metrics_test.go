@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"bytes"
+	"datalock/pkg/errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutcomeOf(t *testing.T) {
+	is := assert.New(t)
+	is.Equal(OutcomeSuccess, OutcomeOf(nil))
+	is.Equal(OutcomeConflict, OutcomeOf(errors.E(
+		errors.Op("test"),
+		errors.CodeConflict,
+		fmt.Errorf("key = uuid-1 %w", errors.ErrLocked),
+	)))
+	is.Equal(OutcomeError, OutcomeOf(errors.E(errors.CodeConflict, fmt.Errorf("chaincode failed"))))
+	is.Equal(OutcomeError, OutcomeOf(fmt.Errorf("unknown")))
+}
+
+func TestRegistry(t *testing.T) {
+	is := assert.New(t)
+	r := NewRegistry()
+
+	r.LockAttempt("EmissionsCC", OutcomeSuccess)
+	r.LockAttempt("EmissionsCC", OutcomeSuccess)
+	r.LockAttempt("EmissionsCC", OutcomeConflict)
+	r.Unlock("EmissionsCC", OutcomeError)
+	r.StageUpdate(OutcomeSuccess)
+	r.InvokeDuration("EmissionsCC", 3*time.Millisecond)
+	r.InvokeDuration("EmissionsCC", 20*time.Second)
+
+	buf := new(bytes.Buffer)
+	is.NoError(r.WriteText(buf))
+	out := buf.String()
+	is.Contains(out, "# TYPE datalock_lock_attempts_total counter\n")
+	is.Contains(out, `datalock_lock_attempts_total{chaincode="EmissionsCC",outcome="success"} 2`)
+	is.Contains(out, `datalock_lock_attempts_total{chaincode="EmissionsCC",outcome="conflict"} 1`)
+	is.Contains(out, `datalock_unlocks_total{chaincode="EmissionsCC",outcome="error"} 1`)
+	is.Contains(out, `datalock_stage_updates_total{outcome="success"} 1`)
+	is.Contains(out, "# TYPE datalock_invoke_duration_seconds histogram\n")
+	is.Contains(out, `datalock_invoke_duration_seconds_bucket{chaincode="EmissionsCC",le="0.001"} 0`)
+	is.Contains(out, `datalock_invoke_duration_seconds_bucket{chaincode="EmissionsCC",le="0.005"} 1`)
+	is.Contains(out, `datalock_invoke_duration_seconds_bucket{chaincode="EmissionsCC",le="10"} 1`)
+	is.Contains(out, `datalock_invoke_duration_seconds_bucket{chaincode="EmissionsCC",le="+Inf"} 2`)
+	is.Contains(out, `datalock_invoke_duration_seconds_count{chaincode="EmissionsCC"} 2`)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	is.Equal(200, rec.Code)
+	is.Contains(rec.Header().Get("Content-Type"), "text/plain")
+	is.Equal(out, rec.Body.String())
+}
+
+func TestLabels(t *testing.T) {
+	is := assert.New(t)
+	r := NewRegistry()
+	r.LockAttempt("Émissions\"CC\"\\\n", OutcomeSuccess)
+	buf := new(bytes.Buffer)
+	is.NoError(r.WriteText(buf))
+	is.Contains(buf.String(), `datalock_lock_attempts_total{chaincode="Émissions\"CC\"\\\n",outcome="success"} 1`)
+}
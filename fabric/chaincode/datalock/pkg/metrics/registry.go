@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets : upper bounds (seconds) of invoke duration histogram
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+const (
+	lockAttempts   = "datalock_lock_attempts_total"
+	unlocks        = "datalock_unlocks_total"
+	stageUpdates   = "datalock_stage_updates_total"
+	invokeDuration = "datalock_invoke_duration_seconds"
+)
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// Registry : in memory metrics, exposed in
+// prometheus text format
+type Registry struct {
+	mu       sync.Mutex
+	buckets  []float64
+	counters map[string]map[string]uint64 // name => labels => value
+	hists    map[string]*histogram        // labels => histogram
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		buckets: DefaultBuckets,
+		counters: map[string]map[string]uint64{
+			lockAttempts: {},
+			unlocks:      {},
+			stageUpdates: {},
+		},
+		hists: map[string]*histogram{},
+	}
+}
+
+func (r *Registry) inc(name, labels string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counters[name][labels]++
+}
+
+func (r *Registry) LockAttempt(cc string, outcome Outcome) {
+	r.inc(lockAttempts, labels("chaincode", cc, "outcome", string(outcome)))
+}
+
+func (r *Registry) Unlock(cc string, outcome Outcome) {
+	r.inc(unlocks, labels("chaincode", cc, "outcome", string(outcome)))
+}
+
+func (r *Registry) StageUpdate(outcome Outcome) {
+	r.inc(stageUpdates, labels("outcome", string(outcome)))
+}
+
+func (r *Registry) InvokeDuration(cc string, d time.Duration) {
+	series := labels("chaincode", cc)
+	seconds := d.Seconds()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, ok := r.hists[series]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(r.buckets))}
+		r.hists[series] = h
+	}
+	for i, le := range r.buckets {
+		if seconds <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// WriteText : writes metrics in prometheus text exposition format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	bw := bufio.NewWriter(w)
+
+	help := map[string]string{
+		lockAttempts: "Attempts to lock data chaincode keys, by chaincode and outcome.",
+		unlocks:      "Attempts to unlock data chaincode keys, by chaincode and outcome.",
+		stageUpdates: "Stage updates, by outcome.",
+	}
+	for _, name := range []string{lockAttempts, unlocks, stageUpdates} {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s counter\n", name, help[name], name)
+		series := r.counters[name]
+		for _, labels := range sortedKeys(series) {
+			fmt.Fprintf(bw, "%s{%s} %d\n", name, labels, series[labels])
+		}
+	}
+
+	fmt.Fprintf(bw, "# HELP %s Duration of data chaincode invocations, by chaincode.\n# TYPE %s histogram\n", invokeDuration, invokeDuration)
+	for _, labels := range sortedKeys(r.hists) {
+		h := r.hists[labels]
+		var cumulative uint64
+		for i, le := range r.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(bw, "%s_bucket{%s,le=\"%s\"} %d\n", invokeDuration, labels, formatFloat(le), cumulative)
+		}
+		fmt.Fprintf(bw, "%s_bucket{%s,le=\"+Inf\"} %d\n", invokeDuration, labels, h.count)
+		fmt.Fprintf(bw, "%s_sum{%s} %s\n", invokeDuration, labels, formatFloat(h.sum))
+		fmt.Fprintf(bw, "%s_count{%s} %d\n", invokeDuration, labels, h.count)
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP : prometheus scrape endpoint
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = r.WriteText(w)
+}
+
+// labelEscaper : escapes label values as the prometheus
+// text format expects, other characters are kept as is
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels : formats name, value pairs as name="value",...
+func labels(pairs ...string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i != 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, pairs[i], labelEscaper.Replace(pairs[i+1]))
+	}
+	return b.String()
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,300 @@
+// Package mockstub : in-memory fabric stub for unit tests. It extends
+// shimtest.MockStub with CouchDB rich queries (a subset of mango
+// selectors, sort, limit, skip and fields), bookmark pagination and
+// the private data methods shimtest leaves unimplemented
+package mockstub
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-chaincode-go/shimtest"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+)
+
+const (
+	compositeKeyNamespace = "\x00"
+	maxUnicodeRune        = string(utf8.MaxRune)
+)
+
+// MockStub : shimtest.MockStub with rich query support.
+// State, PvtState, TransientMap, ... are the ones of shimtest.MockStub
+type MockStub struct {
+	*shimtest.MockStub
+	cc shim.Chaincode
+}
+
+var _ shim.ChaincodeStubInterface = (*MockStub)(nil)
+
+// NewMockStub : creates a stub for cc. MockInit and MockInvoke call
+// cc with the returned stub, so queries of cc go through MockStub
+func NewMockStub(name string, cc shim.Chaincode) *MockStub {
+	stub := &MockStub{cc: cc}
+	stub.MockStub = shimtest.NewMockStub(name, forwarder{stub})
+	return stub
+}
+
+// forwarder : lets shimtest.MockStub set up args and tx context,
+// then calls the chaincode with the wrapping stub
+type forwarder struct {
+	stub *MockStub
+}
+
+func (f forwarder) Init(shim.ChaincodeStubInterface) pb.Response {
+	return f.stub.cc.Init(f.stub)
+}
+
+func (f forwarder) Invoke(shim.ChaincodeStubInterface) pb.Response {
+	return f.stub.cc.Invoke(f.stub)
+}
+
+// MockPeerChaincode : registers other as invokable chaincode name
+func (stub *MockStub) MockPeerChaincode(name string, other *MockStub, channel string) {
+	stub.MockStub.MockPeerChaincode(name, other.MockStub, channel)
+}
+
+// GetQueryResult : evaluates a mango query on world state,
+// limit and skip of the query are honored
+func (stub *MockStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	return stub.richQuery(stub.State, query)
+}
+
+// GetQueryResultWithPagination : evaluates a mango query on world state,
+// returning at most pageSize documents following bookmark
+func (stub *MockStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if pageSize <= 0 {
+		return nil, nil, fmt.Errorf("pageSize must be greater than zero")
+	}
+	q, sortBy, err := parseQuery(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	docs, err := execute(stub.State, q, sortBy)
+	if err != nil {
+		return nil, nil, err
+	}
+	// skip applies to the first page, later pages
+	// start after the bookmark
+	skip := q.Skip
+	if bookmark != "" {
+		skip = 0
+		after, err := decodeBookmark(bookmark, len(sortBy))
+		if err != nil {
+			return nil, nil, err
+		}
+		start := sort.Search(len(docs), func(i int) bool {
+			return compareDocs(docs[i].sort, docs[i].key, after.Sort, after.Key, sortBy) > 0
+		})
+		docs = docs[start:]
+	}
+	docs = page(docs, skip, int(pageSize))
+
+	next := bookmark
+	if len(docs) != 0 {
+		last := docs[len(docs)-1]
+		next = encodeBookmark(last.key, last.sort)
+	}
+	iter, err := stub.docIterator(docs, q.Fields)
+	if err != nil {
+		return nil, nil, err
+	}
+	return iter, &pb.QueryResponseMetadata{
+		FetchedRecordsCount: int32(len(docs)),
+		Bookmark:            next,
+	}, nil
+}
+
+// GetStateByRangeWithPagination : range query where bookmark is the
+// first key to return, returned bookmark is empty on last page
+func (stub *MockStub) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	for _, key := range []string{startKey, endKey} {
+		if strings.HasPrefix(key, compositeKeyNamespace) {
+			return nil, nil, fmt.Errorf("first character of the key [%s] contains a null character which is not allowed", key)
+		}
+	}
+	keys := simpleKeys(sortedKeys(stub.State, startKey, endKey))
+	return stub.rangePage(keys, pageSize, bookmark)
+}
+
+// GetStateByPartialCompositeKeyWithPagination : paginated partial composite key query
+func (stub *MockStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	prefix, err := stub.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	return stub.rangePage(sortedKeys(stub.State, prefix, prefix+maxUnicodeRune), pageSize, bookmark)
+}
+
+// GetPrivateDataQueryResult : evaluates a mango query on a private collection
+func (stub *MockStub) GetPrivateDataQueryResult(collection, query string) (shim.StateQueryIteratorInterface, error) {
+	return stub.richQuery(stub.PvtState[collection], query)
+}
+
+// GetPrivateDataByRange : range query on a private collection
+func (stub *MockStub) GetPrivateDataByRange(collection, startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	state := stub.PvtState[collection]
+	return stub.keyIterator(state, simpleKeys(sortedKeys(state, startKey, endKey))), nil
+}
+
+// GetPrivateDataByPartialCompositeKey : partial composite key query on a private collection
+func (stub *MockStub) GetPrivateDataByPartialCompositeKey(collection, objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	prefix, err := stub.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	state := stub.PvtState[collection]
+	return stub.keyIterator(state, sortedKeys(state, prefix, prefix+maxUnicodeRune)), nil
+}
+
+// DelPrivateData : deletes key of a private collection
+func (stub *MockStub) DelPrivateData(collection, key string) error {
+	if stub.TxID == "" {
+		return fmt.Errorf("cannot DelPrivateData without a transactions - call stub.MockTransactionStart()?")
+	}
+	delete(stub.PvtState[collection], key)
+	return nil
+}
+
+func (stub *MockStub) richQuery(state map[string][]byte, query string) (shim.StateQueryIteratorInterface, error) {
+	q, sortBy, err := parseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	docs, err := execute(state, q, sortBy)
+	if err != nil {
+		return nil, err
+	}
+	return stub.docIterator(page(docs, q.Skip, q.Limit), q.Fields)
+}
+
+func (stub *MockStub) rangePage(keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if pageSize <= 0 {
+		return nil, nil, fmt.Errorf("pageSize must be greater than zero")
+	}
+	if bookmark != "" {
+		keys = keys[sort.SearchStrings(keys, bookmark):]
+	}
+	next := ""
+	if len(keys) > int(pageSize) {
+		next = keys[pageSize]
+		keys = keys[:pageSize]
+	}
+	return stub.keyIterator(stub.State, keys), &pb.QueryResponseMetadata{
+		FetchedRecordsCount: int32(len(keys)),
+		Bookmark:            next,
+	}, nil
+}
+
+func (stub *MockStub) docIterator(docs []*doc, fields []string) (*Iterator, error) {
+	kvs := make([]*queryresult.KV, len(docs))
+	for i, d := range docs {
+		value, err := d.project(fields)
+		if err != nil {
+			return nil, err
+		}
+		kvs[i] = &queryresult.KV{Namespace: stub.Name, Key: d.key, Value: value}
+	}
+	return &Iterator{kvs: kvs}, nil
+}
+
+func (stub *MockStub) keyIterator(state map[string][]byte, keys []string) *Iterator {
+	kvs := make([]*queryresult.KV, len(keys))
+	for i, key := range keys {
+		kvs[i] = &queryresult.KV{Namespace: stub.Name, Key: key, Value: state[key]}
+	}
+	return &Iterator{kvs: kvs}
+}
+
+// page : applies skip and limit, limit = 0 means no limit
+func page(docs []*doc, skip, limit int) []*doc {
+	if skip >= len(docs) {
+		return nil
+	}
+	docs = docs[skip:]
+	if limit > 0 && limit < len(docs) {
+		docs = docs[:limit]
+	}
+	return docs
+}
+
+// sortedKeys : keys of state in [startKey, endKey),
+// empty endKey means no upper bound
+func sortedKeys(state map[string][]byte, startKey, endKey string) []string {
+	keys := make([]string, 0, len(state))
+	for k := range state {
+		if k >= startKey && (endKey == "" || k < endKey) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func simpleKeys(keys []string) []string {
+	out := keys[:0:0]
+	for _, k := range keys {
+		if !strings.HasPrefix(k, compositeKeyNamespace) {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+type bookmarkPosition struct {
+	Key  string        `json:"key"`
+	Sort []interface{} `json:"sort,omitempty"`
+}
+
+func encodeBookmark(key string, sortValues []interface{}) string {
+	raw, _ := json.Marshal(bookmarkPosition{Key: key, Sort: sortValues})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeBookmark(bookmark string, sortFields int) (*bookmarkPosition, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bookmark : %w", err)
+	}
+	var pos bookmarkPosition
+	if err := json.Unmarshal(raw, &pos); err != nil {
+		return nil, fmt.Errorf("invalid bookmark : %w", err)
+	}
+	if len(pos.Sort) != sortFields {
+		return nil, fmt.Errorf("invalid bookmark : sort of query changed")
+	}
+	return &pos, nil
+}
+
+// Iterator : shim.StateQueryIteratorInterface over query results
+type Iterator struct {
+	kvs    []*queryresult.KV
+	next   int
+	closed bool
+}
+
+func (it *Iterator) HasNext() bool {
+	return !it.closed && it.next < len(it.kvs)
+}
+
+func (it *Iterator) Next() (*queryresult.KV, error) {
+	if it.closed {
+		return nil, fmt.Errorf("iterator is closed")
+	}
+	if it.next >= len(it.kvs) {
+		return nil, fmt.Errorf("no more results")
+	}
+	kv := it.kvs[it.next]
+	it.next++
+	return kv, nil
+}
+
+func (it *Iterator) Close() error {
+	it.closed = true
+	return nil
+}
@@ -0,0 +1,303 @@
+package mockstub
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"github.com/stretchr/testify/assert"
+)
+
+type marble struct {
+	DocType string   `json:"docType"`
+	Name    string   `json:"name"`
+	Color   string   `json:"color"`
+	Size    int      `json:"size"`
+	Owner   string   `json:"owner"`
+	Tags    []string `json:"tags,omitempty"`
+	Meta    *meta    `json:"meta,omitempty"`
+}
+
+type meta struct {
+	Origin string `json:"origin"`
+}
+
+var marbles = []marble{
+	{DocType: "marble", Name: "marble1", Color: "blue", Size: 35, Owner: "tom", Tags: []string{"glass"}},
+	{DocType: "marble", Name: "marble2", Color: "red", Size: 50, Owner: "tom", Meta: &meta{Origin: "fr"}},
+	{DocType: "marble", Name: "marble3", Color: "blue", Size: 70, Owner: "jerry", Tags: []string{"glass", "big"}},
+	{DocType: "marble", Name: "marble4", Color: "green", Size: 10, Owner: "jerry", Meta: &meta{Origin: "in"}},
+	{DocType: "marble", Name: "marble5", Color: "red", Size: 90, Owner: "alice"},
+}
+
+func loadMarbles(t *testing.T, stub *MockStub) {
+	stub.MockTransactionStart("load")
+	defer stub.MockTransactionEnd("load")
+	for _, m := range marbles {
+		raw, _ := json.Marshal(m)
+		if err := stub.PutState(m.Name, raw); err != nil {
+			t.Fatal(err)
+		}
+		key, _ := stub.CreateCompositeKey("color~name", []string{m.Color, m.Name})
+		stub.PutState(key, []byte{0x00})
+	}
+	stub.PutState("not-json", []byte("plain value"))
+}
+
+func keys(t *testing.T, iter shim.StateQueryIteratorInterface) []string {
+	defer iter.Close()
+	var out []string
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, kv.Key)
+	}
+	return out
+}
+
+func TestGetQueryResult(t *testing.T) {
+	stub := NewMockStub("marbles", nil)
+	loadMarbles(t, stub)
+
+	for name, tc := range map[string]struct {
+		query string
+		keys  []string
+	}{
+		"ImplicitEq":   {`{"selector":{"owner":"tom"}}`, []string{"marble1", "marble2"}},
+		"Eq":           {`{"selector":{"color":{"$eq":"red"}}}`, []string{"marble2", "marble5"}},
+		"Ne":           {`{"selector":{"color":{"$ne":"blue"}}}`, []string{"marble2", "marble4", "marble5"}},
+		"Range":        {`{"selector":{"size":{"$gt":10,"$lte":70}}}`, []string{"marble1", "marble2", "marble3"}},
+		"In":           {`{"selector":{"owner":{"$in":["alice","jerry"]}}}`, []string{"marble3", "marble4", "marble5"}},
+		"Nin":          {`{"selector":{"owner":{"$nin":["alice","jerry"]}}}`, []string{"marble1", "marble2"}},
+		"InArray":      {`{"selector":{"tags":{"$in":["big"]}}}`, []string{"marble3"}},
+		"Exists":       {`{"selector":{"meta":{"$exists":true}}}`, []string{"marble2", "marble4"}},
+		"NotExists":    {`{"selector":{"tags":{"$exists":false}}}`, []string{"marble2", "marble4", "marble5"}},
+		"Nested":       {`{"selector":{"meta":{"origin":"fr"}}}`, []string{"marble2"}},
+		"Dotted":       {`{"selector":{"meta.origin":{"$gte":"g"}}}`, []string{"marble4"}},
+		"Regex":        {`{"selector":{"owner":{"$regex":"^t"}}}`, []string{"marble1", "marble2"}},
+		"And":          {`{"selector":{"$and":[{"color":"blue"},{"owner":"jerry"}]}}`, []string{"marble3"}},
+		"Or":           {`{"selector":{"$or":[{"color":"green"},{"owner":"alice"}]}}`, []string{"marble4", "marble5"}},
+		"Nor":          {`{"selector":{"$nor":[{"color":"blue"},{"color":"red"}]}}`, []string{"marble4"}},
+		"Not":          {`{"selector":{"$not":{"owner":"tom"},"docType":"marble"}}`, []string{"marble3", "marble4", "marble5"}},
+		"FieldNot":     {`{"selector":{"size":{"$not":{"$gt":40}}}}`, []string{"marble1", "marble4"}},
+		"All":          {`{"selector":{"tags":{"$all":["big","glass"]}}}`, []string{"marble3"}},
+		"Size":         {`{"selector":{"tags":{"$size":1}}}`, []string{"marble1"}},
+		"ElemMatch":    {`{"selector":{"tags":{"$elemMatch":{"$eq":"big"}}}}`, []string{"marble3"}},
+		"Type":         {`{"selector":{"tags":{"$type":"array"}}}`, []string{"marble1", "marble3"}},
+		"Mod":          {`{"selector":{"size":{"$mod":[20,15]}}}`, []string{"marble1"}},
+		"ID":           {`{"selector":{"_id":{"$gt":"marble3"}}}`, []string{"marble4", "marble5"}},
+		"SortDesc":     {`{"selector":{"docType":"marble"},"sort":[{"size":"desc"}]}`, []string{"marble5", "marble3", "marble2", "marble1", "marble4"}},
+		"SortMulti":    {`{"selector":{"docType":"marble"},"sort":["color",{"size":"desc"}]}`, []string{"marble3", "marble1", "marble4", "marble5", "marble2"}},
+		"SortIndexed":  {`{"selector":{"docType":"marble"},"sort":["meta.origin"]}`, []string{"marble2", "marble4"}},
+		"LimitSkip":    {`{"selector":{"docType":"marble"},"sort":["size"],"limit":2,"skip":1}`, []string{"marble1", "marble2"}},
+		"UseIndex":     {`{"selector":{"owner":"alice"},"use_index":["_design/indexOwnerDoc","indexOwner"]}`, []string{"marble5"}},
+		"MissingField": {`{"selector":{"weight":{"$lt":10}}}`, nil},
+	} {
+		t.Run(name, func(t *testing.T) {
+			iter, err := stub.GetQueryResult(tc.query)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, tc.keys, keys(t, iter))
+		})
+	}
+}
+
+func TestGetQueryResultFields(t *testing.T) {
+	is := assert.New(t)
+	stub := NewMockStub("marbles", nil)
+	loadMarbles(t, stub)
+
+	iter, err := stub.GetQueryResult(`{"selector":{"name":"marble2"},"fields":["owner","meta.origin"]}`)
+	is.NoError(err)
+	kv, err := iter.Next()
+	is.NoError(err)
+	is.JSONEq(`{"owner":"tom","meta":{"origin":"fr"}}`, string(kv.Value))
+	is.False(iter.HasNext())
+}
+
+func TestGetQueryResultInvalid(t *testing.T) {
+	stub := NewMockStub("marbles", nil)
+	loadMarbles(t, stub)
+
+	for name, query := range map[string]string{
+		"NotJSON":         `selector`,
+		"NoSelector":      `{"sort":["size"]}`,
+		"UnknownKey":      `{"selector":{},"selecter":{}}`,
+		"UnknownOperator": `{"selector":{"size":{"$near":1}}}`,
+		"InvalidIn":       `{"selector":{"size":{"$in":1}}}`,
+		"InvalidRegex":    `{"selector":{"owner":{"$regex":"("}}}`,
+		"InvalidSort":     `{"selector":{},"sort":[{"size":"up"}]}`,
+		"InvalidAnd":      `{"selector":{"$and":{"size":1}}}`,
+	} {
+		_, err := stub.GetQueryResult(query)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestGetQueryResultWithPagination(t *testing.T) {
+	is := assert.New(t)
+	stub := NewMockStub("marbles", nil)
+	loadMarbles(t, stub)
+
+	query := `{"selector":{"docType":"marble"},"sort":[{"size":"desc"}],"limit":100}`
+	var got []string
+	bookmark := ""
+	for i := 0; i < 5; i++ {
+		iter, meta, err := stub.GetQueryResultWithPagination(query, 2, bookmark)
+		is.NoError(err)
+		page := keys(t, iter)
+		is.Equal(int32(len(page)), meta.FetchedRecordsCount)
+		got = append(got, page...)
+		bookmark = meta.Bookmark
+		if len(page) < 2 {
+			break
+		}
+	}
+	is.Equal([]string{"marble5", "marble3", "marble2", "marble1", "marble4"}, got)
+
+	t.Run("Skip", func(t *testing.T) {
+		query := `{"selector":{"docType":"marble"},"sort":[{"size":"desc"}],"skip":1}`
+		var got []string
+		bookmark := ""
+		for i := 0; i < 5; i++ {
+			iter, meta, err := stub.GetQueryResultWithPagination(query, 2, bookmark)
+			is.NoError(err)
+			page := keys(t, iter)
+			got = append(got, page...)
+			bookmark = meta.Bookmark
+			if len(page) < 2 {
+				break
+			}
+		}
+		is.Equal([]string{"marble3", "marble2", "marble1", "marble4"}, got)
+	})
+
+	t.Run("StableOnInsert", func(t *testing.T) {
+		iter, meta, err := stub.GetQueryResultWithPagination(query, 2, "")
+		is.NoError(err)
+		is.Equal([]string{"marble5", "marble3"}, keys(t, iter))
+
+		// a marble inserted before the bookmark is not returned by later pages
+		stub.MockTransactionStart("insert")
+		stub.PutState("marble6", []byte(`{"docType":"marble","name":"marble6","size":95}`))
+		stub.MockTransactionEnd("insert")
+
+		iter, _, err = stub.GetQueryResultWithPagination(query, 2, meta.Bookmark)
+		is.NoError(err)
+		is.Equal([]string{"marble2", "marble1"}, keys(t, iter))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, _, err := stub.GetQueryResultWithPagination(query, 0, "")
+		is.Error(err)
+		_, _, err = stub.GetQueryResultWithPagination(query, 2, "not-a-bookmark")
+		is.Error(err)
+		_, _, err = stub.GetQueryResultWithPagination(`{"selector":{}}`, 2, encodeBookmark("marble1", []interface{}{35.0}))
+		is.Error(err)
+	})
+}
+
+func TestRangeWithPagination(t *testing.T) {
+	is := assert.New(t)
+	stub := NewMockStub("marbles", nil)
+	loadMarbles(t, stub)
+
+	iter, meta, err := stub.GetStateByRangeWithPagination("marble1", "marble5", 3, "")
+	is.NoError(err)
+	is.Equal([]string{"marble1", "marble2", "marble3"}, keys(t, iter))
+	is.Equal("marble4", meta.Bookmark)
+
+	iter, meta, err = stub.GetStateByRangeWithPagination("marble1", "marble5", 3, meta.Bookmark)
+	is.NoError(err)
+	is.Equal([]string{"marble4"}, keys(t, iter))
+	is.Empty(meta.Bookmark)
+
+	iter, meta, err = stub.GetStateByPartialCompositeKeyWithPagination("color~name", []string{"blue"}, 1, "")
+	is.NoError(err)
+	is.Len(keys(t, iter), 1)
+	is.NotEmpty(meta.Bookmark)
+	iter, meta, err = stub.GetStateByPartialCompositeKeyWithPagination("color~name", []string{"blue"}, 1, meta.Bookmark)
+	is.NoError(err)
+	ks := keys(t, iter)
+	is.Len(ks, 1)
+	_, attrs, _ := stub.SplitCompositeKey(ks[0])
+	is.Equal([]string{"blue", "marble3"}, attrs)
+	is.Empty(meta.Bookmark)
+}
+
+func TestPrivateData(t *testing.T) {
+	is := assert.New(t)
+	stub := NewMockStub("marbles", nil)
+	stub.MockTransactionStart("tx")
+	for i := 1; i <= 3; i++ {
+		stub.PutPrivateData("pvt", fmt.Sprintf("key%d", i), []byte(fmt.Sprintf(`{"value":%d}`, i)))
+	}
+	stub.MockTransactionEnd("tx")
+
+	iter, err := stub.GetPrivateDataQueryResult("pvt", `{"selector":{"value":{"$gte":2}}}`)
+	is.NoError(err)
+	is.Equal([]string{"key2", "key3"}, keys(t, iter))
+
+	iter, err = stub.GetPrivateDataByRange("pvt", "key1", "key3")
+	is.NoError(err)
+	is.Equal([]string{"key1", "key2"}, keys(t, iter))
+
+	is.Error(stub.DelPrivateData("pvt", "key1"))
+	stub.MockTransactionStart("tx")
+	is.NoError(stub.DelPrivateData("pvt", "key1"))
+	stub.MockTransactionEnd("tx")
+	raw, err := stub.GetPrivateData("pvt", "key1")
+	is.NoError(err)
+	is.Nil(raw)
+}
+
+// ownerCC : queries marbles of owner, and
+// counts them through another chaincode if asked
+type ownerCC struct{}
+
+func (ownerCC) Init(shim.ChaincodeStubInterface) pb.Response {
+	return shim.Success(nil)
+}
+
+func (ownerCC) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+	fn, args := stub.GetFunctionAndParameters()
+	switch fn {
+	case "byOwner":
+		iter, err := stub.GetQueryResult(fmt.Sprintf(`{"selector":{"owner":%q}}`, args[0]))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		defer iter.Close()
+		var names []string
+		for iter.HasNext() {
+			kv, _ := iter.Next()
+			names = append(names, kv.Key)
+		}
+		raw, _ := json.Marshal(names)
+		return shim.Success(raw)
+	case "remote":
+		return stub.InvokeChaincode(args[0], [][]byte{[]byte("byOwner"), []byte(args[1])}, "")
+	}
+	return shim.Error("method not supported")
+}
+
+func TestMockInvoke(t *testing.T) {
+	is := assert.New(t)
+	marblesStub := NewMockStub("marbles", ownerCC{})
+	loadMarbles(t, marblesStub)
+	callerStub := NewMockStub("caller", ownerCC{})
+	callerStub.MockPeerChaincode("marbles", marblesStub, "")
+
+	resp := marblesStub.MockInvoke("tx-1", [][]byte{[]byte("byOwner"), []byte("jerry")})
+	is.Equal(int32(shim.OK), resp.Status, resp.Message)
+	is.JSONEq(`["marble3","marble4"]`, string(resp.Payload))
+
+	resp = callerStub.MockInvoke("tx-2", [][]byte{[]byte("remote"), []byte("marbles"), []byte("alice")})
+	is.Equal(int32(shim.OK), resp.Status, resp.Message)
+	is.JSONEq(`["marble5"]`, string(resp.Payload))
+}
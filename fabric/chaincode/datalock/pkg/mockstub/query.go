@@ -0,0 +1,465 @@
+package mockstub
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// query : subset of CouchDB mango query, use_index is accepted and ignored
+type query struct {
+	Selector map[string]interface{} `json:"selector"`
+	Sort     []interface{}          `json:"sort"`
+	Limit    int                    `json:"limit"`
+	Skip     int                    `json:"skip"`
+	Fields   []string               `json:"fields"`
+	UseIndex interface{}            `json:"use_index"`
+}
+
+type sortField struct {
+	path string
+	desc bool
+}
+
+// doc : state entry holding a json object
+type doc struct {
+	key   string
+	value []byte
+	obj   map[string]interface{}
+	sort  []interface{}
+}
+
+func parseQuery(raw string) (*query, []sortField, error) {
+	var q query
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&q); err != nil {
+		return nil, nil, fmt.Errorf("invalid query : %w", err)
+	}
+	if q.Selector == nil {
+		return nil, nil, fmt.Errorf("invalid query : selector is required")
+	}
+	if q.Limit < 0 || q.Skip < 0 {
+		return nil, nil, fmt.Errorf("invalid query : limit and skip must be positive")
+	}
+	fields := make([]sortField, 0, len(q.Sort))
+	for _, s := range q.Sort {
+		switch s := s.(type) {
+		case string:
+			fields = append(fields, sortField{path: s})
+		case map[string]interface{}:
+			if len(s) != 1 {
+				return nil, nil, fmt.Errorf("invalid sort : %v, require {field : direction}", s)
+			}
+			for path, dir := range s {
+				switch dir {
+				case "asc":
+					fields = append(fields, sortField{path: path})
+				case "desc":
+					fields = append(fields, sortField{path: path, desc: true})
+				default:
+					return nil, nil, fmt.Errorf("invalid sort direction = %v", dir)
+				}
+			}
+		default:
+			return nil, nil, fmt.Errorf("invalid sort : %v", s)
+		}
+	}
+	return &q, fields, nil
+}
+
+// execute : returns documents of state (sorted by key) matching q,
+// ordered by sortBy then key. Documents missing a sort field are
+// left out, as CouchDB only sorts on indexed documents
+func execute(state map[string][]byte, q *query, sortBy []sortField) ([]*doc, error) {
+	var docs []*doc
+	for _, key := range sortedKeys(state, "", "") {
+		var obj map[string]interface{}
+		if json.Unmarshal(state[key], &obj) != nil || obj == nil {
+			// not a json document, binary values are stored as attachments
+			continue
+		}
+		obj["_id"] = key
+		ok, err := matchSelector(obj, q.Selector)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		d := &doc{key: key, value: state[key], obj: obj}
+		indexed := true
+		for _, f := range sortBy {
+			v, ok := lookup(obj, f.path)
+			if !ok {
+				indexed = false
+				break
+			}
+			d.sort = append(d.sort, v)
+		}
+		if indexed {
+			docs = append(docs, d)
+		}
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		return compareDocs(docs[i].sort, docs[i].key, docs[j].sort, docs[j].key, sortBy) < 0
+	})
+	return docs, nil
+}
+
+func compareDocs(aSort []interface{}, aKey string, bSort []interface{}, bKey string, sortBy []sortField) int {
+	for i, f := range sortBy {
+		c := collate(aSort[i], bSort[i])
+		if f.desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return strings.Compare(aKey, bKey)
+}
+
+// project : keeps only fields of the document
+func (d *doc) project(fields []string) ([]byte, error) {
+	if len(fields) == 0 {
+		return d.value, nil
+	}
+	out := map[string]interface{}{}
+	for _, f := range fields {
+		v, ok := lookup(d.obj, f)
+		if !ok || f == "_id" {
+			continue
+		}
+		parts := strings.Split(f, ".")
+		m := out
+		for _, p := range parts[:len(parts)-1] {
+			next, ok := m[p].(map[string]interface{})
+			if !ok {
+				next = map[string]interface{}{}
+				m[p] = next
+			}
+			m = next
+		}
+		m[parts[len(parts)-1]] = v
+	}
+	return json.Marshal(out)
+}
+
+func lookup(obj map[string]interface{}, path string) (interface{}, bool) {
+	var cur interface{} = obj
+	for _, p := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		cur, ok = m[p]
+		if !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+func matchSelector(obj map[string]interface{}, selector map[string]interface{}) (bool, error) {
+	for field, cond := range selector {
+		var ok bool
+		var err error
+		switch field {
+		case "$and", "$or", "$nor":
+			ok, err = matchCombination(obj, field, cond)
+		case "$not":
+			sub, isObj := cond.(map[string]interface{})
+			if !isObj {
+				return false, fmt.Errorf("invalid operand of $not : %v", cond)
+			}
+			ok, err = matchSelector(obj, sub)
+			ok = !ok
+		default:
+			if strings.HasPrefix(field, "$") {
+				return false, fmt.Errorf("unsupported operator = %s", field)
+			}
+			value, exists := lookup(obj, field)
+			ok, err = matchCondition(value, exists, cond)
+		}
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchCombination(obj map[string]interface{}, op string, cond interface{}) (bool, error) {
+	selectors, ok := cond.([]interface{})
+	if !ok {
+		return false, fmt.Errorf("invalid operand of %s : %v, require array", op, cond)
+	}
+	matched := 0
+	for _, s := range selectors {
+		sub, ok := s.(map[string]interface{})
+		if !ok {
+			return false, fmt.Errorf("invalid operand of %s : %v", op, s)
+		}
+		ok, err := matchSelector(obj, sub)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			matched++
+		}
+	}
+	switch op {
+	case "$and":
+		return matched == len(selectors), nil
+	case "$or":
+		return matched > 0, nil
+	default: // $nor
+		return matched == 0, nil
+	}
+}
+
+func isOperatorObject(cond interface{}) (map[string]interface{}, bool) {
+	m, ok := cond.(map[string]interface{})
+	if !ok || len(m) == 0 {
+		return nil, false
+	}
+	for k := range m {
+		if !strings.HasPrefix(k, "$") {
+			return nil, false
+		}
+	}
+	return m, true
+}
+
+// matchCondition : matches field value with cond, which is either
+// an operator object, a nested selector or an implicit $eq
+func matchCondition(value interface{}, exists bool, cond interface{}) (bool, error) {
+	ops, ok := isOperatorObject(cond)
+	if !ok {
+		if nested, isObj := cond.(map[string]interface{}); isObj && len(nested) != 0 {
+			sub, isObj := value.(map[string]interface{})
+			if !exists || !isObj {
+				return false, nil
+			}
+			return matchSelector(sub, nested)
+		}
+		return exists && collate(value, cond) == 0, nil
+	}
+	for op, arg := range ops {
+		ok, err := matchOperator(value, exists, op, arg)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchOperator(value interface{}, exists bool, op string, arg interface{}) (bool, error) {
+	switch op {
+	case "$exists":
+		want, ok := arg.(bool)
+		if !ok {
+			return false, fmt.Errorf("invalid operand of $exists : %v, require boolean", arg)
+		}
+		return exists == want, nil
+	case "$not":
+		ok, err := matchCondition(value, exists, arg)
+		return !ok, err
+	}
+	if !exists {
+		return false, nil
+	}
+	switch op {
+	case "$eq":
+		return collate(value, arg) == 0, nil
+	case "$ne":
+		return collate(value, arg) != 0, nil
+	case "$gt":
+		return collate(value, arg) > 0, nil
+	case "$gte":
+		return collate(value, arg) >= 0, nil
+	case "$lt":
+		return collate(value, arg) < 0, nil
+	case "$lte":
+		return collate(value, arg) <= 0, nil
+	case "$in", "$nin":
+		list, ok := arg.([]interface{})
+		if !ok {
+			return false, fmt.Errorf("invalid operand of %s : %v, require array", op, arg)
+		}
+		in := containsAny(list, value)
+		if op == "$in" {
+			return in, nil
+		}
+		return !in, nil
+	case "$all":
+		list, ok := arg.([]interface{})
+		values, isArray := value.([]interface{})
+		if !ok {
+			return false, fmt.Errorf("invalid operand of $all : %v, require array", arg)
+		}
+		if !isArray {
+			return false, nil
+		}
+		for _, want := range list {
+			if !containsAny(values, want) {
+				return false, nil
+			}
+		}
+		return true, nil
+	case "$size":
+		n, ok := arg.(float64)
+		values, isArray := value.([]interface{})
+		if !ok {
+			return false, fmt.Errorf("invalid operand of $size : %v, require integer", arg)
+		}
+		return isArray && float64(len(values)) == n, nil
+	case "$elemMatch", "$allMatch":
+		values, isArray := value.([]interface{})
+		if !isArray || len(values) == 0 {
+			return false, nil
+		}
+		matched := 0
+		for _, v := range values {
+			ok, err := matchCondition(v, true, arg)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				matched++
+			}
+		}
+		if op == "$elemMatch" {
+			return matched > 0, nil
+		}
+		return matched == len(values), nil
+	case "$regex":
+		pattern, ok := arg.(string)
+		if !ok {
+			return false, fmt.Errorf("invalid operand of $regex : %v, require string", arg)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return false, fmt.Errorf("invalid operand of $regex : %w", err)
+		}
+		s, isString := value.(string)
+		return isString && re.MatchString(s), nil
+	case "$type":
+		return typeName(value) == arg, nil
+	case "$mod":
+		args, ok := arg.([]interface{})
+		if !ok || len(args) != 2 {
+			return false, fmt.Errorf("invalid operand of $mod : %v, require [divisor, remainder]", arg)
+		}
+		divisor, ok1 := args[0].(float64)
+		remainder, ok2 := args[1].(float64)
+		if !ok1 || !ok2 || divisor == 0 {
+			return false, fmt.Errorf("invalid operand of $mod : %v", arg)
+		}
+		n, isNumber := value.(float64)
+		return isNumber && n == math.Trunc(n) && math.Mod(n, divisor) == remainder, nil
+	}
+	return false, fmt.Errorf("unsupported operator = %s", op)
+}
+
+// containsAny : list contains value, or any element of value if it is an array
+func containsAny(list []interface{}, value interface{}) bool {
+	candidates := []interface{}{value}
+	if values, ok := value.([]interface{}); ok {
+		candidates = values
+	}
+	for _, c := range candidates {
+		for _, v := range list {
+			if collate(c, v) == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func typeRank(v interface{}) int {
+	switch v := v.(type) {
+	case nil:
+		return 0
+	case bool:
+		if v {
+			return 2
+		}
+		return 1
+	case float64:
+		return 3
+	case string:
+		return 4
+	case []interface{}:
+		return 5
+	default:
+		return 6
+	}
+}
+
+// collate : CouchDB view collation, null < false < true < numbers
+// < strings < arrays < objects. Strings are compared bytewise
+// rather than with ICU collation
+func collate(a, b interface{}) int {
+	ra, rb := typeRank(a), typeRank(b)
+	if ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
+	switch a := a.(type) {
+	case float64:
+		b := b.(float64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(a, b.(string))
+	case []interface{}:
+		b := b.([]interface{})
+		for i := 0; i < len(a) && i < len(b); i++ {
+			if c := collate(a[i], b[i]); c != 0 {
+				return c
+			}
+		}
+		switch {
+		case len(a) < len(b):
+			return -1
+		case len(a) > len(b):
+			return 1
+		}
+		return 0
+	case map[string]interface{}:
+		rawA, _ := json.Marshal(a)
+		rawB, _ := json.Marshal(b)
+		return bytes.Compare(rawA, rawB)
+	}
+	return 0
+}
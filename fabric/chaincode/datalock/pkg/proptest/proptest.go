@@ -0,0 +1,167 @@
+// Package proptest : property based testing of state machines
+// random sequences of commands are replayed against a fresh
+// system, a failing sequence is shrunk to a minimal one
+package proptest
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// SeedEnv : environment variable overriding Config.Seed,
+// used to reproduce a failure reported by Check
+const SeedEnv = "PROPTEST_SEED"
+
+// Config : of a property run
+type Config struct {
+	// Seed : of the first run, run i uses Seed+i
+	// if zero, current time is used
+	Seed int64
+	// Runs : number of random sequences, default 100
+	Runs int
+	// Steps : maximum length of a sequence, default 50
+	Steps int
+}
+
+// Property : of a system driven by commands of type C
+type Property[C any] struct {
+	// Gen : random command
+	Gen func(rnd *rand.Rand) C
+	// Shrink : optional, strictly simpler variants of a command
+	Shrink func(cmd C) []C
+	// Check : replays cmds against a fresh system,
+	// returning error of the first step breaking an invariant
+	Check func(cmds []C) error
+}
+
+// Failure : minimal failing sequence found by Run
+type Failure[C any] struct {
+	// Seed : of the failing run
+	Seed int64
+	// Original : sequence before shrinking
+	Original []C
+	// Cmds : shrunk sequence
+	Cmds []C
+	// Err : returned by Property.Check for Cmds
+	Err error
+	// Shrinks : number of successful shrink steps
+	Shrinks int
+}
+
+func (f *Failure[C]) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "property failed (seed %d, %d steps, shrunk from %d in %d steps) : %v",
+		f.Seed, len(f.Cmds), len(f.Original), f.Shrinks, f.Err)
+	for i, cmd := range f.Cmds {
+		fmt.Fprintf(&sb, "\n  %d. %v", i+1, cmd)
+	}
+	return sb.String()
+}
+
+func (cfg Config) withDefaults() Config {
+	if seed, err := strconv.ParseInt(os.Getenv(SeedEnv), 10, 64); err == nil {
+		cfg.Seed = seed
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+	}
+	if cfg.Runs <= 0 {
+		cfg.Runs = 100
+	}
+	if cfg.Steps <= 0 {
+		cfg.Steps = 50
+	}
+	return cfg
+}
+
+// Run : checks p against cfg.Runs random sequences,
+// returning the shrunk failure of the first failing one
+func Run[C any](cfg Config, p Property[C]) *Failure[C] {
+	cfg = cfg.withDefaults()
+	for i := 0; i < cfg.Runs; i++ {
+		seed := cfg.Seed + int64(i)
+		rnd := rand.New(rand.NewSource(seed))
+		cmds := make([]C, 1+rnd.Intn(cfg.Steps))
+		for j := range cmds {
+			cmds[j] = p.Gen(rnd)
+		}
+		err := p.Check(cmds)
+		if err == nil {
+			continue
+		}
+		f := &Failure[C]{Seed: seed, Original: cmds, Cmds: cmds, Err: err}
+		shrink(p, f)
+		return f
+	}
+	return nil
+}
+
+// Check : Run reporting failure on t. The seed is logged
+// first, so that any failure of the test can be replayed
+func Check[C any](t testing.TB, cfg Config, p Property[C]) {
+	t.Helper()
+	cfg = cfg.withDefaults()
+	t.Logf("proptest seed %d, replay with %s=%d", cfg.Seed, SeedEnv, cfg.Seed)
+	if f := Run(cfg, p); f != nil {
+		t.Fatalf("%v\nreproduce with %s=%d", f, SeedEnv, f.Seed)
+	}
+}
+
+// shrink : replaces f.Cmds by shorter or simpler
+// sequences as long as they still fail
+func shrink[C any](p Property[C], f *Failure[C]) {
+	try := func(cmds []C) bool {
+		err := p.Check(cmds)
+		if err == nil {
+			return false
+		}
+		f.Cmds, f.Err = cmds, err
+		f.Shrinks++
+		return true
+	}
+
+	// shortest failing prefix
+	for n := 1; n < len(f.Cmds); n++ {
+		if try(clone(f.Cmds[:n])) {
+			break
+		}
+	}
+
+	for progress := true; progress; {
+		progress = false
+		// remove chunks, halving their size
+		for size := len(f.Cmds) / 2; size >= 1; size /= 2 {
+			for start := 0; start+size <= len(f.Cmds); {
+				cmds := append(clone(f.Cmds[:start]), f.Cmds[start+size:]...)
+				if len(cmds) != 0 && try(cmds) {
+					progress = true
+					continue
+				}
+				start++
+			}
+		}
+		// simplify single commands
+		if p.Shrink == nil {
+			continue
+		}
+		for i := range f.Cmds {
+			for _, simpler := range p.Shrink(f.Cmds[i]) {
+				cmds := clone(f.Cmds)
+				cmds[i] = simpler
+				if try(cmds) {
+					progress = true
+					break
+				}
+			}
+		}
+	}
+}
+
+func clone[C any](cmds []C) []C {
+	return append([]C(nil), cmds...)
+}
@@ -0,0 +1,127 @@
+package proptest
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// counter : buggy counter bounded at 3, overflows
+// when incremented at 3 right after an increment
+type counter struct{ n, last int }
+
+func (c *counter) apply(cmd int) error {
+	switch {
+	case cmd > 0:
+		if c.n == 3 && c.last > 0 {
+			c.n = 10
+		} else if c.n < 3 {
+			c.n++
+		}
+	case cmd < 0 && c.n > 0:
+		c.n--
+	}
+	c.last = cmd
+	if c.n > 3 {
+		return fmt.Errorf("counter at %d", c.n)
+	}
+	return nil
+}
+
+func counterProperty() Property[int] {
+	return Property[int]{
+		Gen: func(rnd *rand.Rand) int { return rnd.Intn(21) - 10 },
+		Shrink: func(cmd int) []int {
+			switch {
+			case cmd > 1:
+				return []int{1}
+			case cmd < -1:
+				return []int{-1}
+			}
+			return nil
+		},
+		Check: func(cmds []int) error {
+			c := new(counter)
+			for _, cmd := range cmds {
+				if err := c.apply(cmd); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func TestRunShrinks(t *testing.T) {
+	is := assert.New(t)
+	t.Setenv(SeedEnv, "")
+
+	f := Run(Config{Seed: 1, Runs: 200, Steps: 40}, counterProperty())
+	if !is.NotNil(f) {
+		return
+	}
+	is.Equal([]int{1, 1, 1, 1}, f.Cmds)
+	is.EqualError(f.Err, "counter at 10")
+	is.GreaterOrEqual(len(f.Original), len(f.Cmds))
+	is.Contains(f.Error(), "\n  4. 1")
+
+	// failing seed reproduces the same failure
+	again := Run(Config{Seed: f.Seed, Runs: 1, Steps: 40}, counterProperty())
+	is.Equal(f, again)
+}
+
+func TestRunPasses(t *testing.T) {
+	t.Setenv(SeedEnv, "")
+	p := counterProperty()
+	p.Gen = func(rnd *rand.Rand) int { return -rnd.Intn(10) }
+	assert.Nil(t, Run(Config{Runs: 50}, p))
+}
+
+func TestSeedEnv(t *testing.T) {
+	is := assert.New(t)
+	t.Setenv(SeedEnv, "42")
+	is.Equal(int64(42), Config{Seed: 7}.withDefaults().Seed)
+
+	t.Setenv(SeedEnv, "")
+	cfg := Config{Seed: 7}.withDefaults()
+	is.Equal(int64(7), cfg.Seed)
+	is.Equal(100, cfg.Runs)
+	is.Equal(50, cfg.Steps)
+}
+
+// recorder : records what Check logs and reports
+type recorder struct {
+	testing.TB
+	logs   []string
+	failed string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Logf(format string, args ...interface{}) {
+	r.logs = append(r.logs, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.failed = fmt.Sprintf(format, args...)
+}
+
+func TestCheckLogsSeed(t *testing.T) {
+	is := assert.New(t)
+	t.Setenv(SeedEnv, "")
+	r := &recorder{TB: t}
+	Check(r, Config{Seed: 1, Runs: 200, Steps: 40}, counterProperty())
+	is.Equal([]string{"proptest seed 1, replay with PROPTEST_SEED=1"}, r.logs)
+	is.Contains(r.failed, "reproduce with PROPTEST_SEED=")
+
+	// time based seed is logged as well
+	r = &recorder{TB: t}
+	p := counterProperty()
+	p.Gen = func(rnd *rand.Rand) int { return 0 }
+	Check(r, Config{Runs: 1}, p)
+	is.Len(r.logs, 1)
+	is.NotContains(r.logs[0], "seed 0,")
+	is.Empty(r.failed)
+}
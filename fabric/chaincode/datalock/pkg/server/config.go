@@ -0,0 +1,147 @@
+// Package server : runs datalock either launched by the peer
+// or as an external chaincode service (CCaaS)
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"datalock/pkg/logger"
+	"datalock/pkg/metrics"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+type Mode string
+
+const (
+	// ModePeer : chaincode is launched by the peer and connects to it
+	ModePeer Mode = "peer"
+	// ModeServer : chaincode runs as a service the peer connects to
+	ModeServer Mode = "server"
+)
+
+const defaultShutdownTimeout = 10 * time.Second
+
+// Config : how datalock is run, see ConfigFromEnv
+type Config struct {
+	Mode Mode
+	// CCID : package id of chaincode installed on the peer
+	CCID string
+	// Address : listen address of chaincode server
+	Address string
+	// TLS is disabled unless CertFile is set,
+	// ClientCAFile enables client authentication
+	KeyFile      string
+	CertFile     string
+	ClientCAFile string
+	// OperationsAddress : listen address of /healthz and /metrics,
+	// disabled if empty
+	OperationsAddress string
+	// ShutdownTimeout : time given to in-flight requests on shutdown
+	ShutdownTimeout time.Duration
+	// Log : logger of the server, one configured from
+	// the environment if nil
+	Log *logger.Logger
+	// Metrics : served at /metrics, the registry the
+	// chaincode records its metrics in
+	Metrics *metrics.Registry
+}
+
+// ConfigFromEnv : reads config from CHAINCODE_SERVER_MODE, CHAINCODE_CCID,
+// CHAINCODE_ADDRESS, CHAINCODE_TLS_KEY_FILE, CHAINCODE_TLS_CERT_FILE,
+// CHAINCODE_TLS_CLIENT_CA_FILE, DATALOCK_OPERATIONS_ADDRESS and
+// DATALOCK_SHUTDOWN_TIMEOUT. Mode defaults to server when
+// CHAINCODE_ADDRESS is set, peer otherwise
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Mode:              Mode(os.Getenv("CHAINCODE_SERVER_MODE")),
+		CCID:              os.Getenv("CHAINCODE_CCID"),
+		Address:           os.Getenv("CHAINCODE_ADDRESS"),
+		KeyFile:           os.Getenv("CHAINCODE_TLS_KEY_FILE"),
+		CertFile:          os.Getenv("CHAINCODE_TLS_CERT_FILE"),
+		ClientCAFile:      os.Getenv("CHAINCODE_TLS_CLIENT_CA_FILE"),
+		OperationsAddress: os.Getenv("DATALOCK_OPERATIONS_ADDRESS"),
+		ShutdownTimeout:   defaultShutdownTimeout,
+	}
+	if cfg.Mode == "" {
+		cfg.Mode = ModePeer
+		if cfg.Address != "" {
+			cfg.Mode = ModeServer
+		}
+	}
+	if d, err := time.ParseDuration(os.Getenv("DATALOCK_SHUTDOWN_TIMEOUT")); err == nil {
+		cfg.ShutdownTimeout = d
+	}
+	return cfg
+}
+
+// RegisterFlags : registers flags overriding current values of cfg
+func (cfg *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.Func("mode", "peer or server (default "+string(cfg.Mode)+")", func(s string) error {
+		cfg.Mode = Mode(s)
+		return nil
+	})
+	fs.StringVar(&cfg.CCID, "ccid", cfg.CCID, "chaincode package id")
+	fs.StringVar(&cfg.Address, "address", cfg.Address, "chaincode server listen address")
+	fs.StringVar(&cfg.KeyFile, "tls-key", cfg.KeyFile, "chaincode server TLS key file")
+	fs.StringVar(&cfg.CertFile, "tls-cert", cfg.CertFile, "chaincode server TLS certificate file")
+	fs.StringVar(&cfg.ClientCAFile, "tls-client-ca", cfg.ClientCAFile, "CA file of peer client certificates")
+	fs.StringVar(&cfg.OperationsAddress, "operations-address", cfg.OperationsAddress, "listen address of /healthz and /metrics")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "time given to in-flight requests on shutdown")
+}
+
+// Validate : reports first invalid setting of cfg
+func (cfg Config) Validate() error {
+	switch cfg.Mode {
+	case ModePeer:
+		return nil
+	case ModeServer:
+	default:
+		return fmt.Errorf("invalid mode = %s, require %s or %s", cfg.Mode, ModePeer, ModeServer)
+	}
+	if cfg.CCID == "" {
+		return fmt.Errorf("chaincode id is required in %s mode", ModeServer)
+	}
+	if cfg.Address == "" {
+		return fmt.Errorf("chaincode address is required in %s mode", ModeServer)
+	}
+	if (cfg.KeyFile == "") != (cfg.CertFile == "") {
+		return fmt.Errorf("both TLS key and certificate files are required")
+	}
+	if cfg.ClientCAFile != "" && cfg.CertFile == "" {
+		return fmt.Errorf("client CA file requires TLS to be enabled")
+	}
+	return nil
+}
+
+// TLSConfig : server TLS config loaded from files,
+// nil if TLS is disabled
+func (cfg Config) TLSConfig() (*tls.Config, error) {
+	if cfg.CertFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS key pair : %w", err)
+	}
+	tlsCfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.NoClientCert,
+	}
+	if cfg.ClientCAFile != "" {
+		raw, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file : %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(raw) {
+			return nil, fmt.Errorf("no certificate found in client CA file = %s", cfg.ClientCAFile)
+		}
+		tlsCfg.ClientCAs = pool
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsCfg, nil
+}
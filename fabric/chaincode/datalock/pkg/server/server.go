@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"datalock/pkg/logger"
+	"datalock/pkg/metrics"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+)
+
+// Run : runs cc until ctx is done or the chaincode stops.
+// In server mode in-flight requests are given
+// cfg.ShutdownTimeout to finish
+func Run(ctx context.Context, cc shim.Chaincode, cfg Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	log := cfg.Log
+	if log == nil {
+		log = logger.New(logger.ConfigFromEnv())
+	}
+	var healthy atomic.Bool
+	if cfg.OperationsAddress != "" {
+		registry := cfg.Metrics
+		if registry == nil {
+			registry = metrics.NewRegistry()
+		}
+		ops, err := startOperations(log, cfg.OperationsAddress, registry, &healthy)
+		if err != nil {
+			return err
+		}
+		defer ops.Close()
+	}
+	if cfg.Mode == ModePeer {
+		return runPeer(ctx, log, cc, &healthy)
+	}
+	return runServer(ctx, log, cc, cfg, &healthy)
+}
+
+func runPeer(ctx context.Context, log *logger.Logger, cc shim.Chaincode, healthy *atomic.Bool) error {
+	log.Infof("Starting chaincode, connecting to peer")
+	done := make(chan error, 1)
+	go func() { done <- shim.Start(cc) }()
+	healthy.Store(true)
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		// peer stream is closed on exit
+		return nil
+	}
+}
+
+func runServer(ctx context.Context, log *logger.Logger, cc shim.Chaincode, cfg Config, healthy *atomic.Bool) error {
+	tlsCfg, err := cfg.TLSConfig()
+	if err != nil {
+		return err
+	}
+	opts := []grpc.ServerOption{
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    time.Minute,
+			Timeout: 20 * time.Second,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             time.Minute,
+			PermitWithoutStream: true,
+		}),
+	}
+	if tlsCfg != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+	}
+
+	lis, err := net.Listen("tcp", cfg.Address)
+	if err != nil {
+		return fmt.Errorf("failed to listen at %s : %w", cfg.Address, err)
+	}
+	srv := grpc.NewServer(opts...)
+	pb.RegisterChaincodeServer(srv, &shim.ChaincodeServer{CCID: cfg.CCID, CC: cc})
+
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(lis) }()
+	healthy.Store(true)
+	log.Infof("Starting chaincode server at %s, tls = %t", lis.Addr(), tlsCfg != nil)
+
+	select {
+	case err := <-done:
+		healthy.Store(false)
+		return err
+	case <-ctx.Done():
+	}
+
+	healthy.Store(false)
+	log.Infof("Stopping chaincode server")
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(cfg.ShutdownTimeout):
+		log.Warnf("shutdown timeout of %s exceeded, closing open streams", cfg.ShutdownTimeout)
+		srv.Stop()
+	}
+	return nil
+}
+
+// startOperations : serves /healthz and registry
+// as /metrics at addr
+func startOperations(log *logger.Logger, addr string, registry *metrics.Registry, healthy *atomic.Bool) (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		status, code := "OK", http.StatusOK
+		if !healthy.Load() {
+			status, code = "UNAVAILABLE", http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(map[string]string{"status": status})
+	})
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen at %s : %w", addr, err)
+	}
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		log.Infof("Starting operations server at %s", lis.Addr())
+		if err := srv.Serve(lis); err != nil && err != http.ErrServerClosed {
+			log.Errorf("operations server stopped : %v", err)
+		}
+	}()
+	return srv, nil
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"datalock/internal"
+	"datalock/pkg/logger"
+	"datalock/pkg/metrics"
+	"encoding/pem"
+	"flag"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeSelfSigned : writes a self signed localhost certificate,
+// returns key and cert file paths
+func writeSelfSigned(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	keyFile, certFile := filepath.Join(dir, "key.pem"), filepath.Join(dir, "cert.pem")
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey}), 0600)
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	return keyFile, certFile
+}
+
+func freeAddress(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return lis.Addr().String()
+}
+
+func TestConfig(t *testing.T) {
+	is := assert.New(t)
+
+	t.Run("PeerByDefault", func(t *testing.T) {
+		cfg := ConfigFromEnv()
+		is.Equal(ModePeer, cfg.Mode)
+		is.Equal(defaultShutdownTimeout, cfg.ShutdownTimeout)
+		is.NoError(cfg.Validate())
+	})
+
+	t.Run("ServerFromEnv", func(t *testing.T) {
+		t.Setenv("CHAINCODE_CCID", "datalock:abc")
+		t.Setenv("CHAINCODE_ADDRESS", "0.0.0.0:7052")
+		t.Setenv("DATALOCK_SHUTDOWN_TIMEOUT", "3s")
+		cfg := ConfigFromEnv()
+		is.Equal(ModeServer, cfg.Mode)
+		is.Equal("datalock:abc", cfg.CCID)
+		is.Equal(3*time.Second, cfg.ShutdownTimeout)
+		is.NoError(cfg.Validate())
+	})
+
+	t.Run("FlagsOverrideEnv", func(t *testing.T) {
+		t.Setenv("CHAINCODE_CCID", "datalock:abc")
+		cfg := ConfigFromEnv()
+		fs := flag.NewFlagSet("datalock", flag.ContinueOnError)
+		cfg.RegisterFlags(fs)
+		is.NoError(fs.Parse([]string{"-mode", "server", "-ccid", "datalock:xyz", "-address", ":7052"}))
+		is.Equal(ModeServer, cfg.Mode)
+		is.Equal("datalock:xyz", cfg.CCID)
+		is.Equal(":7052", cfg.Address)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		for name, cfg := range map[string]Config{
+			"Mode":         {Mode: "sidecar"},
+			"CCID":         {Mode: ModeServer, Address: ":7052"},
+			"Address":      {Mode: ModeServer, CCID: "datalock:abc"},
+			"KeyOnly":      {Mode: ModeServer, CCID: "datalock:abc", Address: ":7052", KeyFile: "key.pem"},
+			"CAWithoutTLS": {Mode: ModeServer, CCID: "datalock:abc", Address: ":7052", ClientCAFile: "ca.pem"},
+		} {
+			is.Error(cfg.Validate(), name)
+		}
+	})
+}
+
+func TestTLSConfig(t *testing.T) {
+	is := assert.New(t)
+	keyFile, certFile := writeSelfSigned(t)
+
+	tlsCfg, err := Config{}.TLSConfig()
+	is.NoError(err)
+	is.Nil(tlsCfg)
+
+	tlsCfg, err = Config{KeyFile: keyFile, CertFile: certFile}.TLSConfig()
+	is.NoError(err)
+	is.Len(tlsCfg.Certificates, 1)
+	is.Equal(tls.NoClientCert, tlsCfg.ClientAuth)
+
+	tlsCfg, err = Config{KeyFile: keyFile, CertFile: certFile, ClientCAFile: certFile}.TLSConfig()
+	is.NoError(err)
+	is.Equal(tls.RequireAndVerifyClientCert, tlsCfg.ClientAuth)
+
+	_, err = Config{KeyFile: keyFile, CertFile: certFile, ClientCAFile: keyFile}.TLSConfig()
+	is.Error(err)
+
+	_, err = Config{KeyFile: certFile, CertFile: certFile}.TLSConfig()
+	is.Error(err)
+}
+
+func TestRunServer(t *testing.T) {
+	is := assert.New(t)
+	keyFile, certFile := writeSelfSigned(t)
+
+	cfg := Config{
+		Mode:              ModeServer,
+		CCID:              "datalock:abc",
+		Address:           freeAddress(t),
+		KeyFile:           keyFile,
+		CertFile:          certFile,
+		OperationsAddress: freeAddress(t),
+		ShutdownTimeout:   time.Second,
+		Log:               logger.New(logger.Config{Level: "debug"}),
+		Metrics:           metrics.NewRegistry(),
+	}
+	cfg.Metrics.StageUpdate(metrics.OutcomeSuccess)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Run(ctx, internal.New(internal.WithMetrics(cfg.Metrics)), cfg) }()
+
+	healthz := "http://" + cfg.OperationsAddress + "/healthz"
+	is.Eventually(func() bool {
+		resp, err := http.Get(healthz)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, 5*time.Second, 20*time.Millisecond)
+
+	resp, err := http.Get("http://" + cfg.OperationsAddress + "/metrics")
+	is.NoError(err)
+	is.Equal(http.StatusOK, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	is.Contains(string(body), `datalock_stage_updates_total{outcome="success"} 1`)
+
+	raw, _ := os.ReadFile(certFile)
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(raw)
+	conn, err := tls.Dial("tcp", cfg.Address, &tls.Config{RootCAs: pool, ServerName: "localhost", NextProtos: []string{"h2"}})
+	is.NoError(err)
+	if conn != nil {
+		conn.Close()
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		is.NoError(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop")
+	}
+}
@@ -0,0 +1,327 @@
+// Package validation : checks client input of datalock methods
+// before any state is touched. Every violation is reported as
+// a separate error with errors.CodeInvalidInput and the path
+// of the offending field.
+package validation
+
+import (
+	"datalock/model"
+	"datalock/pkg/errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// MaxIDLength : of txID, stage, chaincode and key names
+	MaxIDLength = 256
+	// MaxKeys : per data chaincode input
+	MaxKeys = 256
+	// MaxParams : per data chaincode input
+	MaxParams = 512
+	// MaxEntries : of storage and checkpoint maps
+	MaxEntries = 64
+	// MaxValueSize : in bytes of a storage or checkpoint value
+	MaxValueSize = 16 * 1024
+)
+
+// Errors : list of field level errors
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e Errors) Unwrap() []error {
+	return e
+}
+
+type validator struct {
+	errs Errors
+}
+
+// check : records an error on field if ok is false
+func (v *validator) check(ok bool, field string, format string, args ...interface{}) {
+	if ok {
+		return
+	}
+	v.errs = append(v.errs, errors.E(
+		errors.CodeInvalidInput,
+		errors.Field(field),
+		fmt.Errorf("%s : %s", field, fmt.Sprintf(format, args...)),
+		errors.SeverityDebug,
+	))
+}
+
+func (v *validator) err(op errors.Op) error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return errors.E(op, errors.CodeInvalidInput, errors.SeverityDebug, v.errs)
+}
+
+// id : non empty, bounded and usable as (part of) state key
+func (v *validator) id(field, value string) {
+	v.check(value != "", field, "is required")
+	v.check(len(value) <= MaxIDLength, field, "longer than %d bytes", MaxIDLength)
+	v.check(utf8.ValidString(value), field, "is not valid utf8")
+	v.check(!strings.ContainsRune(value, 0), field, "contains null character")
+}
+
+// txID : id that can't be mistaken for a lock state
+// key (chaincode::key), both being stored side by side
+func (v *validator) txID(value string) {
+	v.id("tx_id", value)
+	v.check(!strings.Contains(value, "::"), "tx_id", "contains ::")
+}
+
+// chaincode : id of a data chaincode, with no ':' as
+// lockIDs (chaincode::key) are split at the first "::"
+func (v *validator) chaincode(field, value string) {
+	v.id(field, value)
+	v.check(!strings.Contains(value, ":"), field, "contains :")
+}
+
+func (v *validator) values(field string, values map[string]string) {
+	v.check(len(values) <= MaxEntries, field, "has more than %d entries", MaxEntries)
+	for _, key := range sortedKeys(values) {
+		v.check(key != "", field, "contains empty key")
+		v.check(len(values[key]) <= MaxValueSize, fmt.Sprintf("%s.%s", field, key), "larger than %d bytes", MaxValueSize)
+	}
+}
+
+func (v *validator) ccInput(field string, input model.DataChaincodeInput) {
+	v.check(len(input.Keys) != 0, field+".keys", "is required")
+	v.keys(field+".keys", input.Keys)
+	v.check(len(input.Params) <= MaxParams, field+".params", "has more than %d params", MaxParams)
+}
+
+// keys : bounded list of distinct ids
+func (v *validator) keys(field string, keys []string) {
+	v.check(len(keys) <= MaxKeys, field, "has more than %d keys", MaxKeys)
+	seen := make(map[string]int, len(keys))
+	for i, key := range keys {
+		keyField := fmt.Sprintf("%s[%d]", field, i)
+		v.id(keyField, key)
+		if j, ok := seen[key]; ok {
+			v.check(false, keyField, "duplicate of keys[%d]", j)
+		}
+		seen[key] = i
+	}
+}
+
+// TxID : validates identifier of a transition
+func TxID(txID string) error {
+	const op = errors.Op("Validation.TxID")
+	v := new(validator)
+	v.txID(txID)
+	return v.err(op)
+}
+
+// Stage : validates identifier of a transition and
+// name of one of its stages
+func Stage(txID, stage string) error {
+	const op = errors.Op("Validation.Stage")
+	v := new(validator)
+	v.txID(txID)
+	v.id("name", stage)
+	return v.err(op)
+}
+
+// StageUpdateInput : validates input of stageUpdate
+func StageUpdateInput(input model.StageUpdateInput) error {
+	const op = errors.Op("Validation.StageUpdateInput")
+	v := new(validator)
+	v.txID(input.TxID)
+	v.id("name", input.Name)
+
+	for _, cc := range sortedKeys(input.DataLocks) {
+		field := fmt.Sprintf("data_locks[%s]", cc)
+		v.chaincode(field, cc)
+		v.ccInput(field, input.DataLocks[cc])
+		_, ok := input.DataFree[cc]
+		v.check(!ok, field, "chaincode also present in data_free")
+	}
+	for _, cc := range sortedKeys(input.DataFree) {
+		field := fmt.Sprintf("data_free[%s]", cc)
+		v.chaincode(field, cc)
+		v.ccInput(field, input.DataFree[cc])
+	}
+
+	v.values("storage", input.Storage)
+	if input.Collection != "" {
+		v.id("collection", input.Collection)
+		v.check(len(input.Storage) == 0, "storage", "of a private stage must be provided in transient map")
+	}
+	if input.Commitment != nil {
+		v.check(input.Commitment.ChainID != "", "commitment.chain_id", "is required")
+		v.check(input.Commitment.PayloadHash != "", "commitment.payload_hash", "is required")
+	}
+	if input.Receipt != nil {
+		v.check(input.Receipt.ChainID != "", "receipt.chain_id", "is required")
+		v.check(input.Receipt.TxHash != "", "receipt.tx_hash", "is required")
+		v.check(input.Receipt.PayloadHash != "", "receipt.payload_hash", "is required")
+		v.check(input.Receipt.CommitmentStage != "", "receipt.commitment_stage", "is required")
+	}
+	return v.err(op)
+}
+
+// TxPauseInput : validates input of pauseTransitionProcess
+func TxPauseInput(input model.TxPauseInput) error {
+	const op = errors.Op("Validation.TxPauseInput")
+	v := new(validator)
+	v.txID(input.TxID)
+	v.id("reason_code", input.ReasonCode)
+	v.values("checkpoint", input.Checkpoint)
+	return v.err(op)
+}
+
+// TxResumeInput : validates input of resumeTransitionProcess,
+// a checkpoint needs a reason
+func TxResumeInput(input model.TxResumeInput) error {
+	const op = errors.Op("Validation.TxResumeInput")
+	v := new(validator)
+	v.txID(input.TxID)
+	if input.ReasonCode != "" || len(input.Checkpoint) != 0 {
+		v.id("reason_code", input.ReasonCode)
+	}
+	v.values("checkpoint", input.Checkpoint)
+	return v.err(op)
+}
+
+// ObservedReceipt : validates receipt observed by an auditor
+// on external ledger
+func ObservedReceipt(receipt model.ExternalReceipt) error {
+	const op = errors.Op("Validation.ObservedReceipt")
+	v := new(validator)
+	v.check(receipt.TxHash != "", "tx_hash", "is required")
+	return v.err(op)
+}
+
+// DataChaincodeOutput : validates output of a data chaincode,
+// whose keys are locked or unlocked by datalock
+func DataChaincodeOutput(output model.DataChaincodeOutput) error {
+	const op = errors.Op("Validation.DataChaincodeOutput")
+	v := new(validator)
+	v.keys("keys", output.Keys)
+	v.values("output_to_store", output.OutputToStore)
+	return v.err(op)
+}
+
+// CheckLocksInput : validates input of checkLocks
+func CheckLocksInput(input model.CheckLocksInput) error {
+	const op = errors.Op("Validation.CheckLocksInput")
+	v := new(validator)
+	v.chaincode("chaincode", input.Chaincode)
+	v.check(len(input.Keys) != 0, "keys", "is required")
+	v.keys("keys", input.Keys)
+	if input.TxID != "" {
+		v.txID(input.TxID)
+	}
+	return v.err(op)
+}
+
+// QueueInput : validates input of joinQueue and leaveQueue
+func QueueInput(input model.QueueInput) error {
+	const op = errors.Op("Validation.QueueInput")
+	v := new(validator)
+	v.txID(input.TxID)
+	v.chaincode("chaincode", input.Chaincode)
+	v.check(len(input.Keys) != 0, "keys", "is required")
+	v.keys("keys", input.Keys)
+	v.check(input.Grace >= 0 && input.Grace <= model.MaxQueueGrace, "grace", "must be between 0 and %d", model.MaxQueueGrace)
+	return v.err(op)
+}
+
+// ArchiveInput : validates input of archiveFinished
+func ArchiveInput(input model.ArchiveInput) error {
+	const op = errors.Op("Validation.ArchiveInput")
+	v := new(validator)
+	v.check(input.Retention >= 0, "retention", "must not be negative")
+	v.check(input.Limit >= 0 && input.Limit <= MaxKeys, "limit", "must be between 0 and %d", MaxKeys)
+	v.check(utf8.ValidString(input.Bookmark), "bookmark", "is not valid utf8")
+	v.check(!strings.ContainsRune(input.Bookmark, 0), "bookmark", "contains null character")
+	return v.err(op)
+}
+
+// Workflow : validates name of a workflow
+func Workflow(workflow string) error {
+	const op = errors.Op("Validation.Workflow")
+	v := new(validator)
+	v.id("workflow", workflow)
+	return v.err(op)
+}
+
+// FinishPolicy : validates finish policy of a workflow
+func FinishPolicy(policy model.FinishPolicy) error {
+	const op = errors.Op("Validation.FinishPolicy")
+	v := new(validator)
+	v.id("workflow", policy.Workflow)
+	v.check(policy.OnLocks == model.FinishReject || policy.OnLocks == model.FinishRelease,
+		"on_locks", "must be %s or %s", model.FinishReject, model.FinishRelease)
+	if policy.OnLocks == model.FinishRelease {
+		v.check(len(policy.Free) != 0, "free", "is required to release locks")
+	}
+	for _, cc := range sortedKeys(policy.Free) {
+		field := fmt.Sprintf("free[%s]", cc)
+		v.chaincode(field, cc)
+		v.check(len(policy.Free[cc]) != 0, field, "method is required")
+		v.check(len(policy.Free[cc]) <= MaxParams, field, "has more than %d params", MaxParams)
+	}
+	return v.err(op)
+}
+
+// Config : validates configuration of datalock,
+// 0 standing for the default of a limit
+func Config(config model.Config) error {
+	const op = errors.Op("Validation.Config")
+	v := new(validator)
+	v.check(len(config.Admins) != 0, "admins", "is required")
+	v.keys("admins", config.Admins)
+	v.check(config.DefaultLease >= 0 && config.DefaultLease <= model.MaxQueueGrace, "default_lease", "must be between 0 and %d", model.MaxQueueGrace)
+	v.check(config.MaxKeys >= 0 && config.MaxKeys <= MaxKeys, "max_keys", "must be between 0 and %d", MaxKeys)
+	v.check(config.MaxStages >= 0, "max_stages", "must not be negative")
+	v.keys("chaincodes", config.Chaincodes)
+	for i, cc := range config.Chaincodes {
+		v.check(!strings.Contains(cc, ":"), fmt.Sprintf("chaincodes[%d]", i), "contains :")
+	}
+	return v.err(op)
+}
+
+// HandoffInput : validates input of approveHandoff and handoffLocks
+func HandoffInput(input model.HandoffInput) error {
+	const op = errors.Op("Validation.HandoffInput")
+	v := new(validator)
+	v.id("from", input.From)
+	v.check(!strings.Contains(input.From, "::"), "from", "contains ::")
+	v.id("to", input.To)
+	v.check(!strings.Contains(input.To, "::"), "to", "contains ::")
+	v.check(input.From != input.To, "to", "same as from")
+	v.check(len(input.Locks) != 0, "locks", "is required")
+	total := 0
+	for _, cc := range sortedKeys(input.Locks) {
+		field := fmt.Sprintf("locks[%s]", cc)
+		v.chaincode(field, cc)
+		v.check(len(input.Locks[cc]) != 0, field, "is required")
+		v.keys(field, input.Locks[cc])
+		total += len(input.Locks[cc])
+	}
+	v.check(total <= MaxKeys, "locks", "has more than %d keys", MaxKeys)
+	return v.err(op)
+}
+
+// sortedKeys : map keys in sorted order, so that
+// errors are reported deterministically
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,231 @@
+package validation
+
+import (
+	"datalock/model"
+	"datalock/pkg/errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fields : field paths of every error reported by err
+func fields(err error) []errors.Field {
+	var errs Errors
+	if !errors.As(err, &errs) {
+		return nil
+	}
+	out := make([]errors.Field, len(errs))
+	for i, e := range errs {
+		out[i] = errors.GetField(e)
+	}
+	return out
+}
+
+func TestStageUpdateInput(t *testing.T) {
+	is := assert.New(t)
+
+	valid := model.StageUpdateInput{
+		TxID: "tx-1",
+		Name: "GetValidEmissions",
+		DataLocks: map[string]model.DataChaincodeInput{
+			"EmissionsCC": {
+				Keys:   []string{"uuid-1", "uuid-2"},
+				Params: []string{"getValidEmissions", "uuid-1", "uuid-2"},
+			},
+		},
+		Storage: map[string]string{"tokenID": "0x1"},
+	}
+	is.NoError(StageUpdateInput(valid))
+
+	t.Run("Empty", func(t *testing.T) {
+		err := StageUpdateInput(model.StageUpdateInput{})
+		is.Equal(errors.CodeInvalidInput, errors.ErrCode(err))
+		is.Equal([]errors.Field{"tx_id", "name"}, fields(err))
+	})
+
+	t.Run("DataLocks", func(t *testing.T) {
+		input := valid
+		input.DataLocks = map[string]model.DataChaincodeInput{
+			"EmissionsCC": {Keys: []string{"uuid-1", "", "uuid-1"}},
+			"OtherCC":     {},
+		}
+		input.DataFree = map[string]model.DataChaincodeInput{
+			"EmissionsCC": {Keys: []string{"uuid-3"}},
+		}
+		err := StageUpdateInput(input)
+		is.Equal([]errors.Field{
+			"data_locks[EmissionsCC].keys[1]",
+			"data_locks[EmissionsCC].keys[2]",
+			"data_locks[EmissionsCC]",
+			"data_locks[OtherCC].keys",
+		}, fields(err))
+		is.Contains(err.Error(), "data_locks[EmissionsCC].keys[2] : duplicate of keys[0]")
+		is.Contains(err.Error(), "data_locks[EmissionsCC] : chaincode also present in data_free")
+	})
+
+	t.Run("TooManyKeys", func(t *testing.T) {
+		keys := make([]string, MaxKeys+1)
+		for i := range keys {
+			keys[i] = fmt.Sprintf("uuid-%d", i)
+		}
+		input := valid
+		input.DataLocks = nil
+		input.DataFree = map[string]model.DataChaincodeInput{"EmissionsCC": {Keys: keys}}
+		is.Equal([]errors.Field{"data_free[EmissionsCC].keys"}, fields(StageUpdateInput(input)))
+	})
+
+	t.Run("Storage", func(t *testing.T) {
+		input := valid
+		input.Storage = map[string]string{"big": strings.Repeat("a", MaxValueSize+1)}
+		is.Equal([]errors.Field{"storage.big"}, fields(StageUpdateInput(input)))
+
+		input.Storage = map[string]string{}
+		for i := 0; i <= MaxEntries; i++ {
+			input.Storage[fmt.Sprintf("k-%d", i)] = "v"
+		}
+		is.Equal([]errors.Field{"storage"}, fields(StageUpdateInput(input)))
+	})
+
+	t.Run("PrivateStorage", func(t *testing.T) {
+		input := valid
+		input.Collection = "collection"
+		is.Equal([]errors.Field{"storage"}, fields(StageUpdateInput(input)))
+	})
+
+	t.Run("ExternalLedger", func(t *testing.T) {
+		input := valid
+		input.Commitment = &model.ExternalCommitment{}
+		input.Receipt = &model.ExternalReceipt{ChainID: "1", TxHash: "0x1"}
+		is.Equal([]errors.Field{
+			"commitment.chain_id",
+			"commitment.payload_hash",
+			"receipt.payload_hash",
+			"receipt.commitment_stage",
+		}, fields(StageUpdateInput(input)))
+	})
+
+	t.Run("NullCharacter", func(t *testing.T) {
+		input := valid
+		input.TxID = "tx\x001"
+		is.Equal([]errors.Field{"tx_id"}, fields(StageUpdateInput(input)))
+	})
+}
+
+func TestTxID(t *testing.T) {
+	is := assert.New(t)
+	is.NoError(TxID("tx-1"))
+	is.Equal([]errors.Field{"tx_id"}, fields(TxID("")))
+	is.Equal([]errors.Field{"tx_id"}, fields(TxID(strings.Repeat("a", MaxIDLength+1))))
+	is.Equal([]errors.Field{"tx_id"}, fields(TxID("EmissionsCC::uuid-1")))
+	is.Equal([]errors.Field{"tx_id", "name"}, fields(Stage("", "")))
+}
+
+func TestTxPauseInput(t *testing.T) {
+	is := assert.New(t)
+	is.NoError(TxPauseInput(model.TxPauseInput{TxID: "tx-1", ReasonCode: "WAIT"}))
+	is.Equal([]errors.Field{"reason_code"}, fields(TxPauseInput(model.TxPauseInput{TxID: "tx-1"})))
+}
+
+func TestTxResumeInput(t *testing.T) {
+	is := assert.New(t)
+	is.NoError(TxResumeInput(model.TxResumeInput{TxID: "txID-1"}))
+	is.NoError(TxResumeInput(model.TxResumeInput{TxID: "txID-1", ReasonCode: "CONFIRMED", Checkpoint: map[string]string{"block": "42"}}))
+	is.Equal([]errors.Field{"tx_id", "reason_code"}, fields(TxResumeInput(model.TxResumeInput{Checkpoint: map[string]string{"block": "42"}})))
+}
+
+func TestObservedReceipt(t *testing.T) {
+	is := assert.New(t)
+	is.NoError(ObservedReceipt(model.ExternalReceipt{TxHash: "0x1"}))
+	is.Equal([]errors.Field{"tx_hash"}, fields(ObservedReceipt(model.ExternalReceipt{})))
+}
+
+func TestDataChaincodeOutput(t *testing.T) {
+	is := assert.New(t)
+	is.NoError(DataChaincodeOutput(model.DataChaincodeOutput{}))
+	is.NoError(DataChaincodeOutput(model.DataChaincodeOutput{Keys: []string{"uuid-1", "uuid-2"}}))
+	is.Equal([]errors.Field{"keys[1]", "keys[2]", "output_to_store"}, fields(DataChaincodeOutput(model.DataChaincodeOutput{
+		Keys:          []string{"uuid-1", "uuid-\x00", "uuid-1"},
+		OutputToStore: map[string]string{"": "value"},
+	})))
+}
+
+func TestCheckLocksInput(t *testing.T) {
+	is := assert.New(t)
+	is.NoError(CheckLocksInput(model.CheckLocksInput{Chaincode: "EmissionsCC", Keys: []string{"uuid-1"}}))
+	is.Equal([]errors.Field{"chaincode", "keys", "tx_id"}, fields(CheckLocksInput(model.CheckLocksInput{TxID: "a::b"})))
+}
+
+// TestChaincodeName : a chaincode name with ':' would make the
+// lockID of one of its keys collide with one of another chaincode
+func TestChaincodeName(t *testing.T) {
+	is := assert.New(t)
+	err := CheckLocksInput(model.CheckLocksInput{Chaincode: "a::b", Keys: []string{"c"}})
+	is.Equal([]errors.Field{"chaincode"}, fields(err))
+	is.Contains(err.Error(), "chaincode : contains :")
+	is.Equal([]errors.Field{"chaincode"}, fields(QueueInput(model.QueueInput{TxID: "txID-1", Chaincode: "a:", Keys: []string{"b::c"}})))
+	is.Equal([]errors.Field{"data_free[a::b]"}, fields(StageUpdateInput(model.StageUpdateInput{
+		TxID:     "txID-1",
+		Name:     "stage",
+		DataFree: map[string]model.DataChaincodeInput{"a::b": {Keys: []string{"c"}}},
+	})))
+	is.Equal([]errors.Field{"free[a:b]"}, fields(FinishPolicy(model.FinishPolicy{
+		Workflow: "issuance",
+		OnLocks:  model.FinishRelease,
+		Free:     map[string][]string{"a:b": {"release"}},
+	})))
+	is.Equal([]errors.Field{"locks[a::b]"}, fields(HandoffInput(model.HandoffInput{From: "txID-1", To: "txID-2", Locks: map[string][]string{"a::b": {"c"}}})))
+	is.Equal([]errors.Field{"chaincodes[1]"}, fields(Config(model.Config{Admins: []string{"Org1MSP"}, Chaincodes: []string{"EmissionsCC", "a::b"}})))
+}
+
+func TestQueueInput(t *testing.T) {
+	is := assert.New(t)
+	is.NoError(QueueInput(model.QueueInput{TxID: "txID-1", Chaincode: "EmissionsCC", Keys: []string{"uuid-1"}, Grace: 60}))
+	is.Equal([]errors.Field{"tx_id", "chaincode", "keys", "grace"}, fields(QueueInput(model.QueueInput{Grace: -1})))
+	is.Equal([]errors.Field{"grace"}, fields(QueueInput(model.QueueInput{TxID: "txID-1", Chaincode: "EmissionsCC", Keys: []string{"uuid-1"}, Grace: model.MaxQueueGrace + 1})))
+}
+
+func TestFinishPolicy(t *testing.T) {
+	is := assert.New(t)
+	is.NoError(FinishPolicy(model.FinishPolicy{Workflow: "issuance", OnLocks: model.FinishReject}))
+	is.NoError(FinishPolicy(model.FinishPolicy{
+		Workflow: "issuance",
+		OnLocks:  model.FinishRelease,
+		Free:     map[string][]string{"EmissionsCC": {"release"}},
+	}))
+	is.Equal([]errors.Field{"workflow", "on_locks"}, fields(FinishPolicy(model.FinishPolicy{OnLocks: "keep"})))
+	is.Equal([]errors.Field{"free"}, fields(FinishPolicy(model.FinishPolicy{Workflow: "issuance", OnLocks: model.FinishRelease})))
+	is.Equal([]errors.Field{"free[EmissionsCC]"}, fields(FinishPolicy(model.FinishPolicy{
+		Workflow: "issuance",
+		OnLocks:  model.FinishRelease,
+		Free:     map[string][]string{"EmissionsCC": {}},
+	})))
+	is.Equal([]errors.Field{"workflow"}, fields(Workflow("")))
+}
+
+func TestHandoffInput(t *testing.T) {
+	is := assert.New(t)
+	is.NoError(HandoffInput(model.HandoffInput{From: "txID-1", To: "txID-2", Locks: map[string][]string{"EmissionsCC": {"uuid-1"}}}))
+	is.Equal([]errors.Field{"from", "to", "to", "locks"}, fields(HandoffInput(model.HandoffInput{From: "a::b", To: "a::b"})))
+	is.Equal([]errors.Field{"locks[EmissionsCC][1]", "locks[OtherCC]"}, fields(HandoffInput(model.HandoffInput{
+		From:  "txID-1",
+		To:    "txID-2",
+		Locks: map[string][]string{"EmissionsCC": {"uuid-1", "uuid-1"}, "OtherCC": {}},
+	})))
+}
+
+func TestArchiveInput(t *testing.T) {
+	is := assert.New(t)
+	is.NoError(ArchiveInput(model.ArchiveInput{Retention: 3600, Bookmark: "txID-1", Limit: 10}))
+	is.NoError(ArchiveInput(model.ArchiveInput{}))
+	is.Equal([]errors.Field{"retention", "limit", "bookmark"}, fields(ArchiveInput(model.ArchiveInput{Retention: -1, Limit: MaxKeys + 1, Bookmark: "\x00"})))
+}
+
+func TestConfig(t *testing.T) {
+	is := assert.New(t)
+	is.NoError(Config(model.Config{Admins: []string{"Org1MSP"}}))
+	is.NoError(Config(model.Config{Admins: []string{"Org1MSP"}, DefaultLease: 60, MaxKeys: 16, MaxStages: 8, Chaincodes: []string{"EmissionsCC"}}))
+	is.Equal([]errors.Field{"admins", "default_lease", "max_keys", "max_stages"}, fields(Config(model.Config{DefaultLease: -1, MaxKeys: MaxKeys + 1, MaxStages: -1})))
+	is.Equal([]errors.Field{"admins[1]", "chaincodes[0]"}, fields(Config(model.Config{Admins: []string{"Org1MSP", "Org1MSP"}, Chaincodes: []string{""}})))
+}
@@ -81,6 +81,8 @@ package main
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"strconv"
@@ -109,21 +111,83 @@ type marble struct {
 // ===================================================================================
 func main() {
 
+	tlsProps, err := getTLSProperties()
+	if err != nil {
+		fmt.Printf("Error starting Marbles02 chaincode: %s\n", err)
+		os.Exit(1)
+	}
+
 	server := &shim.ChaincodeServer{
-		CCID:    os.Getenv("CHAINCODE_CCID"),
-		Address: os.Getenv("CHAINCODE_ADDRESS"),
-		CC:      new(SimpleChaincode),
-		TLSProps: shim.TLSProperties{
-				Disabled: true,
-		},
+		CCID:     os.Getenv("CHAINCODE_CCID"),
+		Address:  os.Getenv("CHAINCODE_ADDRESS"),
+		CC:       new(SimpleChaincode),
+		TLSProps: tlsProps,
 	}
 
 	// Start the chaincode external server
-	err := server.Start()
+	err = server.Start()
+
+	if err != nil {
+		fmt.Printf("Error starting Marbles02 chaincode: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// ===========================================================================================
+// getTLSProperties reads the chaincode server TLS settings from the environment.
+// TLS is enabled when CHAINCODE_TLS_KEY_FILE and CHAINCODE_TLS_CERT_FILE are set,
+// and peers must present a client certificate signed by CHAINCODE_TLS_CLIENT_CA_FILE
+// when it is set (mutual TLS). Without any of them TLS stays disabled.
+// ===========================================================================================
+func getTLSProperties() (shim.TLSProperties, error) {
+	keyFile := os.Getenv("CHAINCODE_TLS_KEY_FILE")
+	certFile := os.Getenv("CHAINCODE_TLS_CERT_FILE")
+	clientCAFile := os.Getenv("CHAINCODE_TLS_CLIENT_CA_FILE")
+
+	if keyFile == "" && certFile == "" && clientCAFile == "" {
+		return shim.TLSProperties{Disabled: true}, nil
+	}
+	if keyFile == "" || certFile == "" {
+		return shim.TLSProperties{}, fmt.Errorf("both CHAINCODE_TLS_KEY_FILE and CHAINCODE_TLS_CERT_FILE must be set to enable TLS")
+	}
+
+	key, err := readTLSFile("CHAINCODE_TLS_KEY_FILE", keyFile)
+	if err != nil {
+		return shim.TLSProperties{}, err
+	}
+	cert, err := readTLSFile("CHAINCODE_TLS_CERT_FILE", certFile)
+	if err != nil {
+		return shim.TLSProperties{}, err
+	}
+	if _, err := tls.X509KeyPair(cert, key); err != nil {
+		return shim.TLSProperties{}, fmt.Errorf("invalid TLS key pair %s, %s: %s", keyFile, certFile, err)
+	}
+
+	var clientCACerts []byte
+	if clientCAFile != "" {
+		clientCACerts, err = readTLSFile("CHAINCODE_TLS_CLIENT_CA_FILE", clientCAFile)
+		if err != nil {
+			return shim.TLSProperties{}, err
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(clientCACerts) {
+			return shim.TLSProperties{}, fmt.Errorf("no PEM certificate found in CHAINCODE_TLS_CLIENT_CA_FILE %s", clientCAFile)
+		}
+	}
+
+	return shim.TLSProperties{
+		Disabled:      false,
+		Key:           key,
+		Cert:          cert,
+		ClientCACerts: clientCACerts,
+	}, nil
+}
 
+func readTLSFile(env, path string) ([]byte, error) {
+	raw, err := os.ReadFile(path)
 	if err != nil {
-		fmt.Printf("Error starting Marbles02 chaincode: %s", err)
+		return nil, fmt.Errorf("failed to read %s: %s", env, err)
 	}
+	return raw, nil
 }
 
 // Init initializes chaincode
@@ -3,6 +3,7 @@ module github.com/marbles
 go 1.23.0
 
 require (
+	datalock v0.0.0
 	github.com/hyperledger/fabric-chaincode-go v0.0.0-20220131132609-1476cf1d3206
 	github.com/hyperledger/fabric-protos-go v0.0.0-20220315113721-7dc293e117f7
 )
@@ -16,3 +17,6 @@ require (
 	google.golang.org/grpc v1.56.3 // indirect
 	google.golang.org/protobuf v1.33.0 // indirect
 )
+
+// datalock/pkg/mockstub evaluates the rich queries of the tests
+replace datalock => ../../../chaincode/datalock
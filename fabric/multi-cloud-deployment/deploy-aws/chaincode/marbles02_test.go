@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"datalock/pkg/mockstub"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert creates a certificate for localhost signed by parent,
+// or a self-signed CA when parent is nil
+func newTestCert(t *testing.T, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.Subject.CommonName = "marbles test CA"
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	rawKey, _ := x509.MarshalECPrivateKey(key)
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: rawKey}),
+	}
+}
+
+func writeFile(t *testing.T, dir, name string, raw []byte) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, raw, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func freeAddress(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return lis.Addr().String()
+}
+
+func TestGetTLSPropertiesDisabled(t *testing.T) {
+	props, err := getTLSProperties()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !props.Disabled {
+		t.Fatal("TLS should be disabled without env")
+	}
+}
+
+func TestGetTLSPropertiesInvalid(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, nil)
+	server := newTestCert(t, ca)
+	keyFile := writeFile(t, dir, "server.key", server.keyPEM)
+	certFile := writeFile(t, dir, "server.crt", server.certPEM)
+
+	for name, tc := range map[string]struct {
+		env     map[string]string
+		wantErr string
+	}{
+		"KeyWithoutCert": {
+			env:     map[string]string{"CHAINCODE_TLS_KEY_FILE": keyFile},
+			wantErr: "CHAINCODE_TLS_CERT_FILE must be set",
+		},
+		"MissingKeyFile": {
+			env: map[string]string{
+				"CHAINCODE_TLS_KEY_FILE":  filepath.Join(dir, "missing.key"),
+				"CHAINCODE_TLS_CERT_FILE": certFile,
+			},
+			wantErr: "failed to read CHAINCODE_TLS_KEY_FILE",
+		},
+		"MissingClientCAFile": {
+			env: map[string]string{
+				"CHAINCODE_TLS_KEY_FILE":       keyFile,
+				"CHAINCODE_TLS_CERT_FILE":      certFile,
+				"CHAINCODE_TLS_CLIENT_CA_FILE": filepath.Join(dir, "missing.crt"),
+			},
+			wantErr: "failed to read CHAINCODE_TLS_CLIENT_CA_FILE",
+		},
+		"MismatchedKeyPair": {
+			env: map[string]string{
+				"CHAINCODE_TLS_KEY_FILE":  writeFile(t, dir, "ca.key", ca.keyPEM),
+				"CHAINCODE_TLS_CERT_FILE": certFile,
+			},
+			wantErr: "invalid TLS key pair",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			_, err := getTLSProperties()
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestTLSHandshake starts the chaincode server with mutual TLS on localhost
+// and checks that only clients presenting a certificate signed by the CA connect
+func TestTLSHandshake(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, nil)
+	server := newTestCert(t, ca)
+	client := newTestCert(t, ca)
+
+	t.Setenv("CHAINCODE_TLS_KEY_FILE", writeFile(t, dir, "server.key", server.keyPEM))
+	t.Setenv("CHAINCODE_TLS_CERT_FILE", writeFile(t, dir, "server.crt", server.certPEM))
+	t.Setenv("CHAINCODE_TLS_CLIENT_CA_FILE", writeFile(t, dir, "ca.crt", ca.certPEM))
+	props, err := getTLSProperties()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address := freeAddress(t)
+	cc := &shim.ChaincodeServer{
+		CCID:     "marbles:test",
+		Address:  address,
+		CC:       new(SimpleChaincode),
+		TLSProps: props,
+	}
+	go cc.Start()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientPair, err := tls.X509KeyPair(client.certPEM, client.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dial := func(certs []tls.Certificate) (*tls.Conn, error) {
+		var conn *tls.Conn
+		var err error
+		for i := 0; i < 50; i++ {
+			conn, err = tls.Dial("tcp", address, &tls.Config{
+				RootCAs:      roots,
+				ServerName:   "localhost",
+				Certificates: certs,
+				NextProtos:   []string{"h2"},
+			})
+			if err == nil {
+				return conn, nil
+			}
+			if _, ok := err.(*net.OpError); !ok {
+				return nil, err
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		return nil, err
+	}
+
+	t.Run("WithClientCert", func(t *testing.T) {
+		conn, err := dial([]tls.Certificate{clientPair})
+		if err != nil {
+			t.Fatalf("handshake failed: %s", err)
+		}
+		defer conn.Close()
+		if !conn.ConnectionState().HandshakeComplete {
+			t.Fatal("handshake not complete")
+		}
+	})
+
+	t.Run("WithoutClientCert", func(t *testing.T) {
+		conn, err := dial(nil)
+		if err != nil {
+			return // rejected during handshake (TLS 1.2)
+		}
+		defer conn.Close()
+		// with TLS 1.3 the server rejects the client after the handshake
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		if netErr, ok := err.(net.Error); err == nil || (ok && netErr.Timeout()) {
+			t.Fatal("server accepted client without certificate")
+		}
+	})
+}
+
+// ===========================================================================================
+// Chaincode tests, run on the in-memory MockStub of datalock/pkg/mockstub which
+// evaluates CouchDB rich queries
+// ===========================================================================================
+
+type queryRecord struct {
+	Key    string `json:"Key"`
+	Record marble `json:"Record"`
+}
+
+type responseMetadata struct {
+	ResponseMetadata struct {
+		RecordsCount string `json:"RecordsCount"`
+		Bookmark     string `json:"Bookmark"`
+	} `json:"ResponseMetadata"`
+}
+
+func invoke(t *testing.T, stub *mockstub.MockStub, args ...string) []byte {
+	t.Helper()
+	raw := make([][]byte, len(args))
+	for i, arg := range args {
+		raw[i] = []byte(arg)
+	}
+	resp := stub.MockInvoke("tx", raw)
+	if resp.Status != shim.OK {
+		t.Fatalf("%s failed: %s", args[0], resp.Message)
+	}
+	return resp.Payload
+}
+
+func invokeError(t *testing.T, stub *mockstub.MockStub, args ...string) string {
+	t.Helper()
+	raw := make([][]byte, len(args))
+	for i, arg := range args {
+		raw[i] = []byte(arg)
+	}
+	resp := stub.MockInvoke("tx", raw)
+	if resp.Status == shim.OK {
+		t.Fatalf("%s should fail", args[0])
+	}
+	return resp.Message
+}
+
+// newMarblesStub creates marbles of tom, jerry and alice
+func newMarblesStub(t *testing.T) *mockstub.MockStub {
+	stub := mockstub.NewMockStub("marbles", new(SimpleChaincode))
+	for _, m := range [][]string{
+		{"marble1", "blue", "35", "tom"},
+		{"marble2", "red", "50", "tom"},
+		{"marble3", "blue", "70", "jerry"},
+		{"marble4", "green", "10", "jerry"},
+		{"marble5", "red", "90", "alice"},
+	} {
+		invoke(t, stub, append([]string{"initMarble"}, m...)...)
+	}
+	return stub
+}
+
+func readOwner(t *testing.T, stub *mockstub.MockStub, name string) string {
+	t.Helper()
+	var m marble
+	if err := json.Unmarshal(invoke(t, stub, "readMarble", name), &m); err != nil {
+		t.Fatal(err)
+	}
+	return m.Owner
+}
+
+// decodeRecords decodes query results, followed by the pagination
+// metadata for paginated queries
+func decodeRecords(t *testing.T, payload []byte, metadata *responseMetadata) []string {
+	t.Helper()
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	var records []queryRecord
+	if err := dec.Decode(&records); err != nil {
+		t.Fatalf("invalid query result %s: %s", payload, err)
+	}
+	if metadata != nil {
+		var meta []responseMetadata
+		if err := dec.Decode(&meta); err != nil || len(meta) != 1 {
+			t.Fatalf("invalid pagination metadata %s: %v", payload, err)
+		}
+		*metadata = meta[0]
+	}
+	names := []string{}
+	for _, r := range records {
+		if r.Key != r.Record.Name {
+			t.Fatalf("key %s does not match marble %s", r.Key, r.Record.Name)
+		}
+		names = append(names, r.Key)
+	}
+	return names
+}
+
+func assertNames(t *testing.T, want, got []string) {
+	t.Helper()
+	if strings.Join(want, ",") != strings.Join(got, ",") {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestInitMarble(t *testing.T) {
+	stub := newMarblesStub(t)
+
+	var m marble
+	if err := json.Unmarshal(invoke(t, stub, "readMarble", "marble1"), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m != (marble{ObjectType: "marble", Name: "marble1", Color: "blue", Size: 35, Owner: "tom"}) {
+		t.Fatalf("unexpected marble %+v", m)
+	}
+
+	for name, args := range map[string][]string{
+		"Exists":       {"initMarble", "marble1", "blue", "35", "tom"},
+		"NotNumeric":   {"initMarble", "marble9", "blue", "big", "tom"},
+		"MissingArgs":  {"initMarble", "marble9", "blue"},
+		"EmptyArg":     {"initMarble", "marble9", "", "35", "tom"},
+		"ReadNotFound": {"readMarble", "marble9"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			invokeError(t, stub, args...)
+		})
+	}
+}
+
+func TestTransferMarble(t *testing.T) {
+	stub := newMarblesStub(t)
+
+	invoke(t, stub, "transferMarble", "marble1", "Jerry")
+	if owner := readOwner(t, stub, "marble1"); owner != "jerry" {
+		t.Fatalf("expected owner jerry, got %s", owner)
+	}
+
+	payload := invoke(t, stub, "transferMarblesBasedOnColor", "blue", "alice")
+	if string(payload) != "Transferred 2 blue marbles to alice" {
+		t.Fatalf("unexpected payload %s", payload)
+	}
+	names := decodeRecords(t, invoke(t, stub, "queryMarblesByOwner", "alice"), nil)
+	assertNames(t, []string{"marble1", "marble3", "marble5"}, names)
+
+	invokeError(t, stub, "transferMarble", "marble9", "bob")
+}
+
+func TestDeleteMarble(t *testing.T) {
+	stub := newMarblesStub(t)
+
+	invoke(t, stub, "delete", "marble3")
+	invokeError(t, stub, "readMarble", "marble3")
+	invokeError(t, stub, "delete", "marble3")
+
+	// color index is maintained
+	payload := invoke(t, stub, "transferMarblesBasedOnColor", "blue", "bob")
+	if string(payload) != "Transferred 1 blue marbles to bob" {
+		t.Fatalf("unexpected payload %s", payload)
+	}
+}
+
+func TestQueryMarblesByOwner(t *testing.T) {
+	stub := newMarblesStub(t)
+
+	assertNames(t, []string{"marble1", "marble2"}, decodeRecords(t, invoke(t, stub, "queryMarblesByOwner", "TOM"), nil))
+	assertNames(t, []string{}, decodeRecords(t, invoke(t, stub, "queryMarblesByOwner", "bob"), nil))
+}
+
+func TestQueryMarbles(t *testing.T) {
+	stub := newMarblesStub(t)
+
+	for name, tc := range map[string]struct {
+		query string
+		names []string
+	}{
+		"Owner":     {`{"selector":{"owner":"jerry"}}`, []string{"marble3", "marble4"}},
+		"SizeRange": {`{"selector":{"docType":"marble","size":{"$gte":35,"$lt":90}}}`, []string{"marble1", "marble2", "marble3"}},
+		"Or":        {`{"selector":{"$or":[{"color":"green"},{"owner":"alice"}]}}`, []string{"marble4", "marble5"}},
+		"SortDesc": {
+			`{"selector":{"docType":{"$eq":"marble"},"owner":{"$eq":"tom"},"size":{"$gt":0}},"fields":["docType","owner","size","name"],"sort":[{"size":"desc"}],"use_index":"_design/indexSizeSortDoc"}`,
+			[]string{"marble2", "marble1"},
+		},
+		"Limit": {`{"selector":{"docType":"marble"},"sort":["size"],"limit":2}`, []string{"marble4", "marble1"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assertNames(t, tc.names, decodeRecords(t, invoke(t, stub, "queryMarbles", tc.query), nil))
+		})
+	}
+
+	invokeError(t, stub, "queryMarbles", `{"selector":{"size":{"$near":1}}}`)
+	invokeError(t, stub, "queryMarbles")
+}
+
+func TestQueryMarblesWithPagination(t *testing.T) {
+	stub := newMarblesStub(t)
+	query := `{"selector":{"docType":"marble"},"sort":[{"size":"desc"}]}`
+
+	var got []string
+	bookmark := ""
+	for {
+		var meta responseMetadata
+		names := decodeRecords(t, invoke(t, stub, "queryMarblesWithPagination", query, "2", bookmark), &meta)
+		if meta.ResponseMetadata.RecordsCount != strconv.Itoa(len(names)) {
+			t.Fatalf("records count %s does not match %d results", meta.ResponseMetadata.RecordsCount, len(names))
+		}
+		got = append(got, names...)
+		if len(names) < 2 {
+			break
+		}
+		if meta.ResponseMetadata.Bookmark == "" || meta.ResponseMetadata.Bookmark == bookmark {
+			t.Fatalf("expected a new bookmark after %v, got %q", names, meta.ResponseMetadata.Bookmark)
+		}
+		bookmark = meta.ResponseMetadata.Bookmark
+	}
+	assertNames(t, []string{"marble5", "marble3", "marble2", "marble1", "marble4"}, got)
+
+	invokeError(t, stub, "queryMarblesWithPagination", query, "two", "")
+}
+
+func TestGetMarblesByRange(t *testing.T) {
+	stub := newMarblesStub(t)
+
+	assertNames(t, []string{"marble2", "marble3", "marble4"}, decodeRecords(t, invoke(t, stub, "getMarblesByRange", "marble2", "marble5"), nil))
+
+	var meta responseMetadata
+	names := decodeRecords(t, invoke(t, stub, "getMarblesByRangeWithPagination", "marble1", "", "3", ""), &meta)
+	assertNames(t, []string{"marble1", "marble2", "marble3"}, names)
+	if meta.ResponseMetadata.Bookmark != "marble4" {
+		t.Fatalf("expected bookmark marble4, got %s", meta.ResponseMetadata.Bookmark)
+	}
+	names = decodeRecords(t, invoke(t, stub, "getMarblesByRangeWithPagination", "marble1", "", "3", "marble4"), &meta)
+	assertNames(t, []string{"marble4", "marble5"}, names)
+	if meta.ResponseMetadata.Bookmark != "" {
+		t.Fatalf("expected empty bookmark on last page, got %s", meta.ResponseMetadata.Bookmark)
+	}
+}